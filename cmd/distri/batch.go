@@ -5,7 +5,9 @@ import (
 	"flag"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/distr1/distri/internal/batch"
 	"github.com/distr1/distri/internal/build"
@@ -51,8 +53,26 @@ Build all distri packages.
 
 Packages which are already built (i.e. their .squashfs image exists) are skipped.
 
+Packages on the critical path (estimated from the build durations of previous
+batches, stored in build/distri/batch-history.json) are built first. The CPUs
+are shared between the running builds in proportion to their expected
+duration, so that the last few large builds of a batch use all CPUs.
+
+With -builders, packages are built on remote builders (see distri builder)
+instead of locally. Build inputs are uploaded to the builders and the resulting
+images are stored in the local repository. Builds are re-queued when their
+builder becomes unreachable.
+
+With -report_dir, build logs and a report (report.json and report.html) listing
+the status, duration and log of each package are stored in the specified
+directory. With -resume, only the packages which did not succeed in the batch
+whose report is stored in -report_dir are built.
+
 Example:
   % distri batch -dry_run
+  % distri batch -builders=builder1:2019,builder2:2019
+  % distri batch -report_dir=/tmp/batch
+  % distri batch -report_dir=/tmp/batch -resume
 `
 
 func cmdbatch(ctx context.Context, args []string) error {
@@ -61,7 +81,7 @@ func cmdbatch(ctx context.Context, args []string) error {
 		dryRun    = fset.Bool("dry_run", false, "only print packages which would otherwise be built")
 		simulate  = fset.Bool("simulate", false, "simulate builds by sleeping for random times instead of actually building packages")
 		rebuild   = fset.Bool("rebuild", false, "rebuild all packages, regardless of whether they need to be built or not")
-		jobs      = fset.Int("jobs", runtime.NumCPU(), "number of parallel jobs to run")
+		jobs      = fset.Int("jobs", runtime.NumCPU(), "number of packages to build in parallel")
 		ignoreGov = fset.Bool("dont_set_governor",
 			false,
 			"Don’t automatically set the “performance” CPU frequency scaling governor. Why wouldn’t you?")
@@ -71,6 +91,15 @@ func cmdbatch(ctx context.Context, args []string) error {
 		arch = fset.String("cross",
 			"",
 			"If non-empty, cross-build for the specified architecture (e.g. i686)")
+		builders = fset.String("builders",
+			"",
+			"If non-empty, a comma-separated list of host:port addresses of remote builders to build on, one build per builder at a time. -jobs is ignored in this mode")
+		reportDir = fset.String("report_dir",
+			"",
+			"If non-empty, the directory in which to store build logs and a report (report.json, report.html) of the batch")
+		resume = fset.Bool("resume",
+			false,
+			"Only build the packages which did not succeed (failed, skipped due to a failed dependency, or interrupted) in the batch whose report is stored in -report_dir")
 	)
 	fset.Usage = usage(fset, batchHelp)
 	fset.Parse(args)
@@ -105,6 +134,17 @@ func cmdbatch(ctx context.Context, args []string) error {
 			Arch: *arch,
 			Repo: env.DefaultRepo,
 		},
+		Resume: *resume,
+	}
+	if *builders != "" {
+		bctx.Builders = strings.Split(*builders, ",")
+	}
+	if *reportDir != "" {
+		abs, err := filepath.Abs(*reportDir)
+		if err != nil {
+			return err
+		}
+		bctx.ReportDir = abs
 	}
 	return bctx.Build(ctx, *dryRun, *simulate, *rebuild, *jobs)
 }
@@ -21,6 +21,7 @@ import (
 	"github.com/distr1/distri"
 	"github.com/distr1/distri/internal/build"
 	"github.com/distr1/distri/internal/env"
+	"github.com/distr1/distri/internal/squashfs"
 	"github.com/distr1/distri/internal/trace"
 	"github.com/distr1/distri/pb"
 	"github.com/golang/protobuf/proto"
@@ -140,7 +141,7 @@ func updateFromDistriroot(builddir string) error {
 	return nil
 }
 
-func buildpkg(ctx context.Context, hermetic bool, debug string, fuse bool, pwd, cross, remote string, artifactFd, jobs int) error {
+func buildpkg(ctx context.Context, hermetic bool, debug string, fuse bool, pwd, cross, remote string, artifactFd, jobs int, compression squashfs.Compression) error {
 	defer trace.Event("buildpkg", tidBuildpkg).Done()
 	buildProto, err := pb.ReadBuildFile("build.textproto")
 	if err != nil {
@@ -163,6 +164,7 @@ func buildpkg(ctx context.Context, hermetic bool, debug string, fuse bool, pwd,
 		Debug:          debug,
 		ArtifactWriter: ioutil.Discard,
 		Jobs:           jobs,
+		Compression:    compression,
 	}
 
 	if artifactFd > -1 {
@@ -475,6 +477,9 @@ func buildpkg(ctx context.Context, hermetic bool, debug string, fuse bool, pwd,
 			Version:      proto.String(b.Version),
 			RuntimeUnion: unions,
 			InputDigest:  proto.String(b.InputDigest),
+			Description:  b.Proto.Description,
+			Homepage:     b.Proto.Homepage,
+			License:      b.Proto.License,
 		})
 		fn := filepath.Join("../distri/pkg/" + fullName + ".meta.textproto")
 		b.ArtifactWriter.Write([]byte("_build/" + strings.TrimPrefix(fn, "../") + "\n"))
@@ -491,7 +496,12 @@ func buildpkg(ctx context.Context, hermetic bool, debug string, fuse bool, pwd,
 }
 
 func store(ctx context.Context, cl bpb.BuildClient, fn string) error {
-	f, err := os.Open(filepath.Join(string(env.DistriRoot), fn))
+	path := filepath.Join(string(env.DistriRoot), fn)
+	digest, err := fileDigest(path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
@@ -501,7 +511,14 @@ func store(ctx context.Context, cl bpb.BuildClient, fn string) error {
 	if err != nil {
 		return err
 	}
-	path := fn
+	// The first chunk only announces the file, allowing the server to skip the
+	// transfer if it already has the file:
+	if err := upcl.Send(&bpb.Chunk{
+		Path:   fn,
+		Digest: digest,
+	}); err != nil && err != io.EOF {
+		return xerrors.Errorf("Send: %v", err)
+	}
 	var buf [4096]byte
 	for {
 		n, err := f.Read(buf[:])
@@ -511,27 +528,25 @@ func store(ctx context.Context, cl bpb.BuildClient, fn string) error {
 			}
 			return xerrors.Errorf("Read: %v", err)
 		}
-		chunk := buf[:n]
 		if err := upcl.Send(&bpb.Chunk{
-			Path:  path,
-			Chunk: chunk,
+			Chunk: buf[:n],
 		}); err != nil {
-			if status.Code(err) == codes.AlreadyExists {
-				return nil
-			}
 			if err == io.EOF {
-				return nil // server closed stream, file already present
+				break // server closed stream, status is returned by CloseAndRecv
 			}
 			return xerrors.Errorf("Send: %v", err)
 		}
-		path = ""
 	}
-	if _, err := upcl.CloseAndRecv(); err != nil {
+	resp, err := upcl.CloseAndRecv()
+	if err != nil {
 		if status.Code(err) == codes.AlreadyExists {
 			return nil
 		}
 		return xerrors.Errorf("CloseAndRecv: %v", err)
 	}
+	if resp.GetCached() {
+		log.Printf("store(%s): already present on the builder", fn)
+	}
 	return nil
 }
 
@@ -620,10 +635,19 @@ func cmdbuild(ctx context.Context, args []string) error {
 		jobs = fset.Int("jobs",
 			runtime.NumCPU(),
 			"Number of parallel jobs, passed to make -j, ninja --jobs, etc.")
+
+		compressionFlag = fset.String("compression",
+			"none",
+			"compression for data blocks of the resulting SquashFS images. one of none, gzip, xz, zstd")
 	)
 	fset.Usage = usage(fset, buildHelp)
 	fset.Parse(args)
 
+	compression, err := squashfs.ParseCompression(*compressionFlag)
+	if err != nil {
+		return err
+	}
+
 	if *job != "" {
 		return runBuildJob(ctx, *job)
 	}
@@ -635,7 +659,6 @@ func cmdbuild(ctx context.Context, args []string) error {
 			return err
 		}
 	} else {
-		var err error
 		pwd, err = os.Getwd()
 		if err != nil {
 			return err
@@ -677,7 +700,7 @@ func cmdbuild(ctx context.Context, args []string) error {
 		}
 	}
 
-	if err := buildpkg(ctx, *hermetic, *debug, *fuse, pwd, *cross, *remote, *artifactFd, *jobs); err != nil {
+	if err := buildpkg(ctx, *hermetic, *debug, *fuse, pwd, *cross, *remote, *artifactFd, *jobs, compression); err != nil {
 		return err
 	}
 
@@ -3,14 +3,17 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
-	"hash/fnv"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/distr1/distri/internal/addrfd"
@@ -29,10 +32,35 @@ const builderHelp = `distri builder [-flags]
 
 builder runs a remote build server. This is useful to leverage additional
 compute capacity, e.g. from a cluster or the public cloud.
+
+Uploaded files and build artifacts are kept in a content-addressed store (see
+-cas_dir): files the builder already has are not transferred again, and builds
+whose inputs match a previous build return the previous artifacts right away.
 `
 
 type buildsrv struct {
 	uploadBaseDir string
+	cas           *cas
+	distriDigest  string // of the distri binary running builds, see buildKey
+}
+
+// chunkReader reads the file contents of a Store stream.
+type chunkReader struct {
+	srv bpb.Build_StoreServer
+	buf []byte
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.srv.Recv()
+		if err != nil {
+			return 0, err // including io.EOF
+		}
+		r.buf = chunk.GetChunk()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
 }
 
 func (b *buildsrv) Store(srv bpb.Build_StoreServer) error {
@@ -45,6 +73,25 @@ func (b *buildsrv) Store(srv bpb.Build_StoreServer) error {
 		return status.Errorf(codes.InvalidArgument, "path traversal detected")
 	}
 
+	if digest := chunk.GetDigest(); digest != "" {
+		if _, err := b.cas.blobPath(digest); err != nil {
+			return status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		if b.cas.has(digest) {
+			if err := b.cas.link(digest, path); err != nil {
+				return err
+			}
+			return srv.SendAndClose(&bpb.StoreResponse{Cached: true})
+		}
+		if _, err := b.cas.add(&chunkReader{srv: srv, buf: chunk.GetChunk()}, digest); err != nil {
+			return status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		if err := b.cas.link(digest, path); err != nil {
+			return err
+		}
+		return srv.SendAndClose(&bpb.StoreResponse{})
+	}
+
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
@@ -78,27 +125,25 @@ func (b *buildsrv) Store(srv bpb.Build_StoreServer) error {
 func (b *buildsrv) Build(req *bpb.BuildRequest, srv bpb.Build_BuildServer) error {
 	// TODO: enforce minimum request deadline before starting a build
 
-	for _, p := range req.GetInputPath() {
-		path := filepath.Join(b.uploadBaseDir, p)
-		if !strings.HasPrefix(path, filepath.Clean(b.uploadBaseDir)+"/") {
-			return status.Errorf(codes.InvalidArgument, "path traversal detected")
-		}
-
-		f, err := os.Open(path)
-		if err != nil {
-			if os.IsNotExist(err) {
-				return status.Errorf(codes.NotFound, "%v", err)
+	key, err := b.buildKey(req)
+	if err != nil {
+		return err
+	}
+	artifacts, ok, err := b.cas.lookupBuild(key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		log.Printf("build %s: cached, returning %d artifacts", key, len(artifacts))
+		paths := make([]string, len(artifacts))
+		for i, a := range artifacts {
+			if err := b.cas.link(a.digest, filepath.Join(b.uploadBaseDir, a.path)); err != nil {
+				return err
 			}
-			return err
-		}
-		defer f.Close()
-		h := fnv.New64()
-		if _, err := io.Copy(h, f); err != nil {
-			return err
+			paths[i] = a.path
 		}
-		log.Printf("sum(%s) = %v", path, h.Sum64())
+		return srv.Send(&bpb.BuildProgress{OutputPath: paths})
 	}
-	// TODO: use the hash of hashes as cache key, store <hash>→<artifacts> in a file
 
 	// TODO: enforce inputs can only be read
 
@@ -135,12 +180,17 @@ func (b *buildsrv) Build(req *bpb.BuildRequest, srv bpb.Build_BuildServer) error
 	if err := w.Close(); err != nil {
 		return err
 	}
-	var eg errgroup.Group
+	var (
+		eg      errgroup.Group
+		outputs []string
+	)
 	eg.Go(func() error {
 		scanner := bufio.NewScanner(r)
 		for scanner.Scan() {
+			paths := strings.Split(scanner.Text(), "\x00")
+			outputs = append(outputs, paths...)
 			if err := srv.Send(&bpb.BuildProgress{
-				OutputPath: strings.Split(scanner.Text(), "\x00"),
+				OutputPath: paths,
 			}); err != nil {
 				return err
 			}
@@ -152,9 +202,51 @@ func (b *buildsrv) Build(req *bpb.BuildRequest, srv bpb.Build_BuildServer) error
 	if err := eg.Wait(); err != nil {
 		return err
 	}
+
+	artifacts = make([]artifact, 0, len(outputs))
+	for _, path := range outputs {
+		digest, err := b.cas.addFile(filepath.Join(b.uploadBaseDir, path))
+		if err != nil {
+			log.Printf("not caching build %s: %v", key, err)
+			return nil
+		}
+		artifacts = append(artifacts, artifact{digest: digest, path: path})
+	}
+	if err := b.cas.recordBuild(key, artifacts); err != nil {
+		log.Printf("not caching build %s: %v", key, err)
+	}
 	return nil
 }
 
+// buildKey returns the digest of all inputs of the build requested by req,
+// i.e. the working directory, build flags, paths and contents of input files,
+// and the distri binary running the build.
+func (b *buildsrv) buildKey(req *bpb.BuildRequest) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "working_directory %q\n", req.GetWorkingDirectory())
+	for _, flag := range req.GetBuildFlag() {
+		fmt.Fprintf(h, "build_flag %q\n", flag)
+	}
+	inputs := append([]string(nil), req.GetInputPath()...)
+	sort.Strings(inputs)
+	for _, p := range inputs {
+		path := filepath.Join(b.uploadBaseDir, p)
+		if !strings.HasPrefix(path, filepath.Clean(b.uploadBaseDir)+"/") {
+			return "", status.Errorf(codes.InvalidArgument, "path traversal detected")
+		}
+		digest, err := fileDigest(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", status.Errorf(codes.NotFound, "%v", err)
+			}
+			return "", err
+		}
+		fmt.Fprintf(h, "input_path %q %s\n", p, digest)
+	}
+	fmt.Fprintf(h, "distri %s\n", b.distriDigest)
+	return digestPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
 func (b *buildsrv) Retrieve(req *bpb.RetrieveRequest, srv bpb.Build_RetrieveServer) error {
 	fn := filepath.Join(b.uploadBaseDir, req.GetPath())
 	if !strings.HasPrefix(fn, filepath.Clean(b.uploadBaseDir)+"/") {
@@ -198,6 +290,10 @@ func builder(ctx context.Context, args []string) error {
 		uploadBaseDir = fset.String("upload_base_dir",
 			"",
 			"directory in which to store uploaded files")
+
+		casDir = fset.String("cas_dir",
+			"",
+			"directory in which to store uploaded files and build artifacts by content. empty means _cas within -upload_base_dir")
 	)
 	addrfd := addrfd.RegisterFlags(fset)
 	fset.Usage = usage(fset, builderHelp)
@@ -210,9 +306,20 @@ func builder(ctx context.Context, args []string) error {
 		return err
 	}
 	addrfd.MustWrite(ln.Addr().String())
+	if *casDir == "" {
+		*casDir = filepath.Join(*uploadBaseDir, "_cas")
+	}
+	var distriDigest string
+	if distri, err := exec.LookPath("distri"); err == nil {
+		if distriDigest, err = fileDigest(distri); err != nil {
+			return err
+		}
+	}
 	srv := grpc.NewServer()
 	bpb.RegisterBuildServer(srv, &buildsrv{
 		uploadBaseDir: *uploadBaseDir,
+		cas:           &cas{dir: *casDir},
+		distriDigest:  distriDigest,
 	})
 	reflection.Register(srv)
 	return srv.Serve(ln)
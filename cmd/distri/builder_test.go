@@ -2,11 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -25,14 +27,10 @@ import (
 	bpb "github.com/distr1/distri/pb/builder"
 )
 
-func TestBuilder(t *testing.T) {
-	ctx, canc := distri.InterruptibleContext()
-	defer canc()
-	tmp, err := ioutil.TempDir("", "distri-test-builder")
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer distritest.RemoveAll(t, tmp)
+// startBuilder starts a builder storing files in uploadBaseDir and returns a
+// client connected to it.
+func startBuilder(ctx context.Context, t *testing.T, uploadBaseDir string) bpb.BuildClient {
+	t.Helper()
 	r, w, err := os.Pipe()
 	if err != nil {
 		t.Fatal(err)
@@ -41,7 +39,7 @@ func TestBuilder(t *testing.T) {
 	defer w.Close()
 	go func() {
 		if err := builder(ctx, []string{
-			"-upload_base_dir=" + tmp,
+			"-upload_base_dir=" + uploadBaseDir,
 			"-listen=localhost:0",
 			fmt.Sprintf("-addrfd=%d", w.Fd()),
 		}); err != nil {
@@ -57,7 +55,18 @@ func TestBuilder(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	cl := bpb.NewBuildClient(conn)
+	return bpb.NewBuildClient(conn)
+}
+
+func TestBuilder(t *testing.T) {
+	ctx, canc := distri.InterruptibleContext()
+	defer canc()
+	tmp, err := ioutil.TempDir("", "distri-test-builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer distritest.RemoveAll(t, tmp)
+	cl := startBuilder(ctx, t, tmp)
 
 	const path = "subdir/src.tar.gz"
 	var succeeded bool
@@ -195,3 +204,149 @@ func TestBuilder(t *testing.T) {
 		// TODO: open buf as a squashfs file
 	})
 }
+
+func TestBuilderCache(t *testing.T) {
+	ctx, canc := distri.InterruptibleContext()
+	defer canc()
+	tmp, err := ioutil.TempDir("", "distri-test-builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer distritest.RemoveAll(t, tmp)
+	uploadBaseDir := filepath.Join(tmp, "upload")
+	cl := startBuilder(ctx, t, uploadBaseDir)
+
+	// store reads files relative to env.DistriRoot:
+	root := filepath.Join(tmp, "distri")
+	defer func(old env.DistriRootDir) { env.DistriRoot = old }(env.DistriRoot)
+	env.DistriRoot = env.DistriRootDir(root)
+	want := bytes.Repeat([]byte("hello world\n"), 1000) // multiple chunks
+	for _, fn := range []string{"pkgs/hello/build.textproto", "pkgs/hello2/build.textproto"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, fn)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, fn), want, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Store", func(t *testing.T) {
+		const fn = "pkgs/hello/build.textproto"
+		if err := store(ctx, cl, fn); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(uploadBaseDir, fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("uploaded file differs")
+		}
+	})
+
+	t.Run("StoreCached", func(t *testing.T) {
+		// The same contents under a different path are linked from the CAS:
+		const fn = "pkgs/hello2/build.textproto"
+		digest, err := fileDigest(filepath.Join(root, fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		upcl, err := cl.Store(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := upcl.Send(&bpb.Chunk{Path: fn, Digest: digest}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := upcl.CloseAndRecv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.GetCached() {
+			t.Errorf("StoreResponse.Cached = false, want true")
+		}
+		got, err := ioutil.ReadFile(filepath.Join(uploadBaseDir, fn))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("uploaded file differs")
+		}
+	})
+
+	t.Run("StoreDigestMismatch", func(t *testing.T) {
+		upcl, err := cl.Store(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := upcl.Send(&bpb.Chunk{
+			Path:   "pkgs/hello3/build.textproto",
+			Digest: digestPrefix + strings.Repeat("00", 32),
+			Chunk:  []byte("not matching"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := upcl.CloseAndRecv(); err == nil {
+			t.Fatalf("Store unexpectedly succeeded despite digest mismatch")
+		}
+	})
+
+	t.Run("BuildCached", func(t *testing.T) {
+		req := &bpb.BuildRequest{
+			WorkingDirectory: "pkgs/hello",
+			InputPath:        []string{"pkgs/hello/build.textproto"},
+		}
+		// Record a previous build, as the builder does after building:
+		var distriDigest string
+		if distri, err := exec.LookPath("distri"); err == nil {
+			if distriDigest, err = fileDigest(distri); err != nil {
+				t.Fatal(err)
+			}
+		}
+		srv := &buildsrv{
+			uploadBaseDir: uploadBaseDir,
+			cas:           &cas{dir: filepath.Join(uploadBaseDir, "_cas")},
+			distriDigest:  distriDigest,
+		}
+		key, err := srv.buildKey(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		const artifactPath = "_build/distri/pkg/hello-amd64-1.squashfs"
+		artifactDigest, err := srv.cas.add(strings.NewReader("squashfs image"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := srv.cas.recordBuild(key, []artifact{
+			{digest: artifactDigest, path: artifactPath},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		bcl, err := cl.Build(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var artifacts []string
+		for {
+			progress, err := bcl.Recv()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				t.Fatal(err)
+			}
+			artifacts = append(artifacts, progress.GetOutputPath()...)
+		}
+		if diff := cmp.Diff([]string{artifactPath}, artifacts); diff != "" {
+			t.Fatalf("Build: unexpected artifacts: diff (-want +got):\n%s", diff)
+		}
+		got, err := ioutil.ReadFile(filepath.Join(uploadBaseDir, artifactPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(got), "squashfs image"; got != want {
+			t.Fatalf("artifact contents: got %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/renameio"
+	"golang.org/x/xerrors"
+)
+
+const digestPrefix = "sha256:"
+
+// fileDigest returns the digest (e.g. sha256:e3b0c442…) of the file at path.
+func fileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return digestPrefix + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cas is a content-addressed store: files (blobs) are stored by their digest.
+// Additionally, cas records the artifacts of builds, keyed by the digest of
+// their inputs.
+type cas struct {
+	dir string
+}
+
+// blobPath returns the path of the blob with the specified digest.
+func (c *cas) blobPath(digest string) (string, error) {
+	hexDigest := strings.TrimPrefix(digest, digestPrefix)
+	if hexDigest == digest {
+		return "", xerrors.Errorf("unsupported digest %q: expected %s prefix", digest, digestPrefix)
+	}
+	if b, err := hex.DecodeString(hexDigest); err != nil || len(b) != sha256.Size {
+		return "", xerrors.Errorf("malformed digest %q", digest)
+	}
+	return filepath.Join(c.dir, "sha256", hexDigest[:2], hexDigest), nil
+}
+
+// has returns whether the blob with the specified digest is present.
+func (c *cas) has(digest string) bool {
+	path, err := c.blobPath(digest)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// link makes the blob with the specified digest available at dest, replacing
+// dest if it exists.
+func (c *cas) link(digest, dest string) error {
+	path, err := c.blobPath(digest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmp := dest + ".cas-tmp"
+	os.Remove(tmp)
+	if err := os.Link(path, tmp); err != nil {
+		// e.g. a different file system: fall back to copying
+		if err := copyBlob(path, tmp); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp, dest)
+}
+
+// add stores the contents of r as a blob. If want is non-empty, the contents
+// must match the digest want. add returns the digest of the blob.
+func (c *cas) add(r io.Reader, want string) (string, error) {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(c.dir, "blob-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(f, io.TeeReader(r, h)); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	digest := digestPrefix + hex.EncodeToString(h.Sum(nil))
+	if want != "" && digest != want {
+		return "", xerrors.Errorf("digest mismatch: got %s, want %s", digest, want)
+	}
+	path, err := c.blobPath(digest)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	// Blobs are never modified, so they can be hard-linked safely:
+	if err := os.Chmod(f.Name(), 0444); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// addFile stores the file at path as a blob and returns its digest.
+func (c *cas) addFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return c.add(f, "")
+}
+
+// artifact is a build output file.
+type artifact struct {
+	digest string
+	path   string // relative to the upload base dir
+}
+
+func (c *cas) buildPath(key string) (string, error) {
+	path, err := c.blobPath(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(c.dir, "builds", filepath.Base(path)), nil
+}
+
+// lookupBuild returns the artifacts recorded for the build with the specified
+// input digest, or false if no build was recorded or its artifacts are no
+// longer present.
+func (c *cas) lookupBuild(key string) ([]artifact, bool, error) {
+	path, err := c.buildPath(key)
+	if err != nil {
+		return nil, false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	defer f.Close()
+	var artifacts []artifact
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// e.g. sha256:e3b0c442… build/distri/pkg/hello-amd64-1.squashfs
+		parts := strings.SplitN(scanner.Text(), " ", 2)
+		if len(parts) != 2 {
+			return nil, false, xerrors.Errorf("%s: malformed line %q", path, scanner.Text())
+		}
+		if !c.has(parts[0]) {
+			return nil, false, nil // artifact was cleaned up
+		}
+		artifacts = append(artifacts, artifact{digest: parts[0], path: parts[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	return artifacts, true, nil
+}
+
+// recordBuild records artifacts as the result of the build with the specified
+// input digest.
+func (c *cas) recordBuild(key string, artifacts []artifact) error {
+	path, err := c.buildPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, a := range artifacts {
+		fmt.Fprintf(&b, "%s %s\n", a.digest, a.path)
+	}
+	return renameio.WriteFile(path, []byte(b.String()), 0644)
+}
+
+func copyBlob(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0444)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
@@ -3,31 +3,106 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/distr1/distri/pb"
+	"golang.org/x/xerrors"
 	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
-const fusectlHelp = `distri fusectl [-flags]
+const fusectlHelp = `distri fusectl [-flags] [packages|conflicts|stats]
 
-Send a control instruction to the FUSE file system.
+Send a control instruction to the FUSE file system, or inspect it:
+
+packages lists the available packages, their images and inode ranges.
+conflicts lists exchange directory entries (e.g. /ro/bin/sh) provided by more
+than one package, and which package wins.
+stats prints request counters.
 
 Typically only used under the covers, or for debugging.
 
 Example:
   % distri fusectl -scan_packages
+  % distri fusectl -remove_packages=less-amd64-530
+  % distri fusectl conflicts -dir=bin
+  % distri fusectl -json stats
 `
 
+// printJSON writes msg to w in the protobuf JSON mapping.
+func printJSON(w io.Writer, msg proto.Message) error {
+	b, err := protojson.MarshalOptions{Multiline: true}.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+func printPackages(w io.Writer, resp *pb.ListPackagesReply) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "PACKAGE\tOPEN\tLOOKUPS\tINODES\tIMAGE\n")
+	for _, pkg := range resp.GetPackage() {
+		fmt.Fprintf(tw, "%s\t%v\t%d\t%#x-%#x\t%s\n",
+			pkg.GetName(),
+			pkg.GetOpen(),
+			pkg.GetLookups(),
+			pkg.GetInodeMin(),
+			pkg.GetInodeMax(),
+			pkg.GetImage())
+	}
+	return tw.Flush()
+}
+
+func printConflicts(w io.Writer, resp *pb.ExchangeConflictsReply) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "PATH\tWINNER\tCANDIDATES\n")
+	for _, c := range resp.GetConflict() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n",
+			c.GetPath(),
+			c.GetWinner(),
+			strings.Join(c.GetCandidate(), ", "))
+	}
+	return tw.Flush()
+}
+
+func printStats(w io.Writer, resp *pb.StatsReply) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "packages\t%d\n", resp.GetPackages())
+	fmt.Fprintf(tw, "lookups\t%d\n", resp.GetLookups())
+	fmt.Fprintf(tw, "reads\t%d\n", resp.GetReads())
+	fmt.Fprintf(tw, "bytes served\t%d\n", resp.GetBytesServed())
+	fmt.Fprintf(tw, "autodownloads\t%d\n", resp.GetAutodownloads())
+	return tw.Flush()
+}
+
 func fusectl(ctx context.Context, args []string) error {
 	fset := flag.NewFlagSet("fusectl", flag.ExitOnError)
 	var (
 		mkdirAll     = fset.String("mkdirall", "", "if non-empty, sends a MkdirAll request")
 		scanPackages = fset.Bool("scan_packages", false, "sends a ScanPackages request")
+		remove       = fset.String("remove_packages", "", "if non-empty, sends a RemovePackages request for this comma-separated list of packages")
+		asJSON       = fset.Bool("json", false, "print packages, conflicts and stats as JSON instead of a table")
+		dir          = fset.String("dir", "", "if non-empty, only list conflicts within this exchange directory (e.g. bin)")
 	)
 	fset.Usage = usage(fset, fusectlHelp)
 	fset.Parse(args)
+	verb := fset.Arg(0)
+	if fset.NArg() > 1 {
+		// Allow flags after the verb, e.g. distri fusectl conflicts -dir=bin
+		fset.Parse(fset.Args()[1:])
+	}
+	switch verb {
+	case "", "packages", "conflicts", "stats":
+	default:
+		return xerrors.Errorf("unknown verb %q (expected one of packages, conflicts, stats)", verb)
+	}
 
 	ctl, err := os.Readlink("/ro/ctl")
 	if err != nil {
@@ -40,10 +115,50 @@ func fusectl(ctx context.Context, args []string) error {
 		return err
 	}
 	cl := pb.NewFUSEClient(conn)
+
+	switch verb {
+	case "":
+		// control instruction, see below
+
+	case "packages":
+		resp, err := cl.ListPackages(ctx, &pb.ListPackagesRequest{})
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(os.Stdout, resp)
+		}
+		return printPackages(os.Stdout, resp)
+
+	case "conflicts":
+		resp, err := cl.ExchangeConflicts(ctx, &pb.ExchangeConflictsRequest{Dir: dir})
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(os.Stdout, resp)
+		}
+		return printConflicts(os.Stdout, resp)
+
+	case "stats":
+		resp, err := cl.Stats(ctx, &pb.StatsRequest{})
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(os.Stdout, resp)
+		}
+		return printStats(os.Stdout, resp)
+	}
+
 	if *mkdirAll != "" {
 		if _, err := cl.MkdirAll(ctx, &pb.MkdirAllRequest{Dir: mkdirAll}); err != nil {
 			return err
 		}
+	} else if *remove != "" {
+		if _, err := cl.RemovePackages(ctx, &pb.RemovePackagesRequest{Pkg: strings.Split(*remove, ",")}); err != nil {
+			return err
+		}
 	} else if *scanPackages {
 		if _, err := cl.ScanPackages(ctx, &pb.ScanPackagesRequest{}); err != nil {
 			return err
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/distr1/distri/pb"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestFusectlOutput(t *testing.T) {
+	conflicts := &pb.ExchangeConflictsReply{
+		Conflict: []*pb.ExchangeConflict{
+			{
+				Path:      proto.String("bin/sh"),
+				Winner:    proto.String("bash-amd64-5.0-4"),
+				Candidate: []string{"bash-amd64-5.0-4", "busybox-amd64-1.31.1-3"},
+			},
+		},
+	}
+
+	t.Run("Table", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printConflicts(&buf, conflicts); err != nil {
+			t.Fatal(err)
+		}
+		want := `PATH    WINNER            CANDIDATES
+bin/sh  bash-amd64-5.0-4  bash-amd64-5.0-4, busybox-amd64-1.31.1-3
+`
+		if diff := cmp.Diff(want, buf.String()); diff != "" {
+			t.Errorf("printConflicts: unexpected output: diff (-want +got):\n%s", diff)
+		}
+
+		buf.Reset()
+		if err := printPackages(&buf, &pb.ListPackagesReply{
+			Package: []*pb.MountedPackage{
+				{
+					Name:     proto.String("less-amd64-530"),
+					Image:    proto.String("/roimg/less-amd64-530.squashfs"),
+					InodeMin: proto.Uint64(1 << 48),
+					InodeMax: proto.Uint64(1<<48 | 0xFFFFFFFFFFFF),
+					Open:     proto.Bool(true),
+					Lookups:  proto.Uint64(3),
+				},
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		want = `PACKAGE         OPEN  LOOKUPS  INODES                           IMAGE
+less-amd64-530  true  3        0x1000000000000-0x1ffffffffffff  /roimg/less-amd64-530.squashfs
+`
+		if diff := cmp.Diff(want, buf.String()); diff != "" {
+			t.Errorf("printPackages: unexpected output: diff (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := printJSON(&buf, conflicts); err != nil {
+			t.Fatal(err)
+		}
+		var got pb.ExchangeConflictsReply
+		if err := protojson.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(&got, conflicts) {
+			t.Errorf("printJSON round-trip: got %v, want %v", &got, conflicts)
+		}
+	})
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -11,7 +13,11 @@ import (
 	"strings"
 
 	"github.com/distr1/distri"
+	"github.com/distr1/distri/internal/storelock"
 	"github.com/distr1/distri/pb"
+	"github.com/google/renameio"
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
 	"google.golang.org/grpc"
 )
 
@@ -19,10 +25,301 @@ const gcHelp = `distri gc [-flags]
 
 Garbage collect unreferenced packages.
 
+A package is kept if it is reachable (via runtime dependencies) from one of
+the following roots:
+
+  • the packages listed in /etc/distri/pkgset.d/*.pkgset (the most recent
+    revision of each), plus base and distri1, which distri update installs
+  • the running kernel and the most recent kernel (default boot entry)
+  • packages which are mounted or in use by running processes
+
+Without any package sets (or when using -store), the most recent revision of
+every package is a root instead. Installed packages which were never added to a
+package set (e.g. installed by an older distri) are added to local.pkgset.
+
+Package images without meta data (e.g. left behind by an interrupted
+installation) are deleted as well.
+
 Example:
   % distri gc -dry_run
+  % distri gc -why glibc
 `
 
+// gcGraph tracks which packages are kept alive, and why.
+type gcGraph struct {
+	store string
+	pkgs  map[string]bool // all packages in the store
+
+	// parent maps a kept package to the package which references it (empty
+	// for roots).
+	parent map[string]string
+	// reason maps a root package to the reason it is a root.
+	reason map[string]string
+}
+
+// newestRevision returns the most recent revision within pkgs of name, which
+// can be a package (e.g. bash), a package with architecture (e.g.
+// bash-amd64) or a fully specified package (e.g. bash-amd64-5.0-4).
+func newestRevision(pkgs map[string]bool, name string) string {
+	if pkgs[name] {
+		return name
+	}
+	var newest string
+	for pkg := range pkgs {
+		pv := distri.ParseVersion(pkg)
+		if pv.Pkg != name && pv.Pkg+"-"+pv.Arch != name {
+			continue
+		}
+		if newest == "" || distri.PackageRevisionLess(newest, pkg) {
+			newest = pkg
+		}
+	}
+	return newest
+}
+
+// addRoot marks pkg and all of its runtime dependencies as kept.
+func (g *gcGraph) addRoot(pkg, reason string) error {
+	if _, ok := g.parent[pkg]; ok {
+		return nil // already kept
+	}
+	g.parent[pkg] = ""
+	g.reason[pkg] = reason
+	queue := []string{pkg}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		meta, err := pb.ReadMetaFile(filepath.Join(g.store, pkg+".meta.textproto"))
+		if err != nil {
+			return err
+		}
+		for _, dep := range meta.GetRuntimeDep() {
+			if !g.pkgs[dep] {
+				continue // not installed, nothing to keep
+			}
+			if _, ok := g.parent[dep]; ok {
+				continue
+			}
+			g.parent[dep] = pkg
+			queue = append(queue, dep)
+		}
+	}
+	return nil
+}
+
+// why returns the chain of references from a root to pkg, starting with the
+// root, or nil if pkg is not kept.
+func (g *gcGraph) why(pkg string) []string {
+	if _, ok := g.parent[pkg]; !ok {
+		return nil
+	}
+	chain := []string{pkg}
+	for p := g.parent[pkg]; p != ""; p = g.parent[p] {
+		chain = append([]string{p}, chain...)
+	}
+	return chain
+}
+
+// pkgsetRoots returns the entries of all package sets in root, keyed by
+// entry, with the package set file name as value.
+func pkgsetRoots(root string) (map[string]string, error) {
+	matches, err := filepath.Glob(filepath.Join(root, "etc", "distri", "pkgset.d", "*.pkgset"))
+	if err != nil {
+		return nil, err
+	}
+	roots := make(map[string]string)
+	for _, m := range matches {
+		b, err := ioutil.ReadFile(m)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+			roots[line] = filepath.Base(m)
+		}
+	}
+	return roots, nil
+}
+
+// migrateLocalPkgset seeds local.pkgset in root with the installed top-level
+// packages (i.e. packages on which no other installed package depends) which
+// are not part of any package set. Packages installed before distri install
+// recorded them in local.pkgset would otherwise be deleted by distri gc. If
+// local.pkgset exists, the migration already happened and nothing is done. The
+// seeded entries are returned, and written unless dryRun is true.
+func migrateLocalPkgset(root string, dryRun bool) ([]string, error) {
+	fn := filepath.Join(root, "etc", "distri", "pkgset.d", localPkgset+".pkgset")
+	if _, err := os.Stat(fn); err == nil {
+		return nil, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	store := filepath.Join(root, "roimg")
+	matches, err := filepath.Glob(filepath.Join(store, "*.meta.textproto"))
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make(map[string]bool)
+	depended := map[string]bool{
+		// roots of distri gc regardless of package sets:
+		"base":    true,
+		"distri1": true,
+		"linux":   true,
+	}
+	for _, m := range matches {
+		pkg := strings.TrimSuffix(filepath.Base(m), ".meta.textproto")
+		pkgs[pkg] = true
+		meta, err := pb.ReadMetaFile(m)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range meta.GetRuntimeDep() {
+			depended[distri.ParseVersion(dep).Pkg] = true
+		}
+	}
+	pkgsets, err := pkgsetRoots(root)
+	if err != nil {
+		return nil, err
+	}
+	for entry := range pkgsets {
+		if pkg := newestRevision(pkgs, entry); pkg != "" {
+			depended[distri.ParseVersion(pkg).Pkg] = true
+		}
+	}
+	top := make(map[string]bool)
+	for pkg := range pkgs {
+		if name := distri.ParseVersion(pkg).Pkg; !depended[name] {
+			top[name] = true
+		}
+	}
+	seeded := make([]string, 0, len(top))
+	for name := range top {
+		seeded = append(seeded, name)
+	}
+	sort.Strings(seeded)
+	if dryRun {
+		return seeded, nil
+	}
+	if len(seeded) > 0 {
+		log.Printf("adding packages which are not part of any package set to %s: %s", fn, strings.Join(seeded, " "))
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return nil, err
+	}
+	var content string
+	for _, pkg := range seeded {
+		content += pkg + "\n"
+	}
+	// Written even if empty, marking the migration as done:
+	return seeded, renameio.WriteFile(fn, []byte(content), 0644)
+}
+
+// runningKernel returns the linux package of the running kernel, based on
+// the BOOT_IMAGE kernel parameter (e.g. /vmlinuz-5.6.5-15) or, as a fallback,
+// on the kernel release.
+func runningKernel(pkgs map[string]bool) string {
+	if b, err := ioutil.ReadFile("/proc/cmdline"); err == nil {
+		for _, param := range strings.Fields(string(b)) {
+			if !strings.HasPrefix(param, "BOOT_IMAGE=") {
+				continue
+			}
+			version := strings.TrimPrefix(filepath.Base(param), "vmlinuz-")
+			for pkg := range pkgs {
+				pv := distri.ParseVersion(pkg)
+				if pv.Pkg == "linux" && fmt.Sprintf("%s-%d", pv.Upstream, pv.DistriRevision) == version {
+					return pkg
+				}
+			}
+		}
+	}
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return ""
+	}
+	release := unix.ByteSliceToString(uts.Release[:])
+	var newest string
+	for pkg := range pkgs {
+		pv := distri.ParseVersion(pkg)
+		if pv.Pkg != "linux" || pv.Upstream != release {
+			continue
+		}
+		if newest == "" || distri.PackageRevisionLess(newest, pkg) {
+			newest = pkg
+		}
+	}
+	return newest
+}
+
+// packageOfPath returns the package referenced by path, i.e. the package
+// directory of a /ro path (e.g. /ro/bash-amd64-5.0-4/bin/bash) or the image
+// of a mounted package (e.g. /roimg/bash-amd64-5.0-4.squashfs).
+func packageOfPath(store, path string) string {
+	if rel := strings.TrimPrefix(path, "/ro/"); rel != path {
+		if idx := strings.IndexByte(rel, '/'); idx > -1 {
+			rel = rel[:idx]
+		}
+		return rel
+	}
+	if filepath.Dir(path) == store && strings.HasSuffix(path, ".squashfs") {
+		return strings.TrimSuffix(filepath.Base(path), ".squashfs")
+	}
+	return ""
+}
+
+// inUse returns the packages which running processes use (executables,
+// mapped libraries, working directories and open files), mapped to an
+// example process. The FUSE daemon keeps mounted images open, so mounted
+// packages are included.
+func inUse(store string, pkgs map[string]bool) (map[string]string, error) {
+	procs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]string)
+	use := func(proc, path string) {
+		pkg := packageOfPath(store, path)
+		if !pkgs[pkg] {
+			return
+		}
+		if _, ok := used[pkg]; !ok {
+			comm, _ := ioutil.ReadFile(filepath.Join(proc, "comm"))
+			used[pkg] = fmt.Sprintf("pid %s (%s)", filepath.Base(proc), strings.TrimSpace(string(comm)))
+		}
+	}
+	for _, proc := range procs {
+		// Errors are ignored: processes might exit at any time, and we might
+		// lack permission to inspect processes of other users.
+		for _, link := range []string{"exe", "cwd", "root"} {
+			if target, err := os.Readlink(filepath.Join(proc, link)); err == nil {
+				use(proc, target)
+			}
+		}
+		if fds, err := ioutil.ReadDir(filepath.Join(proc, "fd")); err == nil {
+			for _, fd := range fds {
+				if target, err := os.Readlink(filepath.Join(proc, "fd", fd.Name())); err == nil {
+					use(proc, target)
+				}
+			}
+		}
+		f, err := os.Open(filepath.Join(proc, "maps"))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// e.g. 7f3c1e5c2000-7f3c1e5e4000 r--p 00000000 00:2b 1234 /ro/glibc-amd64-2.31-4/out/lib/libc-2.31.so
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 6 {
+				continue
+			}
+			use(proc, fields[5])
+		}
+		f.Close()
+	}
+	return used, nil
+}
+
 func gc(ctx context.Context, args []string) error {
 	fset := flag.NewFlagSet("gc", flag.ExitOnError)
 	var (
@@ -37,6 +334,14 @@ func gc(ctx context.Context, args []string) error {
 		dryRun = fset.Bool("dry_run",
 			false,
 			"only print packages which would otherwise be deleted")
+
+		wait = fset.Bool("wait",
+			false,
+			"wait for other processes modifying the package store instead of failing")
+
+		why = fset.String("why",
+			"",
+			"if non-empty, print why the specified package (e.g. glibc or glibc-amd64-2.31-4) is kept instead of deleting anything")
 	)
 	fset.Usage = usage(fset, gcHelp)
 	fset.Parse(args)
@@ -46,9 +351,26 @@ func gc(ctx context.Context, args []string) error {
 		store = filepath.Join(*root, "roimg")
 	}
 
-	// eligible maps from package (e.g. libudev) to a list of package
-	// versions (e.g. libudev-amd64-239-10) to be garbage collected.
-	eligible := make(map[string]map[string]bool)
+	lock, err := storelock.Acquire(store, *wait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	remove := func(fn string) error {
+		if *dryRun {
+			fmt.Printf("rm '%s'\n", fn)
+			return nil
+		}
+		return os.Remove(fn)
+	}
+
+	g := &gcGraph{
+		store:  store,
+		pkgs:   make(map[string]bool),
+		parent: make(map[string]string),
+		reason: make(map[string]string),
+	}
 	{
 		matches, err := filepath.Glob(filepath.Join(store, "*.squashfs"))
 		if err != nil {
@@ -56,68 +378,148 @@ func gc(ctx context.Context, args []string) error {
 		}
 		for _, match := range matches {
 			pkg := strings.TrimSuffix(filepath.Base(match), ".squashfs")
-			pv := distri.ParseVersion(pkg)
-			if _, ok := eligible[pv.Pkg]; !ok {
-				eligible[pv.Pkg] = make(map[string]bool)
+			if _, err := os.Stat(filepath.Join(store, pkg+".meta.textproto")); err != nil {
+				if !os.IsNotExist(err) {
+					return err
+				}
+				// An interrupted installation or gc run (which delete
+				// .meta.textproto first) left behind an orphaned image.
+				if *why == "" {
+					if err := remove(match); err != nil {
+						return err
+					}
+				}
+				continue
 			}
-			eligible[pv.Pkg][pkg] = true
+			g.pkgs[pkg] = true
 		}
+	}
 
-		// Keep the most recent package version around.
-		var kept []string
-		for pkg, pkgs := range eligible {
-			if len(pkgs) == 0 {
+	var pkgsets map[string]string
+	if *storeFlag == "" {
+		pkgsets, err = pkgsetRoots(*root)
+		if err != nil {
+			return err
+		}
+	}
+	if len(pkgsets) > 0 {
+		seeded, err := migrateLocalPkgset(*root, *dryRun || *why != "")
+		if err != nil {
+			return err
+		}
+		for _, pkg := range seeded {
+			pkgsets[pkg] = localPkgset + ".pkgset"
+		}
+	}
+	if len(pkgsets) == 0 {
+		// Keep the most recent revision of every package around.
+		newest := make(map[string]string)
+		for pkg := range g.pkgs {
+			pv := distri.ParseVersion(pkg)
+			if cur, ok := newest[pv.Pkg]; !ok || distri.PackageRevisionLess(cur, pkg) {
+				newest[pv.Pkg] = pkg
+			}
+		}
+		for _, pkg := range newest {
+			if err := g.addRoot(pkg, "most recent revision"); err != nil {
+				return err
+			}
+		}
+	} else {
+		entries := make([]string, 0, len(pkgsets))
+		for entry := range pkgsets {
+			entries = append(entries, entry)
+		}
+		sort.Strings(entries)
+		for _, entry := range entries {
+			pkg := newestRevision(g.pkgs, entry)
+			if pkg == "" {
+				log.Printf("package %s from %s not installed", entry, pkgsets[entry])
 				continue
 			}
-			if len(pkgs) == 1 {
-				for pkg := range pkgs {
-					kept = append(kept, pkg)
+			if err := g.addRoot(pkg, "listed in package set "+pkgsets[entry]); err != nil {
+				return err
+			}
+		}
+		for _, name := range []string{"base", "distri1"} {
+			if pkg := newestRevision(g.pkgs, name); pkg != "" {
+				if err := g.addRoot(pkg, "installed by distri update"); err != nil {
+					return err
 				}
-				eligible[pkg] = nil // keep the only revision around
-				continue
 			}
-			revs := make([]distri.PackageVersion, 0, len(pkgs))
-			for pkg := range pkgs {
-				revs = append(revs, distri.ParseVersion(pkg))
-			}
-			sort.Slice(revs, func(i, j int) bool {
-				revI := revs[i].DistriRevision
-				revJ := revs[j].DistriRevision
-				return revI >= revJ // reverse
-			})
-			mostRecent := revs[0].String()
-			delete(eligible[pkg], mostRecent) // keep in store
-			kept = append(kept, mostRecent)
-		}
-
-		// Keep all of their runtime dependencies around, too. This happens in a
-		// separate pass so that we can clearly attribute which package causes
-		// which other packages to stick around.
-		for _, mostRecent := range kept {
-			meta, err := pb.ReadMetaFile(filepath.Join(store, mostRecent+".meta.textproto"))
-			if err != nil {
+		}
+	}
+
+	if *storeFlag == "" {
+		if pkg := newestRevision(g.pkgs, "linux"); pkg != "" {
+			if err := g.addRoot(pkg, "most recent kernel (default boot entry)"); err != nil {
 				return err
 			}
-			for _, rd := range meta.GetRuntimeDep() {
-				pv := distri.ParseVersion(rd)
-				delete(eligible[pv.Pkg], rd)
+		}
+	}
+
+	if *storeFlag == "" && *root == "/" {
+		if pkg := runningKernel(g.pkgs); pkg != "" {
+			if err := g.addRoot(pkg, "running kernel"); err != nil {
+				return err
+			}
+		}
+
+		used, err := inUse(store, g.pkgs)
+		if err != nil {
+			return err
+		}
+		pkgs := make([]string, 0, len(used))
+		for pkg := range used {
+			pkgs = append(pkgs, pkg)
+		}
+		sort.Strings(pkgs)
+		for _, pkg := range pkgs {
+			if err := g.addRoot(pkg, "mounted or in use by "+used[pkg]); err != nil {
+				return err
 			}
 		}
 	}
 
-	// delete all eligible packages (first .meta.textproto, then .squashfs)
-	for _, pkgs := range eligible {
-		for pkg := range pkgs {
-			for _, suffix := range []string{".meta.textproto", ".squashfs"} {
-				fn := filepath.Join(store, pkg+suffix)
-				if *dryRun {
-					fmt.Printf("rm '%s'\n", fn)
-				} else {
-					if err := os.Remove(fn); err != nil {
-						return err
-					}
+	if *why != "" {
+		var pkgs []string
+		if g.pkgs[*why] {
+			pkgs = []string{*why}
+		} else {
+			for pkg := range g.pkgs {
+				if pv := distri.ParseVersion(pkg); pv.Pkg == *why || pv.Pkg+"-"+pv.Arch == *why {
+					pkgs = append(pkgs, pkg)
 				}
 			}
+			sort.Strings(pkgs)
+		}
+		if len(pkgs) == 0 {
+			return xerrors.Errorf("package %s not found in %s", *why, store)
+		}
+		for _, pkg := range pkgs {
+			chain := g.why(pkg)
+			if chain == nil {
+				fmt.Printf("%s is not referenced and will be deleted\n", pkg)
+				continue
+			}
+			fmt.Printf("%s is kept:\n", pkg)
+			fmt.Printf("  %s (%s)\n", chain[0], g.reason[chain[0]])
+			for _, p := range chain[1:] {
+				fmt.Printf("  → %s\n", p)
+			}
+		}
+		return nil
+	}
+
+	// delete all unreferenced packages (first .meta.textproto, then .squashfs)
+	for pkg := range g.pkgs {
+		if _, ok := g.parent[pkg]; ok {
+			continue
+		}
+		for _, suffix := range []string{".meta.textproto", ".squashfs"} {
+			if err := remove(filepath.Join(store, pkg+suffix)); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -126,11 +528,13 @@ func gc(ctx context.Context, args []string) error {
 		return nil
 	}
 
-	// TODO(correctness): delete all .squashfs without corresponding
-	// .meta.textproto (to recover from interruptions)
+	return scanPackages(ctx, *root)
+}
 
-	// Make the FUSE daemon update its packages.
-	ctl, err := os.Readlink(filepath.Join(*root, "ro", "ctl"))
+// scanPackages makes the FUSE daemon serving root/ro (if any) update its
+// packages.
+func scanPackages(ctx context.Context, root string) error {
+	ctl, err := os.Readlink(filepath.Join(root, "ro", "ctl"))
 	if err != nil {
 		log.Printf("not updating FUSE daemon: %v", err)
 		return nil // no FUSE daemon running?
@@ -141,6 +545,7 @@ func gc(ctx context.Context, args []string) error {
 	if err != nil {
 		return err
 	}
+	defer conn.Close()
 	cl := pb.NewFUSEClient(conn)
 	if _, err := cl.ScanPackages(ctx, &pb.ScanPackagesRequest{}); err != nil {
 		return err
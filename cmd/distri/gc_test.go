@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMigrateLocalPkgset(t *testing.T) {
+	root, err := ioutil.TempDir("", "distri-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	store := filepath.Join(root, "roimg")
+	pkgsetDir := filepath.Join(root, "etc", "distri", "pkgset.d")
+	for _, dir := range []string{store, pkgsetDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for pkg, meta := range map[string]string{
+		"base-amd64-1-1":        `runtime_dep: "glibc-amd64-2.31-4"`,
+		"glibc-amd64-2.31-4":    ``,
+		"i3status-amd64-2.13-2": `runtime_dep: "glibc-amd64-2.31-4"`,
+		"i3status-amd64-2.13-3": `runtime_dep: "glibc-amd64-2.31-4"`,
+		"zsh-amd64-5.6.2-3":     `runtime_dep: "glibc-amd64-2.31-4"`,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(store, pkg+".meta.textproto"), []byte(meta), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// e.g. written by distri pack:
+	if err := ioutil.WriteFile(filepath.Join(pkgsetDir, "extrabase.pkgset"), []byte("zsh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	seeded, err := migrateLocalPkgset(root, true /* dryRun */)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"i3status"}, seeded); diff != "" {
+		t.Errorf("migrateLocalPkgset: diff (-want +got):\n%s", diff)
+	}
+	fn := filepath.Join(pkgsetDir, localPkgset+".pkgset")
+	if _, err := os.Stat(fn); !os.IsNotExist(err) {
+		t.Fatalf("dry run unexpectedly wrote %s", fn)
+	}
+
+	if _, err := migrateLocalPkgset(root, false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("i3status\n", string(b)); diff != "" {
+		t.Errorf("local.pkgset: diff (-want +got):\n%s", diff)
+	}
+
+	// distri install adds to the migrated package set:
+	if err := addToPkgset(root, localPkgset, []string{"zsh", "htop"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("i3status\nhtop\n", string(b)); diff != "" {
+		t.Errorf("local.pkgset: diff (-want +got):\n%s", diff)
+	}
+}
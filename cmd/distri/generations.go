@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distr1/distri"
+	"github.com/distr1/distri/internal/install"
+	"github.com/distr1/distri/internal/storelock"
+	"github.com/distr1/distri/pb"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/renameio"
+	"golang.org/x/xerrors"
+	"google.golang.org/protobuf/encoding/prototext"
+)
+
+const generationsHelp = `distri generations [-flags] list
+distri generations [-flags] diff <n> [<m>]
+distri generations [-flags] rollback <n>
+
+Inspect and restore system generations.
+
+distri update records a generation (package store contents, package sets,
+kernel and initramfs) in /var/lib/distri/generations before and after
+updating.
+
+diff compares generation n with generation m (default: the current system).
+
+rollback restores generation n: missing packages are re-installed, packages
+which are not part of generation n are removed, package sets are restored and
+the kernel and CPU microcode hooks are run again.
+
+Example:
+  % distri generations list
+  % distri generations diff 3
+  % distri generations rollback 3
+`
+
+func generationsDir(root string) string {
+	return filepath.Join(root, "var", "lib", "distri", "generations")
+}
+
+// readGenerations returns all recorded generations, sorted by number.
+func readGenerations(root string) ([]*pb.Generation, error) {
+	dir := generationsDir(root)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var gens []*pb.Generation
+	for _, fi := range fis {
+		if !strings.HasSuffix(fi.Name(), ".textproto") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var gen pb.Generation
+		if err := (prototext.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, &gen); err != nil {
+			return nil, xerrors.Errorf("%s: %v", fi.Name(), err)
+		}
+		gens = append(gens, &gen)
+	}
+	sort.Slice(gens, func(i, j int) bool {
+		return gens[i].GetNumber() < gens[j].GetNumber()
+	})
+	return gens, nil
+}
+
+func findGeneration(gens []*pb.Generation, arg string) (*pb.Generation, error) {
+	n, err := strconv.ParseInt(arg, 0, 64)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid generation number %q: %v", arg, err)
+	}
+	for _, gen := range gens {
+		if gen.GetNumber() == n {
+			return gen, nil
+		}
+	}
+	return nil, xerrors.Errorf("generation %d not found", n)
+}
+
+// newestPackage returns the most recent revision of package name (e.g. linux)
+// within pkgs, or the empty string.
+func newestPackage(pkgs []string, name string) string {
+	var newest string
+	for _, pkg := range pkgs {
+		if distri.ParseVersion(pkg).Pkg != name {
+			continue
+		}
+		if newest == "" || distri.PackageRevisionLess(newest, pkg) {
+			newest = pkg
+		}
+	}
+	return newest
+}
+
+// snapshotGeneration returns the current state of the system at root as an
+// unnumbered generation.
+func snapshotGeneration(root string) (*pb.Generation, error) {
+	gen := &pb.Generation{
+		Timestamp: proto.Int64(time.Now().Unix()),
+	}
+
+	matches, err := filepath.Glob(filepath.Join(root, "roimg", "*.squashfs"))
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range matches {
+		gen.Package = append(gen.Package, strings.TrimSuffix(filepath.Base(m), ".squashfs"))
+	}
+	sort.Strings(gen.Package)
+
+	matches, err = filepath.Glob(filepath.Join(root, "etc", "distri", "pkgset.d", "*.pkgset"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	for _, m := range matches {
+		b, err := ioutil.ReadFile(m)
+		if err != nil {
+			return nil, err
+		}
+		pkgset := &pb.Generation_Pkgset{
+			Name: proto.String(strings.TrimSuffix(filepath.Base(m), ".pkgset")),
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			if line == "" {
+				continue
+			}
+			pkgset.Package = append(pkgset.Package, line)
+		}
+		gen.Pkgset = append(gen.Pkgset, pkgset)
+	}
+
+	if kernel := newestPackage(gen.Package, "linux"); kernel != "" {
+		gen.Kernel = proto.String(kernel)
+		gen.Initramfs = proto.String(install.InitramfsPath(kernel))
+	}
+	for _, name := range []string{"intel-ucode", "amd-ucode"} {
+		if ucode := newestPackage(gen.Package, name); ucode != "" {
+			gen.Ucode = append(gen.Ucode, ucode)
+		}
+	}
+
+	return gen, nil
+}
+
+// sameState returns whether a and b describe the same system state, i.e. are
+// equal except for bookkeeping fields.
+func sameState(a, b *pb.Generation) bool {
+	return cmp.Equal(a.GetPackage(), b.GetPackage()) &&
+		cmp.Equal(a.GetKernel(), b.GetKernel()) &&
+		cmp.Equal(a.GetUcode(), b.GetUcode()) &&
+		proto.Equal(&pb.Generation{Pkgset: a.GetPkgset()}, &pb.Generation{Pkgset: b.GetPkgset()})
+}
+
+// recordGeneration records the current state of the system at root as a new
+// generation, unless it is identical to the most recent generation. The caller
+// must hold the store lock.
+func recordGeneration(root, reason string) (*pb.Generation, error) {
+	gens, err := readGenerations(root)
+	if err != nil {
+		return nil, err
+	}
+	gen, err := snapshotGeneration(root)
+	if err != nil {
+		return nil, err
+	}
+	var number int64 = 1
+	if len(gens) > 0 {
+		latest := gens[len(gens)-1]
+		if sameState(latest, gen) {
+			return latest, nil
+		}
+		number = latest.GetNumber() + 1
+	}
+	gen.Number = proto.Int64(number)
+	gen.Reason = proto.String(reason)
+	fn := filepath.Join(generationsDir(root), fmt.Sprintf("%d.textproto", number))
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return nil, err
+	}
+	if err := renameio.WriteFile(fn, []byte(proto.MarshalTextString(gen)), 0644); err != nil {
+		return nil, err
+	}
+	log.Printf("recorded generation %d (%s)", number, reason)
+	return gen, nil
+}
+
+func stringSet(strs []string) map[string]bool {
+	set := make(map[string]bool, len(strs))
+	for _, s := range strs {
+		set[s] = true
+	}
+	return set
+}
+
+// diffGenerations returns a human-readable description of the changes from
+// generation a to generation b, one change per line.
+func diffGenerations(a, b *pb.Generation) []string {
+	var lines []string
+	before, after := stringSet(a.GetPackage()), stringSet(b.GetPackage())
+	for _, pkg := range a.GetPackage() {
+		if !after[pkg] {
+			lines = append(lines, "-"+pkg)
+		}
+	}
+	for _, pkg := range b.GetPackage() {
+		if !before[pkg] {
+			lines = append(lines, "+"+pkg)
+		}
+	}
+	pkgsets := func(gen *pb.Generation) map[string]string {
+		m := make(map[string]string)
+		for _, ps := range gen.GetPkgset() {
+			m[ps.GetName()] = strings.Join(ps.GetPackage(), " ")
+		}
+		return m
+	}
+	psBefore, psAfter := pkgsets(a), pkgsets(b)
+	var names []string
+	for name := range psBefore {
+		names = append(names, name)
+	}
+	for name := range psAfter {
+		if _, ok := psBefore[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if psBefore[name] != psAfter[name] {
+			lines = append(lines, fmt.Sprintf("pkgset %s: %q → %q", name, psBefore[name], psAfter[name]))
+		}
+	}
+	if a.GetKernel() != b.GetKernel() {
+		lines = append(lines, fmt.Sprintf("kernel: %q → %q", a.GetKernel(), b.GetKernel()))
+	}
+	if ua, ub := strings.Join(a.GetUcode(), " "), strings.Join(b.GetUcode(), " "); ua != ub {
+		lines = append(lines, fmt.Sprintf("ucode: %q → %q", ua, ub))
+	}
+	return lines
+}
+
+// rollback restores generation gen on the system at root. The caller must hold
+// the store lock.
+func rollback(ctx context.Context, root, repo string, gen *pb.Generation, dryRun bool) error {
+	current, err := snapshotGeneration(root)
+	if err != nil {
+		return err
+	}
+	for _, line := range diffGenerations(current, gen) {
+		log.Printf("rollback: %s", line)
+	}
+	if dryRun {
+		return nil
+	}
+
+	// Re-install packages which were removed since (e.g. by distri gc):
+	installed := stringSet(current.GetPackage())
+	var missing []string
+	for _, pkg := range gen.GetPackage() {
+		if !installed[pkg] {
+			missing = append(missing, pkg)
+		}
+	}
+	if len(missing) > 0 {
+		c := &install.Ctx{StoreLocked: true}
+		if err := c.Packages(missing, root, repo, false); err != nil {
+			return xerrors.Errorf("re-installing packages: %v", err)
+		}
+	}
+
+	// Remove packages which are not part of gen (first .meta.textproto, then
+	// .squashfs, like distri gc):
+	wanted := stringSet(gen.GetPackage())
+	for _, pkg := range current.GetPackage() {
+		if wanted[pkg] {
+			continue
+		}
+		for _, suffix := range []string{".meta.textproto", ".squashfs"} {
+			if err := os.Remove(filepath.Join(root, "roimg", pkg+suffix)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	// Restore package sets:
+	pkgsetDir := filepath.Join(root, "etc", "distri", "pkgset.d")
+	wantedPkgsets := make(map[string]bool)
+	for _, ps := range gen.GetPkgset() {
+		fn := ps.GetName() + ".pkgset"
+		wantedPkgsets[fn] = true
+		if err := os.MkdirAll(pkgsetDir, 0755); err != nil {
+			return err
+		}
+		b := []byte(strings.Join(ps.GetPackage(), "\n") + "\n")
+		if err := renameio.WriteFile(filepath.Join(pkgsetDir, fn), b, 0644); err != nil {
+			return err
+		}
+	}
+	for _, ps := range current.GetPkgset() {
+		fn := ps.GetName() + ".pkgset"
+		if wantedPkgsets[fn] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(pkgsetDir, fn)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Make the kernel and CPU microcode of gen the default again:
+	c := &install.Ctx{}
+	hookPkgs := gen.GetUcode()
+	if kernel := gen.GetKernel(); kernel != "" {
+		hookPkgs = append([]string{kernel}, hookPkgs...)
+	}
+	for _, pkg := range hookPkgs {
+		if err := c.RerunBootHooks(root, pkg); err != nil {
+			return xerrors.Errorf("%s: %v", pkg, err)
+		}
+	}
+
+	if err := scanPackages(ctx, root); err != nil {
+		return err
+	}
+
+	_, err = recordGeneration(root, fmt.Sprintf("rollback to generation %d", gen.GetNumber()))
+	return err
+}
+
+func generations(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("generations", flag.ExitOnError)
+	var (
+		root = fset.String("root",
+			"/",
+			"root directory for optionally operating on a chroot")
+
+		repo = fset.String("repo", "", "repository from which to re-install packages. path (default TODO) or HTTP URL (e.g. TODO)")
+
+		dryRun = fset.Bool("dry_run",
+			false,
+			"rollback: only print the changes which would be made")
+
+		wait = fset.Bool("wait",
+			false,
+			"wait for other processes modifying the package store instead of failing")
+	)
+	fset.Usage = usage(fset, generationsHelp)
+	fset.Parse(args)
+	if fset.NArg() < 1 {
+		fset.Usage()
+		os.Exit(2)
+	}
+	verb, args := fset.Arg(0), fset.Args()[1:]
+
+	if *repo != "" {
+		*repo = *repo + "/pkg"
+	}
+
+	gens, err := readGenerations(*root)
+	if err != nil {
+		return err
+	}
+
+	switch verb {
+	case "list":
+		current, err := snapshotGeneration(*root)
+		if err != nil {
+			return err
+		}
+		for _, gen := range gens {
+			marker := " "
+			if sameState(gen, current) {
+				marker = "*"
+			}
+			fmt.Printf("%s %4d  %s  %-32s  %d packages, kernel %s\n",
+				marker,
+				gen.GetNumber(),
+				time.Unix(gen.GetTimestamp(), 0).Format("2006-01-02 15:04:05"),
+				gen.GetReason(),
+				len(gen.GetPackage()),
+				gen.GetKernel())
+		}
+		return nil
+
+	case "diff":
+		if len(args) < 1 || len(args) > 2 {
+			return xerrors.Errorf("syntax: generations diff <n> [<m>]")
+		}
+		a, err := findGeneration(gens, args[0])
+		if err != nil {
+			return err
+		}
+		var b *pb.Generation
+		if len(args) == 2 {
+			b, err = findGeneration(gens, args[1])
+		} else {
+			b, err = snapshotGeneration(*root)
+		}
+		if err != nil {
+			return err
+		}
+		for _, line := range diffGenerations(a, b) {
+			fmt.Println(line)
+		}
+		return nil
+
+	case "rollback":
+		if len(args) != 1 {
+			return xerrors.Errorf("syntax: generations rollback <n>")
+		}
+		gen, err := findGeneration(gens, args[0])
+		if err != nil {
+			return err
+		}
+		lock, err := storelock.Acquire(filepath.Join(*root, "roimg"), *wait)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+		if !*dryRun {
+			// Ensure the current state can be restored, too:
+			if _, err := recordGeneration(*root, "before rollback"); err != nil {
+				return err
+			}
+		}
+		return rollback(ctx, *root, *repo, gen, *dryRun)
+
+	default:
+		return xerrors.Errorf("unknown generations command %q (expected one of list, diff, rollback)", verb)
+	}
+}
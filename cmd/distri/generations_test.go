@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestGenerations(t *testing.T) {
+	root, err := ioutil.TempDir("", "distri-generations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	touch := func(pkg string) {
+		t.Helper()
+		fn := filepath.Join(root, "roimg", pkg+".squashfs")
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	touch("bash-amd64-5.0-4")
+	touch("linux-amd64-5.6.5-15")
+
+	first, err := recordGeneration(root, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := first.GetNumber(), int64(1); got != want {
+		t.Fatalf("unexpected generation number: got %d, want %d", got, want)
+	}
+	if got, want := first.GetKernel(), "linux-amd64-5.6.5-15"; got != want {
+		t.Errorf("unexpected kernel: got %q, want %q", got, want)
+	}
+
+	// Recording an unchanged system must not result in a new generation:
+	same, err := recordGeneration(root, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := same.GetNumber(), int64(1); got != want {
+		t.Fatalf("unexpected generation number: got %d, want %d", got, want)
+	}
+
+	touch("linux-amd64-5.6.6-16")
+	if err := os.Remove(filepath.Join(root, "roimg", "bash-amd64-5.0-4.squashfs")); err != nil {
+		t.Fatal(err)
+	}
+	second, err := recordGeneration(root, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := second.GetNumber(), int64(2); got != want {
+		t.Fatalf("unexpected generation number: got %d, want %d", got, want)
+	}
+
+	gens, err := readGenerations(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(gens), 2; got != want {
+		t.Fatalf("unexpected number of generations: got %d, want %d", got, want)
+	}
+
+	want := []string{
+		"-bash-amd64-5.0-4",
+		"+linux-amd64-5.6.6-16",
+		`kernel: "linux-amd64-5.6.5-15" → "linux-amd64-5.6.6-16"`,
+	}
+	if diff := cmp.Diff(want, diffGenerations(gens[0], gens[1])); diff != "" {
+		t.Errorf("diffGenerations: unexpected diff (-want +got):\n%s", diff)
+	}
+}
@@ -3,10 +3,15 @@ package main
 import (
 	"context"
 	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 
 	// TODO: consider "github.com/klauspost/pgzip"
 
 	"github.com/distr1/distri/internal/install"
+	"github.com/google/renameio"
 	"golang.org/x/xerrors"
 )
 
@@ -14,6 +19,9 @@ const installHelp = `distri install [-flags] <package>…
 
 Install a distri package from a repository.
 
+Packages which are not yet part of a package set in /etc/distri/pkgset.d are
+added to local.pkgset, so that distri gc keeps them.
+
 Example:
   % distri install i3status
 `
@@ -29,6 +37,10 @@ func cmdinstall(ctx context.Context, args []string) error {
 
 		update = fset.Bool("update", false, "internal flag set by distri update, do not use")
 
+		wait = fset.Bool("wait", false, "wait for other processes modifying the package store instead of failing")
+
+		parallel = fset.Int("parallel", install.DefaultParallel, "maximum number of concurrent package downloads per repository")
+
 		//pkg = fset.String("pkg", "", "path to .squashfs package to mount")
 	)
 	fset.Usage = usage(fset, installHelp)
@@ -37,9 +49,58 @@ func cmdinstall(ctx context.Context, args []string) error {
 		return xerrors.Errorf("syntax: install [options] <package> [<package>...]")
 	}
 
-	c := &install.Ctx{}
+	c := &install.Ctx{
+		WaitForLock: *wait,
+		Parallel:    *parallel,
+	}
 	if *repo != "" {
 		*repo = *repo + "/pkg"
 	}
-	return c.Packages(fset.Args(), *root, *repo, *update)
+	if err := c.Packages(fset.Args(), *root, *repo, *update); err != nil {
+		return err
+	}
+	if *update {
+		return nil
+	}
+	return addToPkgset(*root, localPkgset, fset.Args())
+}
+
+// localPkgset is the package set to which distri install adds packages.
+const localPkgset = "local"
+
+// addToPkgset adds all pkgs which are not yet part of any package set in root
+// to the package set name.
+func addToPkgset(root, name string, pkgs []string) error {
+	if _, err := migrateLocalPkgset(root, false); err != nil {
+		return err
+	}
+	existing, err := pkgsetRoots(root)
+	if err != nil {
+		return err
+	}
+	fn := filepath.Join(root, "etc", "distri", "pkgset.d", name+".pkgset")
+	b, err := ioutil.ReadFile(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	content := string(b)
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	var added bool
+	for _, pkg := range pkgs {
+		if _, ok := existing[pkg]; ok {
+			continue
+		}
+		existing[pkg] = name + ".pkgset"
+		content += pkg + "\n"
+		added = true
+	}
+	if !added {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+	return renameio.WriteFile(fn, []byte(content), 0644)
 }
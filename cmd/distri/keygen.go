@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/distr1/distri/internal/signing"
+	"golang.org/x/xerrors"
+)
+
+const keygenHelp = `distri keygen [-flags] <basename>
+
+Generate a key pair for signing repositories with distri mirror -sign_key.
+
+The private key is written to <basename>.key, the public key to <basename>.pub.
+Copy the public key into /etc/distri/keys.d on all machines which should
+verify packages from the repository.
+
+Example:
+  % distri keygen ~/.config/distri/repo
+`
+
+func keygen(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("keygen", flag.ExitOnError)
+	var (
+		comment = fset.String("comment", "", "free-form comment to include in the key files")
+	)
+	fset.Usage = usage(fset, keygenHelp)
+	fset.Parse(args)
+	if fset.NArg() != 1 {
+		return xerrors.Errorf("syntax: keygen [options] <basename>")
+	}
+	base := fset.Arg(0)
+
+	pub, priv, err := signing.GenerateKey(*comment)
+	if err != nil {
+		return err
+	}
+	// O_EXCL: never overwrite an existing private key.
+	f, err := os.OpenFile(base+".key", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(priv); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(base+".pub", pub, 0644); err != nil {
+		return err
+	}
+	log.Printf("wrote private key to %s.key, public key to %s.pub", base, base)
+	return nil
+}
@@ -10,7 +10,9 @@ import (
 	"strings"
 
 	"github.com/distr1/distri/internal/fuse"
+	"github.com/distr1/distri/internal/signing"
 	"github.com/distr1/distri/internal/squashfs"
+	"github.com/distr1/distri/internal/storelock"
 	"github.com/distr1/distri/pb"
 	"github.com/golang/protobuf/proto"
 	"github.com/google/renameio"
@@ -21,11 +23,19 @@ const mirrorHelp = `distri mirror [-flags]
 Make a package store fully usable as a repository
 by bundling metadata from packages into meta.binaryproto.
 
+The metadata includes the size and SHA-256 hash of each package file, which
+distri install and distri fuse -autodownload use to detect corrupted downloads.
+
 This is not required for distri install to work, but e.g. for debugfs.
 
+distri mirror also writes a SHA256SUMS manifest covering all files. When
+-sign_key is specified, the manifest is signed (SHA256SUMS.sig), and clients
+with the corresponding public key in /etc/distri/keys.d will verify every
+package they install. Use distri keygen to create a key pair.
+
 Example:
   % cd distri/build/distri/pkg
-  % distri mirror
+  % distri mirror -sign_key ~/.config/distri/repo.key
 `
 
 // TODO: have export automatically call mirror
@@ -53,10 +63,32 @@ func walk(rd *squashfs.Reader, dirInode squashfs.Inode, dir string) ([]string, e
 
 func mirror(ctx context.Context, args []string) error {
 	fset := flag.NewFlagSet("mirror", flag.ExitOnError)
+	var (
+		signKey = fset.String("sign_key", "", "if non-empty, path to a private key (see distri keygen) with which to sign the manifest")
+		wait    = fset.Bool("wait", false, "wait for other processes modifying the package store instead of failing")
+	)
 	fset.Usage = usage(fset, mirrorHelp)
 	fset.Parse(args)
 
+	var key *signing.PrivateKey
+	if *signKey != "" {
+		var err error
+		key, err = signing.ReadPrivateKey(*signKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Lock the directory itself: a lock file would be published along with
+	// the repository.
+	lock, err := storelock.AcquireDir(".", *wait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
 	var mm pb.MirrorMeta
+	manifest := make(signing.Manifest)
 
 	fis, err := ioutil.ReadDir(".")
 	if err != nil {
@@ -71,6 +103,35 @@ func mirror(ctx context.Context, args []string) error {
 			Name: proto.String(pkg),
 		}
 
+		squashfsHash, err := signing.HashFile(fi.Name())
+		if err != nil {
+			return err
+		}
+		manifest[fi.Name()] = squashfsHash
+		mmp.SquashfsSha256 = proto.String(squashfsHash)
+		mmp.SquashfsSize = proto.Int64(fi.Size())
+
+		metaFn := pkg + ".meta.textproto"
+		if st, err := os.Stat(metaFn); err == nil {
+			metaHash, err := signing.HashFile(metaFn)
+			if err != nil {
+				return err
+			}
+			manifest[metaFn] = metaHash
+			mmp.MetaSha256 = proto.String(metaHash)
+			mmp.MetaSize = proto.Int64(st.Size())
+			meta, err := pb.ReadMetaFile(metaFn)
+			if err != nil {
+				return err
+			}
+			mmp.RuntimeDep = meta.GetRuntimeDep()
+			mmp.Description = meta.Description
+			mmp.Homepage = meta.Homepage
+			mmp.License = meta.License
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
 		f, err := os.Open(fi.Name())
 		if err != nil {
 			return err
@@ -111,5 +172,23 @@ func mirror(ctx context.Context, args []string) error {
 	}
 	log.Printf("wrote %d packages to meta.binaryproto (%d bytes)", len(mm.Package), len(b))
 
+	manifest["meta.binaryproto"] = signing.HashBytes(b)
+	mb := manifest.Marshal()
+	if err := renameio.WriteFile(signing.ManifestName, mb, 0644); err != nil {
+		return err
+	}
+	if key == nil {
+		// A stale signature would not match the new manifest.
+		if err := os.Remove(signing.SignatureName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Printf("wrote unsigned %s covering %d files", signing.ManifestName, len(manifest))
+		return nil
+	}
+	if err := renameio.WriteFile(signing.SignatureName, key.Sign(mb), 0644); err != nil {
+		return err
+	}
+	log.Printf("wrote %s covering %d files, signed with key %s", signing.ManifestName, len(manifest), key.ID)
+
 	return nil
 }
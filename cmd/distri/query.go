@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/distr1/distri"
+	"github.com/distr1/distri/internal/env"
+	"github.com/distr1/distri/internal/repo"
+	"github.com/distr1/distri/internal/squashfs"
+	"github.com/distr1/distri/pb"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/xerrors"
+)
+
+const queryHelp = `distri query [-flags] files <package>
+distri query [-flags] owner <path>…
+distri query [-flags] rdeps <package>
+distri query [-flags] deps <package>
+
+Query package contents and dependencies.
+
+files lists the files shipped by a package.
+owner prints the packages providing a path (e.g. /ro/bin/i3status).
+rdeps lists the packages which depend on a package.
+deps lists the runtime dependencies of a package.
+
+By default, the local package store (/roimg) is queried. With -remote (or
+-repo), the meta.binaryproto of the repositories is queried instead. Note that
+remote repositories only list files in exchange directories (e.g. bin,
+out/lib), so files and owner queries are limited to those.
+
+Example:
+  % distri query owner /ro/bin/i3status
+  % distri query -remote rdeps libxcb
+`
+
+// queryPackage is a package as seen by distri query.
+type queryPackage struct {
+	name        string   // e.g. i3status-amd64-2.13-3
+	runtimeDeps []string // e.g. yajl-amd64-2.1.0-4
+	description string
+	homepage    string
+	license     string
+
+	// Either image (local store) or wellKnownPaths (remote repository) is
+	// set.
+	image          string
+	wellKnownPaths []string
+}
+
+// localPackages returns all packages in the package store directory store.
+func localPackages(store string) ([]queryPackage, error) {
+	matches, err := filepath.Glob(filepath.Join(store, "*.meta.textproto"))
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make([]queryPackage, 0, len(matches))
+	for _, m := range matches {
+		name := strings.TrimSuffix(filepath.Base(m), ".meta.textproto")
+		image := filepath.Join(store, name+".squashfs")
+		if _, err := os.Stat(image); err != nil {
+			continue // not (yet) installed
+		}
+		meta, err := pb.ReadMetaFile(m)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, queryPackage{
+			name:        name,
+			runtimeDeps: meta.GetRuntimeDep(),
+			description: meta.GetDescription(),
+			homepage:    meta.GetHomepage(),
+			license:     meta.GetLicense(),
+			image:       image,
+		})
+	}
+	return pkgs, nil
+}
+
+// remotePackages returns all packages listed in the meta.binaryproto of
+// repos.
+func remotePackages(ctx context.Context, repos []distri.Repo) ([]queryPackage, error) {
+	var pkgs []queryPackage
+	for _, r := range repos {
+		rd, err := repo.Reader(ctx, r, "meta.binaryproto", true /* cache */)
+		if err != nil {
+			if isNotExist(err) {
+				log.Printf("repo %s has no meta.binaryproto (see distri mirror), skipping", r.PkgPath)
+				continue
+			}
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			return nil, err
+		}
+		var mm pb.MirrorMeta
+		if err := proto.Unmarshal(b, &mm); err != nil {
+			return nil, err
+		}
+		for _, pkg := range mm.GetPackage() {
+			pkgs = append(pkgs, queryPackage{
+				name:           pkg.GetName(),
+				runtimeDeps:    pkg.GetRuntimeDep(),
+				description:    pkg.GetDescription(),
+				homepage:       pkg.GetHomepage(),
+				license:        pkg.GetLicense(),
+				wellKnownPaths: pkg.GetWellKnownPath(),
+			})
+		}
+	}
+	return pkgs, nil
+}
+
+// resolvePackage returns the most recent revision of the package called name
+// (see matchesPackage) within pkgs.
+func resolvePackage(pkgs []queryPackage, name string) (queryPackage, error) {
+	var (
+		newest queryPackage
+		found  bool
+	)
+	for _, pkg := range pkgs {
+		if !matchesPackage(name, pkg.name) {
+			continue
+		}
+		if !found || distri.PackageRevisionLess(newest.name, pkg.name) {
+			newest = pkg
+			found = true
+		}
+	}
+	if !found {
+		return queryPackage{}, xerrors.Errorf("package %s not found", name)
+	}
+	return newest, nil
+}
+
+// imageFiles returns the paths of all regular files and symbolic links within
+// the package image.
+func imageFiles(image string) ([]string, error) {
+	f, err := os.Open(image)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rd, err := squashfs.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	var walk func(inode squashfs.Inode, dir string) error
+	walk = func(inode squashfs.Inode, dir string) error {
+		fis, err := rd.Readdir(inode)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			fn := filepath.Join(dir, fi.Name())
+			if fi.IsDir() {
+				if err := walk(fi.Sys().(*squashfs.FileInfo).Inode, fn); err != nil {
+					return err
+				}
+				continue
+			}
+			files = append(files, fn)
+		}
+		return nil
+	}
+	if err := walk(rd.RootInode(), ""); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (p queryPackage) files() ([]string, error) {
+	if p.image == "" {
+		return p.wellKnownPaths, nil
+	}
+	return imageFiles(p.image)
+}
+
+// ownerCandidates returns the paths within a package which the path (e.g.
+// /ro/bin/i3status or /ro/lib/libyajl.so) can refer to, and the package
+// directory the path explicitly names, if any (e.g. /ro/yajl-amd64-2.1.0-4/…).
+func ownerCandidates(path string) (pkg string, candidates []string) {
+	rel := strings.TrimPrefix(filepath.Clean(path), "/ro/")
+	rel = strings.TrimPrefix(rel, "/")
+	if idx := strings.IndexByte(rel, '/'); idx > -1 && distri.LikelyFullySpecified(rel[:idx]) {
+		return rel[:idx], []string{rel[idx+1:]}
+	}
+	// Exchange directories (e.g. /ro/lib) are backed by out/ (e.g. out/lib),
+	// with the exception of /ro/bin.
+	return "", []string{rel, "out/" + rel}
+}
+
+// owners returns the names of the packages providing path.
+func owners(pkgs []queryPackage, path string) ([]string, error) {
+	pkgName, candidates := ownerCandidates(path)
+	var result []string
+	for _, p := range pkgs {
+		if pkgName != "" && p.name != pkgName {
+			continue
+		}
+		if p.image == "" {
+			wellKnown := make(map[string]bool, len(p.wellKnownPaths))
+			for _, wk := range p.wellKnownPaths {
+				wellKnown[wk] = true
+			}
+			for _, c := range candidates {
+				if wellKnown[c] {
+					result = append(result, p.name)
+					break
+				}
+			}
+			continue
+		}
+		found, err := imageContains(p.image, candidates)
+		if err != nil {
+			return nil, xerrors.Errorf("%s: %v", p.image, err)
+		}
+		if found {
+			result = append(result, p.name)
+		}
+	}
+	return result, nil
+}
+
+// imageContains returns whether one of paths exists in the package image.
+func imageContains(image string, paths []string) (bool, error) {
+	f, err := os.Open(image)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	rd, err := squashfs.NewReader(f)
+	if err != nil {
+		return false, err
+	}
+	for _, path := range paths {
+		if _, err := rd.LlookupPath(path); err == nil {
+			return true, nil
+		} else if _, ok := err.(*squashfs.FileNotFoundError); !ok {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func query(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("query", flag.ExitOnError)
+	var (
+		root = fset.String("root",
+			"/",
+			"root directory for optionally operating on a chroot")
+
+		remote = fset.Bool("remote",
+			false,
+			"query the configured repositories instead of the local package store")
+
+		repoFlag = fset.String("repo",
+			"",
+			"if non-empty, query this repository instead of the local package store. path or HTTP URL (e.g. https://repo.distr1.org/distri/jackherer)")
+	)
+	fset.Usage = usage(fset, queryHelp)
+	fset.Parse(args)
+	if fset.NArg() < 2 {
+		fset.Usage()
+		os.Exit(2)
+	}
+	verb, args := fset.Arg(0), fset.Args()[1:]
+
+	var (
+		pkgs []queryPackage
+		err  error
+	)
+	switch {
+	case *repoFlag != "":
+		pkgs, err = remotePackages(ctx, []distri.Repo{{Path: *repoFlag, PkgPath: *repoFlag + "/pkg"}})
+	case *remote:
+		var repos []distri.Repo
+		repos, err = env.Repos()
+		if err != nil {
+			return err
+		}
+		pkgs, err = remotePackages(ctx, repos)
+	default:
+		pkgs, err = localPackages(filepath.Join(*root, "roimg"))
+	}
+	if err != nil {
+		return err
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].name < pkgs[j].name })
+
+	switch verb {
+	case "files":
+		if len(args) != 1 {
+			return xerrors.Errorf("syntax: query files <package>")
+		}
+		pkg, err := resolvePackage(pkgs, args[0])
+		if err != nil {
+			return err
+		}
+		files, err := pkg.files()
+		if err != nil {
+			return err
+		}
+		for _, fn := range files {
+			fmt.Println("/ro/" + pkg.name + "/" + fn)
+		}
+
+	case "owner":
+		for _, path := range args {
+			result, err := owners(pkgs, path)
+			if err != nil {
+				return err
+			}
+			if len(result) == 0 {
+				return xerrors.Errorf("%s: not provided by any package", path)
+			}
+			for _, pkg := range result {
+				fmt.Printf("%s: %s\n", path, pkg)
+			}
+		}
+
+	case "rdeps":
+		if len(args) != 1 {
+			return xerrors.Errorf("syntax: query rdeps <package>")
+		}
+		var found bool
+		for _, pkg := range pkgs {
+			if matchesPackage(args[0], pkg.name) {
+				found = true
+			}
+			for _, dep := range pkg.runtimeDeps {
+				if matchesPackage(args[0], dep) && !matchesPackage(args[0], pkg.name) {
+					fmt.Println(pkg.name)
+					break
+				}
+			}
+		}
+		if !found {
+			return xerrors.Errorf("package %s not found", args[0])
+		}
+
+	case "deps":
+		if len(args) != 1 {
+			return xerrors.Errorf("syntax: query deps <package>")
+		}
+		pkg, err := resolvePackage(pkgs, args[0])
+		if err != nil {
+			return err
+		}
+		for _, dep := range pkg.runtimeDeps {
+			fmt.Println(dep)
+		}
+
+	default:
+		return xerrors.Errorf("unknown query %q (expected one of files, owner, rdeps, deps)", verb)
+	}
+	return nil
+}
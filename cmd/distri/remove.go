@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/distr1/distri"
+	"github.com/distr1/distri/internal/storelock"
+	"github.com/distr1/distri/pb"
+	"github.com/google/renameio"
+	"golang.org/x/xerrors"
+)
+
+const removeHelp = `distri remove [-flags] <package>…
+
+Remove installed packages.
+
+The packages are removed from the package sets in /etc/distri/pkgset.d, and
+their images are deleted from the package store. distri remove refuses to
+remove packages which other installed packages depend on, unless -force is
+specified.
+
+A package can be specified with (e.g. i3status-amd64-2.13-3) or without
+version (e.g. i3status), in which case all installed revisions are removed.
+
+Example:
+  % distri remove i3status
+`
+
+// matchesPackage returns whether name (e.g. i3status, i3status-amd64 or
+// i3status-amd64-2.13-3) refers to the package pkg (e.g.
+// i3status-amd64-2.13-3).
+func matchesPackage(name, pkg string) bool {
+	if name == pkg {
+		return true
+	}
+	pv := distri.ParseVersion(pkg)
+	return pv.Pkg == name || pv.Pkg+"-"+pv.Arch == name
+}
+
+// removeFromPkgsets removes all entries referring to one of pkgs from the
+// package sets in root. Entries which still refer to one of the remaining
+// packages (e.g. i3status when only an old revision of i3status is removed)
+// are kept.
+func removeFromPkgsets(root string, pkgs map[string]bool, remaining []string, dryRun bool) error {
+	matches, err := filepath.Glob(filepath.Join(root, "etc", "distri", "pkgset.d", "*.pkgset"))
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		b, err := ioutil.ReadFile(m)
+		if err != nil {
+			return err
+		}
+		var (
+			kept    []string
+			removed bool
+		)
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			entry := strings.TrimSpace(line)
+			if entry == "" {
+				continue
+			}
+			var matched bool
+			for pkg := range pkgs {
+				if matchesPackage(entry, pkg) {
+					matched = true
+					break
+				}
+			}
+			if matched {
+				for _, pkg := range remaining {
+					if matchesPackage(entry, pkg) {
+						matched = false // still installed, keep the gc root
+						break
+					}
+				}
+			}
+			if matched {
+				removed = true
+				log.Printf("removing %s from %s", entry, m)
+				continue
+			}
+			kept = append(kept, line)
+		}
+		if !removed || dryRun {
+			continue
+		}
+		if len(kept) == 0 {
+			if err := os.Remove(m); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := renameio.WriteFile(m, []byte(strings.Join(kept, "\n")+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func remove(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("remove", flag.ExitOnError)
+	var (
+		root = fset.String("root",
+			"/",
+			"root directory for optionally operating on a chroot")
+
+		force = fset.Bool("force",
+			false,
+			"remove packages even if other installed packages depend on them")
+
+		dryRun = fset.Bool("dry_run",
+			false,
+			"only print which files would otherwise be deleted")
+
+		wait = fset.Bool("wait",
+			false,
+			"wait for other processes modifying the package store instead of failing")
+	)
+	fset.Usage = usage(fset, removeHelp)
+	fset.Parse(args)
+	if fset.NArg() < 1 {
+		return xerrors.Errorf("syntax: remove [options] <package> [<package>...]")
+	}
+
+	store := filepath.Join(*root, "roimg")
+	lock, err := storelock.Acquire(store, *wait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	matches, err := filepath.Glob(filepath.Join(store, "*.squashfs"))
+	if err != nil {
+		return err
+	}
+	installed := make([]string, 0, len(matches))
+	for _, m := range matches {
+		installed = append(installed, strings.TrimSuffix(filepath.Base(m), ".squashfs"))
+	}
+	sort.Strings(installed)
+
+	targets := make(map[string]bool)
+	for _, name := range fset.Args() {
+		var found bool
+		for _, pkg := range installed {
+			if matchesPackage(name, pkg) {
+				targets[pkg] = true
+				found = true
+			}
+		}
+		if !found {
+			return xerrors.Errorf("package %s is not installed", name)
+		}
+	}
+
+	// Refuse to remove packages which remaining packages depend on.
+	rdeps := make(map[string][]string)
+	for _, pkg := range installed {
+		if targets[pkg] {
+			continue
+		}
+		meta, err := pb.ReadMetaFile(filepath.Join(store, pkg+".meta.textproto"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // orphaned image, see distri gc
+			}
+			return err
+		}
+		for _, dep := range meta.GetRuntimeDep() {
+			if targets[dep] {
+				rdeps[dep] = append(rdeps[dep], pkg)
+			}
+		}
+	}
+	if len(rdeps) > 0 {
+		var msgs []string
+		for dep, pkgs := range rdeps {
+			msgs = append(msgs, fmt.Sprintf("%s is required by %s", dep, strings.Join(pkgs, ", ")))
+		}
+		sort.Strings(msgs)
+		if !*force {
+			return xerrors.Errorf("refusing to remove packages (use -force to remove anyway):\n%s", strings.Join(msgs, "\n"))
+		}
+		for _, msg := range msgs {
+			log.Printf("-force: removing anyway: %s", msg)
+		}
+	}
+
+	remaining := make([]string, 0, len(installed))
+	for _, pkg := range installed {
+		if !targets[pkg] {
+			remaining = append(remaining, pkg)
+		}
+	}
+	if err := removeFromPkgsets(*root, targets, remaining, *dryRun); err != nil {
+		return err
+	}
+
+	// delete images (first .meta.textproto, then .squashfs)
+	for _, pkg := range installed {
+		if !targets[pkg] {
+			continue
+		}
+		for _, suffix := range []string{".meta.textproto", ".squashfs"} {
+			fn := filepath.Join(store, pkg+suffix)
+			if *dryRun {
+				fmt.Printf("rm '%s'\n", fn)
+				continue
+			}
+			if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	if *dryRun {
+		return nil
+	}
+
+	return scanPackages(ctx, *root)
+}
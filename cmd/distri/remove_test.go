@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRemoveFromPkgsets(t *testing.T) {
+	root, err := ioutil.TempDir("", "distri-remove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	pkgsetDir := filepath.Join(root, "etc", "distri", "pkgset.d")
+	if err := os.MkdirAll(pkgsetDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	const pkgset = `i3status
+i3status-amd64-2.13-2
+zsh
+`
+	fn := filepath.Join(pkgsetDir, "extrabase.pkgset")
+	if err := ioutil.WriteFile(fn, []byte(pkgset), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the old revision keeps the unversioned entry, which still
+	// refers to the new revision:
+	targets := map[string]bool{"i3status-amd64-2.13-2": true}
+	remaining := []string{"i3status-amd64-2.13-3", "zsh-amd64-5.6.2-3"}
+	if err := removeFromPkgsets(root, targets, remaining, false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("i3status\nzsh\n", string(b)); diff != "" {
+		t.Errorf("pkgset: diff (-want +got):\n%s", diff)
+	}
+
+	// Removing the last revision removes the unversioned entry:
+	targets = map[string]bool{"i3status-amd64-2.13-3": true}
+	remaining = []string{"zsh-amd64-5.6.2-3"}
+	if err := removeFromPkgsets(root, targets, remaining, false); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("zsh\n", string(b)); diff != "" {
+		t.Errorf("pkgset: diff (-want +got):\n%s", diff)
+	}
+}
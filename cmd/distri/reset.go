@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+
+	"github.com/distr1/distri/internal/storelock"
 )
 
 const resetHelp = `distri reset [-flags] <path/to/files.before.txt>
@@ -29,6 +31,9 @@ func reset(ctx context.Context, args []string) error {
 		write = fset.Bool("w",
 			false,
 			"write changes (default is dry run)")
+		wait = fset.Bool("wait",
+			false,
+			"wait for other processes modifying the package store instead of failing")
 	)
 	fset.Usage = usage(fset, resetHelp)
 	fset.Parse(args)
@@ -48,6 +53,11 @@ func reset(ctx context.Context, args []string) error {
 		keep[pkg] = true
 	}
 	roimg := filepath.Join(*root, "roimg")
+	lock, err := storelock.Acquire(roimg, *wait)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
 	log.Printf("resetting package store %s to contents %s", roimg, before)
 	f, err := os.Open(roimg)
 	if err != nil {
@@ -60,7 +70,7 @@ func reset(ctx context.Context, args []string) error {
 	}
 	sort.Strings(names)
 	for _, n := range names {
-		if keep[n] {
+		if keep[n] || n == storelock.Name {
 			continue
 		}
 		log.Printf("deleting %s", n)
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/distr1/distri"
+	"github.com/distr1/distri/internal/env"
+	"golang.org/x/xerrors"
+)
+
+const searchHelp = `distri search [-flags] <term>…
+
+Search packages of all configured repositories by name, description and file
+paths (in exchange directories, e.g. bin/i3status).
+
+All terms must match (case-insensitively). Results are ranked: exact name
+matches first, then name prefixes and substrings, then matches in the
+description, then matches in file names.
+
+Example:
+  % distri search status bar
+  % distri search -local ssh
+`
+
+// Scores for the different places in which a search term can match.
+const (
+	scoreNameExact     = 100
+	scoreNamePrefix    = 50
+	scoreNameSubstring = 30
+	scoreDescWord      = 10
+	scoreDescSubstring = 5
+	scoreFileExact     = 8
+	scoreFileSubstring = 2
+)
+
+// searchResult is a package matching all search terms.
+type searchResult struct {
+	pkg   queryPackage
+	score int
+}
+
+// scoreTerm returns how well term (lower case) matches pkg, or 0 if it does
+// not match at all.
+func scoreTerm(pkg queryPackage, term string) int {
+	name := strings.ToLower(distri.ParseVersion(pkg.name).Pkg)
+	var score int
+	switch {
+	case name == term:
+		score += scoreNameExact
+	case strings.HasPrefix(name, term):
+		score += scoreNamePrefix
+	case strings.Contains(name, term):
+		score += scoreNameSubstring
+	}
+
+	desc := strings.ToLower(pkg.description)
+	if strings.Contains(desc, term) {
+		score += scoreDescSubstring
+		for _, word := range strings.FieldsFunc(desc, func(r rune) bool {
+			return !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-' || r == '+')
+		}) {
+			if word == term {
+				score += scoreDescWord
+				break
+			}
+		}
+	}
+
+	var fileScore int
+	for _, path := range pkg.wellKnownPaths {
+		base := strings.ToLower(filepath.Base(path))
+		if base == term {
+			fileScore = scoreFileExact
+			break
+		}
+		if fileScore == 0 && strings.Contains(base, term) {
+			fileScore = scoreFileSubstring
+		}
+	}
+	return score + fileScore
+}
+
+// search returns the most recent revision of all packages in pkgs which match
+// all terms, highest ranked first.
+func search(pkgs []queryPackage, terms []string) []searchResult {
+	newest := make(map[string]queryPackage)
+	for _, pkg := range pkgs {
+		pv := distri.ParseVersion(pkg.name)
+		key := pv.Pkg + "-" + pv.Arch
+		if cur, ok := newest[key]; !ok || distri.PackageRevisionLess(cur.name, pkg.name) {
+			newest[key] = pkg
+		}
+	}
+
+	var results []searchResult
+	for _, pkg := range newest {
+		total := 0
+		for _, term := range terms {
+			score := scoreTerm(pkg, strings.ToLower(term))
+			if score == 0 {
+				total = 0
+				break
+			}
+			total += score
+		}
+		if total == 0 {
+			continue
+		}
+		results = append(results, searchResult{pkg: pkg, score: total})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].pkg.name < results[j].pkg.name
+	})
+	return results
+}
+
+func cmdsearch(ctx context.Context, args []string) error {
+	fset := flag.NewFlagSet("search", flag.ExitOnError)
+	var (
+		root = fset.String("root",
+			"/",
+			"root directory for optionally operating on a chroot (with -local)")
+
+		local = fset.Bool("local",
+			false,
+			"search the local package store instead of the configured repositories")
+
+		repoFlag = fset.String("repo",
+			"",
+			"if non-empty, search this repository instead of the configured repositories. path or HTTP URL (e.g. https://repo.distr1.org/distri/jackherer)")
+	)
+	fset.Usage = usage(fset, searchHelp)
+	fset.Parse(args)
+	if fset.NArg() < 1 {
+		return xerrors.Errorf("syntax: search [options] <term> [<term>...]")
+	}
+
+	var (
+		pkgs []queryPackage
+		err  error
+	)
+	switch {
+	case *local:
+		pkgs, err = localPackages(filepath.Join(*root, "roimg"))
+	case *repoFlag != "":
+		pkgs, err = remotePackages(ctx, []distri.Repo{{Path: *repoFlag, PkgPath: *repoFlag + "/pkg"}})
+	default:
+		var repos []distri.Repo
+		repos, err = env.Repos()
+		if err != nil {
+			return err
+		}
+		pkgs, err = remotePackages(ctx, repos)
+	}
+	if err != nil {
+		return err
+	}
+
+	results := search(pkgs, fset.Args())
+	if len(results) == 0 {
+		fmt.Fprintf(os.Stderr, "no packages found\n")
+		os.Exit(1)
+	}
+	for _, r := range results {
+		fmt.Println(r.pkg.name)
+		if r.pkg.description != "" {
+			fmt.Printf("    %s\n", r.pkg.description)
+		}
+		var details []string
+		if r.pkg.homepage != "" {
+			details = append(details, r.pkg.homepage)
+		}
+		if r.pkg.license != "" {
+			details = append(details, "license: "+r.pkg.license)
+		}
+		if len(details) > 0 {
+			fmt.Printf("    %s\n", strings.Join(details, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSearch(t *testing.T) {
+	pkgs := []queryPackage{
+		{
+			name:           "i3status-amd64-2.13-2",
+			description:    "Generates status bar to use with i3bar",
+			wellKnownPaths: []string{"bin/i3status"},
+		},
+		{
+			name:           "i3status-amd64-2.13-3",
+			description:    "Generates status bar to use with i3bar",
+			wellKnownPaths: []string{"bin/i3status"},
+		},
+		{
+			name:           "i3-amd64-4.18-9",
+			description:    "Tiling window manager",
+			wellKnownPaths: []string{"bin/i3", "bin/i3-msg"},
+		},
+		{
+			name:           "polybar-amd64-3.4.3-5",
+			description:    "A fast and easy-to-use status bar",
+			wellKnownPaths: []string{"bin/polybar"},
+		},
+		{
+			name:           "zsh-amd64-5.6.2-3",
+			wellKnownPaths: []string{"bin/zsh"},
+		},
+	}
+
+	for _, tt := range []struct {
+		terms []string
+		want  []string
+	}{
+		{
+			terms: []string{"i3"},
+			want:  []string{"i3-amd64-4.18-9", "i3status-amd64-2.13-3"},
+		},
+		{
+			terms: []string{"Status", "bar"},
+			want:  []string{"i3status-amd64-2.13-3", "polybar-amd64-3.4.3-5"},
+		},
+		{
+			terms: []string{"i3-msg"},
+			want:  []string{"i3-amd64-4.18-9"},
+		},
+		{
+			terms: []string{"emacs"},
+			want:  nil,
+		},
+	} {
+		var got []string
+		for _, r := range search(pkgs, tt.terms) {
+			got = append(got, r.pkg.name)
+		}
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("search(%q): unexpected results: diff (-want +got):\n%s", tt.terms, diff)
+		}
+	}
+}
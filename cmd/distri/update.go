@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/distr1/distri/internal/install"
+	"github.com/distr1/distri/internal/storelock"
 	"github.com/distr1/distri/pb"
 	"golang.org/x/xerrors"
 )
@@ -35,6 +36,9 @@ func update(ctx context.Context, args []string) error {
 
 		repo   = fset.String("repo", "", "repository from which to install packages from. path (default TODO) or HTTP URL (e.g. TODO)")
 		pkgset = fset.String("pkgset", "", "if non-empty, a package set to update")
+		wait   = fset.Bool("wait", false, "wait for other processes modifying the package store instead of failing")
+
+		parallel = fset.Int("parallel", install.DefaultParallel, "maximum number of concurrent package downloads per repository")
 	)
 	fset.Usage = usage(fset, updateHelp)
 	fset.Parse(args)
@@ -49,30 +53,69 @@ func update(ctx context.Context, args []string) error {
 	}
 
 	if os.Getenv("DISTRI_REEXEC") != "1" {
+		lock, err := storelock.Acquire(filepath.Join(*root, "roimg"), *wait)
+		if err != nil {
+			return err
+		}
 		if err := persistFileListing(fileListingFileName(*root, updateStart, "files.before.txt"), filepath.Join(*root, "roimg")); err != nil {
+			lock.Release()
+			return err
+		}
+		// Record the state before updating so that distri generations rollback
+		// can restore it:
+		if _, err := recordGeneration(*root, "before distri update"); err != nil {
+			lock.Release()
 			return err
 		}
 
-		c := &install.Ctx{}
+		c := &install.Ctx{StoreLocked: true, Parallel: *parallel}
 		if err := c.Packages([]string{"distri1"}, *root, *repo, false); err != nil {
+			lock.Release()
 			return err
 		}
-
+		// The re-executed process inherits the lock (as file descriptor 3), so
+		// that no other process can modify the store in between.
 		cmd := exec.Command("distri", append([]string{"update"}, args...)...)
 		log.Printf("re-executing %v", cmd.Args)
 		// TODO: clean the environment
 		cmd.Env = append(os.Environ(),
 			"DISTRI_REEXEC=1",
-			fmt.Sprintf("UPDATE_START=%d", updateStart.Unix()))
+			fmt.Sprintf("UPDATE_START=%d", updateStart.Unix()),
+			storelock.FDEnv+"=3")
+		cmd.ExtraFiles = []*os.File{lock.File()}
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		if err := cmd.Run(); err != nil {
+			lock.Release()
 			return xerrors.Errorf("%v: %v", cmd.Args, err)
 		}
-		return nil
+		return lock.Release()
+	}
+
+	var lock *storelock.Lock
+	if v := os.Getenv(storelock.FDEnv); v != "" {
+		fd, err := strconv.ParseUint(v, 0, 64)
+		if err != nil {
+			return xerrors.Errorf("%s: %v", storelock.FDEnv, err)
+		}
+		lock, err = storelock.Inherit(filepath.Join(*root, "roimg"), uintptr(fd))
+		if err != nil {
+			return err
+		}
+		os.Unsetenv(storelock.FDEnv)
+	} else {
+		// Re-executed by a distri version which releases the lock before
+		// re-executing: acquire it again (waiting for it, as this update was
+		// already granted the lock once).
+		var err error
+		lock, err = storelock.Acquire(filepath.Join(*root, "roimg"), true)
+		if err != nil {
+			return err
+		}
 	}
+	defer lock.Release()
 
-	c := &install.Ctx{}
+	c := &install.Ctx{StoreLocked: true, Parallel: *parallel}
 	if err := c.Packages([]string{"base"}, *root, *repo, false); err != nil {
 		return err
 	}
@@ -108,7 +151,7 @@ func update(ctx context.Context, args []string) error {
 		return nil
 	}
 
-	c = &install.Ctx{}
+	c = &install.Ctx{StoreLocked: true, Parallel: *parallel}
 	if err := c.Packages(pkgs, *root, *repo, true); err != nil {
 		// try to persist an after file listing (best effort)
 		if err := persistFileListing(fileListingFileName(*root, updateStart, "files.after.txt"), filepath.Join(*root, "roimg")); err != nil {
@@ -121,6 +164,10 @@ func update(ctx context.Context, args []string) error {
 		return err
 	}
 
+	if _, err := recordGeneration(*root, "distri update"); err != nil {
+		return err
+	}
+
 	return nil
 }
 
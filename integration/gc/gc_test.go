@@ -110,3 +110,77 @@ func TestGC(t *testing.T) {
 		}
 	})
 }
+
+func TestGCRoots(t *testing.T) {
+	ctx, canc := distri.InterruptibleContext()
+	defer canc()
+
+	root, err := ioutil.TempDir("", "distrigc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer distritest.RemoveAll(t, root)
+
+	store := filepath.Join(root, "roimg")
+	pkgsetDir := filepath.Join(root, "etc", "distri", "pkgset.d")
+	for _, dir := range []string{store, pkgsetDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const (
+		i3status = "i3status-amd64-2.13-3"
+		yajl     = "yajl-amd64-2.1.0-4"
+		strace   = "strace-amd64-5.1-5"
+		orphan   = "zsh-amd64-5.6.2-3"
+	)
+	files := map[string]string{
+		i3status + ".squashfs":       "",
+		i3status + ".meta.textproto": `runtime_dep: "` + yajl + `"`,
+		yajl + ".squashfs":           "",
+		yajl + ".meta.textproto":     "",
+		strace + ".squashfs":         "",
+		strace + ".meta.textproto":   "",
+		orphan + ".squashfs":         "", // no .meta.textproto
+	}
+	for fn, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(store, fn), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(pkgsetDir, "local.pkgset"), []byte("i3status\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	why := exec.CommandContext(ctx, "distri", "gc", "-root="+root, "-why=yajl")
+	why.Stderr = os.Stderr
+	out, err := why.Output()
+	if err != nil {
+		t.Fatalf("%v: %v", why.Args, err)
+	}
+	want := yajl + " is kept:\n" +
+		"  " + i3status + " (listed in package set local.pkgset)\n" +
+		"  → " + yajl + "\n"
+	if got := string(out); got != want {
+		t.Errorf("gc -why: unexpected output: got %q, want %q", got, want)
+	}
+
+	distrigc := exec.CommandContext(ctx, "distri", "gc", "-root="+root)
+	distrigc.Stderr = os.Stderr
+	if err := distrigc.Run(); err != nil {
+		t.Fatalf("%v: %v", distrigc.Args, err)
+	}
+
+	for _, pkg := range []string{i3status, yajl} {
+		if !stracePresent(store, pkg) {
+			t.Errorf("gc unexpectedly deleted referenced package %s", pkg)
+		}
+	}
+	if stracePresent(store, strace) {
+		t.Errorf("gc unexpectedly did not delete unreferenced package %s", strace)
+	}
+	if _, err := os.Stat(filepath.Join(store, orphan+".squashfs")); !os.IsNotExist(err) {
+		t.Errorf("gc unexpectedly did not delete orphaned image %s.squashfs", orphan)
+	}
+}
@@ -0,0 +1,137 @@
+package query_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distr1/distri"
+	"github.com/distr1/distri/internal/distritest"
+	"github.com/distr1/distri/internal/squashfs"
+)
+
+// writeImage writes a package image containing empty files at paths.
+func writeImage(t *testing.T, fn string, paths ...string) {
+	t.Helper()
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := squashfs.NewWriter(f, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := make(map[string]*squashfs.Directory)
+	var dir func(path string) *squashfs.Directory
+	dir = func(path string) *squashfs.Directory {
+		if path == "." {
+			return w.Root
+		}
+		if d, ok := dirs[path]; ok {
+			return d
+		}
+		d := dir(filepath.Dir(path)).Directory(filepath.Base(path), time.Now())
+		dirs[path] = d
+		return d
+	}
+	for _, path := range paths {
+		fw, err := dir(filepath.Dir(path)).File(filepath.Base(path), time.Now(), 0755, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Directories must be flushed before their parents, i.e. deepest first:
+	sorted := make([]string, 0, len(dirs))
+	for path := range dirs {
+		sorted = append(sorted, path)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return strings.Count(sorted[i], "/") > strings.Count(sorted[j], "/")
+	})
+	for _, path := range sorted {
+		if err := dirs[path].Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Root.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	ctx, canc := distri.InterruptibleContext()
+	defer canc()
+
+	root, err := ioutil.TempDir("", "distriquery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer distritest.RemoveAll(t, root)
+
+	store := filepath.Join(root, "roimg")
+	if err := os.MkdirAll(store, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		i3status = "i3status-amd64-2.13-3"
+		yajl     = "yajl-amd64-2.1.0-4"
+	)
+	writeImage(t, filepath.Join(store, i3status+".squashfs"), "bin/i3status", "out/bin/i3status")
+	writeImage(t, filepath.Join(store, yajl+".squashfs"), "out/lib/libyajl.so")
+	metas := map[string]string{
+		i3status: `runtime_dep: "` + yajl + `"`,
+		yajl:     "",
+	}
+	for pkg, content := range metas {
+		if err := ioutil.WriteFile(filepath.Join(store, pkg+".meta.textproto"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{
+			args: []string{"files", "i3status"},
+			want: "/ro/" + i3status + "/bin/i3status\n/ro/" + i3status + "/out/bin/i3status\n",
+		},
+		{
+			args: []string{"owner", "/ro/bin/i3status", "/ro/lib/libyajl.so"},
+			want: "/ro/bin/i3status: " + i3status + "\n/ro/lib/libyajl.so: " + yajl + "\n",
+		},
+		{
+			args: []string{"rdeps", "yajl"},
+			want: i3status + "\n",
+		},
+		{
+			args: []string{"deps", "i3status"},
+			want: yajl + "\n",
+		},
+	} {
+		t.Run(tt.args[0], func(t *testing.T) {
+			query := exec.CommandContext(ctx, "distri", append([]string{"query", "-root=" + root}, tt.args...)...)
+			query.Stderr = os.Stderr
+			out, err := query.Output()
+			if err != nil {
+				t.Fatalf("%v: %v", query.Args, err)
+			}
+			if got := string(out); got != tt.want {
+				t.Errorf("%v: unexpected output: got %q, want %q", query.Args, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package remove_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/distr1/distri"
+	"github.com/distr1/distri/internal/distritest"
+)
+
+func TestRemove(t *testing.T) {
+	ctx, canc := distri.InterruptibleContext()
+	defer canc()
+
+	root, err := ioutil.TempDir("", "distriremove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer distritest.RemoveAll(t, root)
+
+	store := filepath.Join(root, "roimg")
+	pkgsetDir := filepath.Join(root, "etc", "distri", "pkgset.d")
+	for _, dir := range []string{store, pkgsetDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const (
+		i3status = "i3status-amd64-2.13-3"
+		yajl     = "yajl-amd64-2.1.0-4"
+	)
+	files := map[string]string{
+		i3status + ".squashfs":       "",
+		i3status + ".meta.textproto": `runtime_dep: "` + yajl + `"`,
+		yajl + ".squashfs":           "",
+		yajl + ".meta.textproto":     "",
+	}
+	for fn, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(store, fn), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pkgset := filepath.Join(pkgsetDir, "local.pkgset")
+	if err := ioutil.WriteFile(pkgset, []byte("i3status\nyajl\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	present := func(pkg string) bool {
+		_, err := os.Stat(filepath.Join(store, pkg+".squashfs"))
+		return err == nil
+	}
+
+	// yajl is required by i3status:
+	remove := exec.CommandContext(ctx, "distri", "remove", "-root="+root, "yajl")
+	if err := remove.Run(); err == nil {
+		t.Fatalf("%v unexpectedly succeeded", remove.Args)
+	}
+	if !present(yajl) {
+		t.Fatalf("%v unexpectedly deleted %s", remove.Args, yajl)
+	}
+
+	remove = exec.CommandContext(ctx, "distri", "remove", "-root="+root, "i3status")
+	remove.Stderr = os.Stderr
+	if err := remove.Run(); err != nil {
+		t.Fatalf("%v: %v", remove.Args, err)
+	}
+	if present(i3status) {
+		t.Errorf("%v did not delete %s", remove.Args, i3status)
+	}
+	b, err := ioutil.ReadFile(pkgset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), "yajl\n"; got != want {
+		t.Errorf("unexpected pkgset contents after remove: got %q, want %q", got, want)
+	}
+
+	remove = exec.CommandContext(ctx, "distri", "remove", "-root="+root, "yajl")
+	remove.Stderr = os.Stderr
+	if err := remove.Run(); err != nil {
+		t.Fatalf("%v: %v", remove.Args, err)
+	}
+	if present(yajl) {
+		t.Errorf("%v did not delete %s", remove.Args, yajl)
+	}
+	if _, err := os.Stat(pkgset); !os.IsNotExist(err) {
+		t.Errorf("empty pkgset %s not deleted", pkgset)
+	}
+}
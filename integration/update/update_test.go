@@ -73,6 +73,8 @@ func TestUpdate(t *testing.T) {
 	distri1deps := make(map[string]bool)
 	{
 		distri1deps["/pkg/distri1-amd64.meta.textproto"] = true
+		// for verifying the integrity of downloaded packages:
+		distri1deps["/pkg/meta.binaryproto"] = true
 		deps, err := resolve1(env.DefaultRepo, "distri1-amd64")
 		if err != nil {
 			t.Fatal(err)
@@ -9,8 +9,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/distr1/distri"
@@ -23,7 +25,6 @@ import (
 	"golang.org/x/xerrors"
 	"gonum.org/v1/gonum/graph"
 	"gonum.org/v1/gonum/graph/simple"
-	"gonum.org/v1/gonum/graph/topo"
 	"google.golang.org/protobuf/encoding/prototext"
 )
 
@@ -32,10 +33,33 @@ type node struct {
 
 	pkg      string // e.g. make
 	fullname string // package and version, e.g. make-4.2.1
+
+	expected time.Duration // expected build duration, based on history
+	priority time.Duration // critical path length, see prioritize
+
+	// bootstrap is set for the first stage of building a cycle, see
+	// bootstrapCycles.
+	bootstrap bool
 }
 
 func (n *node) ID() int64 { return n.id }
 
+// key identifies the build of n, which is built twice when bootstrapping.
+func (n *node) key() string {
+	if n.bootstrap {
+		return n.fullname + " (bootstrap)"
+	}
+	return n.fullname
+}
+
+// logName returns the file name of the build log of n.
+func (n *node) logName() string {
+	if n.bootstrap {
+		return n.pkg + ".bootstrap.log"
+	}
+	return n.pkg + ".log"
+}
+
 // Ctx is a batch build context, containing configuration and state.
 type Ctx struct {
 	// Configuration
@@ -43,11 +67,41 @@ type Ctx struct {
 	DistriRoot      env.DistriRootDir
 	DefaultBuildCtx *build.Ctx
 	Arch            string
+
+	// Builders contains the host:port addresses of remote builders (see distri
+	// builder). If empty, packages are built locally.
+	Builders []string
+
+	// ReportDir is the directory in which to store build logs and the batch
+	// report (report.json and report.html). If empty, build logs are stored in
+	// a temporary directory and no report is written.
+	ReportDir string
+
+	// Resume restricts the batch to the packages which did not succeed in the
+	// batch whose report is stored in ReportDir.
+	Resume bool
 }
 
 func (c *Ctx) Build(ctx context.Context, dryRun, simulate, rebuild bool, jobs int) error {
 	c.Log.Printf("distriroot %q", c.DistriRoot)
 
+	var (
+		prev  *report
+		retry map[string]bool
+	)
+	if c.Resume {
+		if c.ReportDir == "" {
+			return xerrors.Errorf("resuming requires a report directory")
+		}
+		var err error
+		prev, err = readReport(c.ReportDir)
+		if err != nil {
+			return xerrors.Errorf("resume: %v", err)
+		}
+		retry = prev.retry()
+		c.Log.Printf("resuming batch of %s: retrying %d packages", prev.Started.Format(time.RFC3339), len(retry))
+	}
+
 	// TODO: use simple.NewDirectedMatrix instead?
 	g := simple.NewDirectedGraph()
 
@@ -81,6 +135,9 @@ func (c *Ctx) Build(ctx context.Context, dryRun, simulate, rebuild bool, jobs in
 
 	for idx, fi := range fis {
 		pkg := fi.Name()
+		if retry != nil && !retry[pkg] {
+			continue // succeeded in the resumed batch
+		}
 
 		// TODO(later): parallelize?
 		buildTextprotoPath := filepath.Join(pkgsDir, fi.Name(), "build.textproto")
@@ -188,41 +245,17 @@ func (c *Ctx) Build(ctx context.Context, dryRun, simulate, rebuild bool, jobs in
 		}
 	}
 
-	// detect cycles and break them
-
-	// strong-set = packages which needed a cycle break
-	// 1. build the strong-set once, in any order, with host deps (= remove all deps)
-	// 2. build the strong-set again, with the results of the previous compilation
-	// 3. build the rest of the packages
-
-	// scc := topo.TarjanSCC(g)
-	// log.Printf("%d scc", len(scc))
-	// for idx, c := range scc {
-	// 	log.Printf("scc %d", idx)
-	// 	for _, n := range c {
-	// 		log.Printf("  n %v", n)
-	// 	}
-	// }
+	if err := bootstrapCycles(g, c.Log); err != nil {
+		return err
+	}
 
-	// Break cycles
-	if _, err := topo.Sort(g); err != nil {
-		uo, ok := err.(topo.Unorderable)
-		if !ok {
-			return err
-		}
-		for _, component := range uo { // cyclic component
-			//log.Printf("uo %d", idx)
-			for _, n := range component {
-				c.Log.Printf("  bootstrap %v", n.(*node).pkg)
-				from := g.From(n.ID())
-				for from.Next() {
-					g.RemoveEdge(n.ID(), from.Node().ID())
-				}
-			}
-		}
-		if _, err := topo.Sort(g); err != nil {
-			return xerrors.Errorf("could not break cycles: %v", err)
-		}
+	historyPath := filepath.Join(c.DistriRoot.BuildDir("distri"), "batch-history.json")
+	hist, err := readHistory(historyPath)
+	if err != nil {
+		return err
+	}
+	if path := prioritize(g, hist); len(path) > 0 {
+		c.Log.Printf("critical path: %s (estimated %v)", formatPath(path), path[0].priority.Round(time.Second))
 	}
 
 	if dryRun {
@@ -232,14 +265,32 @@ func (c *Ctx) Build(ctx context.Context, dryRun, simulate, rebuild bool, jobs in
 		}
 		c.Log.Printf("build %d pkg", g.Nodes().Len())
 		for it := g.Nodes(); it.Next(); {
-			c.Log.Printf("  build %s", it.Node().(*node).pkg)
+			if n := it.Node().(*node); n.bootstrap {
+				c.Log.Printf("  bootstrap %s", n.pkg)
+			} else {
+				c.Log.Printf("  build %s", n.pkg)
+			}
 		}
 		return nil
 	}
 
-	logDir, err := ioutil.TempDir("", "distri-batch")
-	if err != nil {
-		return err
+	var logDir string
+	if c.ReportDir != "" {
+		logDir = filepath.Join(c.ReportDir, "logs")
+		if err := os.MkdirAll(logDir, 0755); err != nil {
+			return err
+		}
+	} else {
+		logDir, err = ioutil.TempDir("", "distri-batch")
+		if err != nil {
+			return err
+		}
+	}
+	var p *pool
+	if len(c.Builders) > 0 {
+		c.Log.Printf("building on %d remote builders: %v", len(c.Builders), c.Builders)
+		p = newPool(c.Builders)
+		jobs = len(c.Builders)
 	}
 	s := scheduler{
 		distriRoot: c.DistriRoot,
@@ -248,33 +299,67 @@ func (c *Ctx) Build(ctx context.Context, dryRun, simulate, rebuild bool, jobs in
 		simulate:   simulate,
 		workers:    jobs,
 		g:          g,
-		byFullname: byFullname,
 		built:      make(map[string]error),
+		durations:  make(map[string]time.Duration),
 		status:     make([]string, jobs+1),
 		arch:       arch,
+		pool:       p,
 	}
-	if err := s.run(ctx); err != nil {
-		return err
+	if p == nil {
+		s.cpus = runtime.NumCPU()
 	}
-
-	return nil
+	started := time.Now()
+	runErr := s.run(ctx)
+	if !simulate {
+		for _, n := range byFullname {
+			if err, ok := s.built[n.key()]; ok && err == nil {
+				hist.Seconds[n.pkg] = s.durations[n.key()].Seconds()
+			}
+		}
+		if err := hist.write(historyPath); err != nil {
+			return err
+		}
+	}
+	if c.ReportDir != "" {
+		r := s.report(started)
+		if prev != nil {
+			r.merge(prev)
+		}
+		if err := writeReport(c.ReportDir, r); err != nil {
+			return err
+		}
+		c.Log.Printf("report written to %s", filepath.Join(c.ReportDir, "report.html"))
+	}
+	return runErr
 }
 
 type buildResult struct {
-	node *node
-	err  error
+	node     *node
+	err      error
+	duration time.Duration
+
+	// requeue is set when the remote builder became unreachable, i.e. the
+	// build needs to be retried elsewhere.
+	requeue bool
 }
 
 type scheduler struct {
+	// running is the sum of the expected build durations of all packages
+	// which are being built. Accessed atomically, hence the first field for
+	// 64-bit alignment.
+	running int64
+
 	distriRoot env.DistriRootDir
 	log        *log.Logger
 	logDir     string
 	simulate   bool
 	workers    int
 	g          graph.Directed
-	byFullname map[string]*node
 	built      map[string]error
+	durations  map[string]time.Duration
 	arch       string
+	pool       *pool // nil unless building on remote builders
+	cpus       int   // to distribute among local builds, see jobs
 
 	statusMu   sync.Mutex
 	status     []string
@@ -342,8 +427,11 @@ func (s *scheduler) buildDry(ctx context.Context, pkg string) bool {
 	return pkg != "libx11"
 }
 
-func (s *scheduler) build(ctx context.Context, pkg string) error {
-	logFile, err := os.Create(filepath.Join(s.logDir, pkg+".log"))
+// build builds n using distri build, which builds on the remote builder if
+// remote is non-empty, using the specified number of parallel jobs if
+// non-zero.
+func (s *scheduler) build(ctx context.Context, n *node, remote string, jobs int) error {
+	logFile, err := os.Create(filepath.Join(s.logDir, n.logName()))
 	if err != nil {
 		return err
 	}
@@ -352,7 +440,13 @@ func (s *scheduler) build(ctx context.Context, pkg string) error {
 	if s.arch != "" {
 		build.Args = append(build.Args, "-cross="+s.arch)
 	}
-	build.Dir = s.distriRoot.PkgDir(pkg)
+	if remote != "" {
+		build.Args = append(build.Args, "-remote="+remote)
+	}
+	if jobs > 0 {
+		build.Args = append(build.Args, fmt.Sprintf("-jobs=%d", jobs))
+	}
+	build.Dir = s.distriRoot.PkgDir(n.pkg)
 	build.Stdout = logFile
 	build.Stderr = logFile
 	if err := build.Run(); err != nil {
@@ -363,7 +457,8 @@ func (s *scheduler) build(ctx context.Context, pkg string) error {
 
 func (s *scheduler) run(ctx context.Context) error {
 	numNodes := s.g.Nodes().Len()
-	work := make(chan *node, numNodes)
+	ready := make(chan *node, numNodes)
+	work := make(chan *node)
 	done := make(chan buildResult)
 	eg, ctx := errgroup.WithContext(ctx)
 	const freq = 1 * time.Second
@@ -380,12 +475,29 @@ func (s *scheduler) run(ctx context.Context) error {
 		}
 	}()
 
+	// ticked is closed once all nodes are built (or ctx is canceled):
+	ticked := make(chan struct{})
+
 	for i := 0; i < s.workers; i++ {
 		i := i // copy
 		eg.Go(func() error {
 			ticker := time.NewTicker(100 * time.Millisecond) // TODO: 1*time.Second
 			defer ticker.Stop()
-			for n := range work {
+			var remote, on string
+			if s.pool != nil {
+				remote = s.pool.addrs[i]
+				on = " on " + remote
+			}
+			next := func() (*node, bool) {
+				if s.pool == nil {
+					n, ok := <-work
+					return n, ok
+				}
+				s.pool.idle <- i
+				n, ok := <-s.pool.assigned[i]
+				return n, ok
+			}
+			for n, ok := next(); ok; n, ok = next() {
 				if err := ctx.Err(); err != nil {
 					return err
 				}
@@ -395,7 +507,13 @@ func (s *scheduler) run(ctx context.Context) error {
 					ev.Type = "B" // begin
 					ev.Done()
 				}
-				s.updateStatus(i+1, "building "+n.pkg)
+				atomic.AddInt64(&s.running, int64(n.expected))
+				jobs := s.jobs(n)
+				desc := n.pkg + on
+				if jobs > 0 {
+					desc += fmt.Sprintf(" (%d jobs)", jobs)
+				}
+				s.updateStatus(i+1, "building "+desc)
 				start := time.Now()
 				result := make(chan error)
 				if s.simulate {
@@ -408,7 +526,7 @@ func (s *scheduler) run(ctx context.Context) error {
 					}()
 				} else {
 					go func() {
-						err := s.build(ctx, n.pkg)
+						err := s.build(ctx, n, remote, jobs)
 						result <- err
 					}()
 				}
@@ -421,12 +539,22 @@ func (s *scheduler) run(ctx context.Context) error {
 					case err = <-result:
 						break Build
 					case <-ticker.C:
-						s.updateStatus(i+1, fmt.Sprintf("building %s since %v", n.pkg, time.Since(start)))
+						s.updateStatus(i+1, fmt.Sprintf("building %s since %v", desc, time.Since(start)))
 					}
 				}
 
+				// A failed remote build might be caused by the builder going
+				// away (e.g. crash, reboot, network partition) instead of by
+				// the package itself:
+				requeue := s.pool != nil && !s.simulate && err != nil && ctx.Err() == nil && !s.pool.reachable(ctx, i)
+				duration := time.Since(start)
+				atomic.AddInt64(&s.running, -int64(n.expected))
+				if s.pool != nil && err == nil {
+					s.pool.observe(i, duration)
+				}
+
 				select {
-				case done <- buildResult{node: n, err: err}:
+				case done <- buildResult{node: n, err: err, duration: duration, requeue: requeue}:
 				case <-ctx.Done():
 					return ctx.Err()
 				}
@@ -435,33 +563,58 @@ func (s *scheduler) run(ctx context.Context) error {
 					ev.Type = "E" // end
 					ev.Done()
 				}
+				if requeue {
+					s.updateStatus(i+1, remote+" unreachable")
+					reachable, err := s.pool.waitReachable(ctx, i, ticked)
+					if err != nil {
+						return err
+					}
+					if !reachable {
+						return nil // batch finished without this builder
+					}
+					s.log.Printf("builder %s reachable again", remote)
+					s.refreshStatus()
+				}
 				s.updateStatus(i+1, "idle")
 			}
 			return nil
 		})
 	}
 
+	go prioritizeQueue(ctx, ready, work)
+	if s.pool != nil {
+		go s.pool.dispatch(ctx, work)
+	}
+
+	enqueue := func(n *node) {
+		ready <- n // never blocks: ready has room for all nodes
+	}
+
 	// Enqueue all packages which have no dependencies to get the build started:
 	for nodes := s.g.Nodes(); nodes.Next(); {
 		n := nodes.Node()
 		if s.g.From(n.ID()).Len() == 0 {
-			select {
-			case work <- n.(*node):
-			case <-ctx.Done():
-				return ctx.Err()
-			}
+			enqueue(n.(*node))
 		}
 	}
 	go func() {
-		defer close(work)
+		defer close(ticked)
+		defer close(ready)
 		succeeded := 0
 		failed := 0
 		for len(s.built) < numNodes { // scheduler tick
 			select {
 			case result := <-done:
+				if result.requeue {
+					s.log.Printf("builder unreachable while building %s (%v), re-queueing", result.node.pkg, result.err)
+					s.refreshStatus()
+					ready <- result.node
+					continue
+				}
 				//s.log.Printf("build %s completed", result.name)
-				n := s.byFullname[result.node.fullname]
-				s.built[result.node.fullname] = result.err
+				n := result.node
+				s.built[n.key()] = result.err
+				s.durations[n.key()] = result.duration
 				s.updateStatus(0, fmt.Sprintf("%d of %d packages: %d built, %d failed", len(s.built), numNodes, succeeded, failed))
 
 				if result.err == nil {
@@ -469,13 +622,13 @@ func (s *scheduler) run(ctx context.Context) error {
 					for to := s.g.To(n.ID()); to.Next(); {
 						if candidate := to.Node(); s.canBuild(candidate) {
 							//s.log.Printf("  → enqueuing %s", candidate.(*node).name)
-							work <- candidate.(*node)
+							enqueue(candidate.(*node))
 						}
 					}
 				} else {
-					s.log.Printf("build of %s failed (%v), see %s", result.node.pkg, result.err, filepath.Join(s.logDir, result.node.pkg+".log"))
+					s.log.Printf("build of %s failed (%v), see %s", result.node.pkg, result.err, filepath.Join(s.logDir, n.logName()))
 					s.refreshStatus()
-					failed += 1 + s.markFailed(n)
+					failed += 1 + s.markFailed(n, result.node.pkg)
 				}
 
 			case <-ctx.Done():
@@ -483,7 +636,9 @@ func (s *scheduler) run(ctx context.Context) error {
 			}
 		}
 	}()
-	if err := eg.Wait(); err != nil {
+	err := eg.Wait()
+	<-ticked // s.built must not be modified after run returns
+	if err != nil {
 		return err
 	}
 	succeeded := 0
@@ -498,21 +653,23 @@ func (s *scheduler) run(ctx context.Context) error {
 	return nil
 }
 
-func (s *scheduler) markFailed(n graph.Node) int {
+// markFailed marks all packages which depend on n as failed because their
+// dependency failedPkg failed to build.
+func (s *scheduler) markFailed(n graph.Node, failedPkg string) int {
 	failed := 0
 	//s.log.Printf("marking deps of %s as failed", n.(*node).name)
 	for to := s.g.To(n.ID()); to.Next(); {
 		d := to.Node()
-		name := d.(*node).fullname
+		name := d.(*node).key()
 		//s.log.Printf("→ %s failed", name)
 		if err, ok := s.built[name]; ok && err == nil {
 			s.log.Fatalf("BUG: %s already succeeded, but dependencies cannot be fulfilled", name)
 		}
 		if _, ok := s.built[name]; !ok {
-			s.built[d.(*node).fullname] = xerrors.Errorf("dependencies cannot be fulfilled")
+			s.built[name] = &depFailedError{dep: failedPkg}
 			failed++
 		}
-		failed += s.markFailed(d)
+		failed += s.markFailed(d, failedPkg)
 	}
 	return failed
 }
@@ -521,7 +678,7 @@ func (s *scheduler) markFailed(n graph.Node) int {
 func (s *scheduler) canBuild(candidate graph.Node) bool {
 	//s.log.Printf("  checking %s", candidate.(*node).name)
 	for from := s.g.From(candidate.ID()); from.Next(); {
-		name := from.Node().(*node).fullname
+		name := from.Node().(*node).key()
 		if err, ok := s.built[name]; !ok || err != nil {
 			//s.log.Printf("  dep %s not yet ready", name)
 			return false
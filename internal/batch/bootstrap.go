@@ -0,0 +1,84 @@
+package batch
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+	"gonum.org/v1/gonum/graph"
+	"gonum.org/v1/gonum/graph/simple"
+	"gonum.org/v1/gonum/graph/topo"
+)
+
+// bootstrapCycles makes g acyclic by building each strongly connected component
+// (strong set, e.g. gcc, glibc and binutils depend on each other) twice:
+//
+//  1. build the strong set once, in any order, with the packages which are
+//     currently in the repository (e.g. the previous gcc), i.e. the edges
+//     within the strong set are relaxed.
+//  2. build the strong set again, with the results of the first stage.
+//  3. build the rest of the packages, which depend on the second stage.
+//
+// Builds of the first stage are represented by additional bootstrap nodes.
+func bootstrapCycles(g *simple.DirectedGraph, logger *log.Logger) error {
+	for _, scc := range topo.TarjanSCC(g) {
+		if len(scc) < 2 {
+			continue // self edges are not added in the first place
+		}
+		sort.Slice(scc, func(i, j int) bool {
+			return scc[i].(*node).pkg < scc[j].(*node).pkg
+		})
+		inSCC := make(map[int64]bool)
+		pkgs := make([]string, len(scc))
+		for idx, n := range scc {
+			inSCC[n.ID()] = true
+			pkgs[idx] = n.(*node).pkg
+		}
+		logger.Printf("bootstrapping cycle: %s", strings.Join(pkgs, ", "))
+
+		// stage 1 nodes
+		bootstrap := make(map[int64]*node)
+		for _, n := range scc {
+			n := n.(*node)
+			b := &node{
+				id:        g.NewNode().ID(),
+				pkg:       n.pkg,
+				fullname:  n.fullname,
+				bootstrap: true,
+			}
+			g.AddNode(b)
+			bootstrap[n.id] = b
+		}
+
+		for _, n := range scc {
+			n := n.(*node)
+			var deps []graph.Node
+			for from := g.From(n.ID()); from.Next(); {
+				deps = append(deps, from.Node())
+			}
+			sort.Slice(deps, func(i, j int) bool {
+				return deps[i].(*node).pkg < deps[j].(*node).pkg
+			})
+			for _, d := range deps {
+				if !inSCC[d.ID()] {
+					// Dependencies outside of the strong set are built before
+					// either stage:
+					g.SetEdge(g.NewEdge(bootstrap[n.id], d))
+					continue
+				}
+				logger.Printf("  relaxed edge %s → %s (stage 2 depends on stage 1 instead)", n.pkg, d.(*node).pkg)
+				g.RemoveEdge(n.ID(), d.ID())
+			}
+			// stage 2 is built with the results of stage 1:
+			for _, m := range scc {
+				g.SetEdge(g.NewEdge(n, bootstrap[m.ID()]))
+			}
+		}
+	}
+
+	if _, err := topo.Sort(g); err != nil {
+		return xerrors.Errorf("could not break cycles: %v", err)
+	}
+	return nil
+}
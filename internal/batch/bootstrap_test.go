@@ -0,0 +1,88 @@
+package batch
+
+import (
+	"bytes"
+	"log"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gonum.org/v1/gonum/graph/simple"
+	"gonum.org/v1/gonum/graph/topo"
+)
+
+func TestBootstrapCycles(t *testing.T) {
+	g := simple.NewDirectedGraph()
+	nodes := make(map[string]*node)
+	for idx, pkg := range []string{"linux", "glibc", "gcc", "binutils", "make"} {
+		n := &node{id: int64(idx), pkg: pkg, fullname: pkg + "-amd64-1"}
+		g.AddNode(n)
+		nodes[pkg] = n
+	}
+	for _, e := range [][2]string{
+		// the cycle:
+		{"glibc", "gcc"},
+		{"gcc", "glibc"},
+		{"gcc", "binutils"},
+		{"binutils", "glibc"},
+		// outside of the cycle:
+		{"glibc", "linux"},
+		{"make", "gcc"},
+	} {
+		g.SetEdge(g.NewEdge(nodes[e[0]], nodes[e[1]]))
+	}
+
+	var buf bytes.Buffer
+	if err := bootstrapCycles(g, log.New(&buf, "", 0)); err != nil {
+		t.Fatal(err)
+	}
+	order, err := topo.Sort(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := g.Nodes().Len(), 5+3; got != want {
+		t.Errorf("unexpected number of nodes: got %d, want %d", got, want)
+	}
+	deps := make(map[string][]string)
+	for _, n := range order {
+		n := n.(*node)
+		var d []string
+		for from := g.From(n.ID()); from.Next(); {
+			d = append(d, from.Node().(*node).key())
+		}
+		sort.Strings(d)
+		deps[n.key()] = d
+	}
+	stage1 := []string{
+		"binutils-amd64-1 (bootstrap)",
+		"gcc-amd64-1 (bootstrap)",
+		"glibc-amd64-1 (bootstrap)",
+	}
+	want := map[string][]string{
+		"linux-amd64-1":                nil,
+		"binutils-amd64-1 (bootstrap)": nil,
+		"gcc-amd64-1 (bootstrap)":      nil,
+		"glibc-amd64-1 (bootstrap)":    {"linux-amd64-1"},
+		"binutils-amd64-1":             stage1,
+		"gcc-amd64-1":                  stage1,
+		"glibc-amd64-1":                append(append([]string{}, stage1...), "linux-amd64-1"),
+		"make-amd64-1":                 {"gcc-amd64-1"},
+	}
+	if diff := cmp.Diff(want, deps); diff != "" {
+		t.Errorf("unexpected dependencies after bootstrapCycles: diff (-want +got):\n%s", diff)
+	}
+
+	for _, want := range []string{
+		"bootstrapping cycle: binutils, gcc, glibc",
+		"relaxed edge binutils → glibc",
+		"relaxed edge gcc → binutils",
+		"relaxed edge gcc → glibc",
+		"relaxed edge glibc → gcc",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
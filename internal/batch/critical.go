@@ -0,0 +1,227 @@
+package batch
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/renameio"
+	"golang.org/x/xerrors"
+	"gonum.org/v1/gonum/graph"
+)
+
+// defaultDuration is the expected build duration of packages for which no
+// history is available (and no other package has history either).
+const defaultDuration = 1 * time.Minute
+
+// history contains the build durations of the most recent successful build of
+// each package, stored in build/distri/batch-history.json.
+type history struct {
+	// Seconds maps package (e.g. gcc) to build duration in seconds.
+	Seconds map[string]float64 `json:"seconds"`
+}
+
+func readHistory(path string) (*history, error) {
+	h := &history{Seconds: make(map[string]float64)}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, h); err != nil {
+		return nil, xerrors.Errorf("%s: %v", path, err)
+	}
+	if h.Seconds == nil {
+		h.Seconds = make(map[string]float64)
+	}
+	return h, nil
+}
+
+func (h *history) write(path string) error {
+	b, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return renameio.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// expected returns the expected build duration of pkg. Packages without
+// history are expected to take the median duration of all known packages.
+func (h *history) expected(pkg string, median time.Duration) time.Duration {
+	if secs, ok := h.Seconds[pkg]; ok {
+		return time.Duration(secs * float64(time.Second))
+	}
+	return median
+}
+
+func (h *history) median() time.Duration {
+	if len(h.Seconds) == 0 {
+		return defaultDuration
+	}
+	secs := make([]float64, 0, len(h.Seconds))
+	for _, s := range h.Seconds {
+		secs = append(secs, s)
+	}
+	sort.Float64s(secs)
+	return time.Duration(secs[len(secs)/2] * float64(time.Second))
+}
+
+// prioritize sets the expected duration and the critical path length of all
+// nodes in g, which must be acyclic. The critical path length of a node is its
+// expected duration plus the longest critical path length of all nodes which
+// depend on it, i.e. the minimum time until all builds which are waiting for
+// the node can be completed. prioritize returns the overall critical path.
+func prioritize(g graph.Directed, h *history) []*node {
+	median := h.median()
+	for nodes := g.Nodes(); nodes.Next(); {
+		n := nodes.Node().(*node)
+		n.expected = h.expected(n.pkg, median)
+	}
+	next := make(map[int64]*node) // next node on the critical path
+	done := make(map[int64]bool)
+	var visit func(n *node) time.Duration
+	visit = func(n *node) time.Duration {
+		if done[n.id] {
+			return n.priority
+		}
+		var longest time.Duration
+		for to := g.To(n.ID()); to.Next(); {
+			m := to.Node().(*node)
+			if cp := visit(m); cp > longest || (cp == longest && next[n.id] != nil && m.pkg < next[n.id].pkg) {
+				longest = cp
+				next[n.id] = m
+			}
+		}
+		n.priority = n.expected + longest
+		done[n.id] = true
+		return n.priority
+	}
+	var start *node
+	for nodes := g.Nodes(); nodes.Next(); {
+		n := nodes.Node().(*node)
+		visit(n)
+		if start == nil || n.priority > start.priority || (n.priority == start.priority && n.pkg < start.pkg) {
+			start = n
+		}
+	}
+	var path []*node
+	for n := start; n != nil; n = next[n.id] {
+		path = append(path, n)
+	}
+	return path
+}
+
+func formatPath(path []*node) string {
+	pkgs := make([]string, len(path))
+	for idx, n := range path {
+		pkgs[idx] = n.pkg
+	}
+	return strings.Join(pkgs, " → ")
+}
+
+// nodeQueue is a priority queue (see container/heap) of nodes, ordered by
+// decreasing critical path length.
+type nodeQueue []*node
+
+func (q nodeQueue) Len() int { return len(q) }
+
+func (q nodeQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].pkg < q[j].pkg
+}
+
+func (q nodeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *nodeQueue) Push(x interface{}) { *q = append(*q, x.(*node)) }
+
+func (q *nodeQueue) Pop() interface{} {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
+
+// prioritizeQueue passes the nodes received from ready to work, highest
+// priority first. work is closed once ready is closed and all nodes were
+// passed on.
+func prioritizeQueue(ctx context.Context, ready <-chan *node, work chan<- *node) {
+	defer close(work)
+	var q nodeQueue
+	for ready != nil || q.Len() > 0 {
+		// Receive all nodes which are ready before picking the highest
+		// priority node:
+	Drain:
+		for ready != nil {
+			select {
+			case n, ok := <-ready:
+				if !ok {
+					ready = nil
+					break Drain
+				}
+				heap.Push(&q, n)
+			default:
+				break Drain
+			}
+		}
+		var (
+			out chan<- *node
+			top *node
+		)
+		if q.Len() > 0 {
+			out = work
+			top = q[0]
+		}
+		select {
+		case n, ok := <-ready:
+			if !ok {
+				ready = nil
+				continue
+			}
+			heap.Push(&q, n)
+		case out <- top:
+			heap.Pop(&q)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// jobs returns how many parallel jobs the build of n should use: the available
+// CPUs are shared between all running builds (including n) proportionally to
+// their expected duration, so that the few large builds at the tail of a batch
+// get all CPUs. Builds which are merely ready do not reduce the share, as they
+// might not start before n completes (e.g. when all workers are busy).
+// jobs returns 0 (i.e. use the distri build default) for remote builds.
+func (s *scheduler) jobs(n *node) int {
+	if s.cpus == 0 {
+		return 0
+	}
+	running := atomic.LoadInt64(&s.running)
+	if running <= 0 {
+		return s.cpus
+	}
+	share := float64(n.expected) / float64(running)
+	jobs := int(math.Round(share * float64(s.cpus)))
+	if jobs < 1 {
+		return 1
+	}
+	if jobs > s.cpus {
+		return s.cpus
+	}
+	return jobs
+}
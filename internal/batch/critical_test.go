@@ -0,0 +1,85 @@
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gonum.org/v1/gonum/graph/simple"
+)
+
+func TestPrioritize(t *testing.T) {
+	// glibc ← gcc ← llvm (gcc and llvm depend on glibc, llvm depends on gcc)
+	// glibc ← less
+	g := simple.NewDirectedGraph()
+	nodes := make(map[string]*node)
+	for idx, pkg := range []string{"glibc", "gcc", "llvm", "less", "new"} {
+		n := &node{id: int64(idx), pkg: pkg}
+		g.AddNode(n)
+		nodes[pkg] = n
+	}
+	g.SetEdge(g.NewEdge(nodes["gcc"], nodes["glibc"]))
+	g.SetEdge(g.NewEdge(nodes["llvm"], nodes["gcc"]))
+	g.SetEdge(g.NewEdge(nodes["less"], nodes["glibc"]))
+
+	h := &history{Seconds: map[string]float64{
+		"glibc": 300,
+		"gcc":   1800,
+		"llvm":  3600,
+		"less":  10,
+	}}
+	path := prioritize(g, h)
+	if got, want := formatPath(path), "glibc → gcc → llvm"; got != want {
+		t.Errorf("critical path: got %q, want %q", got, want)
+	}
+	for pkg, want := range map[string]time.Duration{
+		"glibc": (300 + 1800 + 3600) * time.Second,
+		"gcc":   (1800 + 3600) * time.Second,
+		"llvm":  3600 * time.Second,
+		"less":  10 * time.Second,
+		"new":   1800 * time.Second, // median of history
+	} {
+		if got := nodes[pkg].priority; got != want {
+			t.Errorf("priority(%s) = %v, want %v", pkg, got, want)
+		}
+	}
+
+	// Nodes which are ready at the same time are handed out by priority:
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+	ready := make(chan *node, len(nodes))
+	work := make(chan *node)
+	for _, pkg := range []string{"less", "new", "gcc"} {
+		ready <- nodes[pkg]
+	}
+	close(ready)
+	go prioritizeQueue(ctx, ready, work)
+	var got []string
+	for n := range work {
+		got = append(got, n.pkg)
+	}
+	if len(got) != 3 || got[0] != "gcc" || got[1] != "new" || got[2] != "less" {
+		t.Errorf("prioritizeQueue: got %v, want [gcc new less]", got)
+	}
+
+	// CPUs are shared between running builds proportionally to the expected
+	// build duration:
+	s := &scheduler{cpus: 16}
+	s.running = int64(nodes["llvm"].expected + nodes["less"].expected)
+	if got, want := s.jobs(nodes["llvm"]), 16; got != want {
+		t.Errorf("jobs(llvm) = %d, want %d", got, want)
+	}
+	if got, want := s.jobs(nodes["less"]), 1; got != want {
+		t.Errorf("jobs(less) = %d, want %d", got, want)
+	}
+	s.running = int64(nodes["llvm"].expected + nodes["gcc"].expected)
+	if got, want := s.jobs(nodes["gcc"]), 5; got != want {
+		t.Errorf("jobs(gcc) = %d, want %d", got, want)
+	}
+	// Ready (but not yet running) builds, e.g. llvm waiting for a worker, do
+	// not reduce the share:
+	s.running = int64(nodes["gcc"].expected)
+	if got, want := s.jobs(nodes["gcc"]), 16; got != want {
+		t.Errorf("jobs(gcc), running alone = %d, want %d", got, want)
+	}
+}
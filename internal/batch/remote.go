@@ -0,0 +1,152 @@
+package batch
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// probeTimeout bounds how long to wait for a remote builder to accept a
+// connection before considering it unreachable.
+const probeTimeout = 10 * time.Second
+
+// reprobeInterval is how often an unreachable remote builder is probed until it
+// becomes reachable again.
+const reprobeInterval = 30 * time.Second
+
+// pool distributes nodes onto remote builders (distri builder), one build at a
+// time per builder. Listing a builder more than once allows for that many
+// concurrent builds.
+type pool struct {
+	addrs    []string       // host:port of each builder
+	idle     chan int       // builders announce that they are ready for work
+	assigned []chan *node   // per-builder work, fed by dispatch
+	mu       sync.Mutex     // protects durations
+	durs     []observations // observed build durations, per builder
+}
+
+type observations struct {
+	total time.Duration
+	n     int
+}
+
+func (o observations) mean() time.Duration {
+	if o.n == 0 {
+		return 0
+	}
+	return o.total / time.Duration(o.n)
+}
+
+func newPool(addrs []string) *pool {
+	p := &pool{
+		addrs:    addrs,
+		idle:     make(chan int, len(addrs)),
+		assigned: make([]chan *node, len(addrs)),
+		durs:     make([]observations, len(addrs)),
+	}
+	for i := range p.assigned {
+		p.assigned[i] = make(chan *node, 1)
+	}
+	return p
+}
+
+// observe records that builder i took dur for a build.
+func (p *pool) observe(i int, dur time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.durs[i].total += dur
+	p.durs[i].n++
+}
+
+// fastest returns the builder out of idle which finished its builds quickest
+// on average. Builders which have not completed a build yet are preferred, so
+// that every builder is measured.
+func (p *pool) fastest(idle map[int]bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	best := -1
+	for i := range idle {
+		if best == -1 ||
+			p.durs[i].mean() < p.durs[best].mean() ||
+			(p.durs[i].mean() == p.durs[best].mean() && i < best) {
+			best = i
+		}
+	}
+	return best
+}
+
+// dispatch hands out each node from work to the fastest idle builder. It
+// returns once work is closed or ctx is canceled.
+func (p *pool) dispatch(ctx context.Context, work <-chan *node) {
+	defer func() {
+		for _, ch := range p.assigned {
+			close(ch)
+		}
+	}()
+	idle := make(map[int]bool)
+	for n := range work {
+		for len(idle) == 0 {
+			select {
+			case i := <-p.idle:
+				idle[i] = true
+			case <-ctx.Done():
+				return
+			}
+		}
+		// Pick up all builders which became idle in the meantime so that the
+		// choice is not limited to whichever builder announced itself first:
+	Drain:
+		for {
+			select {
+			case i := <-p.idle:
+				idle[i] = true
+			default:
+				break Drain
+			}
+		}
+		best := p.fastest(idle)
+		delete(idle, best)
+		p.assigned[best] <- n
+	}
+}
+
+// reachable returns whether builder i accepts connections.
+func (p *pool) reachable(ctx context.Context, i int) bool {
+	ctx, canc := context.WithTimeout(ctx, probeTimeout)
+	defer canc()
+	conn, err := grpc.DialContext(ctx, p.addrs[i], grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// waitReachable blocks until builder i accepts connections again and returns
+// true, or returns false once finished is closed (i.e. no more work will be
+// handed out), or returns an error once ctx is canceled.
+func (p *pool) waitReachable(ctx context.Context, i int, finished <-chan struct{}) (bool, error) {
+	probeCtx, canc := context.WithCancel(ctx)
+	defer canc()
+	go func() {
+		select {
+		case <-finished:
+			canc() // abort the current probe
+		case <-probeCtx.Done():
+		}
+	}()
+	ticker := time.NewTicker(reprobeInterval)
+	defer ticker.Stop()
+	for !p.reachable(probeCtx, i) {
+		select {
+		case <-ticker.C:
+		case <-finished:
+			return false, nil
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	return true, nil
+}
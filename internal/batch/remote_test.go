@@ -0,0 +1,71 @@
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPoolDispatch(t *testing.T) {
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+
+	p := newPool([]string{"slow:2019", "fast:2019", "new:2019"})
+	p.observe(0, 10*time.Minute)
+	p.observe(1, 1*time.Minute)
+	p.observe(1, 3*time.Minute)
+
+	work := make(chan *node)
+	go p.dispatch(ctx, work)
+	for i := range p.addrs {
+		p.idle <- i
+	}
+
+	// Unmeasured builders are preferred, then the fastest builder:
+	for _, want := range []int{2, 1, 0} {
+		n := &node{pkg: p.addrs[want]}
+		work <- n
+		var got int
+		select {
+		case <-p.assigned[0]:
+			got = 0
+		case <-p.assigned[1]:
+			got = 1
+		case <-p.assigned[2]:
+			got = 2
+		case <-time.After(5 * time.Second):
+			t.Fatalf("node %s not dispatched", n.pkg)
+		}
+		if got != want {
+			t.Errorf("node dispatched to %s, want %s", p.addrs[got], p.addrs[want])
+		}
+	}
+
+	close(work)
+	for i, ch := range p.assigned {
+		if _, ok := <-ch; ok {
+			t.Errorf("builder %d: work channel not closed", i)
+		}
+	}
+}
+
+func TestWaitReachableFinished(t *testing.T) {
+	// Nothing listens on port 1, so the builder never becomes reachable:
+	p := newPool([]string{"localhost:1"})
+	finished := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(finished)
+	}()
+	start := time.Now()
+	reachable, err := p.waitReachable(context.Background(), 0, finished)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reachable {
+		t.Errorf("waitReachable: builder unexpectedly reachable")
+	}
+	if took := time.Since(start); took >= probeTimeout {
+		t.Errorf("waitReachable returned after %v, want < %v", took, probeTimeout)
+	}
+}
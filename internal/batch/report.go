@@ -0,0 +1,292 @@
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/renameio"
+	"golang.org/x/xerrors"
+)
+
+// logTailLines is the number of lines at the end of a failed build’s log which
+// are included in the report.
+const logTailLines = 50
+
+const (
+	statusSucceeded = "succeeded"
+	statusFailed    = "failed"
+	statusSkipped   = "skipped" // a dependency failed
+	statusPending   = "pending" // batch was interrupted
+)
+
+// depFailedError is recorded for packages which were not built because one of
+// their (transitive) dependencies failed to build.
+type depFailedError struct {
+	dep string // e.g. libx11
+}
+
+func (e *depFailedError) Error() string {
+	return fmt.Sprintf("dependencies cannot be fulfilled: %s failed", e.dep)
+}
+
+// report is the result of a batch build, stored as report.json (for resuming
+// and further processing) and report.html (for humans) in -report_dir.
+type report struct {
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Arch     string       `json:"arch"`
+	Packages []*pkgReport `json:"packages"`
+}
+
+type pkgReport struct {
+	Pkg      string   `json:"pkg"`      // e.g. make
+	Fullname string   `json:"fullname"` // e.g. make-amd64-4.2.1-4
+	Status   string   `json:"status"`   // one of the status constants
+	Deps     []string `json:"deps,omitempty"`
+
+	// Bootstrap is set for the first stage build of a package which is part
+	// of a dependency cycle.
+	Bootstrap bool `json:"bootstrap,omitempty"`
+
+	// Resumed is set for packages which were built in a previous batch that
+	// this batch resumed.
+	Resumed bool `json:"resumed,omitempty"`
+
+	DurationSeconds float64  `json:"duration_seconds,omitempty"`
+	Log             string   `json:"log,omitempty"`
+	LogTail         []string `json:"log_tail,omitempty"`
+	Error           string   `json:"error,omitempty"`
+	FailedDep       string   `json:"failed_dep,omitempty"`
+}
+
+// Count returns the number of packages with the specified status.
+func (r *report) Count(status string) int {
+	var n int
+	for _, p := range r.Packages {
+		if p.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// retry returns the set of packages which did not succeed.
+func (r *report) retry() map[string]bool {
+	retry := make(map[string]bool)
+	for _, p := range r.Packages {
+		if p.Status != statusSucceeded {
+			retry[p.Pkg] = true
+		}
+	}
+	return retry
+}
+
+// merge adds the packages which succeeded in prev to r, so that the report of
+// a resumed batch covers all packages.
+func (r *report) merge(prev *report) {
+	contained := make(map[string]bool)
+	for _, p := range r.Packages {
+		contained[p.Pkg] = true
+	}
+	for _, p := range prev.Packages {
+		if contained[p.Pkg] || p.Status != statusSucceeded {
+			continue
+		}
+		p.Resumed = true
+		r.Packages = append(r.Packages, p)
+	}
+	r.sort()
+}
+
+func (r *report) sort() {
+	sort.Slice(r.Packages, func(i, j int) bool {
+		if r.Packages[i].Pkg == r.Packages[j].Pkg {
+			return r.Packages[i].Bootstrap // first stage first
+		}
+		return r.Packages[i].Pkg < r.Packages[j].Pkg
+	})
+}
+
+// logTail returns the last n lines of the file at path.
+func logTail(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines := make([]string, 0, n)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024) // compiler output can contain long lines
+	for scanner.Scan() {
+		if len(lines) == n {
+			lines = append(lines[:0], lines[1:]...)
+		}
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// report returns a report of the builds which s has run so far.
+func (s *scheduler) report(started time.Time) *report {
+	r := &report{
+		Started:  started,
+		Finished: time.Now(),
+		Arch:     s.arch,
+	}
+	for nodes := s.g.Nodes(); nodes.Next(); {
+		n := nodes.Node().(*node)
+		p := &pkgReport{
+			Pkg:       n.pkg,
+			Fullname:  n.fullname,
+			Bootstrap: n.bootstrap,
+		}
+		for from := s.g.From(n.ID()); from.Next(); {
+			dep := from.Node().(*node)
+			if dep.bootstrap {
+				continue // an implementation detail of building cycles
+			}
+			p.Deps = append(p.Deps, dep.pkg)
+		}
+		sort.Strings(p.Deps)
+		err, ok := s.built[n.key()]
+		var depErr *depFailedError
+		switch {
+		case !ok:
+			p.Status = statusPending
+		case err == nil:
+			p.Status = statusSucceeded
+		case xerrors.As(err, &depErr):
+			p.Status = statusSkipped
+			p.FailedDep = depErr.dep
+		default:
+			p.Status = statusFailed
+		}
+		if err != nil {
+			p.Error = err.Error()
+		}
+		if dur, ok := s.durations[n.key()]; ok {
+			p.DurationSeconds = dur.Seconds()
+		}
+		if p.Status == statusSucceeded || p.Status == statusFailed {
+			p.Log = filepath.Join(s.logDir, n.logName())
+		}
+		if p.Status == statusFailed {
+			tail, err := logTail(p.Log, logTailLines)
+			if err != nil {
+				s.log.Printf("reading log of %s: %v", n.pkg, err)
+			}
+			p.LogTail = tail
+		}
+		r.Packages = append(r.Packages, p)
+	}
+	r.sort()
+	return r
+}
+
+func readReport(dir string) (*report, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "report.json"))
+	if err != nil {
+		return nil, err
+	}
+	var r report
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, xerrors.Errorf("%s: %v", filepath.Join(dir, "report.json"), err)
+	}
+	return &r, nil
+}
+
+func writeReport(dir string, r *report) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := renameio.WriteFile(filepath.Join(dir, "report.json"), append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := reportTmpl.Execute(&buf, struct {
+		*report
+		Dir string
+	}{r, dir}); err != nil {
+		return err
+	}
+	return renameio.WriteFile(filepath.Join(dir, "report.html"), buf.Bytes(), 0644)
+}
+
+var reportTmpl = template.Must(template.New("").Funcs(template.FuncMap{
+	"rel": func(base, path string) string {
+		if rel, err := filepath.Rel(base, path); err == nil {
+			return rel
+		}
+		return path
+	},
+	"duration": func(seconds float64) string {
+		return time.Duration(seconds * float64(time.Second)).Round(time.Second).String()
+	},
+}).Parse(`<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>distri batch report ({{ .Started.Format "2006-01-02 15:04" }})</title>
+  <style type="text/css">
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.5em; text-align: left; vertical-align: top; }
+tr.failed { background-color: #f8d7da; }
+tr.skipped { background-color: #fff3cd; }
+tr.pending { background-color: #e2e3e5; }
+pre { max-height: 30em; overflow: auto; }
+  </style>
+</head>
+<body>
+<h1>distri batch report</h1>
+<p>
+  Architecture: <code>{{ .Arch }}</code><br>
+  Started: {{ .Started.Format "2006-01-02 15:04:05" }}, finished: {{ .Finished.Format "2006-01-02 15:04:05" }}<br>
+  {{ .Count "succeeded" }} succeeded, {{ .Count "failed" }} failed, {{ .Count "skipped" }} skipped, {{ .Count "pending" }} pending, {{ len .Packages }} total
+</p>
+<table>
+<thead>
+  <tr>
+    <th>Package</th>
+    <th>Status</th>
+    <th>Duration</th>
+    <th>Dependencies</th>
+    <th>Log</th>
+  </tr>
+</thead>
+{{ range $pkg := .Packages }}
+  <tr class="{{ $pkg.Status }}"{{ if not $pkg.Bootstrap }} id="{{ $pkg.Pkg }}"{{ end }}>
+    <td>{{ $pkg.Fullname }}{{ if $pkg.Bootstrap }} (bootstrap){{ end }}</td>
+    <td>
+      {{ $pkg.Status }}{{ if $pkg.Resumed }} (previous batch){{ end }}
+      {{ if $pkg.FailedDep }}(<a href="#{{ $pkg.FailedDep }}">{{ $pkg.FailedDep }}</a> failed){{ end }}
+    </td>
+    <td>{{ if $pkg.DurationSeconds }}{{ duration $pkg.DurationSeconds }}{{ end }}</td>
+    <td>{{ range $idx, $dep := $pkg.Deps }}{{ if $idx }}, {{ end }}<a href="#{{ $dep }}">{{ $dep }}</a>{{ end }}</td>
+    <td>{{ if $pkg.Log }}<a href="{{ rel $.Dir $pkg.Log }}">{{ rel $.Dir $pkg.Log }}</a>{{ end }}</td>
+  </tr>
+  {{ if $pkg.LogTail }}
+  <tr class="{{ $pkg.Status }}">
+    <td colspan="5">
+      {{ $pkg.Error }}
+      <pre>{{ range $line := $pkg.LogTail }}{{ $line }}
+{{ end }}</pre>
+    </td>
+  </tr>
+  {{ end }}
+{{ end }}
+</table>
+</body>
+</html>
+`))
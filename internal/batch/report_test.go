@@ -0,0 +1,133 @@
+package batch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gonum.org/v1/gonum/graph/simple"
+)
+
+func TestReport(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "distri-batch-report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// libx11 always fails in simulation mode, see buildDry
+	g := simple.NewDirectedGraph()
+	nodes := make(map[string]*node)
+	for idx, pkg := range []string{"libxau", "libx11", "libxcb", "i3", "xterm"} {
+		n := &node{id: int64(idx), pkg: pkg, fullname: pkg + "-amd64-1"}
+		g.AddNode(n)
+		nodes[pkg] = n
+	}
+	for _, e := range [][2]string{
+		{"libxcb", "libxau"},
+		{"libx11", "libxcb"},
+		{"i3", "libxcb"},
+		{"xterm", "libx11"},
+	} {
+		g.SetEdge(g.NewEdge(nodes[e[0]], nodes[e[1]]))
+	}
+
+	// The log of the failing package is read for the report:
+	var failing strings.Builder
+	for i := 0; i < logTailLines+10; i++ {
+		fmt.Fprintf(&failing, "line %d\n", i)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "libx11.log"), []byte(failing.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &scheduler{
+		log:       log.New(ioutil.Discard, "", 0),
+		logDir:    tmp,
+		simulate:  true,
+		workers:   2,
+		g:         g,
+		built:     make(map[string]error),
+		durations: make(map[string]time.Duration),
+		status:    make([]string, 3),
+		arch:      "amd64",
+	}
+	if err := s.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	r := s.report(time.Now())
+	if err := writeReport(tmp, r); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readReport(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := make(map[string]string)
+	for _, p := range got.Packages {
+		status[p.Pkg] = p.Status
+		switch p.Pkg {
+		case "libx11":
+			if want := fmt.Sprintf("line %d", logTailLines+9); len(p.LogTail) != logTailLines || p.LogTail[len(p.LogTail)-1] != want {
+				t.Errorf("libx11 log tail: got %d lines ending in %q, want %d lines ending in %q", len(p.LogTail), p.LogTail[len(p.LogTail)-1], logTailLines, want)
+			}
+		case "xterm":
+			if got, want := p.FailedDep, "libx11"; got != want {
+				t.Errorf("xterm failed dependency: got %q, want %q", got, want)
+			}
+		case "libxcb":
+			if diff := cmp.Diff([]string{"libxau"}, p.Deps); diff != "" {
+				t.Errorf("libxcb deps: diff (-want +got):\n%s", diff)
+			}
+		}
+	}
+	want := map[string]string{
+		"libxau": statusSucceeded,
+		"libxcb": statusSucceeded,
+		"i3":     statusSucceeded,
+		"libx11": statusFailed,
+		"xterm":  statusSkipped,
+	}
+	if diff := cmp.Diff(want, status); diff != "" {
+		t.Errorf("report: unexpected package status: diff (-want +got):\n%s", diff)
+	}
+
+	html, err := ioutil.ReadFile(filepath.Join(tmp, "report.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"3 succeeded, 1 failed, 1 skipped, 0 pending, 5 total",
+		`<a href="libx11.log">libx11.log</a>`,
+		fmt.Sprintf("line %d", logTailLines+9),
+	} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("report.html does not contain %q", want)
+		}
+	}
+
+	// Resuming retries the failed and skipped packages and keeps the
+	// succeeded packages in the report:
+	if diff := cmp.Diff(map[string]bool{"libx11": true, "xterm": true}, got.retry()); diff != "" {
+		t.Errorf("retry: diff (-want +got):\n%s", diff)
+	}
+	resumed := &report{Packages: []*pkgReport{
+		{Pkg: "libx11", Status: statusSucceeded},
+		{Pkg: "xterm", Status: statusSucceeded},
+	}}
+	resumed.merge(got)
+	if got, want := len(resumed.Packages), len(want); got != want {
+		t.Errorf("merged report: got %d packages, want %d", got, want)
+	}
+	if got, want := resumed.Count(statusSucceeded), len(want); got != want {
+		t.Errorf("merged report: got %d succeeded packages, want %d", got, want)
+	}
+}
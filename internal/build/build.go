@@ -32,7 +32,6 @@ import (
 	"github.com/distr1/distri/internal/env"
 	cmdfuse "github.com/distr1/distri/internal/fuse"
 	"github.com/distr1/distri/internal/squashfs"
-	"github.com/distr1/distri/internal/trace"
 	"github.com/distr1/distri/pb"
 	"github.com/golang/protobuf/proto"
 	"github.com/google/renameio"
@@ -275,55 +274,129 @@ func (cpFi *cpFileInfo) copyTo(w *squashfs.Directory) error {
 	return nil
 }
 
-func cp(w *squashfs.Directory, dir string) error {
+// devIno identifies a file on the host file system.
+type devIno struct {
+	dev, ino uint64
+}
+
+// cpHardlinks tracks regular files with more than one link so that cp can
+// preserve hard links.
+type cpHardlinks struct {
+	// nlink is the number of links to each file within the copied tree,
+	// which can be lower than the number of links on the host file system.
+	nlink map[devIno]uint32
+
+	// written contains the SquashFS files which were already copied.
+	written map[devIno]io.WriteCloser
+}
+
+func newCpHardlinks() *cpHardlinks {
+	return &cpHardlinks{
+		nlink:   make(map[devIno]uint32),
+		written: make(map[devIno]io.WriteCloser),
+	}
+}
+
+// scan counts the links to each regular file within root, skipping claimed
+// paths (see cp) other than root itself.
+func (l *cpHardlinks) scan(root string, claimed map[string]string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, ok := claimed[path]; ok && path != root {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		st, ok := info.Sys().(*syscall.Stat_t)
+		if !ok || !info.Mode().IsRegular() || st.Nlink < 2 {
+			return nil
+		}
+		l.nlink[devIno{uint64(st.Dev), st.Ino}]++
+		return nil
+	})
+}
+
+// cp copies the contents of dir into w and flushes w. Paths in claimed (which
+// belong to a different package) are replaced by a symlink to the target
+// claimed maps them to.
+func cp(w *squashfs.Directory, dir string, links *cpHardlinks, claimed map[string]string) error {
 	//log.Printf("cp(%s)", dir)
 	fis, err := ioutil.ReadDir(dir)
 	if err != nil {
 		return err
 	}
 	for _, fi := range fis {
-		//log.Printf("file %s, mode %#o (raw %#o)", fi.Name(), fi.Mode(), fi.Sys().(*syscall.Stat_t).Mode)
-		if fi.IsDir() {
-			subdir := w.Directory(fi.Name(), fi.ModTime())
-			if err := cp(subdir, filepath.Join(dir, fi.Name())); err != nil {
-				return err
-			}
-		} else if fi.Mode().IsRegular() {
-			in, err := os.Open(filepath.Join(dir, fi.Name()))
-			if err != nil {
-				return err
-			}
-			defer in.Close()
-			attrs, err := readXattrs(int(in.Fd()))
-			if err != nil {
-				return err
-			}
-			f, err := w.File(fi.Name(), fi.ModTime(), uint16(fi.Sys().(*syscall.Stat_t).Mode), attrs)
-			if err != nil {
-				return err
-			}
-			if _, err := io.Copy(f, in); err != nil {
-				return err
-			}
-			if err := f.Close(); err != nil {
-				return err
-			}
-			in.Close()
-		} else if fi.Mode()&os.ModeSymlink != 0 {
-			dest, err := os.Readlink(filepath.Join(dir, fi.Name()))
-			if err != nil {
-				return err
-			}
-			if err := w.Symlink(dest, fi.Name(), fi.ModTime(), fi.Mode().Perm()); err != nil {
-				return err
-			}
-		} else {
-			log.Printf("ERROR: unsupported file: %v", filepath.Join(dir, fi.Name()))
+		if err := cpEntry(w, dir, fi, links, claimed); err != nil {
+			return err
 		}
 	}
 	return w.Flush()
 }
 
+// cpEntry copies the directory entry fi within dir into w (see cp).
+func cpEntry(w *squashfs.Directory, dir string, fi os.FileInfo, links *cpHardlinks, claimed map[string]string) error {
+	//log.Printf("file %s, mode %#o (raw %#o)", fi.Name(), fi.Mode(), fi.Sys().(*syscall.Stat_t).Mode)
+	path := filepath.Join(dir, fi.Name())
+	if target, ok := claimed[path]; ok {
+		return w.Symlink(target, fi.Name(), fi.ModTime(), 0777)
+	}
+	return cpCopy(w, path, fi, links, claimed)
+}
+
+// cpCopy copies the file, directory or symlink at path into w.
+func cpCopy(w *squashfs.Directory, path string, fi os.FileInfo, links *cpHardlinks, claimed map[string]string) error {
+	if fi.IsDir() {
+		subdir := w.Directory(fi.Name(), fi.ModTime())
+		return cp(subdir, path, links, claimed)
+	} else if fi.Mode().IsRegular() {
+		st := fi.Sys().(*syscall.Stat_t)
+		id := devIno{uint64(st.Dev), st.Ino}
+		if target, ok := links.written[id]; ok {
+			return w.Link(fi.Name(), target)
+		}
+		nlink := links.nlink[id]
+		if nlink == 0 {
+			nlink = 1
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		attrs, err := readXattrs(int(in.Fd()))
+		if err != nil {
+			return err
+		}
+		f, err := w.LinkedFile(fi.Name(), fi.ModTime(), uint16(st.Mode), attrs, nlink)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, in); err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		if nlink > 1 {
+			links.written[id] = f
+		}
+	} else if fi.Mode()&os.ModeSymlink != 0 {
+		dest, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if err := w.Symlink(dest, fi.Name(), fi.ModTime(), fi.Mode().Perm()); err != nil {
+			return err
+		}
+	} else {
+		log.Printf("ERROR: unsupported file: %v", path)
+	}
+	return nil
+}
+
 // Ctx is a build context: it contains state about a build.
 type Ctx struct {
 	Proto     *pb.Build `json:"-"`
@@ -344,6 +417,8 @@ type Ctx struct {
 	Jobs        int
 	InputDigest string // opaque result of digest()
 	Repo        string
+	// Compression selects the compressor for data blocks of package images.
+	Compression squashfs.Compression
 
 	// substituteCache maps from a variable name like ${DISTRI_RESOLVE:expat} to
 	// the resolved package name like expat-amd64-2.2.6-1.
@@ -580,6 +655,7 @@ func (b *Ctx) PkgSource() error {
 	if err != nil {
 		return err
 	}
+	w.Compression = b.Compression
 
 	children, err := cpscan(src, "")
 	if err != nil {
@@ -657,126 +733,6 @@ func (b *Ctx) PkgSource() error {
 	return nil
 }
 
-func (b *Ctx) Package() error {
-	type splitPackage struct {
-		Proto  *pb.SplitPackage
-		subdir string
-	}
-	var pkgs []splitPackage
-	for _, pkg := range b.Proto.GetSplitPackage() {
-		pkgs = append(pkgs, splitPackage{
-			Proto:  pkg,
-			subdir: "pkg",
-		})
-	}
-	if _, err := os.Stat(filepath.Join(filepath.Dir(b.DestDir), b.FullName(), "debug")); err == nil {
-		pkgs = append(pkgs, splitPackage{
-			Proto: &pb.SplitPackage{
-				Name:  proto.String(b.Pkg),
-				Claim: []*pb.Claim{{Glob: proto.String("debug")}},
-			},
-			subdir: "debug",
-		})
-	}
-	pkgs = append(pkgs, splitPackage{
-		Proto: &pb.SplitPackage{
-			Name:  proto.String(b.Pkg),
-			Claim: []*pb.Claim{{Glob: proto.String("*")}},
-		},
-		subdir: "pkg",
-	})
-	for _, pkg := range pkgs {
-		log.Printf("packaging %+v", pkg)
-		fullName := pkg.Proto.GetName() + "-" + b.Arch + "-" + b.Version
-		squashfsName := pkg.subdir + "/" + fullName + ".squashfs"
-		pkgEv := trace.Event("pkg "+squashfsName, tidBuildpkg)
-		dest, err := filepath.Abs("../distri/" + squashfsName)
-		if err != nil {
-			return err
-		}
-
-		f, err := renameio.TempFile("", dest)
-		if err != nil {
-			return err
-		}
-		defer f.Cleanup()
-		w, err := squashfs.NewWriter(f, time.Now())
-		if err != nil {
-			return err
-		}
-
-		// Look for files in b.fullName(), i.e. the actual package name
-		destRoot := filepath.Join(filepath.Dir(b.DestDir), b.FullName())
-		// Place files in fullName, i.e. the split package name
-		tmp := filepath.Join(filepath.Dir(b.DestDir), fullName)
-		if pkg.subdir != "pkg" {
-			// Side-step directory conflict for packages with the same name in a
-			// different subdir (e.g. pkg/irssi-amd64-1.1.1.squashfs
-			// vs. debug/irssi-amd64-1.1.1.squashfs):
-			tmp += "-" + pkg.subdir
-		}
-		for _, claim := range pkg.Proto.GetClaim() {
-			if claim.GetGlob() == "*" {
-				// Common path: no globbing or file manipulation required
-				continue
-			}
-			matches, err := filepath.Glob(filepath.Join(destRoot, claim.GetGlob()))
-			if err != nil {
-				return err
-			}
-			// Move files from actual package dir to split package dir
-			for _, m := range matches {
-				rel, err := filepath.Rel(destRoot, m)
-				if err != nil {
-					return err
-				}
-				// rel is e.g. out/lib64/libgcc_s.so.1
-				dest := filepath.Join(tmp, rel)
-				if dir := claim.GetDir(); dir != "" {
-					dest = filepath.Join(tmp, dir, filepath.Base(m))
-				}
-				if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
-					return err
-				}
-				if err := os.Rename(m, dest); err != nil {
-					return err
-				}
-				// TODO: make symlinking the original optional
-				rel, err = filepath.Rel(filepath.Dir(m), dest)
-				if err != nil {
-					return err
-				}
-				if err := os.Symlink(rel, m); err != nil {
-					return err
-				}
-				if pkg.Proto.GetName() == b.Pkg {
-					// Do not add a circular runtime dependency on the main package
-					continue
-				}
-				// automatically add runtime dep on split package to main
-				// package:
-				b.Proto.RuntimeDep = append(b.Proto.RuntimeDep, fullName)
-			}
-		}
-		if err := cp(w.Root, tmp); err != nil {
-			return err
-		}
-
-		if err := w.Flush(); err != nil {
-			return err
-		}
-
-		if err := f.CloseAtomicallyReplace(); err != nil {
-			return err
-		}
-		b.ArtifactWriter.Write([]byte("_build/distri/" + pkg.subdir + "/" + fullName + ".squashfs" + "\n"))
-		log.Printf("package successfully created in %s", dest)
-		pkgEv.Done()
-	}
-
-	return nil
-}
-
 func (b *Ctx) fillSubstituteCache(deps []string) {
 	cache := make(map[string]string)
 	for _, dep := range deps {
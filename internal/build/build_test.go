@@ -1,8 +1,13 @@
 package build
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/distr1/distri/internal/squashfs"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -81,3 +86,69 @@ func TestNewerRevisionGoesFirst(t *testing.T) {
 		t.Errorf("newerRevisionGoesFirst() returned unexpected order: diff (-want +got):\n%s", diff)
 	}
 }
+
+func TestCpHardlinks(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "distri-cp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	src := filepath.Join(tmp, "src")
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "bin", "gzip"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "bin", "gzip"), filepath.Join(src, "bin", "gunzip")); err != nil {
+		t.Fatal(err)
+	}
+	// A link outside of the copied tree must not be counted:
+	if err := os.Link(filepath.Join(src, "bin", "gzip"), filepath.Join(tmp, "outside")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(filepath.Join(tmp, "image.squashfs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := squashfs.NewWriter(f, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := newCpHardlinks()
+	if err := links.scan(src, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp(w.Root, src, links, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := squashfs.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inodes []uint32
+	for _, name := range []string{"gunzip", "gzip"} {
+		inode, err := rd.LookupPath("bin/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := rd.Stat(name, inode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sfi := fi.Sys().(*squashfs.FileInfo)
+		if got, want := sfi.Nlink(), uint32(2); got != want {
+			t.Errorf("bin/%s: unexpected Nlink: got %d, want %d", name, got, want)
+		}
+		inodes = append(inodes, sfi.InodeNumber)
+	}
+	if inodes[0] != inodes[1] {
+		t.Errorf("bin/gunzip and bin/gzip do not share an inode: %v", inodes)
+	}
+}
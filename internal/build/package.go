@@ -0,0 +1,284 @@
+package build
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distr1/distri/internal/squashfs"
+	"github.com/distr1/distri/internal/trace"
+	"github.com/distr1/distri/pb"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/renameio"
+	"golang.org/x/sync/errgroup"
+)
+
+type splitPackage struct {
+	Proto  *pb.SplitPackage
+	subdir string
+}
+
+// packageImage is a SquashFS image which Package writes.
+type packageImage struct {
+	splitPackage
+
+	fullName string // e.g. gcc-libs-amd64-8.2.0-3
+
+	// dir is the (virtual) directory next to the main package directory in
+	// which the package contents are located, e.g.
+	// /tmp/distri-dest123/gcc-libs-amd64-8.2.0-3. Symlinks to claimed paths
+	// point into dir.
+	dir string
+
+	// tree contains the paths claimed by a split package, or is nil for the
+	// main package (which contains all unclaimed paths).
+	tree *splitTree
+
+	// srcs are the claimed paths within the main package directory.
+	srcs []string
+}
+
+// splitTree is a directory within a split package image, assembled from the
+// paths the package claims.
+type splitTree struct {
+	src      string // if non-empty, the claimed file or directory
+	children map[string]*splitTree
+}
+
+// add places the claimed path src at dest (relative to the image root).
+func (t *splitTree) add(dest, src string) {
+	for _, component := range strings.Split(dest, "/") {
+		if t.children == nil {
+			t.children = make(map[string]*splitTree)
+		}
+		child, ok := t.children[component]
+		if !ok {
+			child = &splitTree{}
+			t.children[component] = child
+		}
+		t = child
+	}
+	t.src = src
+}
+
+// copyTo writes t into w and flushes w. Directories which only exist in the
+// split package get modTime.
+func (t *splitTree) copyTo(w *squashfs.Directory, modTime time.Time, links *cpHardlinks, claimed map[string]string) error {
+	names := make([]string, 0, len(t.children))
+	for name := range t.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := t.children[name]
+		if child.src == "" {
+			subdir := w.Directory(name, modTime)
+			if err := child.copyTo(subdir, modTime, links, claimed); err != nil {
+				return err
+			}
+			continue
+		}
+		fi, err := os.Lstat(child.src)
+		if err != nil {
+			return err
+		}
+		// Copy the claimed entry itself, not the symlink it is replaced with
+		// in the main package:
+		if err := cpCopy(w, child.src, fi, links, claimed); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// claimedAncestor returns whether path or one of its parent directories up to
+// root was already claimed.
+func claimedAncestor(claimed map[string]string, root, path string) bool {
+	for ; path != root && path != "/" && path != "."; path = filepath.Dir(path) {
+		if _, ok := claimed[path]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// route resolves the claims of all split packages (in order) within the main
+// package directory destRoot. It returns the claimed paths, mapped to the
+// symlink which replaces them in the main package.
+func (b *Ctx) route(destRoot string, images []*packageImage) (map[string]string, error) {
+	claimed := make(map[string]string)
+	for _, img := range images {
+		if img.tree == nil {
+			continue // main package
+		}
+		var claimedAny bool
+		for _, claim := range img.Proto.GetClaim() {
+			if claim.GetGlob() == "*" {
+				continue
+			}
+			matches, err := filepath.Glob(filepath.Join(destRoot, claim.GetGlob()))
+			if err != nil {
+				return nil, err
+			}
+			for _, m := range matches {
+				if claimedAncestor(claimed, destRoot, m) {
+					log.Printf("%s: %s already claimed by a different split package, skipping", img.fullName, m)
+					continue
+				}
+				rel, err := filepath.Rel(destRoot, m)
+				if err != nil {
+					return nil, err
+				}
+				// rel is e.g. out/lib64/libgcc_s.so.1
+				dest := rel
+				if dir := claim.GetDir(); dir != "" {
+					dest = filepath.Join(dir, filepath.Base(m))
+				}
+				img.tree.add(dest, m)
+				img.srcs = append(img.srcs, m)
+				// TODO: make symlinking the original optional
+				target, err := filepath.Rel(filepath.Dir(m), filepath.Join(img.dir, dest))
+				if err != nil {
+					return nil, err
+				}
+				claimed[m] = target
+				claimedAny = true
+			}
+		}
+		if claimedAny && img.Proto.GetName() != b.Pkg {
+			// automatically add runtime dep on split package to main
+			// package (but not a circular one on the main package):
+			b.Proto.RuntimeDep = append(b.Proto.RuntimeDep, img.fullName)
+		}
+	}
+	return claimed, nil
+}
+
+// Package writes the SquashFS images of the main package and all split
+// packages. Files are streamed from the main package directory into all images
+// at once: paths claimed by split packages are written into their image and
+// replaced by a symlink in the main package.
+func (b *Ctx) Package() error {
+	var pkgs []splitPackage
+	for _, pkg := range b.Proto.GetSplitPackage() {
+		pkgs = append(pkgs, splitPackage{
+			Proto:  pkg,
+			subdir: "pkg",
+		})
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(b.DestDir), b.FullName(), "debug")); err == nil {
+		pkgs = append(pkgs, splitPackage{
+			Proto: &pb.SplitPackage{
+				Name:  proto.String(b.Pkg),
+				Claim: []*pb.Claim{{Glob: proto.String("debug")}},
+			},
+			subdir: "debug",
+		})
+	}
+	main := splitPackage{
+		Proto: &pb.SplitPackage{
+			Name:  proto.String(b.Pkg),
+			Claim: []*pb.Claim{{Glob: proto.String("*")}},
+		},
+		subdir: "pkg",
+	}
+
+	// Look for files in b.fullName(), i.e. the actual package name
+	destRoot := filepath.Join(filepath.Dir(b.DestDir), b.FullName())
+
+	images := make([]*packageImage, 0, len(pkgs)+1)
+	for _, pkg := range append(pkgs, main) {
+		fullName := pkg.Proto.GetName() + "-" + b.Arch + "-" + b.Version
+		img := &packageImage{
+			splitPackage: pkg,
+			fullName:     fullName,
+			dir:          filepath.Join(filepath.Dir(b.DestDir), fullName),
+			tree:         &splitTree{},
+		}
+		if pkg.subdir != "pkg" {
+			// Side-step directory conflict for packages with the same name in a
+			// different subdir (e.g. pkg/irssi-amd64-1.1.1.squashfs
+			// vs. debug/irssi-amd64-1.1.1.squashfs):
+			img.dir += "-" + pkg.subdir
+		}
+		images = append(images, img)
+	}
+	images[len(images)-1].tree = nil // main package
+
+	claimed, err := b.route(destRoot, images)
+	if err != nil {
+		return err
+	}
+
+	mkfsTime := time.Now()
+	var eg errgroup.Group
+	for _, img := range images {
+		img := img // copy
+		eg.Go(func() error {
+			return b.writeImage(img, destRoot, mkfsTime, claimed)
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return err
+	}
+	for _, img := range images {
+		b.ArtifactWriter.Write([]byte("_build/distri/" + img.subdir + "/" + img.fullName + ".squashfs" + "\n"))
+	}
+	return nil
+}
+
+// writeImage writes the SquashFS image of img.
+func (b *Ctx) writeImage(img *packageImage, destRoot string, mkfsTime time.Time, claimed map[string]string) error {
+	log.Printf("packaging %+v", img.splitPackage)
+	squashfsName := img.subdir + "/" + img.fullName + ".squashfs"
+	pkgEv := trace.Event("pkg "+squashfsName, tidBuildpkg)
+	defer pkgEv.Done()
+	dest, err := filepath.Abs("../distri/" + squashfsName)
+	if err != nil {
+		return err
+	}
+
+	f, err := renameio.TempFile("", dest)
+	if err != nil {
+		return err
+	}
+	defer f.Cleanup()
+	w, err := squashfs.NewWriter(f, mkfsTime)
+	if err != nil {
+		return err
+	}
+	w.Compression = b.Compression
+	w.Deduplicate = true
+
+	links := newCpHardlinks()
+	if img.tree == nil {
+		if err := links.scan(destRoot, claimed); err != nil {
+			return err
+		}
+		if err := cp(w.Root, destRoot, links, claimed); err != nil {
+			return err
+		}
+	} else {
+		for _, src := range img.srcs {
+			if err := links.scan(src, claimed); err != nil {
+				return err
+			}
+		}
+		if err := img.tree.copyTo(w.Root, mkfsTime, links, claimed); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := f.CloseAtomicallyReplace(); err != nil {
+		return err
+	}
+	log.Printf("package successfully created in %s", dest)
+	return nil
+}
@@ -0,0 +1,176 @@
+package build
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/distr1/distri/internal/squashfs"
+	"github.com/distr1/distri/pb"
+	"github.com/golang/protobuf/proto"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPackageSplit(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "distri-package")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Set up the layout which the build leaves behind: the package contents
+	// in /tmp/distri-dest…/<fullname>, writing images to ../distri (relative
+	// to the working directory).
+	destRoot := filepath.Join(tmp, "dest", "foo-amd64-1")
+	for fn, contents := range map[string]string{
+		"out/bin/foo":              "#!/bin/sh\n",
+		"out/lib/libfoo.so.1":      "ELF",
+		"out/lib/pkgconfig/foo.pc": "Name: foo\n",
+		"debug/.build-id/ab/cdef":  "debug info",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(destRoot, fn)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(destRoot, fn), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("libfoo.so.1", filepath.Join(destRoot, "out", "lib", "libfoo.so")); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{"build/foo", "build/distri/pkg", "build/distri/debug"} {
+		if err := os.MkdirAll(filepath.Join(tmp, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(tmp, "build", "foo")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var artifacts bytes.Buffer
+	b := &Ctx{
+		Pkg:     "foo",
+		Arch:    "amd64",
+		Version: "1",
+		DestDir: filepath.Join(tmp, "dest", "tmp"),
+		Proto: &pb.Build{
+			SplitPackage: []*pb.SplitPackage{
+				{
+					Name: proto.String("foo-libs"),
+					Claim: []*pb.Claim{
+						{Glob: proto.String("out/lib/libfoo.so*")},
+						{Glob: proto.String("out/lib/pkgconfig/foo.pc"), Dir: proto.String("out/share/pkgconfig")},
+					},
+				},
+			},
+		},
+		ArtifactWriter: &artifacts,
+	}
+	if err := b.Package(); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"foo-libs-amd64-1"}, b.Proto.GetRuntimeDep()); diff != "" {
+		t.Errorf("unexpected runtime deps: diff (-want +got):\n%s", diff)
+	}
+	wantArtifacts := []string{
+		"_build/distri/pkg/foo-libs-amd64-1.squashfs",
+		"_build/distri/debug/foo-amd64-1.squashfs",
+		"_build/distri/pkg/foo-amd64-1.squashfs",
+	}
+	if diff := cmp.Diff(wantArtifacts, strings.Fields(artifacts.String())); diff != "" {
+		t.Errorf("unexpected artifacts: diff (-want +got):\n%s", diff)
+	}
+
+	// The contents must not have been modified:
+	if _, err := os.Stat(filepath.Join(destRoot, "out", "lib", "libfoo.so.1")); err != nil {
+		t.Errorf("package directory modified: %v", err)
+	}
+
+	type entry struct {
+		path   string
+		target string // empty for regular files
+	}
+	for _, tt := range []struct {
+		image   string
+		entries []entry
+	}{
+		{
+			image: "pkg/foo-amd64-1.squashfs",
+			entries: []entry{
+				{"debug", "../foo-amd64-1-debug/debug"},
+				{"out/bin/foo", ""},
+				{"out/lib/libfoo.so", "../../../foo-libs-amd64-1/out/lib/libfoo.so"},
+				{"out/lib/libfoo.so.1", "../../../foo-libs-amd64-1/out/lib/libfoo.so.1"},
+				{"out/lib/pkgconfig/foo.pc", "../../../../foo-libs-amd64-1/out/share/pkgconfig/foo.pc"},
+			},
+		},
+		{
+			image: "pkg/foo-libs-amd64-1.squashfs",
+			entries: []entry{
+				{"out/lib/libfoo.so", "libfoo.so.1"},
+				{"out/lib/libfoo.so.1", ""},
+				{"out/share/pkgconfig/foo.pc", ""},
+			},
+		},
+		{
+			image: "debug/foo-amd64-1.squashfs",
+			entries: []entry{
+				{"debug/.build-id/ab/cdef", ""},
+			},
+		},
+	} {
+		t.Run(tt.image, func(t *testing.T) {
+			f, err := os.Open(filepath.Join(tmp, "build", "distri", tt.image))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			rd, err := squashfs.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []entry
+			var walk func(inode squashfs.Inode, dir string) error
+			walk = func(inode squashfs.Inode, dir string) error {
+				fis, err := rd.Readdir(inode)
+				if err != nil {
+					return err
+				}
+				for _, fi := range fis {
+					path := filepath.Join(dir, fi.Name())
+					inode := fi.Sys().(*squashfs.FileInfo).Inode
+					switch {
+					case fi.IsDir():
+						if err := walk(inode, path); err != nil {
+							return err
+						}
+					case fi.Mode()&os.ModeSymlink != 0:
+						target, err := rd.ReadLink(inode)
+						if err != nil {
+							return err
+						}
+						got = append(got, entry{path, target})
+					default:
+						got = append(got, entry{path, ""})
+					}
+				}
+				return nil
+			}
+			if err := walk(rd.RootInode(), ""); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.entries, got, cmp.AllowUnexported(entry{})); diff != "" {
+				t.Errorf("unexpected image contents: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -42,6 +42,12 @@ var DistriConfig = func() string {
 	return "/etc/distri" // default
 }()
 
+// TrustedKeysDir returns the directory containing the public keys which are
+// trusted to sign repository manifests (typically /etc/distri/keys.d).
+func TrustedKeysDir() string {
+	return filepath.Join(DistriConfig, "keys.d")
+}
+
 // Repos returns all configured repositories by consulting DistriConfig. It is a
 // function to avoid I/O for invocations which don’t need to deal with
 // repositories.
@@ -14,8 +14,10 @@ import (
 	"os/signal"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -24,11 +26,14 @@ import (
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 	"golang.org/x/xerrors"
 	"google.golang.org/grpc"
 
 	"github.com/distr1/distri"
 	"github.com/distr1/distri/internal/env"
+	"github.com/distr1/distri/internal/repo"
+	"github.com/distr1/distri/internal/signing"
 	"github.com/distr1/distri/internal/squashfs"
 	"github.com/distr1/distri/pb"
 )
@@ -124,18 +129,26 @@ func Mount(ctx context.Context, args []string) (join func(context.Context) error
 		ExchangeDirs = filtered
 	}
 
-	// TODO: use inotify to efficiently get updates to the store
+	var trustedKeys []signing.PublicKey
+	if *autoDownload {
+		trustedKeys, err = signing.TrustedKeys(env.TrustedKeysDir())
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	fs := &fuseFS{
 		repo:         *repo,
 		remoteRepos:  remotes,
 		autoDownload: *autoDownload,
+		trustedKeys:  trustedKeys,
 		repoSection:  *section,
 		fileReaders:  make(map[fuseops.InodeID]*io.SectionReader),
 		inodeCnt:     2, // root + ctl inode
 		dirs:         make(map[string]*dir),
 		inodes:       make(map[fuseops.InodeID]interface{}),
 		unions:       make(map[fuseops.InodeID][]fuseops.InodeID),
+		remote:       make(map[string]bool),
 	}
 	dir := &dir{
 		byName: make(map[string]*dirent),
@@ -206,28 +219,20 @@ func Mount(ctx context.Context, args []string) (join func(context.Context) error
 		}
 	}()
 
-	// Set up signal handler for rescanning the repo, but only if the package
-	// list is not filtered:
+	// Set up signal handler and inotify watch for rescanning the repo, but only
+	// if the package list is not filtered:
 	if *pkgsList == "" {
 		go func() {
 			c := make(chan os.Signal, 1)
 			signal.Notify(c, syscall.SIGUSR1)
 			for range c {
-				log.Printf("scanning packages upon SIGUSR1")
-				pkgs, err := fs.findPackages()
-				if err != nil {
-					log.Printf("findPackages: %v", err)
-					continue
-				}
-				fs.mu.Lock()
-				err = fs.scanPackages(&nopLocker{}, pkgs)
-				fs.mu.Unlock()
-				if err != nil {
-					log.Printf("scanPackages: %v", err)
-				}
-				log.Printf("scan done")
+				fs.rescan("SIGUSR1")
 			}
 		}()
+		if err := fs.watchRepo(ctx); err != nil {
+			// SIGUSR1 and the ScanPackages RPC still work:
+			log.Printf("not watching %s for new packages: %v", fs.repo, err)
+		}
 	}
 
 	// logf, err := os.Create("/tmp/fuse.log")
@@ -310,6 +315,28 @@ type dirent struct {
 	inode      fuseops.InodeID
 }
 
+// exposed returns whether a file of the specified mode within a package image
+// is visible in the file system. Device nodes are not: the vendored
+// github.com/jacobsa/fuse does not support InodeAttributes.Rdev, so they would
+// all refer to device 0,0.
+func exposed(mode os.FileMode) bool {
+	return mode&os.ModeDevice == 0
+}
+
+// direntType returns the directory entry type for a file of the specified
+// mode within a package image.
+func direntType(mode os.FileMode) fuseutil.DirentType {
+	switch {
+	case mode.IsDir():
+		return fuseutil.DT_Directory
+	case mode&os.ModeNamedPipe != 0:
+		return fuseutil.DT_FIFO
+	case mode&os.ModeSocket != 0:
+		return fuseutil.DT_Socket
+	}
+	return fuseutil.DT_File
+}
+
 func (d *dirent) typ() fuseutil.DirentType {
 	if d.linkTarget != "" {
 		return fuseutil.DT_File
@@ -352,7 +379,14 @@ type fuseFS struct {
 	remoteRepos  []distri.Repo
 	ctl          string
 	autoDownload bool
-	repoSection  string // e.g. “debug” (default “pkg”)
+	trustedKeys  []signing.PublicKey // if non-empty, downloads must be signed
+	repoSection  string              // e.g. “debug” (default “pkg”)
+
+	manifestMu sync.Mutex // serializes fetching the manifest
+
+	// mounts serializes mounting (and possibly downloading) each image, so
+	// that concurrent lookups do not download the same package concurrently.
+	mounts singleflight.Group
 
 	mu       sync.Mutex
 	inodeCnt fuseops.InodeID
@@ -362,12 +396,35 @@ type fuseFS struct {
 	// pkgs is only ever appended to (empty strings are tombstones), because the
 	// inode for /<pkg> is an index into pkgs.
 	pkgs []string
+	// remote contains the packages which were added from the remote repo’s
+	// metadata (autodownload mode) instead of from the local store. They are
+	// not subject to reconciliation with the local store in scanPackages.
+	remote map[string]bool
 	// readers contains one SquashFS reader for every package, or nil if the
 	// package has not yet been accessed.
 	readers []*squashfsReader
+	// lookups contains the number of kernel references (see ForgetInode) to
+	// inodes of every package. The reader of a removed package is only closed
+	// once its lookup count drops to zero.
+	lookups []uint64
+	// digests contains the expected file digests of the remote repo section
+	// (only populated in autodownload mode).
+	digests map[string]repo.FileDigest
+	// manifest is the verified manifest of the remote repo section (only
+	// populated in autodownload mode with trusted keys). It is replaced
+	// together with digests.
+	manifest signing.Manifest
 
 	fileReadersMu sync.Mutex
 	fileReaders   map[fuseops.InodeID]*io.SectionReader
+
+	// stats are only accessed atomically.
+	stats struct {
+		lookups       uint64
+		reads         uint64
+		bytesServed   uint64
+		autodownloads uint64
+	}
 }
 
 func (fs *fuseFS) growReaders(n int) {
@@ -375,6 +432,9 @@ func (fs *fuseFS) growReaders(n int) {
 	readers := make([]*squashfsReader, n)
 	copy(readers, fs.readers)
 	fs.readers = readers
+	lookups := make([]uint64, n)
+	copy(lookups, fs.lookups)
+	fs.lookups = lookups
 }
 
 func (fs *fuseFS) reader(image int) *squashfsReader {
@@ -623,6 +683,8 @@ func (fs *fuseFS) scanPackage(mu sync.Locker, idx int, pkg string) error {
 	return nil
 }
 
+// scanPackages makes the packages pkgs available, removing previously
+// available packages which are not contained in pkgs.
 func (fs *fuseFS) scanPackages(mu sync.Locker, pkgs []string) error {
 	start := time.Now()
 	defer func() {
@@ -634,9 +696,29 @@ func (fs *fuseFS) scanPackages(mu sync.Locker, pkgs []string) error {
 	}
 
 	existing := make(map[string]bool)
+	mu.Lock()
 	for _, pkg := range fs.pkgs {
+		if pkg == "" || fs.remote[pkg] {
+			continue // tombstone or not backed by the local store
+		}
 		existing[pkg] = true
 	}
+	// Allocate images up-front so that the image numbers of the new packages
+	// are known while scanning them (for runtime unions):
+	images := make(map[string]int)
+	for _, pkg := range pkgs {
+		if existing[pkg] {
+			delete(existing, pkg) // left-overs are deleted packages
+			continue
+		}
+		if fs.remote[pkg] {
+			continue // e.g. autodownloaded, already available
+		}
+		images[pkg] = len(fs.pkgs)
+		fs.pkgs = append(fs.pkgs, pkg)
+	}
+	fs.growReaders(len(fs.pkgs))
+	mu.Unlock()
 
 	{
 		mu := mu // shadow, possibly overwrite:
@@ -645,26 +727,20 @@ func (fs *fuseFS) scanPackages(mu sync.Locker, pkgs []string) error {
 		}
 
 		var eg errgroup.Group
-		for idx, pkg := range pkgs {
-			if existing[pkg] {
-				delete(existing, pkg) // left-overs are deleted packages
-				continue
-			}
+		for pkg, idx := range images {
 			idx, pkg := idx, pkg // copy
 			eg.Go(func() error {
 				if err := fs.scanPackage(mu, idx, pkg); err != nil {
-					if err == errSkipPackage {
-						return nil
+					if err != errSkipPackage {
+						log.Println(err)
 					}
-					log.Println(err)
 					// Not loading a package is a better failure mode than
 					// e.g. distri fuse (which is required for early system
 					// boot) not starting anymore.
-					return nil
+					mu.Lock()
+					fs.pkgs[idx] = "" // tombstone
+					mu.Unlock()
 				}
-				mu.Lock()
-				fs.pkgs = append(fs.pkgs, pkg)
-				mu.Unlock()
 				return nil
 			})
 		}
@@ -674,83 +750,166 @@ func (fs *fuseFS) scanPackages(mu sync.Locker, pkgs []string) error {
 	}
 
 	if leftover := existing; len(leftover) > 0 {
-		// iterate through all symlinks, checking if they begin with /ro/<pkg>,
-		// and <pkg> matching any of the still-present ones
-		scan := make(map[string]bool)
-		for path, dir := range fs.dirs {
-			for idx, dirent := range dir.entries {
-				if dirent == nil {
-					continue // tombstone
-				}
-				if dirent.linkTarget == "" {
-					continue // subdirectory
-				}
-				// e.g. /lib/pkgconfig/bash.pc → ../../bash-amd64-1/out/lib/pkgconfig/bash.pc
-				target := filepath.Clean(filepath.Join(filepath.Dir(path), dirent.linkTarget))
-				// target is now /bash-amd64-1/out/lib/pkgconfig/bash.pc
-				pkg := target[1 : 1+strings.IndexByte(target[1:], '/')]
-				if leftover[pkg] {
-					scan[path] = true
-
-					// delete, in case there is no stand-in (or the stand-in
-					// does not contain the file)
-					delete(dir.byName, dirent.name)
-					dir.entries[idx] = nil // tombstone
-				}
-			}
+		return fs.removePackages(mu, leftover)
+	}
+	return nil
+}
+
+// removePackages removes the packages in removed: their directory in the root
+// directory, their runtime unions and their exchange directory symlinks, which
+// are recomputed from the remaining packages. The reader of a removed package
+// is closed once the kernel no longer references any of its inodes.
+func (fs *fuseFS) removePackages(mu sync.Locker, removed map[string]bool) error {
+	mu.Lock()
+	images := make(map[int]bool)
+	for idx, pkg := range fs.pkgs {
+		if !removed[pkg] {
+			continue
 		}
-		affectedExchangeDirs := make([]string, 0, len(scan))
-		for path := range scan {
-			affectedExchangeDirs = append(affectedExchangeDirs, path)
+		log.Printf("removing %s", pkg)
+		fs.pkgs[idx] = "" // tombstone
+		delete(fs.remote, pkg)
+		images[idx] = true
+		if fs.lookups[idx] == 0 {
+			fs.releaseLocked(idx)
 		}
-		for deleted := range existing {
-			var standin string
-			for arch := range distri.Architectures {
-				archmiddle := "-" + arch + "-"
-				if !strings.Contains(deleted, archmiddle) {
-					continue
-				}
-				source := deleted[:strings.Index(deleted, archmiddle)+len(archmiddle)]
-				matches, err := filepath.Glob(filepath.Join(fs.repo, source+"*.squashfs"))
-				if err != nil {
-					return err
-				}
-				if len(matches) == 0 {
-					continue
-				}
-				for idx, m := range matches {
-					matches[idx] = strings.TrimSuffix(filepath.Base(m), ".squashfs")
-				}
-				sort.Slice(matches, func(i, j int) bool {
-					return distri.PackageRevisionLess(matches[j], matches[i]) // reverse
-				})
-				standin = matches[0]
-				break
+	}
+	if len(images) == 0 {
+		mu.Unlock()
+		return nil // none of the packages are available
+	}
+
+	for src, dsts := range fs.unions {
+		if images[inodeImage(src)] {
+			delete(fs.unions, src)
+			continue
+		}
+		filtered := dsts[:0]
+		for _, dst := range dsts {
+			if !images[inodeImage(dst)] {
+				filtered = append(filtered, dst)
 			}
-			if standin == "" {
-				continue
+		}
+		if len(filtered) == len(dsts) {
+			continue
+		}
+		fs.unions[src] = filtered
+		if rd := fs.readers[inodeImage(src)]; rd != nil {
+			rd.dircacheMu.Lock()
+			delete(rd.dircache, squashfs.Inode(src&0xFFFFFFFFFFFF)) // invalidate dircache
+			rd.dircacheMu.Unlock()
+		}
+	}
+
+	// Delete all symlinks pointing into the removed packages, remembering the
+	// affected exchange directories:
+	affected := make(map[string]bool)
+	for path, dir := range fs.dirs {
+		for idx, dirent := range dir.entries {
+			if dirent == nil {
+				continue // tombstone
 			}
-			f, err := os.Open(filepath.Join(fs.repo, standin+".squashfs"))
-			if err != nil {
-				return err
+			if dirent.linkTarget == "" {
+				continue // subdirectory
 			}
-			defer f.Close()
-			rd, err := squashfs.NewReader(f)
-			if err != nil {
-				return err
+			// e.g. /lib/pkgconfig/bash.pc → ../../bash-amd64-1/out/lib/pkgconfig/bash.pc
+			target := filepath.Clean(filepath.Join(filepath.Dir(path), dirent.linkTarget))
+			// target is now /bash-amd64-1/out/lib/pkgconfig/bash.pc
+			pkg := target[1 : 1+strings.IndexByte(target[1:], '/')]
+			if !removed[pkg] {
+				continue
 			}
-
-			if err := fs.scanPackagesSymlink(mu, rd, standin, affectedExchangeDirs); err != nil {
-				return err
+			affected[path] = true
+			if dir.byName[dirent.name] == dirent {
+				delete(dir.byName, dirent.name)
 			}
+			dir.entries[idx] = nil // tombstone
 		}
 	}
+	remaining := make([]string, 0, len(fs.pkgs))
+	for _, pkg := range fs.pkgs {
+		if pkg != "" && !fs.remote[pkg] { // remote symlinks are not recomputed
+			remaining = append(remaining, pkg)
+		}
+	}
+	mu.Unlock()
 
-	fs.growReaders(len(fs.pkgs))
+	if len(affected) == 0 {
+		return nil
+	}
 
+	// Recompute the affected exchange directories, in the same order in which
+	// packages were added, so that the same packages win:
+	exchangeDirs := packagePaths(affected)
+	for _, pkg := range remaining {
+		if err := fs.rescanSymlinks(mu, pkg, exchangeDirs); err != nil {
+			log.Printf("%s: %v", pkg, err)
+		}
+	}
 	return nil
 }
 
+// rescanSymlinks adds symlinks into pkg to exchangeDirs (paths within the
+// package, e.g. /out/lib).
+func (fs *fuseFS) rescanSymlinks(mu sync.Locker, pkg string, exchangeDirs []string) error {
+	f, err := os.Open(filepath.Join(fs.repo, pkg+".squashfs"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rd, err := squashfs.NewReader(f)
+	if err != nil {
+		return err
+	}
+	return fs.scanPackagesSymlink(mu, rd, pkg, exchangeDirs)
+}
+
+// packagePaths returns the package paths (e.g. /out/lib/pkgconfig) backing the
+// specified exchange directories (e.g. /lib/pkgconfig), omitting paths whose
+// parent directory is contained, too.
+func packagePaths(exchangeDirs map[string]bool) []string {
+	var paths []string
+	for path := range exchangeDirs {
+		covered := false
+		for parent := filepath.Dir(path); parent != "/"; parent = filepath.Dir(parent) {
+			if exchangeDirs[parent] {
+				covered = true
+				break
+			}
+		}
+		if covered {
+			continue
+		}
+		pkgPath := "/out" + path
+		for _, dir := range ExchangeDirs {
+			if !strings.HasPrefix(dir, "/out/") && (path == dir || strings.HasPrefix(path, dir+"/")) {
+				pkgPath = path // e.g. /bin, which is not backed by /out/bin
+				break
+			}
+		}
+		paths = append(paths, pkgPath)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// releaseLocked closes the reader of image, if any. fs.mu must be held.
+func (fs *fuseFS) releaseLocked(image int) {
+	rd := fs.readers[image]
+	if rd == nil {
+		return
+	}
+	fs.readers[image] = nil
+	rd.file.Close()
+	fs.fileReadersMu.Lock()
+	defer fs.fileReadersMu.Unlock()
+	for inode := range fs.fileReaders {
+		if inodeImage(inode) == image {
+			delete(fs.fileReaders, inode)
+		}
+	}
+}
+
 type nopLocker struct{}
 
 func (*nopLocker) Lock()   {}
@@ -770,6 +929,20 @@ func (fs *fuseFS) updatePackages() error {
 	if err != nil {
 		return xerrors.Errorf("reading meta.binaryproto: %v", err)
 	}
+	// Fetch the manifest again: after a repository publish, the previous
+	// manifest no longer covers meta.binaryproto or the new packages.
+	var manifest signing.Manifest
+	if len(fs.trustedKeys) > 0 {
+		fs.manifestMu.Lock()
+		manifest, err = fetchManifest(fs.remoteRepos[0].Path+"/"+fs.repoSection, fs.trustedKeys)
+		fs.manifestMu.Unlock()
+		if err != nil {
+			return err
+		}
+		if err := manifest.VerifyBytes("meta.binaryproto", b); err != nil {
+			return err
+		}
+	}
 	var mm pb.MirrorMeta
 	if err := proto.Unmarshal(b, &mm); err != nil {
 		return err
@@ -779,6 +952,10 @@ func (fs *fuseFS) updatePackages() error {
 	existing := make(map[string]bool)
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
+	fs.digests = repo.Digests(&mm)
+	if manifest != nil {
+		fs.manifest = manifest
+	}
 	for _, pkg := range fs.pkgs {
 		existing[pkg] = true
 	}
@@ -787,6 +964,7 @@ func (fs *fuseFS) updatePackages() error {
 			continue
 		}
 		fs.pkgs = append(fs.pkgs, pkg.GetName())
+		fs.remote[pkg.GetName()] = true
 		for _, p := range pkg.GetWellKnownPath() {
 			exchangePath := "/" + strings.TrimPrefix(filepath.Dir(p), "out/")
 			fs.mkExchangeDirAll(&nopLocker{}, exchangePath)
@@ -807,15 +985,55 @@ func (fs *fuseFS) updatePackages() error {
 	return nil
 }
 
+// remoteManifest returns the verified manifest of the remote repository section,
+// or nil if no trusted keys are configured. The manifest is fetched on first use
+// and replaced by updatePackages.
+func (fs *fuseFS) remoteManifest() (signing.Manifest, error) {
+	if len(fs.trustedKeys) == 0 {
+		return nil, nil
+	}
+	fs.manifestMu.Lock()
+	defer fs.manifestMu.Unlock()
+	fs.mu.Lock()
+	m := fs.manifest
+	fs.mu.Unlock()
+	if m != nil {
+		return m, nil
+	}
+	m, err := fetchManifest(fs.remoteRepos[0].Path+"/"+fs.repoSection, fs.trustedKeys)
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.manifest == nil { // not yet set by updatePackages
+		fs.manifest = m
+	}
+	return fs.manifest, nil
+}
+
 func (fs *fuseFS) mountImage(image int) error {
 	//log.Printf("mountImage(%d)", image)
 	if fs.reader(image) != nil {
 		return nil // already mounted
 	}
+	_, err, _ := fs.mounts.Do(strconv.Itoa(image), func() (interface{}, error) {
+		return nil, fs.mountImage1(image)
+	})
+	return err
+}
+
+func (fs *fuseFS) mountImage1(image int) error {
+	if fs.reader(image) != nil {
+		return nil // mounted while waiting for a previous mountImage1 call
+	}
 
 	fs.mu.Lock()
 	pkg := fs.pkgs[image]
 	fs.mu.Unlock()
+	if pkg == "" {
+		return xerrors.Errorf("image %d: package was removed", image)
+	}
 	log.Printf("mounting %s", pkg)
 
 	// var err error
@@ -828,10 +1046,17 @@ func (fs *fuseFS) mountImage(image int) error {
 		if !fs.autoDownload {
 			return err
 		}
-		f, err = autodownload(fs.repo, fs.remoteRepos[0].Path, fs.repoSection+"/"+pkg+".squashfs")
+		if _, err := fs.remoteManifest(); err != nil {
+			return err
+		}
+		fs.mu.Lock()
+		manifest, digests := fs.manifest, fs.digests
+		fs.mu.Unlock()
+		f, err = autodownload(context.Background(), fs.repo, fs.remoteRepos[0].Path, fs.repoSection+"/"+pkg+".squashfs", manifest, digests)
 		if err != nil {
 			return err
 		}
+		atomic.AddUint64(&fs.stats.autodownloads, 1)
 	}
 	rd, err := squashfs.NewReader(f)
 	if err != nil {
@@ -850,7 +1075,7 @@ func (fs *fuseFS) mountImage(image int) error {
 func (fs *fuseFS) squashfsInode(i fuseops.InodeID) (int, squashfs.Inode, error) {
 	// encoding scheme: <imagenr(uint16)> <startblock(uint32)> <offset(uint16)>
 	// where imagenr starts at 1 (because 0 is an invalid inode in FUSE, but valid in SquashFS)
-	image := int((i>>48)&0xFFFF) - 1
+	image := inodeImage(i)
 	i &= 0xFFFFFFFFFFFF // remove imagenr
 	// We must support RootInodeID == 1: https://github.com/libfuse/libfuse/issues/267
 	if i == fuseops.RootInodeID {
@@ -866,15 +1091,25 @@ func (fs *fuseFS) squashfsInode(i fuseops.InodeID) (int, squashfs.Inode, error)
 	return image, squashfs.Inode(i), nil
 }
 
+// inodeImage returns the image of the FUSE inode i (see squashfsInode), or -1
+// for virtual inodes.
+func inodeImage(i fuseops.InodeID) int {
+	return int((i>>48)&0xFFFF) - 1
+}
+
 func (fs *fuseFS) fuseInode(image int, i squashfs.Inode) fuseops.InodeID {
 	//log.Printf("fuseInode(%d, %d) = %d", image, i, fuseops.InodeID(uint16(image+1))<<48|fuseops.InodeID(i))
 	return fuseops.InodeID(uint16(image+1))<<48 | fuseops.InodeID(i)
 }
 
 func (fs *fuseFS) fuseAttributes(fi os.FileInfo) fuseops.InodeAttributes {
+	nlink := uint32(1) // TODO: number of incoming hard links to directories
+	if sfi, ok := fi.Sys().(*squashfs.FileInfo); ok && fi.Mode().IsRegular() && sfi.Nlink() > 0 {
+		nlink = sfi.Nlink() // hard links within the image
+	}
 	return fuseops.InodeAttributes{
 		Size:  uint64(fi.Size()),
-		Nlink: 1, // TODO: number of incoming hard links to this inode
+		Nlink: nlink,
 		Mode:  fi.Mode(),
 		Atime: fi.ModTime(),
 		Mtime: fi.ModTime(),
@@ -906,6 +1141,39 @@ var never = time.Now().Add(365 * 24 * time.Hour)
 const VirtualFileExpiration = 1 * time.Second
 
 func (fs *fuseFS) LookUpInode(ctx context.Context, op *fuseops.LookUpInodeOp) error {
+	atomic.AddUint64(&fs.stats.lookups, 1)
+	if err := fs.lookUpInode(ctx, op); err != nil {
+		return err
+	}
+	// Each successful lookup is a reference, which the kernel releases via
+	// ForgetInode:
+	if image := inodeImage(op.Entry.Child); op.Entry.Child != 0 && image > -1 {
+		fs.mu.Lock()
+		fs.lookups[image]++
+		fs.mu.Unlock()
+	}
+	return nil
+}
+
+func (fs *fuseFS) ForgetInode(ctx context.Context, op *fuseops.ForgetInodeOp) error {
+	image := inodeImage(op.Inode)
+	if image == -1 {
+		return nil // virtual inode
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.lookups[image] < op.N {
+		fs.lookups[image] = 0
+	} else {
+		fs.lookups[image] -= op.N
+	}
+	if fs.lookups[image] == 0 && fs.pkgs[image] == "" {
+		fs.releaseLocked(image) // no longer referenced, removed package
+	}
+	return nil
+}
+
+func (fs *fuseFS) lookUpInode(ctx context.Context, op *fuseops.LookUpInodeOp) error {
 	//log.Printf("LookUpInode(op=%+v)", op)
 	// find dirent op.Name in inode op.Parent
 	image, squashfsInode, err := fs.squashfsInode(op.Parent)
@@ -1007,6 +1275,9 @@ func (fs *fuseFS) LookUpInode(ctx context.Context, op *fuseops.LookUpInodeOp) er
 		for ur.Next() {
 			image := ur.Image()
 			for _, fi := range ur.Dir() {
+				if !exposed(fi.Mode()) {
+					continue
+				}
 				fis[fi.Name()] = fuseops.ChildInodeEntry{
 					Child:      fs.fuseInode(image, fi.Sys().(*squashfs.FileInfo).Inode),
 					Attributes: fs.fuseAttributes(fi),
@@ -1199,15 +1470,14 @@ func (fs *fuseFS) ReadDir(ctx context.Context, op *fuseops.ReadDirOp) error {
 	for ur.Next() {
 		image := ur.Image()
 		for _, e := range ur.Dir() {
-			direntType := fuseutil.DT_File
-			if e.IsDir() {
-				direntType = fuseutil.DT_Directory
+			if !exposed(e.Mode()) {
+				continue
 			}
 			fis = append(fis, fuseutil.Dirent{
 				Offset: fuseops.DirOffset(len(fis)) + 1, // (opaque) offset of the next entry
 				Inode:  fs.fuseInode(image, e.Sys().(*squashfs.FileInfo).Inode),
 				Name:   e.Name(),
-				Type:   direntType,
+				Type:   direntType(e.Mode()),
 			})
 		}
 	}
@@ -1260,6 +1530,8 @@ func (fs *fuseFS) ReadFile(ctx context.Context, op *fuseops.ReadFileOp) error {
 	}
 	var err error
 	op.BytesRead, err = r.ReadAt(op.Dst, op.Offset)
+	atomic.AddUint64(&fs.stats.reads, 1)
+	atomic.AddUint64(&fs.stats.bytesServed, uint64(op.BytesRead))
 	if err == io.EOF {
 		err = nil // FUSE does not want io.EOF
 	}
@@ -1410,3 +1682,13 @@ func (fs *fuseFS) ScanPackages(ctx context.Context, req *pb.ScanPackagesRequest)
 	defer fs.mu.Unlock()
 	return &pb.ScanPackagesReply{}, fs.scanPackages(&nopLocker{}, pkgs)
 }
+
+func (fs *fuseFS) RemovePackages(ctx context.Context, req *pb.RemovePackagesRequest) (*pb.RemovePackagesReply, error) {
+	removed := make(map[string]bool)
+	for _, pkg := range req.GetPkg() {
+		removed[pkg] = true
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return &pb.RemovePackagesReply{}, fs.removePackages(&nopLocker{}, removed)
+}
@@ -0,0 +1,26 @@
+package fuse
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPackagePaths(t *testing.T) {
+	got := packagePaths(map[string]bool{
+		"/bin":                true,
+		"/lib":                true,
+		"/lib/pkgconfig":      true, // covered by /lib
+		"/share/man/man1":     true,
+		"/debug/.build-id/ab": true,
+	})
+	want := []string{
+		"/bin",
+		"/debug/.build-id/ab",
+		"/out/lib",
+		"/out/share/man/man1",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("packagePaths: unexpected result: diff (-want +got):\n%s", diff)
+	}
+}
@@ -113,6 +113,31 @@ func TestFUSE(t *testing.T) {
 	}
 	cl := pb.NewFUSEClient(conn)
 
+	t.Run("Introspection", func(t *testing.T) {
+		list, err := cl.ListPackages(ctx, &pb.ListPackagesRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, pkg := range list.GetPackage() {
+			names = append(names, pkg.GetName())
+		}
+		if diff := cmp.Diff([]string{"less-amd64-530", "less-amd64-530-2"}, names); diff != "" {
+			t.Errorf("ListPackages: unexpected packages: diff (-want +got):\n%s", diff)
+		}
+
+		stats, err := cl.Stats(ctx, &pb.StatsRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := stats.GetPackages(), uint64(2); got != want {
+			t.Errorf("Stats: packages = %d, want %d", got, want)
+		}
+		if stats.GetLookups() == 0 {
+			t.Errorf("Stats: lookups = 0, want > 0")
+		}
+	})
+
 	t.Run("AddNewPackage", func(t *testing.T) {
 		addPackage("bash-amd64", "bash-amd64-1", meta("bash", "1"))
 
@@ -170,6 +195,47 @@ func TestFUSE(t *testing.T) {
 			t.Fatalf("Readlink(bin/less) = %v, want %v", got, want)
 		}
 	})
+
+	t.Run("DeletePackage", func(t *testing.T) {
+		for _, suffix := range []string{".meta.textproto", ".squashfs"} {
+			if err := os.Remove(filepath.Join(repo, "bash-amd64-1"+suffix)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := cl.ScanPackages(ctx, &pb.ScanPackagesRequest{}); err != nil {
+			t.Fatal(err)
+		}
+
+		// TODO: drop cache instead of waiting for it to expire
+		time.Sleep(2 * fuse.VirtualFileExpiration) // ensure cache expired
+
+		if _, err := os.Lstat(tmpdir + "/bin/bash"); !os.IsNotExist(err) {
+			t.Fatalf("Lstat(bin/bash) = %v, want not exist", err)
+		}
+		if _, err := os.Stat(tmpdir + "/bash-amd64-1"); !os.IsNotExist(err) {
+			t.Fatalf("Stat(bash-amd64-1) = %v, want not exist", err)
+		}
+	})
+
+	t.Run("RemovePackages", func(t *testing.T) {
+		if _, err := cl.RemovePackages(ctx, &pb.RemovePackagesRequest{
+			Pkg: []string{"less-amd64-530-2"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// TODO: drop cache instead of waiting for it to expire
+		time.Sleep(2 * fuse.VirtualFileExpiration) // ensure cache expired
+
+		target, err := os.Readlink(tmpdir + "/bin/less")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := target, "../less-amd64-530/bin/less"; got != want {
+			t.Fatalf("Readlink(bin/less) = %v, want %v", got, want)
+		}
+	})
 }
 
 func TestXattr(t *testing.T) {
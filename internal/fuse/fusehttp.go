@@ -1,6 +1,7 @@
 package fuse
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,8 +10,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/google/renameio"
+	"github.com/distr1/distri"
+	"github.com/distr1/distri/internal/repo"
+	"github.com/distr1/distri/internal/signing"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/xerrors"
 )
@@ -56,9 +60,48 @@ func (hr *httpReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
 	return n, err
 }
 
-func autodownload(imgDir, remote, rel string) (*os.File, error) {
-	fileurl := remote + "/" + rel
-	dest := filepath.Join(imgDir, filepath.Base(fileurl))
+func httpGet(fileurl string) ([]byte, error) {
+	resp, err := httpClient.Get(fileurl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		return nil, xerrors.Errorf("%s: HTTP status %v", fileurl, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// fetchManifest downloads the manifest of the remote repository directory
+// (e.g. http://repo.distr1.org/distri/jackherer/debug) and verifies its
+// signature.
+func fetchManifest(dirurl string, keys []signing.PublicKey) (signing.Manifest, error) {
+	b, err := httpGet(dirurl + "/" + signing.ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := httpGet(dirurl + "/" + signing.SignatureName)
+	if err != nil {
+		return nil, err
+	}
+	m, err := signing.VerifyManifest(keys, b, sig)
+	if err != nil {
+		return nil, xerrors.Errorf("%s: %v", dirurl, err)
+	}
+	return m, nil
+}
+
+// autodownloadRetries is the number of times an interrupted download is
+// resumed before giving up.
+const autodownloadRetries = 5
+
+// autodownload downloads rel from remote into imgDir. Partial downloads are
+// kept in imgDir/tmp and resumed on the next access. Files for which digests
+// contains an entry are verified (and downloaded again once on mismatch). If
+// manifest is non-nil, all downloaded files are verified against it before
+// being renamed into imgDir.
+func autodownload(ctx context.Context, imgDir, remote, rel string, manifest signing.Manifest, digests map[string]repo.FileDigest) (*os.File, error) {
+	dest := filepath.Join(imgDir, filepath.Base(rel))
 
 	// If the file can be opened, it was successfully downloaded already. As
 	// files never change (only new files are added), no update check is needed.
@@ -67,42 +110,72 @@ func autodownload(imgDir, remote, rel string) (*os.File, error) {
 	}
 
 	var (
-		eg       errgroup.Group
-		base     = strings.TrimSuffix(dest, ".squashfs")
-		baseurl  = strings.TrimSuffix(fileurl, ".squashfs")
-		files    = make(map[string]*renameio.PendingFile)
-		suffixes = []string{".squashfs"}
+		eg     errgroup.Group
+		tmpDir = filepath.Join(imgDir, "tmp")
+		d      = &repo.Downloader{
+			PartialDir: tmpDir,
+			Retries:    autodownloadRetries,
+			Progress: func(p repo.Progress) {
+				log.Print(p)
+			},
+			ProgressInterval: 5 * time.Second,
+		}
+		remoteRepo = distri.Repo{PkgPath: remote}
+		base       = strings.TrimSuffix(rel, ".squashfs")
+		suffixes   = []string{".squashfs"}
 	)
 	if !strings.HasPrefix(rel, "debug/") &&
 		!strings.HasPrefix(rel, "src/") {
 		suffixes = append(suffixes, ".meta.textproto")
 	}
+	// Complete, but not yet verified files are named with a fuse- prefix so
+	// that distri install does not consider them stale work directories.
+	tmpName := func(suffix string) string {
+		return filepath.Join(tmpDir, "fuse-"+filepath.Base(base+suffix))
+	}
 	for _, suffix := range suffixes {
 		suffix := suffix // copy
-		f, err := renameio.TempFile("", base+suffix)
-		if err != nil {
-			return nil, err
-		}
-		defer f.Cleanup()
-		files[suffix] = f
 		eg.Go(func() error {
-			resp, err := http.Get(baseurl + suffix)
-			if err != nil {
-				return err
+			fn := filepath.Base(base + suffix)
+			digest, ok := digests[fn]
+			tmp := tmpName(suffix)
+			for attempt := 0; ; attempt++ {
+				if _, err := d.Download(ctx, remoteRepo, base+suffix, tmp); err != nil {
+					return err
+				}
+				if !ok {
+					break // no digest to verify against
+				}
+				err := digest.Verify(tmp)
+				if err == nil {
+					break
+				}
+				if _, ok := err.(*repo.ErrIntegrity); !ok || attempt > 0 {
+					os.Remove(tmp)
+					return err
+				}
+				log.Printf("%v, retrying download", err)
+				if err := os.Remove(tmp); err != nil {
+					return err
+				}
 			}
-			defer resp.Body.Close()
-			if got, want := resp.StatusCode, http.StatusOK; got != want {
-				return xerrors.Errorf("%s: HTTP status %v", baseurl+suffix, resp.Status)
+			if manifest != nil {
+				if err := manifest.VerifyFile(fn, tmp); err != nil {
+					os.Remove(tmp)
+					return err
+				}
 			}
-			_, err = io.Copy(f, resp.Body)
-			return err
+			return nil
 		})
 	}
 	if err := eg.Wait(); err != nil {
 		return nil, err
 	}
-	for _, suffix := range suffixes {
-		if err := files[suffix].CloseAtomicallyReplace(); err != nil {
+	// First meta, then image: the image is considered canonical, so it must go
+	// last.
+	for i := len(suffixes) - 1; i >= 0; i-- {
+		suffix := suffixes[i]
+		if err := os.Rename(tmpName(suffix), filepath.Join(imgDir, filepath.Base(base+suffix))); err != nil {
 			return nil, err
 		}
 	}
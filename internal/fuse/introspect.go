@@ -0,0 +1,179 @@
+package fuse
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/distr1/distri"
+	"github.com/distr1/distri/internal/squashfs"
+	"github.com/distr1/distri/pb"
+	"github.com/golang/protobuf/proto"
+)
+
+func (fs *fuseFS) ListPackages(ctx context.Context, req *pb.ListPackagesRequest) (*pb.ListPackagesReply, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var pkgs []*pb.MountedPackage
+	for idx, pkg := range fs.pkgs {
+		if pkg == "" {
+			continue // tombstone
+		}
+		pkgs = append(pkgs, &pb.MountedPackage{
+			Name:     proto.String(pkg),
+			Image:    proto.String(filepath.Join(fs.repo, pkg+".squashfs")),
+			InodeMin: proto.Uint64(uint64(fs.fuseInode(idx, 0))),
+			InodeMax: proto.Uint64(uint64(fs.fuseInode(idx, 0xFFFFFFFFFFFF))),
+			Open:     proto.Bool(fs.readers[idx] != nil),
+			Lookups:  proto.Uint64(fs.lookups[idx]),
+		})
+	}
+	return &pb.ListPackagesReply{Package: pkgs}, nil
+}
+
+// exchangeEntries calls fn for every entry (e.g. /bin/sh) which pkg provides
+// in the exchange directories.
+func (fs *fuseFS) exchangeEntries(pkg string, fn func(path string)) error {
+	f, err := os.Open(filepath.Join(fs.repo, pkg+".squashfs"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rd, err := squashfs.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	type pathWithInode struct {
+		path  string
+		inode squashfs.Inode
+	}
+	var inodes []pathWithInode
+	for _, path := range ExchangeDirs {
+		inode, err := rd.LookupPath(strings.TrimPrefix(path, "/"))
+		if err != nil {
+			if _, ok := err.(*squashfs.FileNotFoundError); ok {
+				continue
+			}
+			return err
+		}
+		inodes = append(inodes, pathWithInode{path, inode})
+	}
+	// Exchange directories can be nested (e.g. /out/lib and /out/lib/gio):
+	seen := make(map[string]bool)
+	for len(inodes) > 0 {
+		path, inode := inodes[0].path, inodes[0].inode
+		inodes = inodes[1:]
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		sfis, err := rd.ReaddirNoStat(inode)
+		if err != nil {
+			return err
+		}
+		for _, sfi := range sfis {
+			full := filepath.Join(path, sfi.Name())
+			if sfi.Mode().IsDir() {
+				inodes = append(inodes, pathWithInode{full, sfi.Sys().(*squashfs.FileInfo).Inode})
+				continue
+			}
+			fn(strings.TrimPrefix(full, "/out"))
+		}
+	}
+	return nil
+}
+
+// exchangeOwnerLocked returns the package to which the exchange directory entry
+// path (e.g. /bin/sh) resolves. fs.mu must be held.
+func (fs *fuseFS) exchangeOwnerLocked(path string) string {
+	dir, ok := fs.dirs[filepath.Dir(path)]
+	if !ok {
+		return ""
+	}
+	dirent, ok := dir.byName[filepath.Base(path)]
+	if !ok || dirent.linkTarget == "" {
+		return ""
+	}
+	// e.g. /lib/pkgconfig/bash.pc → ../../bash-amd64-1/out/lib/pkgconfig/bash.pc
+	target := filepath.Clean(filepath.Join(filepath.Dir(path), dirent.linkTarget))
+	return target[1 : 1+strings.IndexByte(target[1:], '/')]
+}
+
+func (fs *fuseFS) ExchangeConflicts(ctx context.Context, req *pb.ExchangeConflictsRequest) (*pb.ExchangeConflictsReply, error) {
+	prefix := "/" + strings.Trim(req.GetDir(), "/")
+	if prefix != "/" {
+		prefix += "/"
+	}
+
+	fs.mu.Lock()
+	pkgs := make([]string, 0, len(fs.pkgs))
+	for _, pkg := range fs.pkgs {
+		if pkg != "" {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	fs.mu.Unlock()
+
+	// candidates are in the order in which packages were added, which is the
+	// order in which they claim exchange directory entries.
+	candidates := make(map[string][]string)
+	for _, pkg := range pkgs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := fs.exchangeEntries(pkg, func(path string) {
+			if strings.HasPrefix(path, prefix) {
+				candidates[path] = append(candidates[path], pkg)
+			}
+		}); err != nil {
+			if os.IsNotExist(err) {
+				continue // removed in the meantime
+			}
+			return nil, err
+		}
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var conflicts []*pb.ExchangeConflict
+	for path, pkgs := range candidates {
+		names := make(map[string]bool)
+		for _, pkg := range pkgs {
+			names[distri.ParseVersion(pkg).Pkg] = true
+		}
+		if len(names) < 2 {
+			continue // revisions of the same package do not conflict
+		}
+		conflicts = append(conflicts, &pb.ExchangeConflict{
+			Path:      proto.String(strings.TrimPrefix(path, "/")),
+			Winner:    proto.String(fs.exchangeOwnerLocked(path)),
+			Candidate: pkgs,
+		})
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].GetPath() < conflicts[j].GetPath()
+	})
+	return &pb.ExchangeConflictsReply{Conflict: conflicts}, nil
+}
+
+func (fs *fuseFS) Stats(ctx context.Context, req *pb.StatsRequest) (*pb.StatsReply, error) {
+	fs.mu.Lock()
+	var packages uint64
+	for _, pkg := range fs.pkgs {
+		if pkg != "" {
+			packages++
+		}
+	}
+	fs.mu.Unlock()
+	return &pb.StatsReply{
+		Lookups:       proto.Uint64(atomic.LoadUint64(&fs.stats.lookups)),
+		Reads:         proto.Uint64(atomic.LoadUint64(&fs.stats.reads)),
+		BytesServed:   proto.Uint64(atomic.LoadUint64(&fs.stats.bytesServed)),
+		Autodownloads: proto.Uint64(atomic.LoadUint64(&fs.stats.autodownloads)),
+		Packages:      proto.Uint64(packages),
+	}, nil
+}
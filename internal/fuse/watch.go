@@ -0,0 +1,157 @@
+package fuse
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// rescanDelay is how long to wait for further changes to the package store
+	// before rescanning it. distri install renames many images into place in
+	// quick succession, which should result in a single rescan.
+	rescanDelay = 500 * time.Millisecond
+
+	// rescanMaxDelay bounds how long a continuous stream of changes can
+	// postpone a rescan.
+	rescanMaxDelay = 5 * time.Second
+)
+
+// rescan picks up packages which were added to or removed from fs.repo.
+func (fs *fuseFS) rescan(reason string) {
+	log.Printf("scanning packages upon %s", reason)
+	pkgs, err := fs.findPackages()
+	if err != nil {
+		log.Printf("findPackages: %v", err)
+		return
+	}
+	fs.mu.Lock()
+	err = fs.scanPackages(&nopLocker{}, pkgs)
+	fs.mu.Unlock()
+	if err != nil {
+		log.Printf("scanPackages: %v", err)
+	}
+	log.Printf("scan done")
+}
+
+// debounce calls fn once no event was received for delay, or once events were
+// received for maxDelay without a break. It returns when ctx is canceled or
+// events is closed.
+func debounce(ctx context.Context, events <-chan struct{}, delay, maxDelay time.Duration, fn func()) {
+	var (
+		timer    *time.Timer
+		timerC   <-chan time.Time
+		deadline time.Time
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+
+		case _, ok := <-events:
+			if !ok {
+				if timer != nil && timer.Stop() {
+					fn() // do not lose pending events
+				}
+				return
+			}
+			now := time.Now()
+			if timer == nil {
+				deadline = now.Add(maxDelay)
+				timer = time.NewTimer(delay)
+				timerC = timer.C
+				continue
+			}
+			wait := delay
+			if remaining := deadline.Sub(now); remaining < wait {
+				wait = remaining
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(wait)
+
+		case <-timerC:
+			timer, timerC = nil, nil
+			fn()
+		}
+	}
+}
+
+// watchRepo rescans fs.repo (debounced) whenever a SquashFS image is added
+// (e.g. renamed into place by distri install) or removed (e.g. by distri gc).
+func (fs *fuseFS) watchRepo(ctx context.Context) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return xerrors.Errorf("inotify_init1: %v", err)
+	}
+	const mask = unix.IN_MOVED_TO | unix.IN_CLOSE_WRITE | unix.IN_MOVED_FROM | unix.IN_DELETE
+	if _, err := unix.InotifyAddWatch(fd, fs.repo, mask); err != nil {
+		unix.Close(fd)
+		return xerrors.Errorf("inotify_add_watch(%s): %v", fs.repo, err)
+	}
+	// As fd is non-blocking, os.File uses the runtime poller, so that Close
+	// unblocks Read:
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	events := make(chan struct{})
+	go debounce(ctx, events, rescanDelay, rescanMaxDelay, func() {
+		fs.rescan("changes in " + fs.repo)
+	})
+	go func() {
+		<-ctx.Done()
+		f.Close() // unblocks Read
+	}()
+	go func() {
+		defer close(events)
+		buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("watching %s: %v", fs.repo, err)
+				}
+				return
+			}
+			if !imageChanged(buf[:n]) {
+				continue
+			}
+			select {
+			case events <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// imageChanged returns whether buf (as read from an inotify file descriptor)
+// contains an event for a SquashFS image, or an overflow event.
+func imageChanged(buf []byte) bool {
+	for len(buf) >= unix.SizeofInotifyEvent {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+			return true // events were lost, rescan to be safe
+		}
+		end := unix.SizeofInotifyEvent + int(ev.Len)
+		if end > len(buf) {
+			break
+		}
+		name := strings.TrimRight(string(buf[unix.SizeofInotifyEvent:end]), "\x00")
+		if strings.HasSuffix(name, ".squashfs") {
+			return true
+		}
+		buf = buf[end:]
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package fuse
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDebounce(t *testing.T) {
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+
+	events := make(chan struct{})
+	calls := make(chan time.Time, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		debounce(ctx, events, 50*time.Millisecond, time.Hour, func() {
+			calls <- time.Now()
+		})
+	}()
+
+	// A burst of events results in a single call:
+	for i := 0; i < 10; i++ {
+		events <- struct{}{}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-calls:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("fn not called after burst")
+	}
+	select {
+	case <-calls:
+		t.Fatalf("fn unexpectedly called twice for one burst")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Closing events flushes pending events:
+	events <- struct{}{}
+	close(events)
+	<-done
+	select {
+	case <-calls:
+	default:
+		t.Fatalf("pending event lost when closing events")
+	}
+}
+
+func TestDebounceMaxDelay(t *testing.T) {
+	ctx, canc := context.WithCancel(context.Background())
+	defer canc()
+
+	events := make(chan struct{})
+	calls := make(chan time.Time, 10)
+	go debounce(ctx, events, 100*time.Millisecond, 300*time.Millisecond, func() {
+		calls <- time.Now()
+	})
+
+	// A continuous stream of events must not postpone fn indefinitely:
+	start := time.Now()
+	stop := time.After(2 * time.Second)
+	for {
+		select {
+		case <-calls:
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("fn called after %v, want ≈300ms", elapsed)
+			}
+			return
+		case <-stop:
+			t.Fatalf("fn not called during continuous stream of events")
+		case events <- struct{}{}:
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+}
+
+func TestImageChanged(t *testing.T) {
+	event := func(mask uint32, name string) []byte {
+		nameLen := (len(name) + 1 + 15) / 16 * 16 // padded like the kernel does
+		b := make([]byte, unix.SizeofInotifyEvent+nameLen)
+		binary.LittleEndian.PutUint32(b[4:], mask)
+		binary.LittleEndian.PutUint32(b[12:], uint32(nameLen))
+		copy(b[unix.SizeofInotifyEvent:], name)
+		return b
+	}
+	concat := func(bufs ...[]byte) []byte {
+		var res []byte
+		for _, b := range bufs {
+			res = append(res, b...)
+		}
+		return res
+	}
+
+	for _, tt := range []struct {
+		desc string
+		buf  []byte
+		want bool
+	}{
+		{
+			desc: "meta only",
+			buf:  event(unix.IN_MOVED_TO, "less-amd64-530.meta.textproto"),
+			want: false,
+		},
+		{
+			desc: "temporary file",
+			buf:  event(unix.IN_CLOSE_WRITE, ".less-amd64-530.squashfs123456"),
+			want: false,
+		},
+		{
+			desc: "image after meta",
+			buf: concat(
+				event(unix.IN_MOVED_TO, "less-amd64-530.meta.textproto"),
+				event(unix.IN_MOVED_TO, "less-amd64-530.squashfs")),
+			want: true,
+		},
+		{
+			desc: "removal",
+			buf:  event(unix.IN_DELETE, "less-amd64-530.squashfs"),
+			want: true,
+		},
+		{
+			desc: "overflow",
+			buf:  event(unix.IN_Q_OVERFLOW, ""),
+			want: true,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := imageChanged(tt.buf); got != tt.want {
+				t.Errorf("imageChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
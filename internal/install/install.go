@@ -12,6 +12,7 @@ import (
 	"runtime/pprof"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -20,9 +21,13 @@ import (
 	"github.com/distr1/distri"
 	"github.com/distr1/distri/internal/env"
 	"github.com/distr1/distri/internal/repo"
+	"github.com/distr1/distri/internal/signing"
 	"github.com/distr1/distri/internal/squashfs"
+	"github.com/distr1/distri/internal/storelock"
 	"github.com/distr1/distri/pb"
+	"github.com/golang/protobuf/proto"
 	"github.com/google/renameio"
+	"github.com/mattn/go-isatty"
 	"golang.org/x/exp/mmap"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/xerrors"
@@ -115,77 +120,213 @@ type Ctx struct {
 	// Configuration
 	SkipContentHooks bool
 	HookDryRun       io.Writer // if non-nil, write commands instead of executing
+	WaitForLock      bool      // wait for the store lock instead of failing
+	StoreLocked      bool      // the caller already holds the store lock
+
+	// Progress, if non-nil, is called with download progress updates.
+	// Otherwise, progress is displayed in a table (if stderr is a terminal) or
+	// logged periodically.
+	Progress func(repo.Progress)
+
+	// Parallel limits the number of concurrent package downloads per
+	// repository. Defaults to DefaultParallel.
+	Parallel int
+
+	// State
+	trustedKeys []signing.PublicKey // if non-empty, packages must be signed
+
+	manifestsMu sync.Mutex
+	manifests   map[string]signing.Manifest // by repo PkgPath
+
+	digestsMu sync.Mutex
+	digests   map[string]map[string]repo.FileDigest // by repo PkgPath
+
+	slotsMu sync.Mutex
+	slots   map[string]chan struct{} // by repo PkgPath
+
+	table *progressTable // nil if stderr is not a terminal
 }
 
-func (c *Ctx) install1(ctx context.Context, root string, installRepo distri.Repo, pkg string, first bool) error {
-	if _, err := os.Stat(filepath.Join(root, "roimg", pkg+".squashfs")); err == nil {
-		return nil // package already installed
+// DefaultParallel is the default number of concurrent package downloads per
+// repository.
+const DefaultParallel = 8
+
+// acquireSlot blocks until fewer than c.Parallel downloads from installRepo
+// are in progress. The returned function must be called to release the slot.
+func (c *Ctx) acquireSlot(ctx context.Context, installRepo distri.Repo) (release func(), _ error) {
+	c.slotsMu.Lock()
+	if c.slots == nil {
+		c.slots = make(map[string]chan struct{})
+	}
+	slots, ok := c.slots[installRepo.PkgPath]
+	if !ok {
+		parallel := c.Parallel
+		if parallel <= 0 {
+			parallel = DefaultParallel
+		}
+		slots = make(chan struct{}, parallel)
+		c.slots[installRepo.PkgPath] = slots
+	}
+	c.slotsMu.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
+}
 
-	tmpDir := filepath.Join(root, "roimg", "tmp", "."+pkg+fmt.Sprintf("%d", os.Getpid()))
-	if err := os.Mkdir(tmpDir, 0755); err != nil {
-		if os.IsExist(err) {
-			return nil // another goroutine is installing this package
+// fileDigests returns the expected file digests of installRepo (from its
+// meta.binaryproto), fetching them on first use. Repositories without
+// meta.binaryproto result in an empty map, i.e. no integrity checks.
+func (c *Ctx) fileDigests(ctx context.Context, installRepo distri.Repo) (map[string]repo.FileDigest, error) {
+	c.digestsMu.Lock()
+	defer c.digestsMu.Unlock()
+	if d, ok := c.digests[installRepo.PkgPath]; ok {
+		return d, nil
+	}
+	digests := make(map[string]repo.FileDigest)
+	rd, err := repo.Reader(ctx, installRepo, "meta.binaryproto", false)
+	if err != nil && !isNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		b, err := ioutil.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			return nil, err
 		}
-		return err
+		if len(c.trustedKeys) > 0 {
+			m, err := c.manifest(ctx, installRepo)
+			if err != nil {
+				return nil, err
+			}
+			if err := m.VerifyBytes("meta.binaryproto", b); err != nil {
+				return nil, xerrors.Errorf("repo %s: %v", installRepo.PkgPath, err)
+			}
+		}
+		var mm pb.MirrorMeta
+		if err := proto.Unmarshal(b, &mm); err != nil {
+			return nil, err
+		}
+		digests = repo.Digests(&mm)
 	}
+	if c.digests == nil {
+		c.digests = make(map[string]map[string]repo.FileDigest)
+	}
+	c.digests[installRepo.PkgPath] = digests
+	return digests, nil
+}
 
-	// TODO: print this as a table if the output is a tty
-	log.Printf("installing package %q to root %s from repo %s", pkg, root, installRepo.Path)
+// downloadRetries is the number of times an interrupted download is resumed
+// before giving up.
+const downloadRetries = 5
 
-	for _, fn := range []string{pkg + ".squashfs", pkg + ".meta.textproto"} {
-		f, err := os.Create(filepath.Join(tmpDir, fn))
-		if err != nil {
+// download copies fn from installRepo to dest. If the repository lists a
+// digest for fn, the download is verified and retried once on mismatch.
+func (c *Ctx) download(ctx context.Context, root string, installRepo distri.Repo, fn, dest string) error {
+	digests, err := c.fileDigests(ctx, installRepo)
+	if err != nil {
+		return err
+	}
+	digest, ok := digests[fn]
+	for attempt := 0; ; attempt++ {
+		if err := c.download1(ctx, root, installRepo, fn, dest); err != nil {
 			return err
 		}
-		in, err := repo.Reader(ctx, installRepo, fn, false)
-		if err != nil {
-			return err
+		if !ok {
+			return nil // no digest to verify against
 		}
-		defer in.Close()
-		n, err := io.Copy(f, in)
-		if err != nil {
+		err := digest.Verify(dest)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*repo.ErrIntegrity); !ok || attempt > 0 {
 			return err
 		}
-		atomic.AddInt64(&totalBytes, n)
-		in.Close()
-		if err := f.Close(); err != nil {
+		log.Printf("%v, retrying download", err)
+		// Start from scratch: the corruption might be in the resumed part.
+		if err := os.Remove(dest); err != nil {
 			return err
 		}
 	}
+}
 
-	// first is true only on the first installation of the package (regardless
-	// of its version).
-	if first {
-		readerAt, err := mmap.Open(filepath.Join(tmpDir, pkg+".squashfs"))
-		if err != nil {
-			return xerrors.Errorf("copying /etc: %v", err)
-		}
-		defer readerAt.Close()
-
-		rd, err := squashfs.NewReader(readerAt)
-		if err != nil {
-			return err
+// download1 downloads fn to dest, keeping partial downloads in root/roimg/tmp
+// so that they can be resumed by the next distri install invocation.
+func (c *Ctx) download1(ctx context.Context, root string, installRepo distri.Repo, fn, dest string) error {
+	progress := c.Progress
+	if progress == nil && c.table != nil {
+		progress = c.table.update
+	}
+	if progress == nil {
+		progress = func(p repo.Progress) {
+			if p.Total < 0 || p.Done < p.Total {
+				log.Print(p)
+			}
 		}
+	}
+	d := &repo.Downloader{
+		PartialDir:       filepath.Join(root, "roimg", "tmp"),
+		Retries:          downloadRetries,
+		Progress:         progress,
+		ProgressInterval: 5 * time.Second,
+	}
+	n, err := d.Download(ctx, installRepo, fn, dest)
+	atomic.AddInt64(&totalBytes, n)
+	return err
+}
 
-		fis, err := rd.Readdir(rd.RootInode())
+// manifest returns the verified manifest of installRepo, fetching it on first
+// use.
+func (c *Ctx) manifest(ctx context.Context, installRepo distri.Repo) (signing.Manifest, error) {
+	c.manifestsMu.Lock()
+	defer c.manifestsMu.Unlock()
+	if m, ok := c.manifests[installRepo.PkgPath]; ok {
+		return m, nil
+	}
+	read := func(fn string) ([]byte, error) {
+		rd, err := repo.Reader(ctx, installRepo, fn, false)
 		if err != nil {
-			return err
-		}
-		for _, fi := range fis {
-			if fi.Name() != "etc" {
-				continue
-			}
-			log.Printf("copying %s/etc", pkg)
-			if err := unpackDir(filepath.Join(root, "etc"), rd, fi.Sys().(*squashfs.FileInfo).Inode); err != nil {
-				return xerrors.Errorf("copying /etc: %v", err)
+			if isNotExist(err) {
+				return nil, xerrors.Errorf("repo %s is not signed (%s not found), but trusted keys are configured in %s", installRepo.PkgPath, fn, env.TrustedKeysDir())
 			}
-			break
+			return nil, err
 		}
+		defer rd.Close()
+		return ioutil.ReadAll(rd)
+	}
+	b, err := read(signing.ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := read(signing.SignatureName)
+	if err != nil {
+		return nil, err
+	}
+	m, err := signing.VerifyManifest(c.trustedKeys, b, sig)
+	if err != nil {
+		return nil, xerrors.Errorf("repo %s: %v", installRepo.PkgPath, err)
 	}
+	if c.manifests == nil {
+		c.manifests = make(map[string]signing.Manifest)
+	}
+	c.manifests[installRepo.PkgPath] = m
+	return m, nil
+}
 
+// InitramfsPath returns the path of the initramfs which the linux hook generates
+// for the kernel package pkg (e.g. linux-amd64-5.6.5-15).
+func InitramfsPath(pkg string) string {
+	pv := distri.ParseVersion(pkg)
+	return "/boot/initramfs-" + pv.Upstream + "-" + strconv.FormatInt(pv.DistriRevision, 10) + ".img"
+}
+
+// bootHooks runs the hooks which install boot-relevant files (/init, kernel,
+// initramfs, CPU microcode) of pkg, read from the SquashFS image at image.
+func (c *Ctx) bootHooks(root, pkg, image string) error {
 	hookinstall := func(dest, src string) error {
-		readerAt, err := mmap.Open(filepath.Join(tmpDir, pkg+".squashfs"))
+		readerAt, err := mmap.Open(image)
 		if err != nil {
 			return xerrors.Errorf("copying %s: %v", src, err)
 		}
@@ -250,7 +391,7 @@ func (c *Ctx) install1(ctx context.Context, root string, installRepo distri.Repo
 					if err == nil {
 						initramfsGenerator = strings.TrimSpace(string(b))
 					}
-					initramfs := "/boot/initramfs-" + pv.Upstream + "-" + strconv.FormatInt(pv.DistriRevision, 10) + ".img"
+					initramfs := InitramfsPath(pkg)
 					var cmd *exec.Cmd
 					switch initramfsGenerator {
 					case "dracut":
@@ -309,6 +450,93 @@ func (c *Ctx) install1(ctx context.Context, root string, installRepo distri.Repo
 		}
 	}
 
+	return nil
+}
+
+// RerunBootHooks runs the boot hooks of the already installed package pkg
+// again, e.g. to make a kernel the default again after a rollback.
+func (c *Ctx) RerunBootHooks(root, pkg string) error {
+	return c.bootHooks(root, pkg, filepath.Join(root, "roimg", pkg+".squashfs"))
+}
+
+func (c *Ctx) install1(ctx context.Context, root string, installRepo distri.Repo, pkg string, first bool) error {
+	if _, err := os.Stat(filepath.Join(root, "roimg", pkg+".squashfs")); err == nil {
+		return nil // package already installed
+	}
+
+	tmpDir := filepath.Join(root, "roimg", "tmp", "."+pkg+fmt.Sprintf("%d", os.Getpid()))
+	if err := os.Mkdir(tmpDir, 0755); err != nil {
+		if os.IsExist(err) {
+			return nil // another goroutine is installing this package
+		}
+		return err
+	}
+
+	release, err := c.acquireSlot(ctx, installRepo)
+	if err != nil {
+		return err
+	}
+	if c.table != nil {
+		c.table.start(pkg + ".squashfs")
+		defer c.table.finish(pkg + ".squashfs")
+	} else {
+		log.Printf("installing package %q to root %s from repo %s", pkg, root, installRepo.Path)
+	}
+
+	for _, fn := range []string{pkg + ".squashfs", pkg + ".meta.textproto"} {
+		if err := c.download(ctx, root, installRepo, fn, filepath.Join(tmpDir, fn)); err != nil {
+			release()
+			return err
+		}
+	}
+	release()
+
+	if len(c.trustedKeys) > 0 {
+		m, err := c.manifest(ctx, installRepo)
+		if err != nil {
+			return err
+		}
+		for _, fn := range []string{pkg + ".squashfs", pkg + ".meta.textproto"} {
+			if err := m.VerifyFile(fn, filepath.Join(tmpDir, fn)); err != nil {
+				return xerrors.Errorf("rejecting package %s from repo %s: %v", pkg, installRepo.PkgPath, err)
+			}
+		}
+	}
+
+	// first is true only on the first installation of the package (regardless
+	// of its version).
+	if first {
+		readerAt, err := mmap.Open(filepath.Join(tmpDir, pkg+".squashfs"))
+		if err != nil {
+			return xerrors.Errorf("copying /etc: %v", err)
+		}
+		defer readerAt.Close()
+
+		rd, err := squashfs.NewReader(readerAt)
+		if err != nil {
+			return err
+		}
+
+		fis, err := rd.Readdir(rd.RootInode())
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			if fi.Name() != "etc" {
+				continue
+			}
+			log.Printf("copying %s/etc", pkg)
+			if err := unpackDir(filepath.Join(root, "etc"), rd, fi.Sys().(*squashfs.FileInfo).Inode); err != nil {
+				return xerrors.Errorf("copying /etc: %v", err)
+			}
+			break
+		}
+	}
+
+	if err := c.bootHooks(root, pkg, filepath.Join(tmpDir, pkg+".squashfs")); err != nil {
+		return err
+	}
+
 	readerAt, err := mmap.Open(filepath.Join(tmpDir, pkg+".squashfs"))
 	if err != nil {
 		return err
@@ -426,7 +654,8 @@ func (c *Ctx) installTransitively1(root string, repos []distri.Repo, pkg string)
 	// in the corresponding pkgset file
 	first := true
 
-	// download all packages with maximum concurrency for the time being
+	// install1 limits the number of concurrent downloads per repo (see
+	// Ctx.Parallel), so starting one goroutine per package is fine.
 	var eg errgroup.Group
 	for _, pkg := range pkgs {
 		pkg := pkg //copy
@@ -467,18 +696,53 @@ func (c *Ctx) Packages(args []string, root, repo string, update bool) error {
 		return xerrors.Errorf("no repos configured")
 	}
 
-	// TODO: lock to ensure only one process modifies roimg at a time
+	c.trustedKeys, err = signing.TrustedKeys(env.TrustedKeysDir())
+	if err != nil {
+		return err
+	}
+
+	// Ensure only one process modifies roimg at a time. In particular, removing
+	// the tmp directory below is only safe while holding the lock.
+	if !c.StoreLocked {
+		lock, err := storelock.Acquire(filepath.Join(root, "roimg"), c.WaitForLock)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
 
 	tmpDir := filepath.Join(root, "roimg", "tmp")
 
-	// Remove stale work directories of previously interrupted/crashed processes.
-	if err := os.RemoveAll(tmpDir); err != nil {
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
 		return err
 	}
 
-	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+	// Remove stale work directories of previously interrupted/crashed
+	// processes. Partial downloads (files) are kept so that they can be
+	// resumed.
+	fis, err := ioutil.ReadDir(tmpDir)
+	if err != nil {
 		return err
 	}
+	for _, fi := range fis {
+		if !fi.IsDir() || !strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(tmpDir, fi.Name())); err != nil {
+			return err
+		}
+	}
+
+	if c.Progress == nil && isatty.IsTerminal(os.Stderr.Fd()) {
+		c.table = newProgressTable(os.Stderr)
+		logOutput := log.Writer()
+		log.SetOutput(c.table)
+		defer func() {
+			c.table.Close()
+			log.SetOutput(logOutput)
+			c.table = nil
+		}()
+	}
 
 	start := time.Now()
 	defer func() {
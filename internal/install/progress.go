@@ -0,0 +1,166 @@
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distr1/distri/internal/repo"
+)
+
+// progressTable renders a live table of in-flight package downloads to a
+// terminal. It also acts as the log output while active, so that log lines
+// are printed above the table instead of garbling it.
+type progressTable struct {
+	out io.Writer
+
+	mu        sync.Mutex
+	rows      map[string]repo.Progress // by file name
+	started   map[string]time.Time
+	installed int
+	lines     int // number of lines of the currently displayed table
+	done      chan struct{}
+}
+
+const (
+	maxTableRows = 20
+	maxNameLen   = 40
+)
+
+func newProgressTable(out io.Writer) *progressTable {
+	t := &progressTable{
+		out:     out,
+		rows:    make(map[string]repo.Progress),
+		started: make(map[string]time.Time),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		ticker := time.NewTicker(250 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.mu.Lock()
+				t.redraw()
+				t.mu.Unlock()
+			case <-t.done:
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// start adds an (empty) row for the package image fn.
+func (t *progressTable) start(fn string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows[fn] = repo.Progress{File: fn, Total: -1}
+	t.started[fn] = time.Now()
+}
+
+// update is a repo.Downloader Progress callback.
+func (t *progressTable) update(p repo.Progress) {
+	if !strings.HasSuffix(p.File, ".squashfs") {
+		return // .meta.textproto files are too small to be interesting
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.rows[p.File]; ok {
+		t.rows[p.File] = p
+	}
+}
+
+// finish removes the row for fn.
+func (t *progressTable) finish(fn string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.rows[fn]; !ok {
+		return
+	}
+	delete(t.rows, fn)
+	delete(t.started, fn)
+	t.installed++
+}
+
+// Write implements io.Writer for use with log.SetOutput.
+func (t *progressTable) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clear()
+	n, err := t.out.Write(p)
+	t.draw()
+	return n, err
+}
+
+// Close stops redrawing and removes the table from the terminal.
+func (t *progressTable) Close() error {
+	close(t.done)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clear()
+	return nil
+}
+
+func (t *progressTable) redraw() {
+	t.clear()
+	t.draw()
+}
+
+// clear erases the currently displayed table. t.mu must be held.
+func (t *progressTable) clear() {
+	if t.lines == 0 {
+		return
+	}
+	// Move the cursor up to the first line of the table, then erase until
+	// the end of the screen.
+	fmt.Fprintf(t.out, "\x1b[%dA\x1b[J", t.lines)
+	t.lines = 0
+}
+
+// draw prints the table. t.mu must be held.
+func (t *progressTable) draw() {
+	if len(t.rows) == 0 {
+		return
+	}
+	files := make([]string, 0, len(t.rows))
+	for fn := range t.rows {
+		files = append(files, fn)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return t.started[files[i]].Before(t.started[files[j]])
+	})
+	const mb = 1024 * 1024
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d installed, %d in flight\n", t.installed, len(files))
+	for idx, fn := range files {
+		if idx == maxTableRows {
+			fmt.Fprintf(&buf, "  … and %d more\n", len(files)-maxTableRows)
+			break
+		}
+		p := t.rows[fn]
+		pct := "   ?"
+		total := "?"
+		if p.Total > 0 {
+			pct = fmt.Sprintf("%3d%%", p.Done*100/p.Total)
+			total = fmt.Sprintf("%.1f", float64(p.Total)/mb)
+		}
+		name := strings.TrimSuffix(fn, ".squashfs")
+		if len(name) > maxNameLen {
+			// Truncate so that rows do not wrap, which would break clear().
+			name = name[:maxNameLen-1] + "…"
+		}
+		fmt.Fprintf(&buf, "  %-40s %s %7.1f/%-7s MB %6.1f MB/s\n",
+			name,
+			pct,
+			float64(p.Done)/mb,
+			total,
+			p.Rate/mb)
+	}
+	t.lines = bytes.Count(buf.Bytes(), []byte{'\n'})
+	t.out.Write(buf.Bytes())
+}
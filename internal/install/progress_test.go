@@ -0,0 +1,110 @@
+package install
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/distr1/distri/internal/repo"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProgressTable(t *testing.T) {
+	var buf bytes.Buffer
+	table := newProgressTable(&buf)
+	// Stop the periodic redraws so that the output is deterministic. The
+	// table keeps working, redraw is called explicitly below.
+	table.Close()
+
+	logOutput, logFlags := log.Writer(), log.Flags()
+	log.SetOutput(table)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(logOutput)
+		log.SetFlags(logFlags)
+	}()
+
+	redraw := func() string {
+		table.mu.Lock()
+		defer table.mu.Unlock()
+		table.redraw()
+		out := buf.String()
+		buf.Reset()
+		return out
+	}
+
+	const (
+		glibc = "glibc-amd64-2.31-4.squashfs"
+		bash  = "bash-amd64-5.0-4.squashfs"
+	)
+	table.start(glibc)
+	table.update(repo.Progress{
+		File:  glibc,
+		Done:  512 * 1024,
+		Total: 2 * 1024 * 1024,
+		Rate:  1024 * 1024,
+	})
+	// .meta.textproto files are not displayed:
+	table.update(repo.Progress{File: "glibc-amd64-2.31-4.meta.textproto", Total: 100})
+	// Progress for files which were not started (e.g. finished concurrently)
+	// is ignored:
+	table.update(repo.Progress{File: bash, Total: 100})
+
+	for _, tt := range []struct {
+		desc string
+		step func()
+		want string
+	}{
+		{
+			desc: "initial draw",
+			want: "0 installed, 1 in flight\n" +
+				"  glibc-amd64-2.31-4                        25%     0.5/2.0     MB    1.0 MB/s\n",
+		},
+
+		{
+			desc: "redraw after starting bash",
+			step: func() { table.start(bash) },
+			want: "\x1b[2A\x1b[J" +
+				"0 installed, 2 in flight\n" +
+				"  glibc-amd64-2.31-4                        25%     0.5/2.0     MB    1.0 MB/s\n" +
+				"  bash-amd64-5.0-4                            ?     0.0/?       MB    0.0 MB/s\n",
+		},
+
+		{
+			desc: "redraw after finishing glibc",
+			step: func() { table.finish(glibc) },
+			want: "\x1b[3A\x1b[J" +
+				"1 installed, 1 in flight\n" +
+				"  bash-amd64-5.0-4                            ?     0.0/?       MB    0.0 MB/s\n",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			if tt.step != nil {
+				tt.step()
+			}
+			if diff := cmp.Diff(tt.want, redraw()); diff != "" {
+				t.Fatalf("redraw: unexpected output: diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// Log lines replace the table, which is then drawn again below them:
+	log.Printf("installing %s", bash)
+	want := "\x1b[2A\x1b[J" +
+		"installing bash-amd64-5.0-4.squashfs\n" +
+		"1 installed, 1 in flight\n" +
+		"  bash-amd64-5.0-4                            ?     0.0/?       MB    0.0 MB/s\n"
+	table.mu.Lock()
+	got := buf.String()
+	buf.Reset()
+	table.mu.Unlock()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("log output: diff (-want +got):\n%s", diff)
+	}
+
+	// Once the last download finished, the table disappears:
+	table.finish(bash)
+	if diff := cmp.Diff("\x1b[2A\x1b[J", redraw()); diff != "" {
+		t.Fatalf("redraw: unexpected output: diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,340 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distr1/distri"
+	"golang.org/x/sys/unix"
+	"golang.org/x/xerrors"
+)
+
+// Progress describes the state of a download, as reported to
+// Downloader.Progress.
+type Progress struct {
+	File  string  // e.g. bash-amd64-5.0-4.squashfs
+	Done  int64   // bytes present locally, including resumed bytes
+	Total int64   // -1 if unknown
+	Rate  float64 // bytes per second transferred by this invocation
+}
+
+func (p Progress) String() string {
+	const mb = 1024 * 1024
+	total := "?"
+	if p.Total >= 0 {
+		total = fmt.Sprintf("%.1f MB", float64(p.Total)/mb)
+	}
+	return fmt.Sprintf("%s: %.1f MB / %s, %.1f MB/s", p.File, float64(p.Done)/mb, total, p.Rate/mb)
+}
+
+// Downloader downloads files from a repository, persisting partial downloads
+// across invocations and resuming them using HTTP Range requests.
+type Downloader struct {
+	// PartialDir is the directory in which partial downloads are kept (e.g.
+	// /roimg/tmp).
+	PartialDir string
+
+	// Retries is the number of times a failed transfer is resumed before
+	// giving up. Retries are spaced out with exponential backoff.
+	Retries int
+
+	// Progress, if non-nil, is called at most once per ProgressInterval while
+	// a file is downloading, and once when it is complete.
+	Progress func(Progress)
+
+	// ProgressInterval defaults to 1s.
+	ProgressInterval time.Duration
+}
+
+// errTransient wraps failures which are worth retrying, e.g. connection resets
+// or HTTP 5xx status codes.
+type errTransient struct {
+	err error
+}
+
+func (e *errTransient) Error() string { return e.err.Error() }
+
+const (
+	initialBackoff = 1 * time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// Download downloads fn (e.g. bash-amd64-5.0-4.squashfs) from repo to dest,
+// resuming a previously interrupted download of fn, if any. It returns the
+// number of bytes transferred by this call.
+func (d *Downloader) Download(ctx context.Context, repo distri.Repo, fn, dest string) (int64, error) {
+	if err := os.MkdirAll(d.PartialDir, 0755); err != nil {
+		return 0, err
+	}
+	partial := filepath.Join(d.PartialDir, strings.ReplaceAll(fn, "/", "_")+".partial")
+	f, err := openPartial(partial)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var (
+		transferred int64
+		backoff     = initialBackoff
+	)
+	for attempt := 0; ; attempt++ {
+		n, err := d.transfer(ctx, repo, fn, f)
+		transferred += n
+		if err == nil {
+			break
+		}
+		if _, ok := err.(*errTransient); !ok || attempt >= d.Retries {
+			return transferred, err
+		}
+		if n > 0 {
+			backoff = initialBackoff // made progress, start over
+		}
+		log.Printf("%s: %v, retrying in %v (attempt %d of %d)", fn, err, backoff, attempt+1, d.Retries)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return transferred, ctx.Err()
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		return transferred, err
+	}
+	if err := os.Rename(partial, dest); err != nil {
+		return transferred, err
+	}
+	return transferred, f.Close()
+}
+
+// openPartial opens (creating if needed) and locks the partial download file
+// path. Another Download of the same file into the same PartialDir might be in
+// progress, in which case openPartial waits for it to finish.
+func openPartial(path string) (*os.File, error) {
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+			f.Close()
+			return nil, xerrors.Errorf("flock(%s): %v", path, err)
+		}
+		// The previous lock holder might have completed (and renamed) the
+		// file, in which case we need to start a new one.
+		fst, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		st, err := os.Stat(path)
+		if err == nil && os.SameFile(fst, st) {
+			return f, nil
+		}
+		f.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// transfer appends the remainder of fn to f, returning the number of bytes
+// written.
+func (d *Downloader) transfer(ctx context.Context, repo distri.Repo, fn string, f *os.File) (int64, error) {
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		body  io.ReadCloser
+		total int64 = -1
+	)
+	if !strings.HasPrefix(repo.PkgPath, "http://") &&
+		!strings.HasPrefix(repo.PkgPath, "https://") {
+		src, err := os.Open(filepath.Join(repo.PkgPath, fn))
+		if err != nil {
+			return 0, err
+		}
+		st, err := src.Stat()
+		if err != nil {
+			src.Close()
+			return 0, err
+		}
+		total = st.Size()
+		if offset > total {
+			offset = 0
+		}
+		if _, err := src.Seek(offset, io.SeekStart); err != nil {
+			src.Close()
+			return 0, err
+		}
+		body = src
+	} else {
+		req, err := http.NewRequest("GET", repo.PkgPath+"/"+fn, nil) // TODO: sanitize slashes
+		if err != nil {
+			return 0, err
+		}
+		if os.Getenv("DISTRI_REEXEC") == "1" {
+			req.Header.Set("X-Distri-Reexec", "yes")
+		}
+		if offset > 0 {
+			// Range applies to the encoded representation, so resumed
+			// transfers are requested without compression: the partial file
+			// contains decoded bytes.
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		} else {
+			req.Header.Set("Accept-Encoding", "zstd, gzip")
+		}
+		resp, err := httpClient.Do(req.WithContext(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			return 0, &errTransient{err}
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			// The server does not support Range requests (or there was nothing
+			// to resume), start from scratch.
+			offset = 0
+			total = resp.ContentLength
+			if resp.Header.Get("Content-Encoding") != "" {
+				total = -1 // ContentLength is the compressed size
+			}
+		case http.StatusPartialContent:
+			start, size, err := parseContentRange(resp.Header.Get("Content-Range"))
+			if err != nil || start != offset {
+				resp.Body.Close()
+				return 0, xerrors.Errorf("%s: unexpected Content-Range %q (requested offset %d)", req.URL, resp.Header.Get("Content-Range"), offset)
+			}
+			total = size
+		case http.StatusRequestedRangeNotSatisfiable:
+			resp.Body.Close()
+			// The partial file is either complete, or longer than the remote
+			// file (stale): start over in the latter case.
+			if _, size, err := parseContentRange(resp.Header.Get("Content-Range")); err == nil && size == offset {
+				d.report(Progress{File: fn, Done: offset, Total: size})
+				return 0, nil
+			}
+			if err := f.Truncate(0); err != nil {
+				return 0, err
+			}
+			return 0, &errTransient{xerrors.Errorf("%s: stale partial download discarded", req.URL)}
+		case http.StatusNotFound:
+			resp.Body.Close()
+			return 0, &ErrNotFound{url: req.URL}
+		default:
+			resp.Body.Close()
+			err := xerrors.Errorf("%s: HTTP status %v", req.URL, resp.Status)
+			if resp.StatusCode >= 500 {
+				return 0, &errTransient{err}
+			}
+			return 0, err
+		}
+		body, err = decodeBody(resp)
+		if err != nil {
+			resp.Body.Close()
+			return 0, err
+		}
+	}
+	defer body.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := f.Truncate(offset); err != nil {
+		return 0, err
+	}
+
+	interval := d.ProgressInterval
+	if interval == 0 {
+		interval = 1 * time.Second
+	}
+	var (
+		start      = time.Now()
+		lastReport = start
+		written    int64
+		buf        = make([]byte, 128*1024)
+	)
+	progress := func() Progress {
+		p := Progress{File: fn, Done: offset + written, Total: total}
+		if elapsed := time.Since(start).Seconds(); elapsed > 0 {
+			p.Rate = float64(written) / elapsed
+		}
+		return p
+	}
+	for {
+		n, rerr := body.Read(buf)
+		if n > 0 {
+			if _, err := f.Write(buf[:n]); err != nil {
+				return written, err
+			}
+			written += int64(n)
+			if d.Progress != nil && time.Since(lastReport) >= interval {
+				d.Progress(progress())
+				lastReport = time.Now()
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			if ctx.Err() != nil {
+				return written, ctx.Err()
+			}
+			return written, &errTransient{rerr}
+		}
+	}
+	if total >= 0 && offset+written != total {
+		return written, &errTransient{xerrors.Errorf("%s: short transfer: got %d bytes, want %d bytes", fn, offset+written, total)}
+	}
+	d.report(progress())
+	return written, nil
+}
+
+func (d *Downloader) report(p Progress) {
+	if d.Progress != nil {
+		d.Progress(p)
+	}
+}
+
+// parseContentRange parses a Content-Range header value like “bytes
+// 100-199/200” or “bytes */200”, returning the first byte position (-1 for
+// the latter form) and the complete length.
+func parseContentRange(val string) (start, size int64, _ error) {
+	if !strings.HasPrefix(val, "bytes ") {
+		return 0, 0, xerrors.Errorf("malformed Content-Range %q", val)
+	}
+	val = strings.TrimPrefix(val, "bytes ")
+	idx := strings.IndexByte(val, '/')
+	if idx == -1 {
+		return 0, 0, xerrors.Errorf("malformed Content-Range %q", val)
+	}
+	rng, length := val[:idx], val[idx+1:]
+	size, err := strconv.ParseInt(length, 10, 64)
+	if err != nil {
+		return 0, 0, xerrors.Errorf("malformed Content-Range %q: %v", val, err)
+	}
+	if rng == "*" {
+		return -1, size, nil
+	}
+	if idx := strings.IndexByte(rng, '-'); idx > -1 {
+		rng = rng[:idx]
+	}
+	start, err = strconv.ParseInt(rng, 10, 64)
+	if err != nil {
+		return 0, 0, xerrors.Errorf("malformed Content-Range %q: %v", val, err)
+	}
+	return start, size, nil
+}
@@ -0,0 +1,142 @@
+package repo_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distr1/distri"
+	"github.com/distr1/distri/internal/repo"
+)
+
+func TestDownloadResume(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "distritest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	content := bytes.Repeat([]byte("distri"), 100*1024)
+	const fn = "bash-amd64-5.0-4.squashfs"
+
+	var (
+		requests int32
+		ranges   = make(chan string, 10)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges <- r.Header.Get("Range")
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// Simulate a connection which breaks half-way through the file.
+			w.Header().Set("Content-Length", "614400")
+			w.WriteHeader(http.StatusOK)
+			w.Write(content[:len(content)/2])
+			return
+		}
+		http.ServeContent(w, r, fn, time.Now(), bytes.NewReader(content))
+	}))
+	defer srv.Close()
+
+	// Simulate a partial download left behind by a previous invocation:
+	partialDir := filepath.Join(tmpdir, "tmp")
+	if err := os.MkdirAll(partialDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(partialDir, fn+".partial"), content[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []repo.Progress
+	d := &repo.Downloader{
+		PartialDir: partialDir,
+		Retries:    3,
+		Progress: func(p repo.Progress) {
+			progress = append(progress, p)
+		},
+	}
+	dest := filepath.Join(tmpdir, fn)
+	r := distri.Repo{PkgPath: srv.URL}
+	if _, err := d.Download(context.Background(), r, fn, dest); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded file differs from remote file (got %d bytes, want %d bytes)", len(got), len(content))
+	}
+	if _, err := os.Stat(filepath.Join(partialDir, fn+".partial")); !os.IsNotExist(err) {
+		t.Errorf("partial download not cleaned up: %v", err)
+	}
+
+	close(ranges)
+	var all []string
+	for rng := range ranges {
+		all = append(all, rng)
+	}
+	if got, want := len(all), 2; got != want {
+		t.Fatalf("unexpected number of requests: got %d (%q), want %d", got, all, want)
+	}
+	if got, want := all[0], "bytes=1000-"; got != want {
+		t.Errorf("first request: unexpected Range header: got %q, want %q", got, want)
+	}
+	// The server ignored the first Range request, so the download starts over
+	// at 0 and must then resume after the interruption:
+	if got, want := all[1], "bytes=307200-"; got != want {
+		t.Errorf("second request: unexpected Range header: got %q, want %q", got, want)
+	}
+
+	if len(progress) == 0 {
+		t.Fatalf("Progress not called")
+	}
+	last := progress[len(progress)-1]
+	if last.Done != int64(len(content)) || last.Total != int64(len(content)) {
+		t.Errorf("unexpected final progress: %v", last)
+	}
+	if !strings.HasPrefix(last.String(), fn+": ") {
+		t.Errorf("unexpected progress string %q", last.String())
+	}
+}
+
+func TestDownloadCompressed(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "distritest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	content := bytes.Repeat([]byte("distri"), 100*1024)
+	const fn = "bash-amd64-5.0-4.squashfs"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("unexpected Accept-Encoding header %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write(content)
+		zw.Close()
+	}))
+	defer srv.Close()
+
+	d := &repo.Downloader{PartialDir: filepath.Join(tmpdir, "tmp")}
+	dest := filepath.Join(tmpdir, fn)
+	if _, err := d.Download(context.Background(), distri.Repo{PkgPath: srv.URL}, fn, dest); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded file differs from remote file (got %d bytes, want %d bytes)", len(got), len(content))
+	}
+}
@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/distr1/distri/internal/signing"
+	"github.com/distr1/distri/pb"
+)
+
+// FileDigest is the expected size and SHA-256 hash of a repository file, as
+// recorded by distri mirror in meta.binaryproto.
+type FileDigest struct {
+	Size   int64
+	SHA256 string // hex-encoded
+}
+
+// ErrIntegrity is returned when a downloaded file does not match its
+// FileDigest.
+type ErrIntegrity struct {
+	Path   string
+	Reason string
+}
+
+func (e *ErrIntegrity) Error() string {
+	return fmt.Sprintf("%s: integrity check failed: %s", e.Path, e.Reason)
+}
+
+// Digests returns the FileDigest of all files listed in mm, keyed by file name
+// (e.g. bash-amd64-5.0-4.squashfs). Packages for which distri mirror did not
+// record digests (older repositories) are skipped.
+func Digests(mm *pb.MirrorMeta) map[string]FileDigest {
+	digests := make(map[string]FileDigest, 2*len(mm.GetPackage()))
+	for _, pkg := range mm.GetPackage() {
+		if h := pkg.GetSquashfsSha256(); h != "" {
+			digests[pkg.GetName()+".squashfs"] = FileDigest{
+				Size:   pkg.GetSquashfsSize(),
+				SHA256: h,
+			}
+		}
+		if h := pkg.GetMetaSha256(); h != "" {
+			digests[pkg.GetName()+".meta.textproto"] = FileDigest{
+				Size:   pkg.GetMetaSize(),
+				SHA256: h,
+			}
+		}
+	}
+	return digests
+}
+
+// Verify returns an *ErrIntegrity unless the file at path matches d.
+func (d FileDigest) Verify(path string) error {
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if got, want := st.Size(), d.Size; got != want {
+		return &ErrIntegrity{
+			Path:   path,
+			Reason: fmt.Sprintf("unexpected size: got %d bytes, want %d bytes", got, want),
+		}
+	}
+	got, err := signing.HashFile(path)
+	if err != nil {
+		return err
+	}
+	if want := d.SHA256; got != want {
+		return &ErrIntegrity{
+			Path:   path,
+			Reason: fmt.Sprintf("unexpected SHA-256: got %s, want %s", got, want),
+		}
+	}
+	return nil
+}
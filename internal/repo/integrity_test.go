@@ -0,0 +1,65 @@
+package repo_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/distr1/distri/internal/repo"
+	"github.com/distr1/distri/internal/signing"
+	"github.com/distr1/distri/pb"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestDigests(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "distritest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	content := []byte("hello world")
+	fn := filepath.Join(tmpdir, "bash-amd64-5.0-4.squashfs")
+	if err := ioutil.WriteFile(fn, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mm := &pb.MirrorMeta{
+		Package: []*pb.MirrorMeta_Package{
+			{
+				Name:           proto.String("bash-amd64-5.0-4"),
+				SquashfsSha256: proto.String(signing.HashBytes(content)),
+				SquashfsSize:   proto.Int64(int64(len(content))),
+			},
+			{
+				// no digests, e.g. written by an older distri mirror
+				Name: proto.String("zsh-amd64-5.6.2-3"),
+			},
+		},
+	}
+	digests := repo.Digests(mm)
+	if got, want := len(digests), 1; got != want {
+		t.Fatalf("unexpected number of digests: got %d, want %d", got, want)
+	}
+	digest := digests["bash-amd64-5.0-4.squashfs"]
+	if err := digest.Verify(fn); err != nil {
+		t.Fatal(err)
+	}
+
+	// truncated download:
+	if err := ioutil.WriteFile(fn, content[:5], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := digest.Verify(fn).(*repo.ErrIntegrity); !ok {
+		t.Fatalf("Verify(truncated) did not return *repo.ErrIntegrity")
+	}
+
+	// corrupted download:
+	if err := ioutil.WriteFile(fn, []byte("hello w0rld"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := digest.Verify(fn).(*repo.ErrIntegrity); !ok {
+		t.Fatalf("Verify(corrupted) did not return *repo.ErrIntegrity")
+	}
+}
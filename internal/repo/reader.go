@@ -74,6 +74,25 @@ var httpClient = &http.Client{Transport: &http.Transport{
 	DisableCompression:  true,
 }}
 
+// decodeBody returns a reader for the decoded body of resp, as requested by
+// the Accept-Encoding request header.
+func decodeBody(resp *http.Response) (io.ReadCloser, error) {
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		rd, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &gzipReader{body: resp.Body, zr: rd}, nil
+	} else if strings.EqualFold(resp.Header.Get("Content-Encoding"), "zstd") {
+		dec, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReader{body: resp.Body, dec: dec}, nil
+	}
+	return resp.Body, nil
+}
+
 func cacheFn(cache bool, repo distri.Repo, fn string) string {
 	if !cache {
 		return ""
@@ -131,19 +150,9 @@ func Reader(ctx context.Context, repo distri.Repo, fn string, cache bool) (io.Re
 		}
 		return nil, fmt.Errorf("%s: HTTP status %v", req.URL, resp.Status)
 	}
-	rdc := resp.Body
-	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
-		rd, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		rdc = &gzipReader{body: resp.Body, zr: rd}
-	} else if strings.EqualFold(resp.Header.Get("Content-Encoding"), "zstd") {
-		dec, err := zstd.NewReader(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-		rdc = &zstdReader{body: resp.Body, dec: dec}
+	rdc, err := decodeBody(resp)
+	if err != nil {
+		return nil, err
 	}
 
 	var cacheFile *os.File
@@ -0,0 +1,273 @@
+// Package signing implements signed repository manifests.
+//
+// A manifest (ManifestName) lists the SHA-256 hash of every file in a
+// repository directory. distri mirror writes the manifest and a detached
+// ed25519 signature (SignatureName) next to meta.binaryproto. Clients verify
+// the signature against the trusted public keys in /etc/distri/keys.d, and
+// then verify each downloaded file against the manifest.
+//
+// The key and signature files are loosely modeled after minisign: an untrusted
+// comment line, followed by a base64-encoded line containing an 8 byte key id
+// and the key or signature itself.
+package signing
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	// ManifestName is the file name of the manifest within a repository
+	// directory (e.g. pkg/SHA256SUMS).
+	ManifestName = "SHA256SUMS"
+
+	// SignatureName is the file name of the detached manifest signature.
+	SignatureName = ManifestName + ".sig"
+)
+
+const keyIDLen = 8
+
+// KeyID identifies a key pair. It is the first 8 bytes of the SHA-256 hash of
+// the public key.
+type KeyID [keyIDLen]byte
+
+func (id KeyID) String() string { return strings.ToUpper(hex.EncodeToString(id[:])) }
+
+func keyID(pub ed25519.PublicKey) KeyID {
+	var id KeyID
+	h := sha256.Sum256(pub)
+	copy(id[:], h[:])
+	return id
+}
+
+// PublicKey is a trusted key, typically read from /etc/distri/keys.d.
+type PublicKey struct {
+	ID  KeyID
+	Key ed25519.PublicKey
+}
+
+// PrivateKey is a secret key used by distri mirror to sign manifests.
+type PrivateKey struct {
+	ID  KeyID
+	Key ed25519.PrivateKey
+}
+
+// GenerateKey returns the file contents of a new public and private key.
+func GenerateKey(comment string) (pub, priv []byte, _ error) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	id := keyID(pubKey)
+	pub = encode("distri public key "+id.String()+" "+comment, id, pubKey)
+	priv = encode("distri secret key "+id.String()+" "+comment, id, privKey)
+	return pub, priv, nil
+}
+
+func encode(comment string, id KeyID, payload []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "untrusted comment: %s\n", comment)
+	buf.WriteString(base64.StdEncoding.EncodeToString(append(id[:], payload...)))
+	buf.WriteString("\n")
+	return buf.Bytes()
+}
+
+// decode returns the key id and payload of the file contents b, which must
+// contain a payload of length n.
+func decode(b []byte, n int) (KeyID, []byte, error) {
+	var id KeyID
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return id, nil, xerrors.Errorf("malformed file: expected comment line and base64 line")
+	}
+	dec, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil {
+		return id, nil, err
+	}
+	if got, want := len(dec), keyIDLen+n; got != want {
+		return id, nil, xerrors.Errorf("malformed file: unexpected length: got %d, want %d", got, want)
+	}
+	copy(id[:], dec)
+	return id, dec[keyIDLen:], nil
+}
+
+// ParsePublicKey parses the contents of a public key file.
+func ParsePublicKey(b []byte) (PublicKey, error) {
+	id, payload, err := decode(b, ed25519.PublicKeySize)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	return PublicKey{ID: id, Key: ed25519.PublicKey(payload)}, nil
+}
+
+// ReadPrivateKey reads the private key file at path.
+func ReadPrivateKey(path string) (*PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	id, payload, err := decode(b, ed25519.PrivateKeySize)
+	if err != nil {
+		return nil, xerrors.Errorf("%s: %v", path, err)
+	}
+	return &PrivateKey{ID: id, Key: ed25519.PrivateKey(payload)}, nil
+}
+
+// TrustedKeys reads all public keys (*.pub) from dir, typically
+// /etc/distri/keys.d. A non-existent dir results in no keys, meaning signature
+// verification is disabled.
+func TrustedKeys(dir string) ([]PublicKey, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var keys []PublicKey
+	for _, fi := range fis {
+		if !strings.HasSuffix(fi.Name(), ".pub") {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParsePublicKey(b)
+		if err != nil {
+			return nil, xerrors.Errorf("%s: %v", fi.Name(), err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Sign returns the contents of a detached signature file for msg.
+func (k *PrivateKey) Sign(msg []byte) []byte {
+	sig := ed25519.Sign(k.Key, msg)
+	return encode("signature from distri secret key "+k.ID.String(), k.ID, sig)
+}
+
+// Verify returns an error unless sig is a valid signature of msg made by one of
+// the trusted keys.
+func Verify(keys []PublicKey, msg, sig []byte) error {
+	id, payload, err := decode(sig, ed25519.SignatureSize)
+	if err != nil {
+		return xerrors.Errorf("signature: %v", err)
+	}
+	for _, key := range keys {
+		if key.ID != id {
+			continue
+		}
+		if !ed25519.Verify(key.Key, msg, payload) {
+			return xerrors.Errorf("signature verification with key %s failed", id)
+		}
+		return nil
+	}
+	return xerrors.Errorf("signature made by untrusted key %s", id)
+}
+
+// Manifest maps file names (e.g. bash-amd64-5.0-4.squashfs) to their
+// hex-encoded SHA-256 hash.
+type Manifest map[string]string
+
+// Marshal returns the manifest in sha256sum(1) format, sorted by file name.
+func (m Manifest) Marshal() []byte {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s  %s\n", m[name], name)
+	}
+	return buf.Bytes()
+}
+
+// ParseManifest parses a manifest in sha256sum(1) format.
+func ParseManifest(b []byte) (Manifest, error) {
+	m := make(Manifest)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, "  ", 2)
+		if len(parts) != 2 || len(parts[0]) != 2*sha256.Size {
+			return nil, xerrors.Errorf("malformed manifest line %q", line)
+		}
+		m[parts[1]] = parts[0]
+	}
+	return m, scanner.Err()
+}
+
+// HashFile returns the hex-encoded SHA-256 hash of the file at path.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashBytes returns the hex-encoded SHA-256 hash of b.
+func HashBytes(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// VerifyFile returns an error unless the file at path (stored in the repository
+// as name) is listed in the manifest with a matching hash.
+func (m Manifest) VerifyFile(name, path string) error {
+	got, err := HashFile(path)
+	if err != nil {
+		return err
+	}
+	return m.check(name, got)
+}
+
+// VerifyBytes is like VerifyFile, but verifies the in-memory contents b.
+func (m Manifest) VerifyBytes(name string, b []byte) error {
+	return m.check(name, HashBytes(b))
+}
+
+func (m Manifest) check(name, got string) error {
+	want, ok := m[name]
+	if !ok {
+		return xerrors.Errorf("%s: not listed in signed manifest", name)
+	}
+	if got != want {
+		return xerrors.Errorf("%s: SHA-256 mismatch: got %s, signed manifest lists %s", name, got, want)
+	}
+	return nil
+}
+
+// VerifyManifest verifies the signature of the manifest contents b using the
+// trusted keys and returns the parsed manifest.
+func VerifyManifest(keys []PublicKey, b, sig []byte) (Manifest, error) {
+	if err := Verify(keys, b, sig); err != nil {
+		return nil, xerrors.Errorf("%s: %v", ManifestName, err)
+	}
+	return ParseManifest(b)
+}
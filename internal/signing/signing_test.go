@@ -0,0 +1,106 @@
+package signing_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/distr1/distri/internal/signing"
+)
+
+func TestSignVerify(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "distritest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	pub, priv, err := signing.GenerateKey("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFn := filepath.Join(tmpdir, "repo.key")
+	if err := ioutil.WriteFile(keyFn, priv, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keysDir := filepath.Join(tmpdir, "keys.d")
+	if err := os.MkdirAll(keysDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(keysDir, "repo.pub"), pub, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := signing.ReadPrivateKey(keyFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := signing.TrustedKeys(keysDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(keys), 1; got != want {
+		t.Fatalf("unexpected number of trusted keys: got %d, want %d", got, want)
+	}
+
+	pkgFn := filepath.Join(tmpdir, "bash-amd64-5.0-4.squashfs")
+	if err := ioutil.WriteFile(pkgFn, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h, err := signing.HashFile(pkgFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := signing.Manifest{"bash-amd64-5.0-4.squashfs": h}
+	b := manifest.Marshal()
+	sig := key.Sign(b)
+
+	t.Run("Valid", func(t *testing.T) {
+		m, err := signing.VerifyManifest(keys, b, sig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.VerifyFile("bash-amd64-5.0-4.squashfs", pkgFn); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("TamperedManifest", func(t *testing.T) {
+		tampered := []byte(strings.Replace(string(b), h[:4], "0000", 1))
+		if _, err := signing.VerifyManifest(keys, tampered, sig); err == nil {
+			t.Fatalf("VerifyManifest unexpectedly succeeded for tampered manifest")
+		}
+	})
+
+	t.Run("TamperedFile", func(t *testing.T) {
+		if err := manifest.VerifyBytes("bash-amd64-5.0-4.squashfs", []byte("hello w0rld")); err == nil {
+			t.Fatalf("VerifyBytes unexpectedly succeeded for tampered file")
+		}
+	})
+
+	t.Run("UnlistedFile", func(t *testing.T) {
+		if err := manifest.VerifyFile("zsh-amd64-5.6.2-3.squashfs", pkgFn); err == nil {
+			t.Fatalf("VerifyFile unexpectedly succeeded for unlisted file")
+		}
+	})
+
+	t.Run("UntrustedKey", func(t *testing.T) {
+		_, otherPriv, err := signing.GenerateKey("other")
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherFn := filepath.Join(tmpdir, "other.key")
+		if err := ioutil.WriteFile(otherFn, otherPriv, 0600); err != nil {
+			t.Fatal(err)
+		}
+		other, err := signing.ReadPrivateKey(otherFn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := signing.VerifyManifest(keys, b, other.Sign(b)); err == nil {
+			t.Fatalf("VerifyManifest unexpectedly succeeded for untrusted key")
+		}
+	})
+}
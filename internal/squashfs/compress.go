@@ -0,0 +1,250 @@
+package squashfs
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Compression selects the compressor for the data blocks of an image. The zero
+// value (Uncompressed) stores data blocks as-is.
+type Compression uint16
+
+const (
+	Uncompressed Compression = 0
+	Gzip         Compression = zlibCompression // called gzip by mksquashfs
+	XZ           Compression = xzCompression
+	Zstd         Compression = zstdCompression
+)
+
+var compressionNames = map[Compression]string{
+	Uncompressed:    "none",
+	Gzip:            "gzip",
+	lzmaCompression: "lzma",
+	lzoCompression:  "lzo",
+	XZ:              "xz",
+	lz4Compression:  "lz4",
+	Zstd:            "zstd",
+}
+
+func (c Compression) String() string {
+	if name, ok := compressionNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Compression(%d)", uint16(c))
+}
+
+// ParseCompression returns the Compression for name, which is one of none,
+// gzip, xz or zstd (as accepted by mksquashfs -comp).
+func ParseCompression(name string) (Compression, error) {
+	switch name {
+	case "none", "":
+		return Uncompressed, nil
+	case "gzip":
+		return Gzip, nil
+	case "xz":
+		return XZ, nil
+	case "zstd":
+		return Zstd, nil
+	}
+	return 0, fmt.Errorf("unsupported compression %q (expected one of none, gzip, xz, zstd)", name)
+}
+
+// compressor compresses data blocks. Implementations must be safe for
+// concurrent use.
+type compressor interface {
+	// compress appends the compressed version of src to dst.
+	compress(dst, src []byte) ([]byte, error)
+}
+
+// newCompressor returns the compressor for c, which can be used by up to
+// concurrency goroutines at a time without blocking.
+func newCompressor(c Compression, concurrency int) (compressor, error) {
+	switch c {
+	case Uncompressed:
+		return nil, nil
+	case Gzip:
+		return &zlibCompressor{}, nil
+	case XZ:
+		return xzCompressor{}, nil
+	case Zstd:
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		// EncodeAll blocks until one of the concurrency encoders is available:
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(concurrency))
+		if err != nil {
+			return nil, err
+		}
+		return zstdCompressor{enc}, nil
+	}
+	return nil, fmt.Errorf("unsupported compression %v", c)
+}
+
+type zlibCompressor struct {
+	writers sync.Pool
+}
+
+func (z *zlibCompressor) compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	zw, ok := z.writers.Get().(*zlib.Writer)
+	if ok {
+		zw.Reset(buf)
+	} else {
+		var err error
+		// zlib.BestSpeed results in only a 2x slow-down over no compression
+		// (compared to >4x slow-down with DefaultCompression), but generates
+		// results which are in the same ball park (10% larger).
+		zw, err = zlib.NewWriterLevel(buf, zlib.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer z.writers.Put(zw)
+	if _, err := zw.Write(src); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type xzCompressor struct{}
+
+func (xzCompressor) compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	xw, err := xz.WriterConfig{
+		// The Linux kernel allocates dictionaries of the block size unless
+		// compressor options say otherwise.
+		DictCap:  dataBlockSize,
+		CheckSum: xz.CRC32,
+	}.NewWriter(buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := xw.Write(src); err != nil {
+		return nil, err
+	}
+	if err := xw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type zstdCompressor struct {
+	enc *zstd.Encoder
+}
+
+func (z zstdCompressor) compress(dst, src []byte) ([]byte, error) {
+	return z.enc.EncodeAll(src, dst), nil
+}
+
+// decompressor decompresses data and metadata blocks. Implementations must be
+// safe for concurrent use.
+type decompressor interface {
+	// decompress appends the decompressed version of src to dst.
+	decompress(dst, src []byte) ([]byte, error)
+}
+
+func newDecompressor(c Compression) decompressor {
+	switch c {
+	case Gzip:
+		return &zlibDecompressor{}
+	case XZ:
+		return xzDecompressor{}
+	case Zstd:
+		return zstdDecompressor{}
+	case lzmaCompression:
+		return lzmaDecompressor{}
+	}
+	// TODO: lzo and lz4, which mksquashfs supports, but which are rarely used
+	return unsupportedDecompressor{c}
+}
+
+type zlibDecompressor struct {
+	readers sync.Pool
+}
+
+func (z *zlibDecompressor) decompress(dst, src []byte) ([]byte, error) {
+	var (
+		zr  io.ReadCloser
+		err error
+	)
+	if r, ok := z.readers.Get().(io.ReadCloser); ok {
+		zr = r
+		err = zr.(zlib.Resetter).Reset(bytes.NewReader(src), nil)
+	} else {
+		zr, err = zlib.NewReader(bytes.NewReader(src))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer z.readers.Put(zr)
+	return readAllTo(dst, zr)
+}
+
+type xzDecompressor struct{}
+
+func (xzDecompressor) decompress(dst, src []byte) ([]byte, error) {
+	xr, err := xz.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return readAllTo(dst, xr)
+}
+
+// lzmaDecompressor decompresses blocks written by the (deprecated) lzma
+// compressor of mksquashfs, which uses the LZMA "alone" format.
+type lzmaDecompressor struct{}
+
+func (lzmaDecompressor) decompress(dst, src []byte) ([]byte, error) {
+	lr, err := lzma.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return readAllTo(dst, lr)
+}
+
+var (
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+)
+
+type zstdDecompressor struct{}
+
+func (zstdDecompressor) decompress(dst, src []byte) ([]byte, error) {
+	// A single decoder is shared by all Readers: DecodeAll is safe for
+	// concurrent use, and decoders are expensive to set up.
+	zstdDecoderOnce.Do(func() {
+		zstdDecoder, zstdDecoderErr = zstd.NewReader(nil)
+	})
+	if zstdDecoderErr != nil {
+		return nil, zstdDecoderErr
+	}
+	return zstdDecoder.DecodeAll(src, dst)
+}
+
+type unsupportedDecompressor struct {
+	c Compression
+}
+
+func (u unsupportedDecompressor) decompress(dst, src []byte) ([]byte, error) {
+	return nil, fmt.Errorf("unsupported compression %v", u.c)
+}
+
+// readAllTo appends everything read from r to dst.
+func readAllTo(dst []byte, r io.Reader) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,170 @@
+package squashfs
+
+import (
+	"crypto/sha256"
+	"runtime"
+)
+
+// pendingBlock is a data or fragment block which is being encoded (compressed
+// and checksummed) by the worker pool. Blocks are written to the data area in
+// the order in which they were submitted, which keeps the image identical to
+// one produced by encoding serially.
+//
+// A pendingBlock without raw contents only runs its written callback once all
+// previously submitted blocks are written, which is used to write inodes in
+// order.
+type pendingBlock struct {
+	raw  []byte // uncompressed contents, owned by the pendingBlock
+	comp []byte // compression output, if any
+
+	// results, valid once done is closed:
+	data []byte // contents to write: either raw or comp
+	size uint32 // size to store in the inode or fragment table
+	sum  [sha256.Size]byte
+	err  error
+	done chan struct{}
+
+	// written is called after the block was written at offset off.
+	written func(pb *pendingBlock, off int64) error
+}
+
+// workers returns the number of data blocks which are encoded concurrently.
+func (w *Writer) workers() int {
+	if w.Workers == 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return w.Workers
+}
+
+// startWorkers sets up the worker pool, unless blocks are encoded serially.
+func (w *Writer) startWorkers() {
+	if w.sem != nil {
+		return
+	}
+	workers := w.workers()
+	if workers < 2 {
+		return // encode serially on the calling goroutine
+	}
+	w.sem = make(chan struct{}, workers)
+}
+
+// getBuf returns an empty buffer with a capacity of (at least) one data block.
+func (w *Writer) getBuf() []byte {
+	if b, ok := w.bufs.Get().([]byte); ok {
+		return b[:0]
+	}
+	return make([]byte, 0, dataBlockSize)
+}
+
+// encode compresses and checksums pb.raw. It is safe to call concurrently.
+func (w *Writer) encode(pb *pendingBlock) {
+	pb.sum = sha256.Sum256(pb.raw)
+	pb.data = pb.raw
+	pb.size = uint32(len(pb.raw)) | dataBlockUncompressed
+	if w.compressor == nil {
+		return
+	}
+	compressed, err := w.compressor.compress(w.getBuf(), pb.raw)
+	if err != nil {
+		pb.err = err
+		return
+	}
+	pb.comp = compressed
+	// Store uncompressed data unless compression saves space: Linux
+	// returns i/o errors when it encounters a compressed block which is
+	// larger than the uncompressed data:
+	// https://github.com/torvalds/linux/blob/3ca24ce9ff764bc27bceb9b2fd8ece74846c3fd3/fs/squashfs/block.c#L150
+	if len(compressed) < len(pb.raw) {
+		pb.data = compressed
+		pb.size = uint32(len(compressed))
+	}
+}
+
+// submit queues raw (which must not be modified afterwards) for encoding.
+// written is called in submission order, after the block was written to the
+// data area. raw may be nil to only call written in order.
+func (w *Writer) submit(raw []byte, written func(pb *pendingBlock, off int64) error) error {
+	if w.err != nil {
+		return w.err
+	}
+	pb := &pendingBlock{
+		raw:     raw,
+		written: written,
+		done:    make(chan struct{}),
+	}
+	switch {
+	case raw == nil:
+		close(pb.done)
+	case w.sem == nil:
+		w.encode(pb)
+		close(pb.done)
+	default:
+		w.sem <- struct{}{}
+		go func() {
+			w.encode(pb)
+			<-w.sem
+			close(pb.done)
+		}()
+	}
+	w.pending = append(w.pending, pb)
+	// Bound the memory usage by writing blocks as soon as the pipeline is
+	// sufficiently full.
+	return w.retire(2 * cap(w.sem))
+}
+
+// retire writes encoded blocks in submission order, waiting for blocks until
+// at most max blocks are pending.
+func (w *Writer) retire(max int) error {
+	if w.err != nil {
+		return w.err
+	}
+	for len(w.pending) > 0 {
+		pb := w.pending[0]
+		if len(w.pending) > max {
+			<-pb.done
+		} else {
+			select {
+			case <-pb.done:
+			default:
+				return nil // not yet encoded
+			}
+		}
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+		if err := w.writePending(pb); err != nil {
+			w.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+// drain writes all pending blocks. It must be called before writing inodes
+// which are not written via submit, so that inodes stay in order.
+func (w *Writer) drain() error {
+	return w.retire(0)
+}
+
+// writePending writes an encoded block to the data area and calls its written
+// callback.
+func (w *Writer) writePending(pb *pendingBlock) error {
+	if pb.err != nil {
+		return pb.err
+	}
+	off := w.dataOff
+	if pb.raw != nil {
+		if _, err := w.w.Write(pb.data); err != nil {
+			return err
+		}
+		w.dataOff += int64(len(pb.data))
+		if w.dataOff > w.end {
+			w.end = w.dataOff
+		}
+		w.bufs.Put(pb.raw)
+		if pb.comp != nil {
+			w.bufs.Put(pb.comp)
+		}
+		pb.raw, pb.comp, pb.data = nil, nil, nil
+	}
+	return pb.written(pb, off)
+}
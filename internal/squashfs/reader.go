@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,6 +19,19 @@ import (
 type Reader struct {
 	r     io.ReaderAt
 	super superblock
+
+	// decompressor is used for compressed data and metadata blocks.
+	decompressor decompressor
+
+	// fragmentMu guards the most recently used fragment block, which is
+	// typically shared by many small files.
+	fragmentMu    sync.Mutex
+	fragmentIdx   int64
+	fragmentBlock []byte
+
+	idsOnce sync.Once
+	ids     []uint32
+	idsErr  error
 }
 
 func NewReader(r io.ReaderAt) (*Reader, error) {
@@ -33,10 +45,20 @@ func NewReader(r io.ReaderAt) (*Reader, error) {
 		return nil, fmt.Errorf("invalid magic (not a SquashFS image?): got %x, want %x", got, want)
 	}
 
+	if sb.Major != majorVersion || sb.Minor != minorVersion {
+		return nil, fmt.Errorf("unsupported SquashFS version %d.%d (want %d.%d)", sb.Major, sb.Minor, majorVersion, minorVersion)
+	}
+
+	if sb.BlockLog > 20 || sb.BlockSize != 1<<sb.BlockLog {
+		return nil, fmt.Errorf("corrupt superblock: block size %d does not match block log %d", sb.BlockSize, sb.BlockLog)
+	}
+
 	//log.Printf("superblock: %+v", sb)
 	return &Reader{
-		r:     r,
-		super: sb,
+		r:            r,
+		super:        sb,
+		decompressor: newDecompressor(sb.Compression),
+		fragmentIdx:  -1,
 	}, nil
 }
 
@@ -48,31 +70,53 @@ func (r *Reader) inode(i Inode) (blockoffset int64, offset int64) {
 
 type blockReader struct {
 	r      io.ReadSeeker
+	dec    decompressor
 	lenBuf [2]byte
 	buf    []byte
+	raw    []byte // scratch buffer for compressed blocks
 	i      int64
 
 	off int64 // TODO: remove this once using mmap
 }
 
-func (br *blockReader) Read(p []byte) (n int, err error) {
-	if br.i >= int64(len(br.buf)) {
-		br.i = 0
-		if _, err := io.ReadFull(br.r, br.lenBuf[:]); err != nil {
-			return 0, err
-		}
-		l := binary.LittleEndian.Uint16(br.lenBuf[:])
-		//uncompressed := l&0x8000 > 0
-		l &= 0x7FFF
-		//log.Printf("block of len %d, uncompressed: %v", l, uncompressed)
+// next reads the next metadata block into br.buf.
+func (br *blockReader) next() error {
+	br.i = 0
+	if _, err := io.ReadFull(br.r, br.lenBuf[:]); err != nil {
+		return err
+	}
+	l := binary.LittleEndian.Uint16(br.lenBuf[:])
+	uncompressed := l&0x8000 > 0
+	l &= 0x7FFF
+	//log.Printf("block of len %d, uncompressed: %v", l, uncompressed)
+	if uncompressed {
 		if int(l) > cap(br.buf) {
 			br.buf = make([]byte, int(l))
 		}
 		br.buf = br.buf[:l]
-		if _, err := io.ReadFull(br.r, br.buf); err != nil {
+		_, err := io.ReadFull(br.r, br.buf)
+		return err
+	}
+	if int(l) > cap(br.raw) {
+		br.raw = make([]byte, int(l))
+	}
+	br.raw = br.raw[:l]
+	if _, err := io.ReadFull(br.r, br.raw); err != nil {
+		return err
+	}
+	var err error
+	br.buf, err = br.dec.decompress(br.buf[:0], br.raw)
+	if err != nil {
+		return xerrors.Errorf("decompressing metadata block: %v", err)
+	}
+	return nil
+}
+
+func (br *blockReader) Read(p []byte) (n int, err error) {
+	if br.i >= int64(len(br.buf)) {
+		if err := br.next(); err != nil {
 			return 0, err
 		}
-		//log.Printf("(retry) n = %v, err = %v", n, err)
 	}
 	n = copy(p, br.buf[br.i:])
 	br.i += int64(n)
@@ -97,23 +141,25 @@ func (r *Reader) blockReader(blockoffset, offset int64) (io.ReadCloser, error) {
 	br := blockReaderPool.Get().(*blockReader)
 	br.buf = br.buf[:0]
 	br.r = io.NewSectionReader(r.r, blockoffset, 5500*1024*1024) // TODO: correct limit? can we use IntMax
+	br.dec = r.decompressor
 	br.off = blockoffset
 	br.i = 0
-	//log.Printf("discarding %d bytes", offset)
-	for n := int64(0); n < offset; {
-		remaining := offset - n
-		if remaining > metadataBlockSize {
-			remaining = metadataBlockSize
-		}
-		nn, err := br.Read(br.buf[:remaining])
-		if err != nil {
+	if offset > 0 {
+		// offset is always within the first (uncompressed) metadata block
+		if err := br.next(); err != nil {
 			return nil, err
 		}
-		n += int64(nn)
+		if offset > int64(len(br.buf)) {
+			return nil, fmt.Errorf("offset %d exceeds metadata block size %d", offset, len(br.buf))
+		}
+		br.i = offset
 	}
 	return br, nil
 }
 
+// header returns the common inode header, promoted to all inode types.
+func (h inodeHeader) header() inodeHeader { return h }
+
 // TODO: define an inode type to use instead of interface{}?
 func (r *Reader) readInode(i Inode) (interface{}, error) {
 	blockoffset, offset := r.inode(i)
@@ -130,134 +176,256 @@ func (r *Reader) readInode(i Inode) (interface{}, error) {
 	if err := binary.Read(io.TeeReader(br, typeBuf), binary.LittleEndian, &inodeType); err != nil {
 		return nil, err
 	}
-	br = ioutil.NopCloser(io.MultiReader(typeBuf, br))
+	rd := io.MultiReader(typeBuf, br)
 
-	// var ih inodeHeader
-	// if err := binary.Read(br, binary.LittleEndian, &ih); err != nil {
-	// 	return err
-	// }
-	// //log.Printf("ih: %+v", ih)
-
-	//log.Printf("inode type: %v", inodeType)
+	var inode interface{}
 	switch inodeType {
 	case dirType:
 		var di dirInodeHeader
-		if err := binary.Read(br, binary.LittleEndian, &di); err != nil {
-			return nil, err
-		}
-		return di, nil
+		err = binary.Read(rd, binary.LittleEndian, &di)
+		inode = di
 
 	case fileType:
 		var ri regInodeHeader
-		if err := binary.Read(br, binary.LittleEndian, &ri); err != nil {
-			return nil, err
-		}
-		return ri, nil
+		err = binary.Read(rd, binary.LittleEndian, &ri)
+		inode = ri
 
 	case symlinkType:
 		var si symlinkInodeHeader
-		if err := binary.Read(br, binary.LittleEndian, &si); err != nil {
-			return nil, err
-		}
-		return si, nil
+		err = binary.Read(rd, binary.LittleEndian, &si)
+		inode = si
+
+	case blkdevType, chrdevType:
+		var di devInodeHeader
+		err = binary.Read(rd, binary.LittleEndian, &di)
+		inode = di
+
+	case fifoType, socketType:
+		var ii ipcInodeHeader
+		err = binary.Read(rd, binary.LittleEndian, &ii)
+		inode = ii
 
 	case ldirType:
+		// Directory index entries (if any) follow, but are not needed: we
+		// always read the entire directory listing.
 		var di ldirInodeHeader
-		if err := binary.Read(br, binary.LittleEndian, &di); err != nil {
-			return nil, err
-		}
-		return di, nil
+		err = binary.Read(rd, binary.LittleEndian, &di)
+		inode = di
 
 	case lregType:
-		var di lregInodeHeader
-		if err := binary.Read(br, binary.LittleEndian, &di); err != nil {
+		var ri lregInodeHeader
+		err = binary.Read(rd, binary.LittleEndian, &ri)
+		inode = ri
+
+	case lsymlinkType:
+		var si lsymlinkInodeHeader
+		if err := binary.Read(rd, binary.LittleEndian, &si.symlinkInodeHeader); err != nil {
 			return nil, err
 		}
-		return di, nil
+		// skip over the target path to the xattr index
+		if _, err := io.CopyN(ioutil.Discard, rd, int64(si.SymlinkSize)); err != nil {
+			return nil, err
+		}
+		err = binary.Read(rd, binary.LittleEndian, &si.Xattr)
+		inode = si
+
+	case lblkdevType, lchrdevType:
+		var di ldevInodeHeader
+		err = binary.Read(rd, binary.LittleEndian, &di)
+		inode = di
+
+	case lfifoType, lsocketType:
+		var ii lipcInodeHeader
+		err = binary.Read(rd, binary.LittleEndian, &ii)
+		inode = ii
 
-		// TODO:
-		// blkdevType
-		// chrdevType
-		// fifoType
-		// socketType
-		// // The larger types are used for e.g. sparse files, xattrs, etc.
-		// ldirType
-		// lsymlinkType
-		// lblkdevType
-		// lchrdevType
-		// lfifoType
-		// lsocketType
+	default:
+		return nil, fmt.Errorf("unknown inode type %d", inodeType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inode, nil
+}
+
+// readIds reads the uid/gid lookup table.
+func (r *Reader) readIds() ([]uint32, error) {
+	r.idsOnce.Do(func() {
+		const idsPerBlock = metadataBlockSize / 4 /* sizeof(uint32) */
+		ids := make([]uint32, 0, r.super.NoIds)
+		for block := int64(0); len(ids) < int(r.super.NoIds); block++ {
+			var blockOffset [8]byte
+			if _, err := r.r.ReadAt(blockOffset[:], r.super.IdTableStart+block*8); err != nil {
+				r.idsErr = err
+				return
+			}
+			br, err := r.blockReader(int64(binary.LittleEndian.Uint64(blockOffset[:])), 0)
+			if err != nil {
+				r.idsErr = err
+				return
+			}
+			n := int(r.super.NoIds) - len(ids)
+			if n > idsPerBlock {
+				n = idsPerBlock
+			}
+			chunk := make([]uint32, n)
+			err = binary.Read(br, binary.LittleEndian, chunk)
+			br.Close()
+			if err != nil {
+				r.idsErr = xerrors.Errorf("reading id table: %v", err)
+				return
+			}
+			ids = append(ids, chunk...)
+		}
+		r.ids = ids
+	})
+	return r.ids, r.idsErr
+}
+
+// id resolves an index into the uid/gid lookup table.
+func (r *Reader) id(idx uint16) (uint32, error) {
+	ids, err := r.readIds()
+	if err != nil {
+		return 0, err
+	}
+	if int(idx) >= len(ids) {
+		return 0, fmt.Errorf("id index %d out of range [0, %d)", idx, len(ids))
+	}
+	return ids[idx], nil
+}
 
+// InodeByNumber returns the inode with the specified inode number (as found in
+// FileInfo.InodeNumber), using the export table. Images created by this
+// package do not contain an export table.
+func (r *Reader) InodeByNumber(ino uint32) (Inode, error) {
+	if r.super.LookupTableStart == -1 {
+		return 0, fmt.Errorf("image does not contain an export table")
+	}
+	if ino < 1 || ino > r.super.Inodes {
+		return 0, fmt.Errorf("inode number %d out of range [1, %d]", ino, r.super.Inodes)
+	}
+	const refsPerBlock = metadataBlockSize / 8 /* sizeof(uint64) */
+	idx := int64(ino - 1)
+	var blockOffset [8]byte
+	if _, err := r.r.ReadAt(blockOffset[:], r.super.LookupTableStart+(idx/refsPerBlock)*8); err != nil {
+		return 0, err
+	}
+	br, err := r.blockReader(int64(binary.LittleEndian.Uint64(blockOffset[:])), (idx%refsPerBlock)*8)
+	if err != nil {
+		return 0, err
+	}
+	defer br.Close()
+	var ref uint64
+	if err := binary.Read(br, binary.LittleEndian, &ref); err != nil {
+		return 0, err
 	}
-	return nil, fmt.Errorf("unknown inode type %d", inodeType)
+	return Inode(ref), nil
 }
 
 func (r *Reader) RootInode() Inode {
 	return r.super.RootInode
 }
 
+// unixMode converts the permission bits of a SquashFS inode to an os.FileMode.
+func unixMode(mode uint16) os.FileMode {
+	result := os.FileMode(mode & 0777)
+	if mode&syscall.S_ISUID != 0 {
+		result |= os.ModeSetuid
+	}
+	if mode&syscall.S_ISGID != 0 {
+		result |= os.ModeSetgid
+	}
+	if mode&syscall.S_ISVTX != 0 {
+		result |= os.ModeSticky
+	}
+	return result
+}
+
 func (r *Reader) Stat(name string, i Inode) (os.FileInfo, error) {
 	inode, err := r.readInode(i)
 	if err != nil {
 		return nil, err
 	}
 	//log.Printf("i %d, inode: %T, %+v", i, inode, inode)
+	hdr := inode.(interface{ header() inodeHeader }).header()
+	fi := &FileInfo{
+		name:        name,
+		mode:        unixMode(hdr.Mode),
+		modTime:     time.Unix(int64(hdr.Mtime), 0),
+		nlink:       1,
+		InodeNumber: hdr.InodeNumber,
+		Inode:       i,
+	}
+	if fi.uid, err = r.id(hdr.Uid); err != nil {
+		return nil, err
+	}
+	if fi.gid, err = r.id(hdr.Gid); err != nil {
+		return nil, err
+	}
 	switch x := inode.(type) {
 	case dirInodeHeader:
-		return &FileInfo{
-			name:    name,
-			size:    int64(x.FileSize),
-			mode:    os.ModeDir | os.FileMode(x.Mode),
-			modTime: time.Unix(int64(x.Mtime), 0),
-			Inode:   i,
-		}, nil
+		fi.size = int64(x.FileSize)
+		fi.mode |= os.ModeDir
+		fi.nlink = x.Nlink
 
 	case ldirInodeHeader:
-		return &FileInfo{
-			name:    name,
-			size:    int64(x.FileSize),
-			mode:    os.ModeDir | os.FileMode(x.Mode),
-			modTime: time.Unix(int64(x.Mtime), 0),
-			Inode:   i,
-		}, nil
+		fi.size = int64(x.FileSize)
+		fi.mode |= os.ModeDir
+		fi.nlink = x.Nlink
 
 	case regInodeHeader:
-		mode := os.FileMode(x.Mode & 0777)
-		if x.Mode&syscall.S_ISUID != 0 {
-			mode |= os.ModeSetuid
-		}
-		return &FileInfo{
-			name:    name,
-			size:    int64(x.FileSize),
-			mode:    mode,
-			modTime: time.Unix(int64(x.Mtime), 0),
-			Inode:   i,
-		}, nil
+		fi.size = int64(x.FileSize)
 
 	case lregInodeHeader:
-		mode := os.FileMode(x.Mode & 0777)
-		if x.Mode&syscall.S_ISUID != 0 {
-			mode |= os.ModeSetuid
-		}
-		return &FileInfo{
-			name:    name,
-			size:    int64(x.FileSize),
-			mode:    mode,
-			modTime: time.Unix(int64(x.Mtime), 0),
-			Inode:   i,
-		}, nil
+		fi.size = int64(x.FileSize)
+		fi.nlink = x.Nlink
 
 	case symlinkInodeHeader:
-		return &FileInfo{
-			name:    name,
-			size:    int64(x.SymlinkSize),
-			mode:    os.ModeSymlink | os.FileMode(x.Mode),
-			modTime: time.Unix(int64(x.Mtime), 0),
-			Inode:   i,
-		}, nil
+		fi.size = int64(x.SymlinkSize)
+		fi.mode |= os.ModeSymlink
+		fi.nlink = x.Nlink
+
+	case lsymlinkInodeHeader:
+		fi.size = int64(x.SymlinkSize)
+		fi.mode |= os.ModeSymlink
+		fi.nlink = x.Nlink
+
+	case devInodeHeader:
+		fi.mode |= deviceMode(x.InodeType)
+		fi.rdev = x.Rdev
+		fi.nlink = x.Nlink
+
+	case ldevInodeHeader:
+		fi.mode |= deviceMode(x.InodeType)
+		fi.rdev = x.Rdev
+		fi.nlink = x.Nlink
+
+	case ipcInodeHeader:
+		fi.mode |= ipcMode(x.InodeType)
+		fi.nlink = x.Nlink
+
+	case lipcInodeHeader:
+		fi.mode |= ipcMode(x.InodeType)
+		fi.nlink = x.Nlink
+
+	default:
+		return nil, fmt.Errorf("unknown inode type %T", inode)
 	}
+	return fi, nil
+}
 
-	return nil, fmt.Errorf("unknown inode type %T", inode)
+func deviceMode(inodeType uint16) os.FileMode {
+	if inodeType == chrdevType || inodeType == lchrdevType {
+		return os.ModeDevice | os.ModeCharDevice
+	}
+	return os.ModeDevice
+}
+
+func ipcMode(inodeType uint16) os.FileMode {
+	if inodeType == socketType || inodeType == lsocketType {
+		return os.ModeSocket
+	}
+	return os.ModeNamedPipe
 }
 
 func (r *Reader) ReadLink(i Inode) (string, error) {
@@ -278,7 +446,7 @@ func (r *Reader) ReadLink(i Inode) (string, error) {
 	}
 	br = ioutil.NopCloser(io.MultiReader(typeBuf, br))
 
-	if inodeType != symlinkType {
+	if inodeType != symlinkType && inodeType != lsymlinkType {
 		return "", fmt.Errorf("invalid inode type: got %d instead of symlink", inodeType)
 	}
 	var si symlinkInodeHeader
@@ -294,24 +462,246 @@ func (r *Reader) ReadLink(i Inode) (string, error) {
 	return string(buf), nil
 }
 
-func (r *Reader) FileReader(inode Inode) (*io.SectionReader, error) {
-	//log.Printf("Readfile(%v)", inode)
-	i, err := r.readInode(inode)
+// fileInode describes the data of a regular file.
+type fileInode struct {
+	start  int64    // byte offset of the first data block
+	size   int64    // (uncompressed) file size
+	blocks []uint32 // on-disk data block sizes
+
+	// fragment is the fragment block index containing the tail end of the
+	// file at fragmentOffset, or invalidFragment.
+	fragment       uint32
+	fragmentOffset int64
+}
+
+// fileInode reads the file inode i.
+func (r *Reader) fileInode(i Inode) (*fileInode, error) {
+	// TODO: reduce code duplication with readInode
+	blockoffset, offset := r.inode(i)
+	br, err := r.blockReader(r.super.InodeTableStart+blockoffset, offset)
 	if err != nil {
 		return nil, err
 	}
-	//log.Printf("i: %+v", i)
-	// TODO(compression): read the blocksizes to read compressed blocks
-	switch ri := i.(type) {
-	case regInodeHeader:
-		off := int64(ri.StartBlock) + int64(ri.Offset)
-		return io.NewSectionReader(r.r, off, int64(ri.FileSize)), nil
-	case lregInodeHeader:
-		off := int64(ri.StartBlock) + int64(ri.Offset)
-		return io.NewSectionReader(r.r, off, int64(ri.FileSize)), nil
+	defer br.Close()
+
+	var inodeType uint16
+	typeBuf := bytes.NewBuffer(make([]byte, 0, binary.Size(inodeType)))
+	if err := binary.Read(io.TeeReader(br, typeBuf), binary.LittleEndian, &inodeType); err != nil {
+		return nil, err
+	}
+	rd := io.MultiReader(typeBuf, br)
+
+	var fi fileInode
+	switch inodeType {
+	case fileType:
+		var ri regInodeHeader
+		if err := binary.Read(rd, binary.LittleEndian, &ri); err != nil {
+			return nil, err
+		}
+		fi.start, fi.size = int64(ri.StartBlock), int64(ri.FileSize)
+		fi.fragment, fi.fragmentOffset = ri.Fragment, int64(ri.Offset)
+
+	case lregType:
+		var ri lregInodeHeader
+		if err := binary.Read(rd, binary.LittleEndian, &ri); err != nil {
+			return nil, err
+		}
+		fi.start, fi.size = int64(ri.StartBlock), int64(ri.FileSize)
+		fi.fragment, fi.fragmentOffset = ri.Fragment, int64(ri.Offset)
+
 	default:
 		return nil, fmt.Errorf("BUG: non-file inode type")
 	}
+
+	// Followed by a uint32 array of compressed block sizes. If the tail end of
+	// the file is stored in a fragment, there is no block for the tail end.
+	bs := int64(r.super.BlockSize)
+	n := (fi.size + bs - 1) / bs
+	if fi.fragment != invalidFragment {
+		n = fi.size / bs
+	}
+	fi.blocks = make([]uint32, n)
+	if err := binary.Read(rd, binary.LittleEndian, fi.blocks); err != nil {
+		return nil, err
+	}
+	return &fi, nil
+}
+
+// fragmentEntry reads entry idx of the fragment table.
+func (r *Reader) fragmentEntry(idx uint32) (fragmentEntry, error) {
+	const entriesPerBlock = metadataBlockSize / 16 /* sizeof(fragmentEntry) */
+	block := int64(idx / entriesPerBlock)
+	offset := int64(idx%entriesPerBlock) * 16
+	var blockOffset [8]byte
+	if _, err := r.r.ReadAt(blockOffset[:], r.super.FragmentTableStart+block*8); err != nil {
+		return fragmentEntry{}, err
+	}
+	br, err := r.blockReader(int64(binary.LittleEndian.Uint64(blockOffset[:])), offset)
+	if err != nil {
+		return fragmentEntry{}, err
+	}
+	defer br.Close()
+	var fe fragmentEntry
+	if err := binary.Read(br, binary.LittleEndian, &fe); err != nil {
+		return fragmentEntry{}, err
+	}
+	return fe, nil
+}
+
+// fragment returns length bytes at offset within fragment block idx.
+func (r *Reader) fragment(idx uint32, offset, length int64) ([]byte, error) {
+	r.fragmentMu.Lock()
+	defer r.fragmentMu.Unlock()
+	if r.fragmentIdx != int64(idx) {
+		fe, err := r.fragmentEntry(idx)
+		if err != nil {
+			return nil, err
+		}
+		r.fragmentIdx = -1
+		r.fragmentBlock, err = r.dataBlock(r.fragmentBlock[:0], int64(fe.Start), fe.Size)
+		if err != nil {
+			return nil, xerrors.Errorf("fragment %d: %v", idx, err)
+		}
+		r.fragmentIdx = int64(idx)
+	}
+	if offset+length > int64(len(r.fragmentBlock)) {
+		return nil, fmt.Errorf("fragment %d: tail end [%d, %d) exceeds fragment block size %d", idx, offset, offset+length, len(r.fragmentBlock))
+	}
+	return append([]byte(nil), r.fragmentBlock[offset:offset+length]...), nil
+}
+
+// dataBlock appends the contents of the data (or fragment) block at off with
+// the specified on-disk size to dst.
+func (r *Reader) dataBlock(dst []byte, off int64, size uint32) ([]byte, error) {
+	length := int64(size &^ dataBlockUncompressed)
+	if size&dataBlockUncompressed != 0 {
+		if int64(cap(dst)) < length {
+			dst = make([]byte, length)
+		}
+		dst = dst[:length]
+		_, err := r.r.ReadAt(dst, off)
+		return dst, err
+	}
+	raw := make([]byte, length)
+	if _, err := r.r.ReadAt(raw, off); err != nil {
+		return nil, err
+	}
+	return r.decompressor.decompress(dst[:0], raw)
+}
+
+// FileReader returns a reader for the contents of the file inode.
+func (r *Reader) FileReader(inode Inode) (*io.SectionReader, error) {
+	//log.Printf("Readfile(%v)", inode)
+	fi, err := r.fileInode(inode)
+	if err != nil {
+		return nil, err
+	}
+	contiguous := fi.fragment == invalidFragment
+	for _, b := range fi.blocks {
+		if b&dataBlockUncompressed == 0 {
+			contiguous = false
+			break
+		}
+	}
+	if contiguous {
+		// Fast path: all blocks are stored uncompressed, back to back.
+		return io.NewSectionReader(r.r, fi.start, fi.size), nil
+	}
+	offsets := make([]int64, len(fi.blocks))
+	off := fi.start
+	for idx, b := range fi.blocks {
+		offsets[idx] = off
+		off += int64(b &^ dataBlockUncompressed)
+	}
+	return io.NewSectionReader(&blockFileReader{
+		r:       r,
+		fi:      fi,
+		offsets: offsets,
+		cached:  -1,
+	}, 0, fi.size), nil
+}
+
+// dataBlockUncompressed is set in the size of data blocks which are stored
+// uncompressed.
+const dataBlockUncompressed = 1 << 24
+
+// blockFileReader implements io.ReaderAt for files consisting of (possibly
+// compressed) data blocks and an optional tail end in a fragment block,
+// decompressing one block at a time.
+type blockFileReader struct {
+	r       *Reader
+	fi      *fileInode
+	offsets []int64 // on-disk block offsets
+
+	mu     sync.Mutex
+	cached int    // index of the block in buf, or -1
+	buf    []byte // decompressed contents of block cached
+}
+
+// block reads block idx into fr.buf, unless it is already cached. The block
+// following the last data block refers to the tail end in the fragment block.
+// fr.mu must be held.
+func (fr *blockFileReader) block(idx int) error {
+	if fr.cached == idx {
+		return nil
+	}
+	fr.cached = -1
+	bs := int64(fr.r.super.BlockSize)
+	want := fr.fi.size - int64(idx)*bs
+	if want > bs {
+		want = bs
+	}
+	if idx == len(fr.fi.blocks) {
+		tail, err := fr.r.fragment(fr.fi.fragment, fr.fi.fragmentOffset, want)
+		if err != nil {
+			return err
+		}
+		fr.buf = tail
+		fr.cached = idx
+		return nil
+	}
+	b := fr.fi.blocks[idx]
+	if b&^dataBlockUncompressed == 0 {
+		// sparse block, i.e. all zero bytes
+		if int64(cap(fr.buf)) < want {
+			fr.buf = make([]byte, want)
+		}
+		fr.buf = fr.buf[:want]
+		for i := range fr.buf {
+			fr.buf[i] = 0
+		}
+	} else {
+		var err error
+		fr.buf, err = fr.r.dataBlock(fr.buf, fr.offsets[idx], b)
+		if err != nil {
+			return xerrors.Errorf("data block %d: %v", idx, err)
+		}
+	}
+	if got := int64(len(fr.buf)); got != want {
+		return fmt.Errorf("data block %d: got %d bytes, want %d", idx, got, want)
+	}
+	fr.cached = idx
+	return nil
+}
+
+// ReadAt implements io.ReaderAt.
+func (fr *blockFileReader) ReadAt(p []byte, off int64) (n int, err error) {
+	fr.mu.Lock()
+	defer fr.mu.Unlock()
+	bs := int64(fr.r.super.BlockSize)
+	for n < len(p) {
+		if off >= fr.fi.size {
+			return n, io.EOF
+		}
+		idx := int(off / bs)
+		if err := fr.block(idx); err != nil {
+			return n, err
+		}
+		nn := copy(p[n:], fr.buf[off-int64(idx)*bs:])
+		n += nn
+		off += int64(nn)
+	}
+	return n, nil
 }
 
 type FileNotFoundError struct {
@@ -354,7 +744,8 @@ func (r *Reader) lookupPath(path string, followSymlink bool) (Inode, error) {
 		if err != nil {
 			return 0, xerrors.Errorf("Stat(%d): %v", inode, err)
 		}
-		if _, ok := i.(symlinkInodeHeader); ok {
+		switch i.(type) {
+		case symlinkInodeHeader, lsymlinkInodeHeader:
 			target, err := r.ReadLink(inode)
 			if err != nil {
 				return 0, err
@@ -486,6 +877,10 @@ func (r *Reader) readdir(dirInode Inode, stat bool) ([]os.FileInfo, error) {
 					ffi.mode |= os.ModeDir
 				case symlinkType, lsymlinkType:
 					ffi.mode |= os.ModeSymlink
+				case blkdevType, chrdevType, lblkdevType, lchrdevType:
+					ffi.mode |= deviceMode(de.EntryType)
+				case fifoType, socketType, lfifoType, lsocketType:
+					ffi.mode |= ipcMode(de.EntryType)
 				}
 				fi = ffi
 			}
@@ -501,7 +896,15 @@ type FileInfo struct {
 	size    int64
 	mode    os.FileMode
 	modTime time.Time
+	uid     uint32
+	gid     uint32
+	rdev    uint32
+	nlink   uint32
 	Inode   Inode
+
+	// InodeNumber is the inode number stored in the image. Only filled in by
+	// Stat (and Readdir).
+	InodeNumber uint32
 }
 
 func (fi *FileInfo) Name() string       { return fi.name }
@@ -511,26 +914,96 @@ func (fi *FileInfo) IsDir() bool        { return fi.mode.IsDir() }
 func (fi *FileInfo) ModTime() time.Time { return fi.modTime }
 func (fi *FileInfo) Sys() interface{}   { return fi }
 
-func (r *Reader) readXattr(tableHeader xattrTableHeader, id xattrId) (*Xattr, error) {
-	blockoffset, offset := r.inode(Inode(id.Xattr))
-	br, err := r.blockReader(int64(tableHeader.XattrTableStart)+blockoffset, offset)
+// Nlink returns the number of hard links to the inode.
+func (fi *FileInfo) Nlink() uint32 { return fi.nlink }
+
+// Uid returns the numeric user id of the file owner.
+func (fi *FileInfo) Uid() uint32 { return fi.uid }
+
+// Gid returns the numeric group id of the file owner.
+func (fi *FileInfo) Gid() uint32 { return fi.gid }
+
+// Rdev returns the device number of block and character devices, encoded like
+// the Linux kernel's new_encode_dev.
+func (fi *FileInfo) Rdev() uint32 { return fi.rdev }
+
+// Major returns the major device number of block and character devices.
+func (fi *FileInfo) Major() uint32 {
+	major, _ := decodeDev(fi.rdev)
+	return major
+}
+
+// Minor returns the minor device number of block and character devices.
+func (fi *FileInfo) Minor() uint32 {
+	_, minor := decodeDev(fi.rdev)
+	return minor
+}
+
+// xattrOutOfLine is ORed to the type of an xattr key if the value is stored
+// elsewhere in the xattr table (as a uint64 reference).
+const xattrOutOfLine = 0x0100
+
+// xattrReader returns a metadata block reader positioned at ref (block offset
+// relative to the xattr table start << 16 | offset).
+func (r *Reader) xattrReader(tableHeader xattrTableHeader, ref uint64) (io.ReadCloser, error) {
+	blockoffset, offset := r.inode(Inode(ref))
+	return r.blockReader(int64(tableHeader.XattrTableStart)+blockoffset, offset)
+}
+
+// readXattrs reads the id.Count key/value pairs referenced by id.
+func (r *Reader) readXattrs(tableHeader xattrTableHeader, id xattrId) ([]Xattr, error) {
+	br, err := r.xattrReader(tableHeader, id.Xattr)
 	if err != nil {
 		return nil, err
 	}
 	defer br.Close()
-	var typ, nameSize uint16
-	if err := binary.Read(br, binary.LittleEndian, &typ); err != nil {
-		return nil, err
-	}
-	if err := binary.Read(br, binary.LittleEndian, &nameSize); err != nil {
-		return nil, err
+	xattrs := make([]Xattr, 0, id.Count)
+	for i := 0; i < int(id.Count); i++ {
+		var key struct {
+			Type     uint16
+			NameSize uint16
+		}
+		if err := binary.Read(br, binary.LittleEndian, &key); err != nil {
+			return nil, err
+		}
+		name := make([]byte, key.NameSize)
+		if _, err := io.ReadFull(br, name); err != nil {
+			return nil, err
+		}
+		var valSize uint32
+		if err := binary.Read(br, binary.LittleEndian, &valSize); err != nil {
+			return nil, err
+		}
+		val := make([]byte, valSize)
+		if _, err := io.ReadFull(br, val); err != nil {
+			return nil, err
+		}
+		if key.Type&xattrOutOfLine != 0 {
+			if valSize != 8 {
+				return nil, fmt.Errorf("invalid out-of-line xattr value reference of size %d", valSize)
+			}
+			val, err = r.readXattrValue(tableHeader, binary.LittleEndian.Uint64(val))
+			if err != nil {
+				return nil, err
+			}
+		}
+		typ := key.Type &^ xattrOutOfLine
+		xattrs = append(xattrs, Xattr{
+			Type:     typ,
+			FullName: xattrPrefix[int(typ)] + string(name),
+			Value:    val,
+		})
 	}
-	log.Printf("type = %v, nameSize = %v", typ, nameSize)
-	name := make([]byte, nameSize)
-	if _, err := io.ReadFull(br, name); err != nil {
+	return xattrs, nil
+}
+
+// readXattrValue reads an out-of-line xattr value.
+func (r *Reader) readXattrValue(tableHeader xattrTableHeader, ref uint64) ([]byte, error) {
+	br, err := r.xattrReader(tableHeader, ref)
+	if err != nil {
 		return nil, err
 	}
-	log.Printf("name = %v", string(name))
+	defer br.Close()
 	var valSize uint32
 	if err := binary.Read(br, binary.LittleEndian, &valSize); err != nil {
 		return nil, err
@@ -539,58 +1012,65 @@ func (r *Reader) readXattr(tableHeader xattrTableHeader, id xattrId) (*Xattr, er
 	if _, err := io.ReadFull(br, val); err != nil {
 		return nil, err
 	}
-	log.Printf("val = %x", val)
-	return &Xattr{
-		Type:     typ,
-		FullName: xattrPrefix[int(typ)] + string(name),
-		Value:    val,
-	}, nil
+	return val, nil
+}
+
+// xattrIndex returns the index into the xattr id table of inode, or
+// invalidXattr if the inode has no extended attributes.
+func xattrIndex(inode interface{}) (uint32, error) {
+	switch x := inode.(type) {
+	case regInodeHeader,
+		dirInodeHeader,
+		symlinkInodeHeader,
+		devInodeHeader,
+		ipcInodeHeader:
+		return invalidXattr, nil // basic inodes have no extended attributes
+
+	case lregInodeHeader:
+		return x.Xattr, nil
+	case ldirInodeHeader:
+		return x.Xattr, nil
+	case lsymlinkInodeHeader:
+		return x.Xattr, nil
+	case ldevInodeHeader:
+		return x.Xattr, nil
+	case lipcInodeHeader:
+		return x.Xattr, nil
+	}
+	return 0, fmt.Errorf("unknown inode type %T", inode)
 }
 
 func (r *Reader) ReadXattrs(inode Inode) ([]Xattr, error) {
-	//log.Printf("Readdir(%v (%x))", dirInode, dirInode)
 	i, err := r.readInode(inode)
 	if err != nil {
 		return nil, err
 	}
-	var xid uint32
-	switch x := i.(type) {
-	case regInodeHeader,
-		dirInodeHeader,
-		ldirInodeHeader,
-		symlinkInodeHeader:
+	xid, err := xattrIndex(i)
+	if err != nil {
+		return nil, err
+	}
+	if xid == invalidXattr || r.super.XattrIdTableStart == -1 {
 		return nil, nil // no extended attributes
-
-	case lregInodeHeader:
-		if x.Xattr == invalidXattr {
-			return nil, nil // file has no extended attributes
-		}
-		xid = x.Xattr
-
-	default:
-		return nil, fmt.Errorf("unknown inode type %T", i)
 	}
 
-	const idEntriesPerBlock = 512 // = 8192 / 16 /* sizeof(xattrId) */
-	block := xid / idEntriesPerBlock
-	offset := (xid % idEntriesPerBlock) * 16
-	log.Printf("xattr id %d, block %d, offset %d", xid, block, offset)
-	log.Printf("r.super.XattrIdTableStart = 0x%x, r.super.XattrIdTableStart = %v", r.super.XattrIdTableStart, r.super.XattrIdTableStart)
-	br := ioutil.NopCloser(io.NewSectionReader(r.r, r.super.XattrIdTableStart, int64(16 /* sizeof(xattrTableHeader) */ +(block+1)*4 /* sizeof(uint32) */)))
 	var tableHeader xattrTableHeader
-	if err := binary.Read(br, binary.LittleEndian, &tableHeader); err != nil {
+	if err := binary.Read(io.NewSectionReader(r.r, r.super.XattrIdTableStart, 16 /* sizeof(xattrTableHeader) */), binary.LittleEndian, &tableHeader); err != nil {
 		return nil, err
 	}
-	// index starts here
-	if _, err := io.CopyN(ioutil.Discard, br, int64(block*4 /* sizeof(uint32) */)); err != nil {
-		return nil, err
+	if xid >= tableHeader.XattrIds {
+		return nil, fmt.Errorf("xattr id %d out of range [0, %d)", xid, tableHeader.XattrIds)
 	}
-	var blockOffset uint32
-	if err := binary.Read(br, binary.LittleEndian, &blockOffset); err != nil {
+
+	// The header is followed by the locations (uint64) of the metadata blocks
+	// holding the xattr id table.
+	const idEntriesPerBlock = metadataBlockSize / 16 /* sizeof(xattrId) */
+	block := int64(xid / idEntriesPerBlock)
+	offset := int64(xid%idEntriesPerBlock) * 16
+	var blockOffset [8]byte
+	if _, err := r.r.ReadAt(blockOffset[:], r.super.XattrIdTableStart+16+block*8); err != nil {
 		return nil, err
 	}
-	log.Printf("blockOffset = 0x%x (%d)", blockOffset, blockOffset)
-	br, err = r.blockReader(int64(blockOffset), int64(offset))
+	br, err := r.blockReader(int64(binary.LittleEndian.Uint64(blockOffset[:])), offset)
 	if err != nil {
 		return nil, err
 	}
@@ -599,17 +1079,5 @@ func (r *Reader) ReadXattrs(inode Inode) ([]Xattr, error) {
 	if err := binary.Read(br, binary.LittleEndian, &id); err != nil {
 		return nil, err
 	}
-	log.Printf("id: %+v", id)
-	log.Printf("tableHeader: %+v (start 0x%x)", tableHeader, tableHeader.XattrTableStart)
-
-	var xattrs []Xattr
-	for i := 0; i < int(id.Count); i++ {
-		xattr, err := r.readXattr(tableHeader, id)
-		if err != nil {
-			return nil, err
-		}
-		xattrs = append(xattrs, *xattr)
-	}
-
-	return xattrs, nil
+	return r.readXattrs(tableHeader, id)
 }
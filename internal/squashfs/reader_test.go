@@ -1,14 +1,26 @@
 package squashfs
 
 import (
+	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
+	"debug/elf"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/distr1/distri/internal/distritest"
 	"github.com/google/go-cmp/cmp"
+	"golang.org/x/sys/unix"
 )
 
 func cmpFileInfo(got os.FileInfo, want FileInfo) error {
@@ -354,3 +366,475 @@ func TestReadXattr(t *testing.T) {
 		}
 	}
 }
+
+// TestMksquashfsFixture reads testdata/xattr.squashfs, which was created by
+// mksquashfs and contains a fragment block and an export table.
+func TestMksquashfsFixture(t *testing.T) {
+	t.Parallel()
+
+	f, err := os.Open("testdata/xattr.squashfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rd, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fis, err := rd.Readdir(rd.RootInode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+		ffi := fi.Sys().(*FileInfo)
+		if got, want := ffi.Uid(), uint32(0); got != want {
+			t.Errorf("%s: unexpected uid: got %d, want %d", fi.Name(), got, want)
+		}
+
+		// Resolve the inode number via the export table:
+		inode, err := rd.InodeByNumber(ffi.InodeNumber)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := inode, ffi.Inode; got != want {
+			t.Errorf("%s: InodeByNumber(%d) = %v, want %v", fi.Name(), ffi.InodeNumber, got, want)
+		}
+
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		// Parsing the ELF section headers, which are located at the end of the
+		// file, verifies the file tail was read from the fragment block.
+		r, err := rd.FileReader(ffi.Inode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ef, err := elf.NewFile(r)
+		if err != nil {
+			t.Fatalf("%s: %v", fi.Name(), err)
+		}
+		if ef.Section(".text") == nil {
+			t.Errorf("%s: ELF file unexpectedly has no .text section", fi.Name())
+		}
+	}
+	if diff := cmp.Diff([]string{"gnome-keyring-daemon", "less", "mtr-packet"}, names); diff != "" {
+		t.Fatalf("unexpected directory entries: diff (-want +got):\n%s", diff)
+	}
+
+	inode, err := rd.LlookupPath("less")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := rd.Stat("less", inode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("less: unexpected mode %v, want a symlink", fi.Mode())
+	}
+}
+
+// fixtureEntry is what TestFixtures compares for each file in a fixture.
+type fixtureEntry struct {
+	Mode         os.FileMode
+	Uid, Gid     uint32
+	Nlink        uint32
+	Size         int64 // regular files and symlinks only
+	Major, Minor uint32
+	MD5          string // contents of regular files
+	Target       string // symlinks
+	Xattrs       []string
+}
+
+func fixtureFile(contents []byte, mode os.FileMode) fixtureEntry {
+	return fixtureEntry{
+		Mode:  mode,
+		Nlink: 1,
+		Size:  int64(len(contents)),
+		MD5:   fmt.Sprintf("%x", md5.Sum(contents)),
+	}
+}
+
+// readFixture walks the tree of rd, returning an entry per path.
+func readFixture(t *testing.T, rd *Reader, dir string, inode Inode, entries map[string]fixtureEntry) {
+	t.Helper()
+	fis, err := rd.Readdir(inode)
+	if err != nil {
+		t.Fatalf("Readdir(%q): %v", dir, err)
+	}
+	for _, fi := range fis {
+		path := filepath.Join(dir, fi.Name())
+		ffi := fi.Sys().(*FileInfo)
+		// Resolve the inode number via the export table:
+		if inode, err := rd.InodeByNumber(ffi.InodeNumber); err != nil {
+			t.Errorf("%s: %v", path, err)
+		} else if inode != ffi.Inode {
+			t.Errorf("%s: InodeByNumber(%d) = %v, want %v", path, ffi.InodeNumber, inode, ffi.Inode)
+		}
+		e := fixtureEntry{
+			Mode:  fi.Mode(),
+			Uid:   ffi.Uid(),
+			Gid:   ffi.Gid(),
+			Nlink: ffi.Nlink(),
+		}
+		xattrs, err := rd.ReadXattrs(ffi.Inode)
+		if err != nil {
+			t.Fatalf("%s: ReadXattrs: %v", path, err)
+		}
+		for _, x := range xattrs {
+			e.Xattrs = append(e.Xattrs, x.FullName+"="+string(x.Value))
+		}
+		sort.Strings(e.Xattrs)
+		switch {
+		case fi.IsDir():
+			readFixture(t, rd, path, ffi.Inode, entries)
+		case fi.Mode().IsRegular():
+			e.Size = fi.Size()
+			r, err := rd.FileReader(ffi.Inode)
+			if err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			h := md5.New()
+			if _, err := io.Copy(h, r); err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			e.MD5 = fmt.Sprintf("%x", h.Sum(nil))
+		case fi.Mode()&os.ModeSymlink != 0:
+			e.Size = fi.Size()
+			if e.Target, err = rd.ReadLink(ffi.Inode); err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+		case fi.Mode()&os.ModeDevice != 0:
+			e.Major, e.Minor = ffi.Major(), ffi.Minor()
+		}
+		entries[path] = e
+	}
+}
+
+// TestFixtures reads the images in testdata which were created by mksquashfs
+// using testdata/mkfixtures.sh.
+func TestFixtures(t *testing.T) {
+	t.Parallel()
+
+	// incompressible data, see random() in mkfixtures.sh
+	var random []byte
+	for i := 1; len(random) < 3*4096+100; i++ {
+		sum := sha256.Sum256([]byte(strconv.Itoa(i)))
+		random = append(random, sum[:]...)
+	}
+	random = random[:3*4096+100]
+	sparse := make([]byte, 2*4096+3)
+	copy(sparse[2*4096:], "end")
+	small := fixtureFile([]byte("hello world\n"), 0644)
+	small.Nlink = 2 // hardlink
+	owned := fixtureFile([]byte("owned by 1000:100\n"), 0600)
+	owned.Uid, owned.Gid = 1000, 100
+	compressed := map[string]fixtureEntry{
+		"empty":          fixtureFile(nil, 0644),
+		"small":          small,
+		"hardlink":       small,
+		"one-block":      fixtureFile(bytes.Repeat([]byte{'a'}, 4096), 0644),
+		"duplicate":      fixtureFile(bytes.Repeat([]byte{'a'}, 4096), 0644),
+		"block-and-tail": fixtureFile(bytes.Repeat([]byte{'b'}, 4096+1), 0644),
+		"random":         fixtureFile(random, 0644),
+		"sparse":         fixtureFile(sparse, 0644),
+		"setuid":         fixtureFile([]byte("#!/bin/sh\n"), 0755|os.ModeSetuid),
+		"owned":          owned,
+		"link": {
+			Mode:   0777 | os.ModeSymlink,
+			Nlink:  1,
+			Size:   int64(len("sub/dir/nested")),
+			Target: "sub/dir/nested",
+		},
+		"empty-dir":      {Mode: 0755 | os.ModeDir, Nlink: 2},
+		"sub":            {Mode: 0755 | os.ModeDir | os.ModeSticky, Nlink: 3},
+		"sub/dir":        {Mode: 0700 | os.ModeDir, Nlink: 2},
+		"sub/dir/nested": fixtureFile([]byte("nested\n"), 0644),
+		"large":          {Mode: 0755 | os.ModeDir, Nlink: 2},
+	}
+	for i := 0; i < 600; i++ {
+		name := fmt.Sprintf("entry%03d", i)
+		compressed["large/"+name] = fixtureFile([]byte(name+"\n"), 0644)
+	}
+
+	label := func(value string) []string {
+		return []string{"trusted.label=" + value}
+	}
+	nobody := fixtureFile([]byte("owned by 65534:65534\n"), 0644)
+	nobody.Uid, nobody.Gid = 65534, 65534
+	xattrFile := fixtureFile([]byte("file with xattrs\n"), 0644)
+	xattrFile.Xattrs = []string{
+		"trusted.md5sum=d41d8cd98f00b204e9800998ecf8427e",
+		"user.mime_type=text/plain",
+	}
+	special := map[string]fixtureEntry{
+		"dev":         {Mode: 0755 | os.ModeDir, Nlink: 2},
+		"dev/null":    {Mode: 0666 | os.ModeDevice | os.ModeCharDevice, Nlink: 1, Major: 1, Minor: 3},
+		"dev/loop300": {Mode: 0660 | os.ModeDevice, Gid: 6, Nlink: 1, Major: 7, Minor: 300},
+		"dev/sda":     {Mode: 0660 | os.ModeDevice, Gid: 6, Nlink: 1, Major: 8, Minor: 0},
+		"dev/tty": {
+			Mode:   0666 | os.ModeDevice | os.ModeCharDevice,
+			Gid:    5,
+			Nlink:  1,
+			Major:  5,
+			Xattrs: label("device"),
+		},
+		"dev/lsda": {
+			Mode:   0660 | os.ModeDevice,
+			Gid:    6,
+			Nlink:  1,
+			Major:  8,
+			Minor:  16,
+			Xattrs: label("device"),
+		},
+		"fifo":         {Mode: 0600 | os.ModeNamedPipe, Nlink: 1},
+		"xattr-fifo":   {Mode: 0600 | os.ModeNamedPipe, Nlink: 1, Xattrs: label("fifo")},
+		"socket":       {Mode: 0755 | os.ModeSocket, Uid: 1000, Gid: 100, Nlink: 1},
+		"xattr-socket": {Mode: 0755 | os.ModeSocket, Nlink: 1, Xattrs: label("socket")},
+		"xattr-link": {
+			Mode:   0777 | os.ModeSymlink,
+			Nlink:  1,
+			Size:   int64(len("xattr-file")),
+			Target: "xattr-file",
+			Xattrs: label("symlink"),
+		},
+		"xattr-dir": {
+			Mode:   0755 | os.ModeDir,
+			Nlink:  2,
+			Xattrs: []string{"user.comment=extended"},
+		},
+		"xattr-dir/file": fixtureFile([]byte("file in xattr-dir\n"), 0644),
+		"xattr-file":     xattrFile,
+		"nobody":         nobody,
+	}
+
+	for _, tt := range []struct {
+		fn          string
+		compression string
+		want        map[string]fixtureEntry
+	}{
+		{"lzma.squashfs", "lzma", compressed},
+		{"xz.squashfs", "xz", compressed},
+		{"zstd.squashfs", "zstd", compressed},
+		{"special.squashfs", "gzip", special},
+	} {
+		tt := tt // copy
+		t.Run(tt.fn, func(t *testing.T) {
+			t.Parallel()
+			f, err := os.Open(filepath.Join("testdata", tt.fn))
+			if os.IsNotExist(err) {
+				t.Skipf("%v (create it using testdata/mkfixtures.sh, which requires mksquashfs)", err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			rd, err := NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := rd.super.Compression.String(), tt.compression; got != want {
+				t.Errorf("unexpected compression: got %q, want %q", got, want)
+			}
+			got := make(map[string]fixtureEntry)
+			readFixture(t, rd, "", rd.RootInode(), got)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected contents: diff (-want +got):\n%s", diff)
+			}
+
+			// Both names of the hardlink refer to the same inode:
+			if _, ok := tt.want["hardlink"]; !ok {
+				return
+			}
+			var numbers []uint32
+			for _, path := range []string{"small", "hardlink"} {
+				inode, err := rd.LookupPath(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				fi, err := rd.Stat(path, inode)
+				if err != nil {
+					t.Fatal(err)
+				}
+				numbers = append(numbers, fi.Sys().(*FileInfo).InodeNumber)
+			}
+			if numbers[0] != numbers[1] {
+				t.Errorf("hardlink: inode number %d, want %d (small)", numbers[1], numbers[0])
+			}
+		})
+	}
+}
+
+// writeMksquashfsTree populates dir with a file system tree exercising all
+// SquashFS features which mksquashfs uses.
+func writeMksquashfsTree(t *testing.T, dir string) {
+	t.Helper()
+	random := make([]byte, 300*1024)
+	if _, err := rand.New(rand.NewSource(1)).Read(random); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"empty":              nil,
+		"small":              []byte("hello world\n"),
+		"one-block":          bytes.Repeat([]byte{'a'}, dataBlockSize),
+		"one-block-and-tail": bytes.Repeat([]byte{'b'}, dataBlockSize+1),
+		"random":             random,
+		"sub/dir/nested":     []byte("nested\n"),
+	}
+	for i := 0; i < 300; i++ {
+		// A directory with more than 256 entries results in an extended
+		// directory inode with a directory index.
+		files[fmt.Sprintf("large/entry%03d", i)] = []byte(strings.Repeat("x", i))
+	}
+	for fn, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, fn)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, fn), contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A sparse file: two blocks of zero bytes, followed by data.
+	sparse, err := os.Create(filepath.Join(dir, "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sparse.WriteAt([]byte("end"), 2*dataBlockSize); err != nil {
+		t.Fatal(err)
+	}
+	if err := sparse.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("sub/dir/nested", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "small"), filepath.Join(dir, "hardlink")); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mkfifo(filepath.Join(dir, "fifo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "sub"), 0755|os.ModeSticky); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMksquashfs creates images with mksquashfs (using all supported
+// compressors) and verifies the Reader returns the original contents.
+func TestMksquashfs(t *testing.T) {
+	t.Parallel()
+
+	if _, err := exec.LookPath("mksquashfs"); err != nil {
+		t.Skip("mksquashfs not found in $PATH")
+	}
+
+	tmp, err := ioutil.TempDir("", "squashfs-mksquashfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer distritest.RemoveAll(t, tmp)
+	src := filepath.Join(tmp, "src")
+	writeMksquashfsTree(t, src)
+
+	for _, compression := range []string{"gzip", "xz", "zstd", "lzma"} {
+		compression := compression // copy
+		t.Run(compression, func(t *testing.T) {
+			img := filepath.Join(tmp, compression+".squashfs")
+			mksquashfs := exec.Command("mksquashfs", src, img, "-noappend", "-all-root", "-comp", compression)
+			if out, err := mksquashfs.CombinedOutput(); err != nil {
+				if strings.Contains(string(out), "not supported") || strings.Contains(string(out), "Unrecognised compressor") {
+					t.Skipf("mksquashfs does not support %s: %s", compression, out)
+				}
+				t.Fatalf("%v: %v\n%s", mksquashfs.Args, err, out)
+			}
+			f, err := os.Open(img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			rd, err := NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := rd.super.Compression.String(), compression; got != want {
+				t.Fatalf("unexpected compression: got %s, want %s", got, want)
+			}
+			err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				rel, err := filepath.Rel(src, path)
+				if err != nil {
+					return err
+				}
+				if rel == "." {
+					return nil
+				}
+				inode, err := rd.LlookupPath(rel)
+				if err != nil {
+					return err
+				}
+				fi, err := rd.Stat(info.Name(), inode)
+				if err != nil {
+					return err
+				}
+				if got, want := fi.Mode(), info.Mode(); got != want {
+					return fmt.Errorf("%s: unexpected mode: got %v, want %v", rel, got, want)
+				}
+				switch {
+				case info.Mode().IsRegular():
+					r, err := rd.FileReader(inode)
+					if err != nil {
+						return err
+					}
+					got, err := ioutil.ReadAll(r)
+					if err != nil {
+						return err
+					}
+					want, err := ioutil.ReadFile(path)
+					if err != nil {
+						return err
+					}
+					if !bytes.Equal(got, want) {
+						return fmt.Errorf("%s: contents differ", rel)
+					}
+
+				case info.Mode()&os.ModeSymlink != 0:
+					got, err := rd.ReadLink(inode)
+					if err != nil {
+						return err
+					}
+					want, err := os.Readlink(path)
+					if err != nil {
+						return err
+					}
+					if got != want {
+						return fmt.Errorf("%s: ReadLink: got %q, want %q", rel, got, want)
+					}
+
+				case info.IsDir():
+					fis, err := rd.Readdir(inode)
+					if err != nil {
+						return err
+					}
+					want, err := ioutil.ReadDir(path)
+					if err != nil {
+						return err
+					}
+					if got, want := len(fis), len(want); got != want {
+						return fmt.Errorf("%s: unexpected number of directory entries: got %d, want %d", rel, got, want)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
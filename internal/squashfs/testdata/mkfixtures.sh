@@ -0,0 +1,111 @@
+#!/bin/bash
+# mkfixtures.sh creates the mksquashfs images in this directory which are read
+# by TestFixtures:
+#
+#   lzma.squashfs, xz.squashfs, zstd.squashfs: the same tree of regular files
+#   (empty, fragment-only, block-sized, duplicate, sparse, incompressible,
+#   hard-linked, setuid, owned by 1000:100), a symlink and directories (one
+#   with more than one metadata block of entries, i.e. a directory index),
+#   compressed with -comp lzma, xz and zstd, respectively.
+#
+#   special.squashfs: device nodes, fifos and sockets, with and without
+#   extended attributes (i.e. basic and extended inodes), and ids > 1000.
+#
+# mksquashfs ≥ 4.4 (for zstd and -all-time) is required, and the script must
+# be run as root (for mknod, chown and trusted.* extended attributes) on a
+# system without SELinux (which would label all files). Run it from
+# internal/squashfs:
+#
+#   sudo ./testdata/mkfixtures.sh
+set -eu
+
+out=$(cd "$(dirname "$0")" && pwd)
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+# -b 4096: the smallest block size, so that small files span multiple blocks
+# -all-time: reproducible images
+common=(-noappend -b 4096 -exports -all-time 1577836800 -mkfs-time 1577836800 -no-progress)
+
+# random writes n bytes of incompressible, reproducible data: the SHA-256
+# digests of 1, 2, 3, …
+random() {
+	local n=$1 i=1
+	while [ "$(stat -c %s random 2>/dev/null || echo 0)" -lt "$n" ]; do
+		printf "$(printf '%d' $i | sha256sum | cut -c1-64 | sed 's/../\\x&/g')" >> random
+		i=$((i + 1))
+	done
+	truncate -s "$n" random
+}
+
+compressed="$tmp/compressed"
+mkdir "$compressed"
+(
+	cd "$compressed"
+	: > empty
+	printf 'hello world\n' > small
+	ln small hardlink
+	head -c 4096 /dev/zero | tr '\0' a > one-block
+	cp one-block duplicate
+	head -c 4097 /dev/zero | tr '\0' b > block-and-tail
+	random $((3 * 4096 + 100))
+	truncate -s $((2 * 4096)) sparse
+	printf 'end' >> sparse
+	printf '#!/bin/sh\n' > setuid
+	chmod 4755 setuid
+	printf 'owned by 1000:100\n' > owned
+	chown 1000:100 owned
+	chmod 600 owned
+	ln -s sub/dir/nested link
+	mkdir empty-dir
+	mkdir -p sub/dir
+	printf 'nested\n' > sub/dir/nested
+	chmod 700 sub/dir
+	chmod 1755 sub
+	mkdir large
+	for i in $(seq -f '%03g' 0 599); do
+		printf 'entry%s\n' "$i" > "large/entry$i"
+	done
+	chmod 644 empty small one-block duplicate block-and-tail random sparse sub/dir/nested large/*
+	chmod 755 empty-dir large
+)
+for comp in lzma xz zstd; do
+	mksquashfs "$compressed" "$out/$comp.squashfs" "${common[@]}" -no-xattrs -comp "$comp"
+done
+
+special="$tmp/special"
+mkdir "$special"
+(
+	cd "$special"
+	mkdir dev
+	mknod -m 666 dev/null c 1 3
+	mknod -m 660 dev/loop300 b 7 300
+	mknod -m 660 dev/sda b 8 0
+	mknod -m 666 dev/tty c 5 0
+	mknod -m 660 dev/lsda b 8 16
+	chgrp 6 dev/loop300 dev/sda dev/lsda
+	chgrp 5 dev/tty
+	# user.* attributes are only permitted on regular files and directories
+	setfattr -n trusted.label -v device dev/tty dev/lsda
+	mkfifo -m 600 fifo xattr-fifo
+	setfattr -n trusted.label -v fifo xattr-fifo
+	python3 -c 'import socket, sys
+for fn in sys.argv[1:]:
+    socket.socket(socket.AF_UNIX).bind(fn)' socket xattr-socket
+	chmod 755 socket xattr-socket
+	chown 1000:100 socket
+	setfattr -n trusted.label -v socket xattr-socket
+	ln -s xattr-file xattr-link
+	setfattr -h -n trusted.label -v symlink xattr-link
+	mkdir xattr-dir
+	printf 'file in xattr-dir\n' > xattr-dir/file
+	setfattr -n user.comment -v extended xattr-dir
+	printf 'file with xattrs\n' > xattr-file
+	setfattr -n user.mime_type -v text/plain xattr-file
+	setfattr -n trusted.md5sum -v d41d8cd98f00b204e9800998ecf8427e xattr-file
+	printf 'owned by 65534:65534\n' > nobody
+	chown 65534:65534 nobody
+	chmod 644 xattr-dir/file xattr-file nobody
+	chmod 755 dev xattr-dir
+)
+mksquashfs "$special" "$out/special.squashfs" "${common[@]}" -comp gzip
@@ -1,23 +1,28 @@
-// Package squashfs implements writing SquashFS file system images using zlib
-// compression for data blocks (inodes and directory entries are written
-// uncompressed for simplicity).
+// Package squashfs implements writing SquashFS file system images with
+// optional gzip, xz or zstd compression for data and metadata blocks. Small
+// files and the tail ends of files are packed into fragment blocks. Data blocks
+// are encoded concurrently (see Writer.Workers). Files with identical contents
+// can share their data (see Writer.Deduplicate), and files can be hard linked
+// (see Directory.LinkedFile).
 //
 // Note that SquashFS requires directory entries to be sorted, i.e. files and
 // directories need to be added in the correct order.
 //
 // This package intentionally only implements a subset of SquashFS. Notably,
-// block devices, character devices, FIFOs, sockets and xattrs are not
-// supported.
+// the Writer supports only one xattr per file, and all files are owned by
+// root.
 package squashfs
 
 import (
 	"bytes"
-	"compress/zlib"
+	"crypto/sha256"
 	"encoding/binary"
+	"fmt"
 	"io"
+	"math"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -32,6 +37,7 @@ const (
 	lzoCompression
 	xzCompression
 	lz4Compression
+	zstdCompression
 )
 
 const (
@@ -64,9 +70,7 @@ type superblock struct {
 
 	// Compression is an ID designating the compressor
 	// used for both data and meta data blocks.
-	//
-	// TODO: define a custom uint16 type
-	Compression uint16
+	Compression Compression
 
 	// The log_2 of the block size. If the two fields do not agree,
 	// the archive is considered corrupted.
@@ -240,7 +244,8 @@ type devInodeHeader struct {
 	// Nlink is the number of hard links to this entry.
 	Nlink uint32
 
-	// Rdev is the system-specific device number.
+	// Rdev is the device number, encoded like the Linux kernel's
+	// new_encode_dev (see encodeDev).
 	Rdev uint32
 }
 
@@ -254,6 +259,38 @@ type ipcInodeHeader struct {
 	Nlink uint32
 }
 
+// lchrdevType and lblkdevType
+//
+// https://dr-emann.github.io/squashfs/squashfs.html#_device_special_files
+type ldevInodeHeader struct {
+	devInodeHeader
+
+	// Xattr is an index into the Xattr table, or 0xFFFFFFFF if the inode has no
+	// extended attributes.
+	Xattr uint32
+}
+
+// lfifoType and lsocketType
+//
+// https://dr-emann.github.io/squashfs/squashfs.html#_ipc_inodes_fifo_or_socket
+type lipcInodeHeader struct {
+	ipcInodeHeader
+
+	// Xattr is an index into the Xattr table, or 0xFFFFFFFF if the inode has no
+	// extended attributes.
+	Xattr uint32
+}
+
+// lsymlinkType: a symlinkInodeHeader and target path, followed by a uint32
+// Xattr index. This type is only used for reading.
+//
+// https://dr-emann.github.io/squashfs/squashfs.html#_symbolic_links
+type lsymlinkInodeHeader struct {
+	symlinkInodeHeader
+
+	Xattr uint32
+}
+
 // dirType
 //
 // https://dr-emann.github.io/squashfs/squashfs.html#_directory_inodes
@@ -441,6 +478,8 @@ func writeIdTable(w io.WriteSeeker, ids []uint32) (start int64, err error) {
 }
 
 type fullDirEntry struct {
+	// startBlock is the offset of the metadata block in the inode table which
+	// contains the inode, relative to the inode table start.
 	startBlock  uint32
 	offset      uint16
 	inodeNumber uint32
@@ -461,16 +500,71 @@ type Writer struct {
 	// called precisely once.
 	Root *Directory
 
+	// Compression selects the compressor for data blocks. It must be set
+	// before creating the first file and defaults to Uncompressed.
+	Compression Compression
+
+	// Workers is the number of data blocks which are encoded (compressed and
+	// checksummed) concurrently. Zero means runtime.GOMAXPROCS(0), one means
+	// encoding blocks serially on the calling goroutine. The resulting image is
+	// identical regardless of Workers. It must be set before creating the
+	// first file.
+	Workers int
+
+	// Deduplicate enables detecting files with identical contents (by
+	// checksum), whose inodes then refer to the same data blocks and
+	// fragment. It must be set before creating the first file.
+	Deduplicate bool
+
+	compressor compressor
+
+	// sem bounds the number of blocks which are encoded concurrently, or is
+	// nil when encoding serially.
+	sem chan struct{}
+
+	// pending holds the blocks which are not yet written, in submission order.
+	pending []*pendingBlock
+
+	// bufs holds buffers of dataBlockSize capacity for pendingBlocks.
+	bufs sync.Pool
+
+	// dataOff is the offset at which the next data block will be written.
+	dataOff int64
+
+	// err is the first error encountered when writing a pending block.
+	err error
+
 	xattrs   []Xattr
 	xattrIds []xattrId
 
 	w io.WriteSeeker
 
-	sb       superblock
-	inodeBuf bytes.Buffer
-	dirBuf   bytes.Buffer
+	sb     superblock
+	inodes metadataWriter
+	dirs   metadataWriter
+
+	// frag accumulates the tail ends of files until a fragment block is full.
+	frag []byte
+
+	// fragmentBlocks is the number of fragment blocks submitted for writing,
+	// fragments holds the entries of those which were already written.
+	fragmentBlocks uint32
+	fragments      []fragmentEntry
+
+	// dupFiles maps the checksum of file contents to the start of their data
+	// blocks, dupTails maps the checksum of a tail end to its fragment
+	// location. Both are only used with Deduplicate.
+	dupFiles map[[sha256.Size]byte]int64
+	dupTails map[[sha256.Size]byte]fragmentLocation
+
+	// end is the largest offset which was written to. Deduplication rewinds
+	// the data area, so end can be larger than the final image size.
+	end int64
+}
 
-	writeInodeNumTo map[string][]int64
+// fragmentLocation is the location of a tail end within a fragment block.
+type fragmentLocation struct {
+	fragment, offset uint32
 }
 
 // TODO: document what this is doing and what it is used for
@@ -485,7 +579,7 @@ func slog(block uint32) uint16 {
 
 // filesystemFlags returns flags for a SquashFS file system created by this
 // package (disabling most features for now).
-func filesystemFlags() uint16 {
+func filesystemFlags(c Compression, dedup bool) uint16 {
 	const (
 		noI = 1 << iota // uncompressed metadata
 		noD             // uncompressed data
@@ -500,7 +594,14 @@ func filesystemFlags() uint16 {
 		compopt           // compressor-specific options present?
 	)
 	// TODO: is noXattr still accurate?
-	return noI | noF | noFrag | noX | noXattr
+	flags := uint16(noX | noXattr)
+	if c == Uncompressed {
+		flags |= noI | noD | noF
+	}
+	if dedup {
+		flags |= duplicateChecking
+	}
+	return flags
 }
 
 // NewWriter returns a Writer which will write a SquashFS file system image to w
@@ -517,45 +618,103 @@ func NewWriter(w io.WriteSeeker, mkfsTime time.Time) (*Writer, error) {
 		return nil, err
 	}
 	wr := &Writer{
-		w: w,
+		w:       w,
+		dataOff: 96,
 		sb: superblock{
 			Magic:             magic,
 			MkfsTime:          int32(mkfsTime.Unix()),
 			BlockSize:         dataBlockSize,
 			Fragments:         0,
-			Compression:       zlibCompression,
 			BlockLog:          slog(dataBlockSize),
-			Flags:             filesystemFlags(),
 			NoIds:             1, // just one uid/gid mapping (for root)
 			Major:             majorVersion,
 			Minor:             minorVersion,
 			XattrIdTableStart: -1, // not present
 			LookupTableStart:  -1, // not present
 		},
-		writeInodeNumTo: make(map[string][]int64),
 	}
+	wr.inodes.w = wr
+	wr.dirs.w = wr
 	wr.Root = &Directory{
-		w:       wr,
-		name:    "", // root
-		modTime: mkfsTime,
+		w:           wr,
+		name:        "", // root
+		modTime:     mkfsTime,
+		inodeNumber: wr.newInodeNumber(),
 	}
 	return wr, nil
 }
 
-// Directory represents a SquashFS directory.
-type Directory struct {
-	w          *Writer
-	name       string
-	modTime    time.Time
-	dirEntries []fullDirEntry
-	parent     *Directory
+// initCompressor sets up the compressor selected by w.Compression, if any.
+func (w *Writer) initCompressor() error {
+	if w.compressor != nil || w.Compression == Uncompressed {
+		return nil
+	}
+	var err error
+	w.compressor, err = newCompressor(w.Compression, w.workers())
+	return err
+}
+
+// newInodeNumber allocates an inode number.
+func (w *Writer) newInodeNumber() uint32 {
+	w.sb.Inodes++
+	return w.sb.Inodes
 }
 
-func (d *Directory) path() string {
-	if d.parent == nil {
-		return d.name
+// https://dr-emann.github.io/squashfs/squashfs.html#_fragment_table
+type fragmentEntry struct {
+	// Start is the absolute byte offset of the fragment block.
+	Start uint64
+
+	// Size is the on-disk size of the fragment block. If the block is stored
+	// uncompressed, bit 24 is set.
+	Size uint32
+
+	Unused uint32
+}
+
+// addFragment appends the tail end of a file to the current fragment block,
+// writing the fragment block first if tail does not fit. It returns the
+// fragment index and offset to store in the inode.
+func (w *Writer) addFragment(tail []byte) (fragment, offset uint32, _ error) {
+	if len(w.frag)+len(tail) > dataBlockSize {
+		if err := w.writeFragmentBlock(); err != nil {
+			return 0, 0, err
+		}
+	}
+	if w.frag == nil {
+		w.frag = w.getBuf()
 	}
-	return filepath.Join(d.parent.path(), d.name)
+	fragment = w.fragmentBlocks
+	offset = uint32(len(w.frag))
+	w.frag = append(w.frag, tail...)
+	return fragment, offset, nil
+}
+
+// writeFragmentBlock submits the current fragment block for writing, if any.
+func (w *Writer) writeFragmentBlock() error {
+	if len(w.frag) == 0 {
+		return nil
+	}
+	block := w.frag
+	w.frag = nil
+	w.fragmentBlocks++
+	return w.submit(block, func(pb *pendingBlock, off int64) error {
+		w.fragments = append(w.fragments, fragmentEntry{
+			Start: uint64(off),
+			Size:  pb.size,
+		})
+		return nil
+	})
+}
+
+// Directory represents a SquashFS directory.
+type Directory struct {
+	w           *Writer
+	name        string
+	modTime     time.Time
+	dirEntries  []fullDirEntry
+	parent      *Directory
+	inodeNumber uint32
 }
 
 type file struct {
@@ -572,16 +731,28 @@ type file struct {
 	buf bytes.Buffer
 
 	// blocksizes stores, for each block of dataBlockSize bytes (uncompressed),
-	// the number of bytes the block compressed down to.
+	// the number of bytes the block compressed down to. Like off, blocksizes
+	// is only complete once all blocks of the file were written.
 	blocksizes []uint32
 
-	// compBuf is used for holding a block during compression to avoid memory
-	// allocations.
-	compBuf *bytes.Buffer
-	// zlibWriter is re-used for each compressed block
-	zlibWriter *zlib.Writer
+	// sums stores the SHA-256 checksum of each (uncompressed) block.
+	sums [][sha256.Size]byte
 
 	xattrRef uint32
+
+	// tailSum is the SHA-256 checksum of the tail end (only with
+	// Writer.Deduplicate).
+	tailSum [sha256.Size]byte
+
+	// nlink is the number of directory entries referring to this file, links
+	// is the number of entries created so far.
+	nlink, links uint32
+
+	// Populated once the inode was written:
+	inodeWritten bool
+	startBlock   uint32
+	offset       uint16
+	inodeNumber  uint32
 }
 
 // Directory creates a new directory with the specified name and modTime.
@@ -591,24 +762,30 @@ func (d *Directory) Directory(name string, modTime time.Time) *Directory {
 		name:    name,
 		modTime: modTime,
 		parent:  d,
+		// Allocate the inode number right away so that subdirectories can
+		// refer to their parent before it is flushed.
+		inodeNumber: d.w.newInodeNumber(),
 	}
 }
 
 // File creates a file with the specified name, modTime and mode. The returned
 // io.WriterCloser must be closed after writing the file.
 func (d *Directory) File(name string, modTime time.Time, mode uint16, xattrs []Xattr) (io.WriteCloser, error) {
-	off, err := d.w.w.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return nil, err
-	}
+	return d.LinkedFile(name, modTime, mode, xattrs, 1)
+}
 
-	// zlib.BestSpeed results in only a 2x slow-down over no compression
-	// (compared to >4x slow-down with DefaultCompression), but generates
-	// results which are in the same ball park (10% larger).
-	zw, err := zlib.NewWriterLevel(nil, zlib.BestSpeed)
-	if err != nil {
+// LinkedFile is like File, but creates a file which will have nlink directory
+// entries in total: the file itself and nlink-1 hard links created via Link.
+// The number of links must be known up front, as SquashFS stores it in the
+// inode.
+func (d *Directory) LinkedFile(name string, modTime time.Time, mode uint16, xattrs []Xattr, nlink uint32) (io.WriteCloser, error) {
+	if nlink < 1 {
+		return nil, fmt.Errorf("%s: invalid number of links: %d", name, nlink)
+	}
+	if err := d.w.initCompressor(); err != nil {
 		return nil, err
 	}
+	d.w.startWorkers()
 
 	xattrRef := uint32(invalidXattr)
 	if len(xattrs) > 0 {
@@ -622,109 +799,238 @@ func (d *Directory) File(name string, modTime time.Time, mode uint16, xattrs []X
 		})
 	}
 	return &file{
-		w:          d.w,
-		d:          d,
-		off:        off,
-		name:       name,
-		modTime:    modTime,
-		mode:       mode,
-		compBuf:    bytes.NewBuffer(make([]byte, dataBlockSize)),
-		zlibWriter: zw,
-		xattrRef:   xattrRef,
+		w:        d.w,
+		d:        d,
+		name:     name,
+		modTime:  modTime,
+		mode:     mode,
+		xattrRef: xattrRef,
+		nlink:    nlink,
+		links:    1,
 	}, nil
 }
 
+// Link creates a hard link called name to target, which must be a file
+// returned by LinkedFile that was already closed.
+func (d *Directory) Link(name string, target io.WriteCloser) error {
+	f, ok := target.(*file)
+	if !ok || f.w != d.w {
+		return fmt.Errorf("%s: link target was not created by this Writer", name)
+	}
+	// Wait for the inode of target to be written:
+	if err := d.w.drain(); err != nil {
+		return err
+	}
+	if !f.inodeWritten {
+		return fmt.Errorf("%s: link target %s not yet closed", name, f.name)
+	}
+	if f.links >= f.nlink {
+		return fmt.Errorf("%s: link target %s already has %d links", name, f.name, f.nlink)
+	}
+	f.links++
+	d.dirEntries = append(d.dirEntries, fullDirEntry{
+		startBlock:  f.startBlock,
+		offset:      f.offset,
+		inodeNumber: f.inodeNumber,
+		entryType:   fileType,
+		name:        name,
+	})
+	return nil
+}
+
 // Symlink creates a symbolic link from newname to oldname with the specified
 // modTime and mode.
 func (d *Directory) Symlink(oldname, newname string, modTime time.Time, mode os.FileMode) error {
-	startBlock := d.w.inodeBuf.Len() / metadataBlockSize
-	offset := d.w.inodeBuf.Len() - startBlock*metadataBlockSize
+	if err := d.w.drain(); err != nil {
+		return err
+	}
+	startBlock, offset := d.w.inodes.position()
+	inodeNumber := d.w.newInodeNumber()
 
-	if err := binary.Write(&d.w.inodeBuf, binary.LittleEndian, symlinkInodeHeader{
+	if err := binary.Write(&d.w.inodes, binary.LittleEndian, symlinkInodeHeader{
 		inodeHeader: inodeHeader{
 			InodeType:   symlinkType,
 			Mode:        uint16(mode),
 			Uid:         0,
 			Gid:         0,
 			Mtime:       int32(modTime.Unix()),
-			InodeNumber: d.w.sb.Inodes + 1,
+			InodeNumber: inodeNumber,
 		},
 		Nlink:       1, // TODO(later): when is this not 1?
 		SymlinkSize: uint32(len(oldname)),
 	}); err != nil {
 		return err
 	}
-	if _, err := d.w.inodeBuf.Write([]byte(oldname)); err != nil {
+	if _, err := d.w.inodes.Write([]byte(oldname)); err != nil {
 		return err
 	}
 
 	d.dirEntries = append(d.dirEntries, fullDirEntry{
-		startBlock:  uint32(startBlock),
-		offset:      uint16(offset),
-		inodeNumber: d.w.sb.Inodes + 1,
+		startBlock:  startBlock,
+		offset:      offset,
+		inodeNumber: inodeNumber,
 		entryType:   symlinkType,
 		name:        newname,
 	})
+	return nil
+}
+
+// encodeDev encodes a device number like the Linux kernel's new_encode_dev,
+// which is the format SquashFS uses.
+func encodeDev(major, minor uint32) uint32 {
+	return (minor & 0xff) | (major&0xfff)<<8 | (minor&^0xff)<<12
+}
+
+// decodeDev is the inverse of encodeDev.
+func decodeDev(rdev uint32) (major, minor uint32) {
+	return (rdev & 0xfff00) >> 8, (rdev & 0xff) | (rdev>>12)&0xfff00
+}
 
-	d.w.sb.Inodes++
+// squashfsMode converts the permission bits of mode into a SquashFS (i.e. unix)
+// mode.
+func squashfsMode(mode os.FileMode) uint16 {
+	result := uint16(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		result |= unix.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		result |= unix.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		result |= unix.S_ISVTX
+	}
+	return result
+}
+
+// Device creates a block device with the specified name, modTime, mode and
+// device number. If mode contains os.ModeCharDevice, a character device is
+// created instead.
+func (d *Directory) Device(name string, modTime time.Time, mode os.FileMode, major, minor uint32) error {
+	inodeType := uint16(blkdevType)
+	if mode&os.ModeCharDevice != 0 {
+		inodeType = chrdevType
+	}
+	return d.special(name, inodeType, modTime, mode, encodeDev(major, minor))
+}
+
+// Fifo creates a named pipe with the specified name, modTime and mode.
+func (d *Directory) Fifo(name string, modTime time.Time, mode os.FileMode) error {
+	return d.special(name, fifoType, modTime, mode, 0)
+}
+
+// Socket creates a unix domain socket with the specified name, modTime and
+// mode.
+func (d *Directory) Socket(name string, modTime time.Time, mode os.FileMode) error {
+	return d.special(name, socketType, modTime, mode, 0)
+}
+
+// special creates a device, FIFO or socket inode and its directory entry.
+func (d *Directory) special(name string, inodeType uint16, modTime time.Time, mode os.FileMode, rdev uint32) error {
+	if err := d.w.drain(); err != nil {
+		return err
+	}
+	startBlock, offset := d.w.inodes.position()
+	inodeNumber := d.w.newInodeNumber()
+
+	hdr := inodeHeader{
+		InodeType:   inodeType,
+		Mode:        squashfsMode(mode),
+		Uid:         0,
+		Gid:         0,
+		Mtime:       int32(modTime.Unix()),
+		InodeNumber: inodeNumber,
+	}
+	var inode interface{}
+	switch inodeType {
+	case blkdevType, chrdevType:
+		inode = devInodeHeader{
+			inodeHeader: hdr,
+			Nlink:       1,
+			Rdev:        rdev,
+		}
+	default:
+		inode = ipcInodeHeader{
+			inodeHeader: hdr,
+			Nlink:       1,
+		}
+	}
+	if err := binary.Write(&d.w.inodes, binary.LittleEndian, inode); err != nil {
+		return err
+	}
+
+	d.dirEntries = append(d.dirEntries, fullDirEntry{
+		startBlock:  startBlock,
+		offset:      offset,
+		inodeNumber: inodeNumber,
+		entryType:   inodeType,
+		name:        name,
+	})
 	return nil
 }
 
 // Flush writes directory entries and creates inodes for the directory.
 func (d *Directory) Flush() error {
-	countByStartBlock := make(map[uint32]uint32)
-	for _, de := range d.dirEntries {
-		countByStartBlock[de.startBlock]++
+	// Write the inodes of all files in this directory first:
+	if err := d.w.drain(); err != nil {
+		return err
 	}
+	dirStartBlock, dirOffset := d.w.dirs.position()
+	dirStart := d.w.dirs.written
 
-	dirBufStartBlock := d.w.dirBuf.Len() / metadataBlockSize
-	dirBufOffset := d.w.dirBuf.Len()
-
-	currentBlock := int64(-1)
-	currentInodeOffset := int64(-1)
 	var subdirs int
 	for _, de := range d.dirEntries {
 		if de.entryType == dirType {
 			subdirs++
 		}
-		if int64(de.startBlock) != currentBlock {
-			dh := dirHeader{
-				Count:       countByStartBlock[de.startBlock] - 1,
-				StartBlock:  de.startBlock * (metadataBlockSize + 2),
-				InodeOffset: de.inodeNumber,
-			}
-			if err := binary.Write(&d.w.dirBuf, binary.LittleEndian, &dh); err != nil {
-				return err
+	}
+	// Entries are grouped under a directory header as long as their inodes are
+	// stored in the same metadata block, up to 256 entries per header, and
+	// their inode numbers can be expressed relative to the header.
+	for i := 0; i < len(d.dirEntries); {
+		first := d.dirEntries[i]
+		j := i + 1
+		for ; j < len(d.dirEntries) && j-i < 256; j++ {
+			de := d.dirEntries[j]
+			diff := int64(de.inodeNumber) - int64(first.inodeNumber)
+			if de.startBlock != first.startBlock ||
+				diff < math.MinInt16 || diff > math.MaxInt16 {
+				break
 			}
-
-			currentBlock = int64(de.startBlock)
-			currentInodeOffset = int64(de.inodeNumber)
 		}
-		if err := binary.Write(&d.w.dirBuf, binary.LittleEndian, &dirEntry{
-			Offset:      de.offset,
-			InodeNumber: int16(de.inodeNumber - uint32(currentInodeOffset)),
-			EntryType:   de.entryType,
-			Size:        uint16(len(de.name) - 1),
+		if err := binary.Write(&d.w.dirs, binary.LittleEndian, &dirHeader{
+			Count:       uint32(j-i) - 1,
+			StartBlock:  first.startBlock,
+			InodeOffset: first.inodeNumber,
 		}); err != nil {
 			return err
 		}
-		if _, err := d.w.dirBuf.Write([]byte(de.name)); err != nil {
-			return err
+		for _, de := range d.dirEntries[i:j] {
+			if err := binary.Write(&d.w.dirs, binary.LittleEndian, &dirEntry{
+				Offset:      de.offset,
+				InodeNumber: int16(int64(de.inodeNumber) - int64(first.inodeNumber)),
+				EntryType:   de.entryType,
+				Size:        uint16(len(de.name) - 1),
+			}); err != nil {
+				return err
+			}
+			if _, err := d.w.dirs.Write([]byte(de.name)); err != nil {
+				return err
+			}
 		}
+		i = j
 	}
+	listingSize := d.w.dirs.written - dirStart
 
-	startBlock := d.w.inodeBuf.Len() / metadataBlockSize
-	offset := d.w.inodeBuf.Len() - startBlock*metadataBlockSize
-	inodeBufOffset := d.w.inodeBuf.Len()
+	startBlock, offset := d.w.inodes.position()
 
-	// parentInodeOffset is the offset (in bytes) of the ParentInode field
-	// within a dirInodeHeader or ldirInodeHeader
-	var parentInodeOffset int64
+	parentInode := d.w.sb.Inodes + 1 // root: points past the last inode, like mksquashfs
+	if d.parent != nil {
+		parentInode = d.parent.inodeNumber
+	}
 
 	if len(d.dirEntries) > 256 ||
-		d.w.dirBuf.Len()-dirBufOffset > metadataBlockSize {
-		parentInodeOffset = (2 + 2 + 2 + 2 + 4 + 4) + 4 + 4 + 4
-		if err := binary.Write(&d.w.inodeBuf, binary.LittleEndian, ldirInodeHeader{
+		listingSize > metadataBlockSize {
+		if err := binary.Write(&d.w.inodes, binary.LittleEndian, ldirInodeHeader{
 			inodeHeader: inodeHeader{
 				InodeType: ldirType,
 				Mode: unix.S_IRUSR | unix.S_IWUSR | unix.S_IXUSR |
@@ -733,22 +1039,21 @@ func (d *Directory) Flush() error {
 				Uid:         0,
 				Gid:         0,
 				Mtime:       int32(d.modTime.Unix()),
-				InodeNumber: d.w.sb.Inodes + 1,
+				InodeNumber: d.inodeNumber,
 			},
 
 			Nlink:       uint32(subdirs + 2 - 1), // + 2 for . and ..
-			FileSize:    uint32(d.w.dirBuf.Len()-dirBufOffset) + 3,
-			StartBlock:  uint32(dirBufStartBlock * (metadataBlockSize + 2)),
-			ParentInode: d.w.sb.Inodes + 2, // invalid
-			Icount:      0,                 // no directory index
-			Offset:      uint16(dirBufOffset - dirBufStartBlock*metadataBlockSize),
+			FileSize:    uint32(listingSize) + 3,
+			StartBlock:  dirStartBlock,
+			ParentInode: parentInode,
+			Icount:      0, // no directory index
+			Offset:      dirOffset,
 			Xattr:       invalidXattr,
 		}); err != nil {
 			return err
 		}
 	} else {
-		parentInodeOffset = (2 + 2 + 2 + 2 + 4 + 4) + 4 + 4 + 2 + 2
-		if err := binary.Write(&d.w.inodeBuf, binary.LittleEndian, dirInodeHeader{
+		if err := binary.Write(&d.w.inodes, binary.LittleEndian, dirInodeHeader{
 			inodeHeader: inodeHeader{
 				InodeType: dirType,
 				Mode: unix.S_IRUSR | unix.S_IWUSR | unix.S_IXUSR |
@@ -757,45 +1062,30 @@ func (d *Directory) Flush() error {
 				Uid:         0,
 				Gid:         0,
 				Mtime:       int32(d.modTime.Unix()),
-				InodeNumber: d.w.sb.Inodes + 1,
+				InodeNumber: d.inodeNumber,
 			},
-			StartBlock:  uint32(dirBufStartBlock * (metadataBlockSize + 2)),
+			StartBlock:  dirStartBlock,
 			Nlink:       uint32(subdirs + 2 - 1), // + 2 for . and ..
-			FileSize:    uint16(d.w.dirBuf.Len()-dirBufOffset) + 3,
-			Offset:      uint16(dirBufOffset - dirBufStartBlock*metadataBlockSize),
-			ParentInode: d.w.sb.Inodes + 2, // invalid
+			FileSize:    uint16(listingSize) + 3,
+			Offset:      dirOffset,
+			ParentInode: parentInode,
 		}); err != nil {
 			return err
 		}
 	}
 
-	path := d.path()
-	for _, offset := range d.w.writeInodeNumTo[path] {
-		// Directly manipulating unread data in bytes.Buffer via Bytes(), as per
-		// https://groups.google.com/d/msg/golang-nuts/1ON9XVQ1jXE/8j9RaeSYxuEJ
-		b := d.w.inodeBuf.Bytes()
-		binary.LittleEndian.PutUint32(b[offset:offset+4], d.w.sb.Inodes+1)
-	}
-
 	if d.parent != nil {
-		parentPath := filepath.Dir(d.path())
-		if parentPath == "." {
-			parentPath = ""
-		}
-		d.w.writeInodeNumTo[parentPath] = append(d.w.writeInodeNumTo[parentPath], int64(inodeBufOffset)+parentInodeOffset)
 		d.parent.dirEntries = append(d.parent.dirEntries, fullDirEntry{
-			startBlock:  uint32(startBlock),
-			offset:      uint16(offset),
-			inodeNumber: d.w.sb.Inodes + 1,
+			startBlock:  startBlock,
+			offset:      offset,
+			inodeNumber: d.inodeNumber,
 			entryType:   dirType,
 			name:        d.name,
 		})
 	} else { // root
-		d.w.sb.RootInode = Inode((startBlock*(metadataBlockSize+2))<<16 | offset)
+		d.w.sb.RootInode = Inode(int64(startBlock)<<16 | int64(offset))
 	}
 
-	d.w.sb.Inodes++
-
 	return nil
 }
 
@@ -819,95 +1109,138 @@ func (f *file) writeBlock() error {
 	if n > dataBlockSize {
 		n = dataBlockSize
 	}
-	// Feed dataBlockSize bytes to the compressor
-	b := f.buf.Bytes()
-	block := b[:n]
-	rest := b[n:]
-	/*
-		f.compBuf.Reset()
-		f.zlibWriter.Reset(f.compBuf)
-		if _, err := f.zlibWriter.Write(block); err != nil {
-			return err
-		}
-		if err := f.zlibWriter.Close(); err != nil {
-			return err
+	// Copy dataBlockSize bytes for the encoder, keep the rest in f.buf for the
+	// next write.
+	block := append(f.w.getBuf(), f.buf.Next(n)...)
+	if f.buf.Len() == 0 {
+		f.buf.Reset()
+	}
+	return f.w.submit(block, func(pb *pendingBlock, off int64) error {
+		if len(f.blocksizes) == 0 {
+			f.off = off
 		}
+		f.blocksizes = append(f.blocksizes, pb.size)
+		f.sums = append(f.sums, pb.sum)
+		return nil
+	})
+}
 
-		size := f.compBuf.Len()
-		if size > len(block) {
-			// Copy uncompressed data: Linux returns i/o errors when it encounters a
-			// compressed block which is larger than the uncompressed data:
-			// https://github.com/torvalds/linux/blob/3ca24ce9ff764bc27bceb9b2fd8ece74846c3fd3/fs/squashfs/block.c#L150
-			size = len(block) | (1 << 24) // SQUASHFS_COMPRESSED_BIT_BLOCK
-			if _, err := f.w.w.Write(block); err != nil {
-				return err
-			}
+// Close implements io.Closer. The inode is written once all blocks of the file
+// were written, so errors might be returned by subsequent calls of the Writer
+// instead.
+func (f *file) Close() error {
+	// Write only wrote full blocks, the tail end goes into a fragment:
+	fragment, fragmentOffset := uint32(invalidFragment), uint32(0)
+	if tail := f.buf.Bytes(); len(tail) > 0 {
+		if f.w.Deduplicate {
+			f.tailSum = sha256.Sum256(tail)
+		}
+		if loc, ok := f.w.dupTails[f.tailSum]; ok && f.w.Deduplicate {
+			fragment, fragmentOffset = loc.fragment, loc.offset
 		} else {
-			if _, err := io.Copy(f.w.w, f.compBuf); err != nil {
+			var err error
+			fragment, fragmentOffset, err = f.w.addFragment(tail)
+			if err != nil {
 				return err
 			}
+			if f.w.Deduplicate {
+				if f.w.dupTails == nil {
+					f.w.dupTails = make(map[[sha256.Size]byte]fragmentLocation)
+				}
+				f.w.dupTails[f.tailSum] = fragmentLocation{fragment, fragmentOffset}
+			}
 		}
-	*/
-	// Copy uncompressed data: Linux returns i/o errors when it encounters a
-	// compressed block which is larger than the uncompressed data:
-	// https://github.com/torvalds/linux/blob/3ca24ce9ff764bc27bceb9b2fd8ece74846c3fd3/fs/squashfs/block.c#L150
-	size := len(block) | (1 << 24) // SQUASHFS_COMPRESSED_BIT_BLOCK
-	if _, err := f.w.w.Write(block); err != nil {
-		return err
+		f.buf.Reset()
 	}
 
-	f.blocksizes = append(f.blocksizes, uint32(size))
+	// Allocate the inode number right away: inode numbers must not depend on
+	// when the inode is written.
+	inodeNumber := f.w.newInodeNumber()
+	return f.w.submit(nil, func(_ *pendingBlock, dataOff int64) error {
+		if len(f.blocksizes) == 0 {
+			f.off = dataOff
+		} else if f.w.Deduplicate {
+			if err := f.w.deduplicate(f); err != nil {
+				return err
+			}
+		}
+		startBlock, offset := f.w.inodes.position()
 
-	// Keep the rest in f.buf for the next write
-	copy(b, rest)
-	f.buf.Truncate(len(rest))
-	return nil
-}
+		if err := binary.Write(&f.w.inodes, binary.LittleEndian, lregInodeHeader{
+			inodeHeader: inodeHeader{
+				InodeType:   lregType,
+				Mode:        f.mode,
+				Uid:         0,
+				Gid:         0,
+				Mtime:       int32(f.modTime.Unix()),
+				InodeNumber: inodeNumber,
+			},
+			StartBlock: uint64(f.off),
+			FileSize:   uint64(f.size),
+			Nlink:      f.nlink,
+			Fragment:   fragment,
+			Offset:     fragmentOffset,
+			Xattr:      f.xattrRef,
+		}); err != nil {
+			return err
+		}
 
-// Close implements io.Closer
-func (f *file) Close() error {
-	for f.buf.Len() > 0 {
-		if err := f.writeBlock(); err != nil {
+		if err := binary.Write(&f.w.inodes, binary.LittleEndian, f.blocksizes); err != nil {
 			return err
 		}
-	}
 
-	startBlock := f.w.inodeBuf.Len() / metadataBlockSize
-	offset := f.w.inodeBuf.Len() - startBlock*metadataBlockSize
+		f.d.dirEntries = append(f.d.dirEntries, fullDirEntry{
+			startBlock:  startBlock,
+			offset:      offset,
+			inodeNumber: inodeNumber,
+			entryType:   fileType,
+			name:        f.name,
+		})
+		f.inodeWritten = true
+		f.startBlock = startBlock
+		f.offset = offset
+		f.inodeNumber = inodeNumber
+		return nil
+	})
+}
 
-	if err := binary.Write(&f.w.inodeBuf, binary.LittleEndian, lregInodeHeader{
-		inodeHeader: inodeHeader{
-			InodeType:   lregType,
-			Mode:        f.mode,
-			Uid:         0,
-			Gid:         0,
-			Mtime:       int32(f.modTime.Unix()),
-			InodeNumber: f.w.sb.Inodes + 1,
-		},
-		StartBlock: uint64(f.off),
-		FileSize:   uint64(f.size),
-		Nlink:      1,
-		Fragment:   invalidFragment,
-		Offset:     0,
-		Xattr:      f.xattrRef,
-	}); err != nil {
-		return err
+// deduplicate points f to the data blocks of an earlier file with identical
+// contents, if any, and reclaims the space of its own data blocks. It is called
+// right after the last data block of f was written.
+func (w *Writer) deduplicate(f *file) error {
+	h := sha256.New()
+	for _, sum := range f.sums {
+		h.Write(sum[:])
 	}
-
-	if err := binary.Write(&f.w.inodeBuf, binary.LittleEndian, f.blocksizes); err != nil {
+	h.Write(f.tailSum[:])
+	binary.Write(h, binary.LittleEndian, f.size)
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+
+	prev, ok := w.dupFiles[sum]
+	if !ok {
+		if w.dupFiles == nil {
+			w.dupFiles = make(map[[sha256.Size]byte]int64)
+		}
+		w.dupFiles[sum] = f.off
+		return nil
+	}
+	end := f.off
+	for _, size := range f.blocksizes {
+		end += int64(size &^ dataBlockUncompressed)
+	}
+	if end != w.dataOff {
+		// Another block (e.g. a fragment block) was written after the data
+		// blocks of f, so their space cannot be reclaimed.
+		return nil
+	}
+	// Identical contents result in identical block sizes, so only the start
+	// needs to be changed.
+	if _, err := w.w.Seek(f.off, io.SeekStart); err != nil {
 		return err
 	}
-
-	f.d.dirEntries = append(f.d.dirEntries, fullDirEntry{
-		startBlock:  uint32(startBlock),
-		offset:      uint16(offset),
-		inodeNumber: f.w.sb.Inodes + 1,
-		entryType:   fileType,
-		name:        f.name,
-	})
-
-	f.w.sb.Inodes++
-
+	w.dataOff = f.off
+	f.off = prev
 	return nil
 }
 
@@ -1035,14 +1368,144 @@ func (w *Writer) writeMetadataChunks(r io.Reader) error {
 	}
 }
 
+// metadataWriter accumulates a metadata table (e.g. the inode table),
+// compressing each block of metadataBlockSize bytes as soon as it is full, so
+// that the on-disk location of every entry is known when it is written.
+type metadataWriter struct {
+	w *Writer
+
+	// buf holds the on-disk (possibly compressed) blocks, each prefixed with
+	// a uint16 length header.
+	buf bytes.Buffer
+
+	// offsets holds the offset of each block in buf.
+	offsets []int64
+
+	// block holds the current, not yet full block.
+	block []byte
+
+	// written is the total number of (uncompressed) bytes written.
+	written int
+
+	compBuf []byte
+}
+
+// position returns the location of the next byte written: the offset of its
+// metadata block relative to the table start and the offset within the
+// (uncompressed) metadata block.
+func (m *metadataWriter) position() (block uint32, offset uint16) {
+	return uint32(m.buf.Len()), uint16(len(m.block))
+}
+
+// Write implements io.Writer.
+func (m *metadataWriter) Write(p []byte) (n int, err error) {
+	if m.block == nil {
+		m.block = make([]byte, 0, metadataBlockSize)
+	}
+	for len(p) > 0 {
+		nn := copy(m.block[len(m.block):cap(m.block)], p)
+		m.block = m.block[:len(m.block)+nn]
+		p = p[nn:]
+		n += nn
+		m.written += nn
+		if len(m.block) == metadataBlockSize {
+			if err := m.flushBlock(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flushBlock appends the current block to buf.
+func (m *metadataWriter) flushBlock() error {
+	if len(m.block) == 0 {
+		return nil
+	}
+	if err := m.w.initCompressor(); err != nil {
+		return err
+	}
+	data := m.block
+	header := uint16(len(m.block)) | 0x8000 // uncompressed
+	if m.w.compressor != nil {
+		compressed, err := m.w.compressor.compress(m.compBuf[:0], m.block)
+		if err != nil {
+			return err
+		}
+		m.compBuf = compressed
+		if len(compressed) < len(m.block) {
+			data = compressed
+			header = uint16(len(compressed))
+		}
+	}
+	m.offsets = append(m.offsets, int64(m.buf.Len()))
+	if err := binary.Write(&m.buf, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	m.buf.Write(data)
+	m.block = m.block[:0]
+	return nil
+}
+
+// writeTo flushes the current block and copies the table to w.
+func (m *metadataWriter) writeTo(w io.Writer) error {
+	if err := m.flushBlock(); err != nil {
+		return err
+	}
+	_, err := m.buf.WriteTo(w)
+	return err
+}
+
+// writeFragmentTable writes the fragment table and returns the offset of its
+// index, which the superblock refers to.
+func (w *Writer) writeFragmentTable() (int64, error) {
+	start, err := w.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	table := metadataWriter{w: w}
+	if err := binary.Write(&table, binary.LittleEndian, w.fragments); err != nil {
+		return 0, err
+	}
+	if err := table.writeTo(w.w); err != nil {
+		return 0, err
+	}
+	off, err := w.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	// The index lists the absolute location of each metadata block.
+	for _, blockOffset := range table.offsets {
+		if err := binary.Write(w.w, binary.LittleEndian, start+blockOffset); err != nil {
+			return 0, err
+		}
+	}
+	return off, nil
+}
+
 // Flush writes the SquashFS file system. The Writer must not be used after
 // calling Flush.
 func (w *Writer) Flush() error {
 	// (1) superblock will be written later
+	w.sb.Compression = w.Compression
+	if w.Compression == Uncompressed {
+		// Data blocks are flagged as uncompressed individually, but the
+		// superblock still needs to name a compressor.
+		w.sb.Compression = zlibCompression
+	}
+	w.sb.Flags = filesystemFlags(w.Compression, w.Deduplicate)
 
 	// (2) compressor-specific options omitted
 
-	// (3) data has already been written
+	// (3) data has already been written, except for the last fragment block
+	// and pending blocks
+	if err := w.writeFragmentBlock(); err != nil {
+		return err
+	}
+	if err := w.drain(); err != nil {
+		return err
+	}
+	w.sb.Fragments = uint32(len(w.fragments))
 
 	// (4) write inode table
 	off, err := w.w.Seek(0, io.SeekCurrent)
@@ -1051,7 +1514,7 @@ func (w *Writer) Flush() error {
 	}
 	w.sb.InodeTableStart = off
 
-	if err := w.writeMetadataChunks(&w.inodeBuf); err != nil {
+	if err := w.inodes.writeTo(w.w); err != nil {
 		return err
 	}
 
@@ -1062,12 +1525,12 @@ func (w *Writer) Flush() error {
 	}
 	w.sb.DirectoryTableStart = off
 
-	if err := w.writeMetadataChunks(&w.dirBuf); err != nil {
+	if err := w.dirs.writeTo(w.w); err != nil {
 		return err
 	}
 
-	// (6) fragment table omitted
-	off, err = w.w.Seek(0, io.SeekCurrent)
+	// (6) write fragment table
+	off, err = w.writeFragmentTable()
 	if err != nil {
 		return err
 	}
@@ -1103,6 +1566,18 @@ func (w *Writer) Flush() error {
 		}
 	}
 
+	// Deduplication rewinds the data area, so stale data blocks might be left
+	// past the end of the image.
+	end, err := w.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if t, ok := w.w.(interface{ Truncate(int64) error }); ok && w.end > end {
+		if err := t.Truncate(end); err != nil {
+			return err
+		}
+	}
+
 	// (1) Write superblock
 	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
 		return err
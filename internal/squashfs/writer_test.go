@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -22,11 +24,12 @@ import (
 
 var fsImagePath = flag.String("fs_image_path", "", "Store the SquashFS test file system in the specified path for manual inspection")
 
-func writeTestImage(iow io.WriteSeeker, xattr bool) error {
+func writeTestImage(iow io.WriteSeeker, xattr bool, compression Compression) error {
 	w, err := NewWriter(iow, time.Now())
 	if err != nil {
 		return err
 	}
+	w.Compression = compression
 
 	var xattrs []Xattr
 	if xattr {
@@ -162,7 +165,7 @@ func TestUnsquashfs(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			if err := writeTestImage(f, xattr); err != nil {
+			if err := writeTestImage(f, xattr, Uncompressed); err != nil {
 				t.Fatal(err)
 			}
 
@@ -223,11 +226,21 @@ func TestUnsquashfs(t *testing.T) {
 func TestReader(t *testing.T) {
 	t.Parallel()
 
-	for _, xattr := range []bool{false, true} {
-		t.Run(fmt.Sprintf("xattr %v", xattr), func(t *testing.T) {
+	for _, tt := range []struct {
+		xattr       bool
+		compression Compression
+	}{
+		{xattr: false, compression: Uncompressed},
+		{xattr: true, compression: Uncompressed},
+		{xattr: false, compression: Gzip},
+		{xattr: false, compression: XZ},
+		{xattr: true, compression: Zstd},
+	} {
+		xattr := tt.xattr
+		t.Run(fmt.Sprintf("xattr %v, compression %v", xattr, tt.compression), func(t *testing.T) {
 			var err error
 			buf := &writerseeker.WriterSeeker{}
-			if err := writeTestImage(buf, xattr); err != nil {
+			if err := writeTestImage(buf, xattr, tt.compression); err != nil {
 				t.Fatal(err)
 			}
 
@@ -308,3 +321,552 @@ func TestReader(t *testing.T) {
 		})
 	}
 }
+
+func TestCompression(t *testing.T) {
+	t.Parallel()
+
+	// Two blocks of compressible data, followed by one block of random data,
+	// which must be stored uncompressed, and a tail end (in a fragment).
+	random := make([]byte, dataBlockSize)
+	if _, err := rand.New(rand.NewSource(1)).Read(random); err != nil {
+		t.Fatal(err)
+	}
+	contents := append(bytes.Repeat([]byte("distri "), 2*dataBlockSize/7+1)[:2*dataBlockSize], random...)
+	contents = append(contents, "tail"...)
+
+	for _, compression := range []Compression{Uncompressed, Gzip, XZ, Zstd} {
+		compression := compression // copy
+		t.Run(compression.String(), func(t *testing.T) {
+			t.Parallel()
+			buf := &writerseeker.WriterSeeker{}
+			w, err := NewWriter(buf, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Compression = compression
+			ff, err := w.Root.File("data", time.Now(), unix.S_IRUSR|unix.S_IRGRP|unix.S_IROTH, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ff.Write(contents); err != nil {
+				t.Fatal(err)
+			}
+			if err := ff.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Root.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			rd, err := NewReader(buf.BytesReader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			inode, err := rd.LookupPath("data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fi, err := rd.fileInode(inode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(fi.blocks), 3; got != want {
+				t.Fatalf("unexpected number of blocks: got %d, want %d", got, want)
+			}
+			if fi.fragment == invalidFragment {
+				t.Fatalf("tail end unexpectedly not stored in a fragment")
+			}
+			for idx, b := range fi.blocks {
+				wantRaw := compression == Uncompressed || idx == 2 /* random */
+				if gotRaw := b&dataBlockUncompressed != 0; gotRaw != wantRaw {
+					t.Errorf("block %d (size %d): uncompressed = %v, want %v", idx, b&^dataBlockUncompressed, gotRaw, wantRaw)
+				}
+			}
+
+			in, err := rd.FileReader(inode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, contents) {
+				t.Fatalf("file contents differ")
+			}
+
+			// Read across a block boundary via ReadAt:
+			part := make([]byte, 10)
+			if _, err := in.ReadAt(part, 2*dataBlockSize-5); err != nil {
+				t.Fatal(err)
+			}
+			if want := contents[2*dataBlockSize-5 : 2*dataBlockSize+5]; !bytes.Equal(part, want) {
+				t.Fatalf("ReadAt: got %x, want %x", part, want)
+			}
+		})
+	}
+}
+
+func TestFragments(t *testing.T) {
+	t.Parallel()
+
+	// Write enough small files to span multiple fragment blocks and multiple
+	// (compressed) metadata blocks, spread across nested directories.
+	contents := func(dir, file int) []byte {
+		return bytes.Repeat([]byte(fmt.Sprintf("dir %d, file %d\n", dir, file)), 10*file+1)
+	}
+	const (
+		dirs        = 3
+		filesPerDir = 300
+	)
+	for _, compression := range []Compression{Uncompressed, Zstd} {
+		compression := compression // copy
+		t.Run(compression.String(), func(t *testing.T) {
+			t.Parallel()
+			buf := &writerseeker.WriterSeeker{}
+			w, err := NewWriter(buf, time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Compression = compression
+			outer := w.Root.Directory("outer", time.Now())
+			for dir := 0; dir < dirs; dir++ {
+				d := outer.Directory(fmt.Sprintf("dir%d", dir), time.Now())
+				for file := 0; file < filesPerDir; file++ {
+					ff, err := d.File(fmt.Sprintf("file%03d", file), time.Now(), unix.S_IRUSR|unix.S_IRGRP|unix.S_IROTH, nil)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, err := ff.Write(contents(dir, file)); err != nil {
+						t.Fatal(err)
+					}
+					if err := ff.Close(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := d.Flush(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := outer.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Root.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := w.sb.Fragments, uint32(2); got < want {
+				t.Errorf("unexpected number of fragment blocks: got %d, want at least %d", got, want)
+			}
+			t.Logf("image size: %d bytes", w.sb.BytesUsed)
+
+			rd, err := NewReader(buf.BytesReader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for dir := 0; dir < dirs; dir++ {
+				dirInode, err := rd.LookupPath(fmt.Sprintf("outer/dir%d", dir))
+				if err != nil {
+					t.Fatal(err)
+				}
+				fis, err := rd.Readdir(dirInode)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := len(fis), filesPerDir; got != want {
+					t.Fatalf("unexpected number of directory entries: got %d, want %d", got, want)
+				}
+				for file, fi := range fis {
+					want := contents(dir, file)
+					if got, want := fi.Size(), int64(len(want)); got != want {
+						t.Fatalf("%s: unexpected size: got %d, want %d", fi.Name(), got, want)
+					}
+					in, err := rd.FileReader(fi.Sys().(*FileInfo).Inode)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got, err := ioutil.ReadAll(in)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(got, want) {
+						t.Fatalf("%s: contents differ", fi.Name())
+					}
+				}
+			}
+		})
+	}
+}
+
+// writeLargeImage writes an image containing large (compressible) files as
+// well as small files and symlinks into w.
+func writeLargeImage(iow io.WriteSeeker, compression Compression, workers int) (int64, error) {
+	mtime := time.Unix(1573049183, 0)
+	w, err := NewWriter(iow, mtime)
+	if err != nil {
+		return 0, err
+	}
+	w.Compression = compression
+	w.Workers = workers
+
+	rnd := rand.New(rand.NewSource(42))
+	words := []string{"squashfs", "distri", "package", "block", "fragment", "inode"}
+	var total int64
+	for dir := 0; dir < 4; dir++ {
+		d := w.Root.Directory(fmt.Sprintf("dir%d", dir), mtime)
+		for file := 0; file < 8; file++ {
+			ff, err := d.File(fmt.Sprintf("file%d", file), mtime, unix.S_IRUSR|unix.S_IRGRP|unix.S_IROTH, nil)
+			if err != nil {
+				return 0, err
+			}
+			// Alternate between multi-block files and small files which end up
+			// in fragments only.
+			size := 1024 + rnd.Intn(4096)
+			if file%2 == 0 {
+				size = 3*dataBlockSize + rnd.Intn(dataBlockSize)
+			}
+			var buf bytes.Buffer
+			for buf.Len() < size {
+				buf.WriteString(words[rnd.Intn(len(words))])
+				fmt.Fprintf(&buf, " %d\n", rnd.Int63())
+			}
+			n, err := ff.Write(buf.Bytes()[:size])
+			if err != nil {
+				return 0, err
+			}
+			total += int64(n)
+			if err := ff.Close(); err != nil {
+				return 0, err
+			}
+			if file%4 == 0 {
+				if err := d.Symlink(fmt.Sprintf("file%d", file), fmt.Sprintf("file%d.link", file), mtime, 0777); err != nil {
+					return 0, err
+				}
+			}
+		}
+		if err := d.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	if err := w.Root.Flush(); err != nil {
+		return 0, err
+	}
+	return total, w.Flush()
+}
+
+func TestParallelIdentical(t *testing.T) {
+	t.Parallel()
+
+	for _, compression := range []Compression{Uncompressed, Gzip, XZ, Zstd} {
+		compression := compression // copy
+		t.Run(compression.String(), func(t *testing.T) {
+			t.Parallel()
+			serial := &writerseeker.WriterSeeker{}
+			if _, err := writeLargeImage(serial, compression, 1); err != nil {
+				t.Fatal(err)
+			}
+			parallel := &writerseeker.WriterSeeker{}
+			if _, err := writeLargeImage(parallel, compression, 8); err != nil {
+				t.Fatal(err)
+			}
+			want, err := ioutil.ReadAll(serial.BytesReader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadAll(parallel.BytesReader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("images differ: parallel encoding resulted in %d bytes, serial encoding in %d bytes", len(got), len(want))
+			}
+
+			rd, err := NewReader(parallel.BytesReader())
+			if err != nil {
+				t.Fatal(err)
+			}
+			inode, err := rd.LookupPath("dir3/file4.link")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fi, err := rd.Stat("file4", inode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, min := fi.Size(), int64(3*dataBlockSize); got < min {
+				t.Fatalf("dir3/file4: unexpected size: got %d, want at least %d", got, min)
+			}
+		})
+	}
+}
+
+// BenchmarkWriter compares serial encoding with encoding on all CPUs.
+func BenchmarkWriter(b *testing.B) {
+	// Compare with -cpu 4 (or more) to see the speed-up of encoding blocks
+	// concurrently:
+	workerCounts := []int{1, 4}
+	if n := runtime.GOMAXPROCS(0); n > 4 {
+		workerCounts = append(workerCounts, n)
+	}
+	for _, compression := range []Compression{Gzip, Zstd} {
+		for _, workers := range workerCounts {
+			b.Run(fmt.Sprintf("%v/workers=%d", compression, workers), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					total, err := writeLargeImage(&writerseeker.WriterSeeker{}, compression, workers)
+					if err != nil {
+						b.Fatal(err)
+					}
+					b.SetBytes(total)
+				}
+			})
+		}
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	t.Parallel()
+
+	large := bytes.Repeat([]byte("duplicate license text\n"), 3*dataBlockSize/10)
+	small := []byte("small duplicate\n")
+	files := []struct {
+		name     string
+		contents []byte
+	}{
+		{"a-large", large},
+		{"b-small", small},
+		{"c-large-copy", large},
+		{"d-small-copy", small},
+		{"e-unique", append([]byte("unique"), large...)},
+	}
+	write := func(dedup bool) (*writerseeker.WriterSeeker, *Writer) {
+		buf := &writerseeker.WriterSeeker{}
+		w, err := NewWriter(buf, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Compression = Zstd
+		w.Deduplicate = dedup
+		for _, f := range files {
+			ff, err := w.Root.File(f.name, time.Now(), unix.S_IRUSR|unix.S_IRGRP|unix.S_IROTH, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ff.Write(f.contents); err != nil {
+				t.Fatal(err)
+			}
+			if err := ff.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Root.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		return buf, w
+	}
+
+	_, plain := write(false)
+	buf, w := write(true)
+	if got, want := w.sb.BytesUsed, plain.sb.BytesUsed; got >= want {
+		t.Errorf("deduplication did not save space: got %d bytes, want < %d bytes", got, want)
+	}
+	if w.sb.Flags&(1<<6) == 0 {
+		t.Errorf("duplicateChecking flag not set: flags = %#x", w.sb.Flags)
+	}
+
+	rd, err := NewReader(buf.BytesReader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	starts := make(map[string]int64)
+	for _, f := range files {
+		inode, err := rd.LookupPath(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := rd.fileInode(inode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		starts[f.name] = fi.start
+		r, err := rd.FileReader(inode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, f.contents) {
+			t.Fatalf("%s: contents differ", f.name)
+		}
+	}
+	if starts["a-large"] != starts["c-large-copy"] {
+		t.Errorf("duplicate files do not share data blocks: a-large starts at %d, c-large-copy at %d", starts["a-large"], starts["c-large-copy"])
+	}
+	if starts["a-large"] == starts["e-unique"] {
+		t.Errorf("unique file unexpectedly shares data blocks")
+	}
+}
+
+func TestHardlinks(t *testing.T) {
+	t.Parallel()
+
+	buf := &writerseeker.WriterSeeker{}
+	w, err := NewWriter(buf, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := w.Root.Directory("bin", time.Now())
+	ff, err := bin.LinkedFile("gzip", time.Now(), unix.S_IRUSR|unix.S_IXUSR, nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ff.Write([]byte("#!/bin/sh\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bin.Link("gunzip", ff); err == nil {
+		t.Fatalf("Link unexpectedly succeeded before closing the target")
+	}
+	if err := ff.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bin.Link("gzip-link", ff); err != nil {
+		t.Fatal(err)
+	}
+	if err := bin.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// Hard links can span directories:
+	sbin := w.Root.Directory("sbin", time.Now())
+	if err := sbin.Link("gunzip", ff); err != nil {
+		t.Fatal(err)
+	}
+	if err := sbin.Link("zcat", ff); err == nil {
+		t.Fatalf("Link unexpectedly succeeded for more than nlink links")
+	}
+	if err := sbin.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Root.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := NewReader(buf.BytesReader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inodes []uint32
+	for _, path := range []string{"bin/gzip", "bin/gzip-link", "sbin/gunzip"} {
+		inode, err := rd.LookupPath(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := rd.Stat(filepath.Base(path), inode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ffi := fi.Sys().(*FileInfo)
+		if got, want := ffi.Nlink(), uint32(3); got != want {
+			t.Errorf("%s: unexpected Nlink: got %d, want %d", path, got, want)
+		}
+		inodes = append(inodes, ffi.InodeNumber)
+	}
+	if inodes[0] != inodes[1] || inodes[0] != inodes[2] {
+		t.Errorf("hard links do not share the inode: inode numbers %v", inodes)
+	}
+}
+
+func TestSpecialFiles(t *testing.T) {
+	t.Parallel()
+
+	buf := &writerseeker.WriterSeeker{}
+	w, err := NewWriter(buf, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev := w.Root.Directory("dev", time.Now())
+	if err := dev.Socket("log", time.Now(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Device("null", time.Now(), os.ModeDevice|os.ModeCharDevice|0666, 1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Device("nvme0n1p300", time.Now(), os.ModeDevice|0660, 259, 300); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Fifo("pipe", time.Now(), 0600|os.ModeSticky); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Root.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := NewReader(buf.BytesReader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name         string
+		mode         os.FileMode
+		major, minor uint32
+	}{
+		{"log", os.ModeSocket | 0666, 0, 0},
+		{"null", os.ModeDevice | os.ModeCharDevice | 0666, 1, 3},
+		{"nvme0n1p300", os.ModeDevice | 0660, 259, 300},
+		{"pipe", os.ModeNamedPipe | os.ModeSticky | 0600, 0, 0},
+	} {
+		inode, err := rd.LookupPath("dev/" + tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := rd.Stat(tt.name, inode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := fi.Mode(), tt.mode; got != want {
+			t.Errorf("%s: unexpected mode: got %v, want %v", tt.name, got, want)
+		}
+		sfi := fi.Sys().(*FileInfo)
+		if got, want := sfi.Major(), tt.major; got != want {
+			t.Errorf("%s: unexpected major: got %d, want %d", tt.name, got, want)
+		}
+		if got, want := sfi.Minor(), tt.minor; got != want {
+			t.Errorf("%s: unexpected minor: got %d, want %d", tt.name, got, want)
+		}
+	}
+
+	// Readdir without stat must report the file types, too:
+	dirInode, err := rd.LookupPath("dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fis, err := rd.ReaddirNoStat(dirInode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []os.FileMode
+	for _, fi := range fis {
+		types = append(types, fi.Mode()&os.ModeType)
+	}
+	want := []os.FileMode{os.ModeSocket, os.ModeDevice | os.ModeCharDevice, os.ModeDevice, os.ModeNamedPipe}
+	if diff := cmp.Diff(want, types); diff != "" {
+		t.Errorf("ReaddirNoStat: unexpected file types: diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,183 @@
+// Package storelock implements an exclusive lock on a package store (e.g.
+// /roimg), ensuring that only one process modifies it at a time.
+package storelock
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Name is the file name of the lock file within the package store.
+const Name = ".lock"
+
+// ErrLocked is returned by Acquire when another process holds the lock.
+type ErrLocked struct {
+	Store string
+	PID   int // 0 if unknown
+}
+
+func (e *ErrLocked) Error() string {
+	holder := "another process"
+	if e.PID != 0 {
+		holder = fmt.Sprintf("pid %d", e.PID)
+	}
+	return fmt.Sprintf("package store %s is locked by %s (use -wait to wait for it)", e.Store, holder)
+}
+
+// Lock is a held package store lock.
+type Lock struct {
+	f   *os.File
+	dir bool // f is the locked directory itself, see AcquireDir
+}
+
+func holder(fn string) int {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// Acquire locks the package store directory store, creating it if needed. If
+// wait is false and another process holds the lock, an *ErrLocked is returned.
+// Otherwise, Acquire blocks until the lock becomes available.
+func Acquire(store string, wait bool) (*Lock, error) {
+	if err := os.MkdirAll(store, 0755); err != nil {
+		return nil, err
+	}
+	fn := filepath.Join(store, Name)
+	f, err := os.OpenFile(fn, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		if err != unix.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("flock(%s): %v", fn, err)
+		}
+		locked := &ErrLocked{Store: store, PID: holder(fn)}
+		if !wait {
+			f.Close()
+			return nil, locked
+		}
+		log.Printf("%v, waiting", locked)
+		if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("flock(%s): %v", fn, err)
+		}
+	}
+	// Record our pid so that other processes can tell who holds the lock:
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// AcquireDir is like Acquire, but locks the directory dir itself instead of
+// creating a lock file within it. Use AcquireDir for directories which are
+// published as a whole (e.g. repositories served by distri export), where a
+// lock file would end up in the published copy. The pid of the lock holder is
+// not recorded.
+func AcquireDir(dir string, wait bool) (*Lock, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		if err != unix.EWOULDBLOCK {
+			f.Close()
+			return nil, fmt.Errorf("flock(%s): %v", dir, err)
+		}
+		locked := &ErrLocked{Store: dir}
+		if !wait {
+			f.Close()
+			return nil, locked
+		}
+		log.Printf("%v, waiting", locked)
+		if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("flock(%s): %v", dir, err)
+		}
+	}
+	return &Lock{f: f, dir: true}, nil
+}
+
+// FDEnv is the environment variable which holds the file descriptor number of
+// a lock passed to a child process, see Lock.File and Inherit.
+const FDEnv = "DISTRI_STORE_LOCK_FD"
+
+// File returns the lock file. Passing it to a child process (e.g. via
+// exec.Cmd.ExtraFiles) hands over the lock without releasing it in between:
+// the flock is held until all file descriptors referring to it are closed.
+func (l *Lock) File() *os.File { return l.f }
+
+// Inherit takes over the lock of package store directory store which the
+// parent process passed as file descriptor fd (see Lock.File).
+func Inherit(store string, fd uintptr) (*Lock, error) {
+	fn := filepath.Join(store, Name)
+	f := os.NewFile(fd, fn)
+	if f == nil {
+		return nil, fmt.Errorf("invalid lock file descriptor %d", fd)
+	}
+	// Our child processes must not keep the lock after we exit:
+	unix.CloseOnExec(int(fd))
+	fst, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	st, err := os.Stat(fn)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !os.SameFile(fst, st) {
+		f.Close()
+		return nil, fmt.Errorf("file descriptor %d does not refer to %s", fd, fn)
+	}
+	// Succeeds without blocking if the lock is held via fd (i.e. by our
+	// parent process):
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		f.Close()
+		if err == unix.EWOULDBLOCK {
+			return nil, &ErrLocked{Store: store, PID: holder(fn)}
+		}
+		return nil, fmt.Errorf("flock(%s): %v", fn, err)
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{f: f}, nil
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	if !l.dir {
+		if err := l.f.Truncate(0); err != nil {
+			return err
+		}
+	}
+	// Closing the file releases the flock.
+	return l.f.Close()
+}
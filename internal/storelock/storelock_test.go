@@ -0,0 +1,140 @@
+package storelock_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/distr1/distri/internal/storelock"
+	"golang.org/x/sys/unix"
+)
+
+func TestAcquire(t *testing.T) {
+	store, err := ioutil.TempDir("", "distritest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store)
+
+	lock, err := storelock.Acquire(store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storelock.Acquire(store, false)
+	locked, ok := err.(*storelock.ErrLocked)
+	if !ok {
+		t.Fatalf("Acquire(locked store): got err %v, want *storelock.ErrLocked", err)
+	}
+	if got, want := locked.PID, os.Getpid(); got != want {
+		t.Fatalf("ErrLocked.PID: got %d, want %d", got, want)
+	}
+
+	acquired := make(chan *storelock.Lock)
+	go func() {
+		lock, err := storelock.Acquire(store, true)
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- lock
+	}()
+	select {
+	case <-acquired:
+		t.Fatalf("Acquire(wait=true) returned while lock was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if lock := <-acquired; lock != nil {
+		if err := lock.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAcquireDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "distritest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lock, err := storelock.AcquireDir(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storelock.AcquireDir(dir, false); err == nil {
+		t.Fatalf("AcquireDir(locked dir) unexpectedly succeeded")
+	} else if _, ok := err.(*storelock.ErrLocked); !ok {
+		t.Fatalf("AcquireDir(locked dir): got err %v, want *storelock.ErrLocked", err)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) > 0 {
+		t.Fatalf("AcquireDir created %d files in %s, want none", len(fis), dir)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = storelock.AcquireDir(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInherit(t *testing.T) {
+	store, err := ioutil.TempDir("", "distritest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(store)
+
+	lock, err := storelock.Acquire(store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Simulate passing the lock to a child process, which refers to the same
+	// open file description via a different file descriptor:
+	fd, err := unix.Dup(int(lock.File().Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	inherited, err := storelock.Inherit(store, uintptr(fd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inherited.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lock is held until the parent releases it, too:
+	if _, err := storelock.Acquire(store, false); err == nil {
+		t.Fatalf("Acquire unexpectedly succeeded while the parent holds the lock")
+	}
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inheriting a file descriptor which does not hold the lock fails while
+	// another process holds it:
+	lock, err = storelock.Acquire(store, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release()
+	f, err := os.Open(filepath.Join(store, storelock.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storelock.Inherit(store, f.Fd()); err == nil {
+		t.Fatalf("Inherit(unlocked file descriptor) unexpectedly succeeded")
+	}
+}
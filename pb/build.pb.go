@@ -2,14 +2,13 @@
 
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.20.1
-// 	protoc        v3.11.4
+// 	protoc-gen-go v1.33.0
+// 	protoc        (unknown)
 // source: build.proto
 
 package pb
 
 import (
-	proto "github.com/golang/protobuf/proto"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
@@ -23,10 +22,6 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-// This is a compile-time assertion that a sufficiently up-to-date version
-// of the legacy proto package is being used.
-const _ = proto.ProtoPackageIsVersion4
-
 type BuildStep struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -927,6 +922,14 @@ type Build struct {
 	// (for human consumption) with details.
 	// E.g. ack_missing_dwarf: "TODO" if the failure is not yet understood.
 	AckMissingDwarf *string `protobuf:"bytes,22,opt,name=ack_missing_dwarf,json=ackMissingDwarf" json:"ack_missing_dwarf,omitempty"`
+	// A one-line description of the package, for humans (e.g. distri search).
+	// E.g. description: "Generates status bar to use with i3bar"
+	Description *string `protobuf:"bytes,23,opt,name=description" json:"description,omitempty"`
+	// The upstream project’s homepage, e.g. homepage: "https://i3wm.org/i3status/"
+	Homepage *string `protobuf:"bytes,24,opt,name=homepage" json:"homepage,omitempty"`
+	// The license of the package as SPDX license expression, e.g.
+	// license: "BSD-3-Clause"
+	License *string `protobuf:"bytes,25,opt,name=license" json:"license,omitempty"`
 	// TODO: rename to build_dep
 	Dep []string `protobuf:"bytes,5,rep,name=dep" json:"dep,omitempty"`
 	// TODO: move this field into a custom builder
@@ -1055,6 +1058,27 @@ func (x *Build) GetAckMissingDwarf() string {
 	return ""
 }
 
+func (x *Build) GetDescription() string {
+	if x != nil && x.Description != nil {
+		return *x.Description
+	}
+	return ""
+}
+
+func (x *Build) GetHomepage() string {
+	if x != nil && x.Homepage != nil {
+		return *x.Homepage
+	}
+	return ""
+}
+
+func (x *Build) GetLicense() string {
+	if x != nil && x.License != nil {
+		return *x.License
+	}
+	return ""
+}
+
 func (x *Build) GetDep() []string {
 	if x != nil {
 		return x.Dep
@@ -1593,7 +1617,7 @@ var file_build_proto_rawDesc = []byte{
 	0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x65, 0x70, 0x6c, 0x61, 0x63, 0x65, 0x41, 0x6c,
 	0x6c, 0x12, 0x21, 0x0a, 0x0c, 0x66, 0x6f, 0x72, 0x63, 0x65, 0x5f, 0x73, 0x65, 0x6d, 0x76, 0x65,
 	0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0b, 0x66, 0x6f, 0x72, 0x63, 0x65, 0x53, 0x65,
-	0x6d, 0x76, 0x65, 0x72, 0x22, 0x97, 0x07, 0x0a, 0x05, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x12, 0x16,
+	0x6d, 0x76, 0x65, 0x72, 0x22, 0xef, 0x07, 0x0a, 0x05, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x12, 0x16,
 	0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
 	0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x1c, 0x0a, 0x04, 0x70, 0x75, 0x6c, 0x6c, 0x18, 0x13,
 	0x20, 0x01, 0x28, 0x0b, 0x32, 0x08, 0x2e, 0x70, 0x62, 0x2e, 0x50, 0x75, 0x6c, 0x6c, 0x52, 0x04,
@@ -1612,46 +1636,51 @@ var file_build_proto_rawDesc = []byte{
 	0x42, 0x75, 0x69, 0x6c, 0x64, 0x12, 0x2a, 0x0a, 0x11, 0x61, 0x63, 0x6b, 0x5f, 0x6d, 0x69, 0x73,
 	0x73, 0x69, 0x6e, 0x67, 0x5f, 0x64, 0x77, 0x61, 0x72, 0x66, 0x18, 0x16, 0x20, 0x01, 0x28, 0x09,
 	0x52, 0x0f, 0x61, 0x63, 0x6b, 0x4d, 0x69, 0x73, 0x73, 0x69, 0x6e, 0x67, 0x44, 0x77, 0x61, 0x72,
-	0x66, 0x12, 0x10, 0x0a, 0x03, 0x64, 0x65, 0x70, 0x18, 0x05, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03,
-	0x64, 0x65, 0x70, 0x12, 0x2c, 0x0a, 0x0a, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x5f, 0x73, 0x74, 0x65,
-	0x70, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0d, 0x2e, 0x70, 0x62, 0x2e, 0x42, 0x75, 0x69,
-	0x6c, 0x64, 0x53, 0x74, 0x65, 0x70, 0x52, 0x09, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x53, 0x74, 0x65,
-	0x70, 0x12, 0x2a, 0x0a, 0x08, 0x63, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x07, 0x20,
-	0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x65,
-	0x72, 0x48, 0x00, 0x52, 0x08, 0x63, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x36, 0x0a,
-	0x0c, 0x63, 0x6d, 0x61, 0x6b, 0x65, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x0e, 0x20,
-	0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x4d, 0x61, 0x6b, 0x65, 0x42, 0x75,
-	0x69, 0x6c, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x0c, 0x63, 0x6d, 0x61, 0x6b, 0x65, 0x62, 0x75,
-	0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x36, 0x0a, 0x0c, 0x6d, 0x65, 0x73, 0x6f, 0x6e, 0x62, 0x75,
-	0x69, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x10, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x62,
-	0x2e, 0x4d, 0x65, 0x73, 0x6f, 0x6e, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52,
-	0x0c, 0x6d, 0x65, 0x73, 0x6f, 0x6e, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x33, 0x0a,
-	0x0b, 0x70, 0x65, 0x72, 0x6c, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x0a, 0x20, 0x01,
-	0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x62, 0x2e, 0x50, 0x65, 0x72, 0x6c, 0x42, 0x75, 0x69, 0x6c,
-	0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x0b, 0x70, 0x65, 0x72, 0x6c, 0x62, 0x75, 0x69, 0x6c, 0x64,
-	0x65, 0x72, 0x12, 0x39, 0x0a, 0x0d, 0x70, 0x79, 0x74, 0x68, 0x6f, 0x6e, 0x62, 0x75, 0x69, 0x6c,
-	0x64, 0x65, 0x72, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x70, 0x62, 0x2e, 0x50,
-	0x79, 0x74, 0x68, 0x6f, 0x6e, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x0d,
-	0x70, 0x79, 0x74, 0x68, 0x6f, 0x6e, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x36, 0x0a,
-	0x0c, 0x67, 0x6f, 0x6d, 0x6f, 0x64, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x0d, 0x20,
-	0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x62, 0x2e, 0x47, 0x6f, 0x6d, 0x6f, 0x64, 0x42, 0x75,
-	0x69, 0x6c, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x0c, 0x67, 0x6f, 0x6d, 0x6f, 0x64, 0x62, 0x75,
-	0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x2d, 0x0a, 0x09, 0x67, 0x6f, 0x62, 0x75, 0x69, 0x6c, 0x64,
-	0x65, 0x72, 0x18, 0x12, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0d, 0x2e, 0x70, 0x62, 0x2e, 0x47, 0x6f,
-	0x42, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x09, 0x67, 0x6f, 0x62, 0x75, 0x69,
-	0x6c, 0x64, 0x65, 0x72, 0x12, 0x1f, 0x0a, 0x0b, 0x72, 0x75, 0x6e, 0x74, 0x69, 0x6d, 0x65, 0x5f,
-	0x64, 0x65, 0x70, 0x18, 0x09, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x72, 0x75, 0x6e, 0x74, 0x69,
-	0x6d, 0x65, 0x44, 0x65, 0x70, 0x12, 0x25, 0x0a, 0x07, 0x69, 0x6e, 0x73, 0x74, 0x61, 0x6c, 0x6c,
-	0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x49, 0x6e, 0x73, 0x74,
-	0x61, 0x6c, 0x6c, 0x52, 0x07, 0x69, 0x6e, 0x73, 0x74, 0x61, 0x6c, 0x6c, 0x12, 0x35, 0x0a, 0x0d,
-	0x73, 0x70, 0x6c, 0x69, 0x74, 0x5f, 0x70, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x18, 0x0b, 0x20,
-	0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x70, 0x6c, 0x69, 0x74, 0x50, 0x61,
-	0x63, 0x6b, 0x61, 0x67, 0x65, 0x52, 0x0c, 0x73, 0x70, 0x6c, 0x69, 0x74, 0x50, 0x61, 0x63, 0x6b,
-	0x61, 0x67, 0x65, 0x12, 0x2e, 0x0a, 0x0d, 0x72, 0x75, 0x6e, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x75,
-	0x6e, 0x69, 0x6f, 0x6e, 0x18, 0x0f, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x70, 0x62, 0x2e,
-	0x55, 0x6e, 0x69, 0x6f, 0x6e, 0x52, 0x0c, 0x72, 0x75, 0x6e, 0x74, 0x69, 0x6d, 0x65, 0x55, 0x6e,
-	0x69, 0x6f, 0x6e, 0x42, 0x09, 0x0a, 0x07, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x42, 0x06,
-	0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62,
+	0x66, 0x12, 0x20, 0x0a, 0x0b, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e,
+	0x18, 0x17, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74,
+	0x69, 0x6f, 0x6e, 0x12, 0x1a, 0x0a, 0x08, 0x68, 0x6f, 0x6d, 0x65, 0x70, 0x61, 0x67, 0x65, 0x18,
+	0x18, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x68, 0x6f, 0x6d, 0x65, 0x70, 0x61, 0x67, 0x65, 0x12,
+	0x18, 0x0a, 0x07, 0x6c, 0x69, 0x63, 0x65, 0x6e, 0x73, 0x65, 0x18, 0x19, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x07, 0x6c, 0x69, 0x63, 0x65, 0x6e, 0x73, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x64, 0x65, 0x70,
+	0x18, 0x05, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x64, 0x65, 0x70, 0x12, 0x2c, 0x0a, 0x0a, 0x62,
+	0x75, 0x69, 0x6c, 0x64, 0x5f, 0x73, 0x74, 0x65, 0x70, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x0d, 0x2e, 0x70, 0x62, 0x2e, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x53, 0x74, 0x65, 0x70, 0x52, 0x09,
+	0x62, 0x75, 0x69, 0x6c, 0x64, 0x53, 0x74, 0x65, 0x70, 0x12, 0x2a, 0x0a, 0x08, 0x63, 0x62, 0x75,
+	0x69, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x70, 0x62,
+	0x2e, 0x43, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x08, 0x63, 0x62, 0x75,
+	0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x36, 0x0a, 0x0c, 0x63, 0x6d, 0x61, 0x6b, 0x65, 0x62, 0x75,
+	0x69, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x62,
+	0x2e, 0x43, 0x4d, 0x61, 0x6b, 0x65, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52,
+	0x0c, 0x63, 0x6d, 0x61, 0x6b, 0x65, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x36, 0x0a,
+	0x0c, 0x6d, 0x65, 0x73, 0x6f, 0x6e, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x10, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x65, 0x73, 0x6f, 0x6e, 0x42, 0x75,
+	0x69, 0x6c, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x0c, 0x6d, 0x65, 0x73, 0x6f, 0x6e, 0x62, 0x75,
+	0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x33, 0x0a, 0x0b, 0x70, 0x65, 0x72, 0x6c, 0x62, 0x75, 0x69,
+	0x6c, 0x64, 0x65, 0x72, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x62, 0x2e,
+	0x50, 0x65, 0x72, 0x6c, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x0b, 0x70,
+	0x65, 0x72, 0x6c, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x39, 0x0a, 0x0d, 0x70, 0x79,
+	0x74, 0x68, 0x6f, 0x6e, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x0c, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x11, 0x2e, 0x70, 0x62, 0x2e, 0x50, 0x79, 0x74, 0x68, 0x6f, 0x6e, 0x42, 0x75, 0x69,
+	0x6c, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x0d, 0x70, 0x79, 0x74, 0x68, 0x6f, 0x6e, 0x62, 0x75,
+	0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x36, 0x0a, 0x0c, 0x67, 0x6f, 0x6d, 0x6f, 0x64, 0x62, 0x75,
+	0x69, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x62,
+	0x2e, 0x47, 0x6f, 0x6d, 0x6f, 0x64, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52,
+	0x0c, 0x67, 0x6f, 0x6d, 0x6f, 0x64, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x2d, 0x0a,
+	0x09, 0x67, 0x6f, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x12, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x0d, 0x2e, 0x70, 0x62, 0x2e, 0x47, 0x6f, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x48,
+	0x00, 0x52, 0x09, 0x67, 0x6f, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x1f, 0x0a, 0x0b,
+	0x72, 0x75, 0x6e, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x64, 0x65, 0x70, 0x18, 0x09, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x0a, 0x72, 0x75, 0x6e, 0x74, 0x69, 0x6d, 0x65, 0x44, 0x65, 0x70, 0x12, 0x25, 0x0a,
+	0x07, 0x69, 0x6e, 0x73, 0x74, 0x61, 0x6c, 0x6c, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b,
+	0x2e, 0x70, 0x62, 0x2e, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6c, 0x6c, 0x52, 0x07, 0x69, 0x6e, 0x73,
+	0x74, 0x61, 0x6c, 0x6c, 0x12, 0x35, 0x0a, 0x0d, 0x73, 0x70, 0x6c, 0x69, 0x74, 0x5f, 0x70, 0x61,
+	0x63, 0x6b, 0x61, 0x67, 0x65, 0x18, 0x0b, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x62,
+	0x2e, 0x53, 0x70, 0x6c, 0x69, 0x74, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x52, 0x0c, 0x73,
+	0x70, 0x6c, 0x69, 0x74, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x12, 0x2e, 0x0a, 0x0d, 0x72,
+	0x75, 0x6e, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x75, 0x6e, 0x69, 0x6f, 0x6e, 0x18, 0x0f, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x09, 0x2e, 0x70, 0x62, 0x2e, 0x55, 0x6e, 0x69, 0x6f, 0x6e, 0x52, 0x0c, 0x72,
+	0x75, 0x6e, 0x74, 0x69, 0x6d, 0x65, 0x55, 0x6e, 0x69, 0x6f, 0x6e, 0x42, 0x09, 0x0a, 0x07, 0x62,
+	0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x42, 0x06, 0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62,
 }
 
 var (
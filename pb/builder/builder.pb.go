@@ -1,14 +1,13 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.20.1
-// 	protoc        v3.11.4
+// 	protoc-gen-go v1.33.0
+// 	protoc        (unknown)
 // source: builder.proto
 
 package builder
 
 import (
 	context "context"
-	proto "github.com/golang/protobuf/proto"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
@@ -25,10 +24,6 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-// This is a compile-time assertion that a sufficiently up-to-date version
-// of the legacy proto package is being used.
-const _ = proto.ProtoPackageIsVersion4
-
 type Chunk struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -38,6 +33,10 @@ type Chunk struct {
 	// path is unset in all but the first Retrieve Chunk message per stream.
 	Path  string `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"` // relative, e.g. pkgs/emacs/build.textproto.
 	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3" json:"chunk,omitempty"`
+	// digest is only set in the first Store Chunk message per stream. If set,
+	// the file is stored in the content-addressed store of the server, and the
+	// file transfer is skipped if the server already has the file.
+	Digest string `protobuf:"bytes,3,opt,name=digest,proto3" json:"digest,omitempty"` // algorithm:hex-encoded digest, e.g. sha256:e3b0c442…
 }
 
 func (x *Chunk) Reset() {
@@ -86,10 +85,21 @@ func (x *Chunk) GetChunk() []byte {
 	return nil
 }
 
+func (x *Chunk) GetDigest() string {
+	if x != nil {
+		return x.Digest
+	}
+	return ""
+}
+
 type StoreResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
+
+	// cached is true if the server already had the file (see Chunk.digest) and
+	// stopped reading the stream.
+	Cached bool `protobuf:"varint,1,opt,name=cached,proto3" json:"cached,omitempty"`
 }
 
 func (x *StoreResponse) Reset() {
@@ -124,6 +134,13 @@ func (*StoreResponse) Descriptor() ([]byte, []int) {
 	return file_builder_proto_rawDescGZIP(), []int{1}
 }
 
+func (x *StoreResponse) GetCached() bool {
+	if x != nil {
+		return x.Cached
+	}
+	return false
+}
+
 type RetrieveRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -287,11 +304,14 @@ var File_builder_proto protoreflect.FileDescriptor
 
 var file_builder_proto_rawDesc = []byte{
 	0x0a, 0x0d, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
-	0x07, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x22, 0x31, 0x0a, 0x05, 0x43, 0x68, 0x75, 0x6e,
+	0x07, 0x62, 0x75, 0x69, 0x6c, 0x64, 0x65, 0x72, 0x22, 0x49, 0x0a, 0x05, 0x43, 0x68, 0x75, 0x6e,
 	0x6b, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
 	0x04, 0x70, 0x61, 0x74, 0x68, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x18, 0x02,
-	0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x22, 0x0f, 0x0a, 0x0d, 0x53,
-	0x74, 0x6f, 0x72, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x25, 0x0a, 0x0f,
+	0x20, 0x01, 0x28, 0x0c, 0x52, 0x05, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x12, 0x16, 0x0a, 0x06, 0x64,
+	0x69, 0x67, 0x65, 0x73, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x64, 0x69, 0x67,
+	0x65, 0x73, 0x74, 0x22, 0x27, 0x0a, 0x0d, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x61, 0x63, 0x68, 0x65, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x63, 0x61, 0x63, 0x68, 0x65, 0x64, 0x22, 0x25, 0x0a, 0x0f,
 	0x52, 0x65, 0x74, 0x72, 0x69, 0x65, 0x76, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
 	0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x70,
 	0x61, 0x74, 0x68, 0x22, 0x79, 0x0a, 0x0c, 0x42, 0x75, 0x69, 0x6c, 0x64, 0x52, 0x65, 0x71, 0x75,
@@ -466,13 +486,20 @@ type BuildClient interface {
 	// Cloud implementations might write the file to a key/value store with a TTL
 	// of one day.
 	//
-	// Files are transferred as a stream of chunks with size 4096 bytes.
+	// Files are transferred as a stream of chunks with size 4096 bytes. If the
+	// first chunk contains a digest of a file which the server already has, the
+	// server responds right away: clients should stop sending once Send returns
+	// io.EOF.
 	Store(ctx context.Context, opts ...grpc.CallOption) (Build_StoreClient, error)
 	// Build ensures the specified input_path are available in the current working
 	// directory, changes into working_directory, then runs a distri build with
 	// any additional build_flag specified.
 	//
 	// Build output artifacts paths are streamed in BuildProgress messages.
+	//
+	// Builds are cached: when the contents of all input_path, the
+	// working_directory and build_flag match a previous successful build, the
+	// artifacts of that build are returned without building.
 	Build(ctx context.Context, in *BuildRequest, opts ...grpc.CallOption) (Build_BuildClient, error)
 	// Retrieve streams the file located at path in chunks of size 4096 bytes.
 	Retrieve(ctx context.Context, in *RetrieveRequest, opts ...grpc.CallOption) (Build_RetrieveClient, error)
@@ -599,13 +626,20 @@ type BuildServer interface {
 	// Cloud implementations might write the file to a key/value store with a TTL
 	// of one day.
 	//
-	// Files are transferred as a stream of chunks with size 4096 bytes.
+	// Files are transferred as a stream of chunks with size 4096 bytes. If the
+	// first chunk contains a digest of a file which the server already has, the
+	// server responds right away: clients should stop sending once Send returns
+	// io.EOF.
 	Store(Build_StoreServer) error
 	// Build ensures the specified input_path are available in the current working
 	// directory, changes into working_directory, then runs a distri build with
 	// any additional build_flag specified.
 	//
 	// Build output artifacts paths are streamed in BuildProgress messages.
+	//
+	// Builds are cached: when the contents of all input_path, the
+	// working_directory and build_flag match a previous successful build, the
+	// artifacts of that build are returned without building.
 	Build(*BuildRequest, Build_BuildServer) error
 	// Retrieve streams the file located at path in chunks of size 4096 bytes.
 	Retrieve(*RetrieveRequest, Build_RetrieveServer) error
@@ -1,14 +1,13 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.20.1
-// 	protoc        v3.11.4
+// 	protoc-gen-go v1.33.0
+// 	protoc        (unknown)
 // source: fusectl.proto
 
 package pb
 
 import (
 	context "context"
-	proto "github.com/golang/protobuf/proto"
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
@@ -25,10 +24,6 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-// This is a compile-time assertion that a sufficiently up-to-date version
-// of the legacy proto package is being used.
-const _ = proto.ProtoPackageIsVersion4
-
 type PingRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -105,31 +100,563 @@ func (*PingReply) Descriptor() ([]byte, []int) {
 	return file_fusectl_proto_rawDescGZIP(), []int{1}
 }
 
-type MkdirAllRequest struct {
+type MkdirAllRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Dir *string `protobuf:"bytes,1,opt,name=dir" json:"dir,omitempty"`
+}
+
+func (x *MkdirAllRequest) Reset() {
+	*x = MkdirAllRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fusectl_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MkdirAllRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MkdirAllRequest) ProtoMessage() {}
+
+func (x *MkdirAllRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MkdirAllRequest.ProtoReflect.Descriptor instead.
+func (*MkdirAllRequest) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *MkdirAllRequest) GetDir() string {
+	if x != nil && x.Dir != nil {
+		return *x.Dir
+	}
+	return ""
+}
+
+type MkdirAllReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *MkdirAllReply) Reset() {
+	*x = MkdirAllReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fusectl_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MkdirAllReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MkdirAllReply) ProtoMessage() {}
+
+func (x *MkdirAllReply) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MkdirAllReply.ProtoReflect.Descriptor instead.
+func (*MkdirAllReply) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{3}
+}
+
+type ScanPackagesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ScanPackagesRequest) Reset() {
+	*x = ScanPackagesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fusectl_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ScanPackagesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanPackagesRequest) ProtoMessage() {}
+
+func (x *ScanPackagesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanPackagesRequest.ProtoReflect.Descriptor instead.
+func (*ScanPackagesRequest) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{4}
+}
+
+type ScanPackagesReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ScanPackagesReply) Reset() {
+	*x = ScanPackagesReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fusectl_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ScanPackagesReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanPackagesReply) ProtoMessage() {}
+
+func (x *ScanPackagesReply) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanPackagesReply.ProtoReflect.Descriptor instead.
+func (*ScanPackagesReply) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{5}
+}
+
+type RemovePackagesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Pkg []string `protobuf:"bytes,1,rep,name=pkg" json:"pkg,omitempty"` // e.g. less-amd64-530
+}
+
+func (x *RemovePackagesRequest) Reset() {
+	*x = RemovePackagesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fusectl_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RemovePackagesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemovePackagesRequest) ProtoMessage() {}
+
+func (x *RemovePackagesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemovePackagesRequest.ProtoReflect.Descriptor instead.
+func (*RemovePackagesRequest) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RemovePackagesRequest) GetPkg() []string {
+	if x != nil {
+		return x.Pkg
+	}
+	return nil
+}
+
+type RemovePackagesReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *RemovePackagesReply) Reset() {
+	*x = RemovePackagesReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fusectl_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RemovePackagesReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemovePackagesReply) ProtoMessage() {}
+
+func (x *RemovePackagesReply) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemovePackagesReply.ProtoReflect.Descriptor instead.
+func (*RemovePackagesReply) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{7}
+}
+
+type ListPackagesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ListPackagesRequest) Reset() {
+	*x = ListPackagesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fusectl_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListPackagesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPackagesRequest) ProtoMessage() {}
+
+func (x *ListPackagesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPackagesRequest.ProtoReflect.Descriptor instead.
+func (*ListPackagesRequest) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{8}
+}
+
+type MountedPackage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name  *string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`   // e.g. less-amd64-530
+	Image *string `protobuf:"bytes,2,opt,name=image" json:"image,omitempty"` // e.g. /roimg/less-amd64-530.squashfs
+	// FUSE inodes of the package contents are within [inode_min, inode_max].
+	InodeMin *uint64 `protobuf:"varint,3,opt,name=inode_min,json=inodeMin" json:"inode_min,omitempty"`
+	InodeMax *uint64 `protobuf:"varint,4,opt,name=inode_max,json=inodeMax" json:"inode_max,omitempty"`
+	Open     *bool   `protobuf:"varint,5,opt,name=open" json:"open,omitempty"` // whether the image was accessed (and is open)
+	// number of kernel references to inodes of the package
+	Lookups *uint64 `protobuf:"varint,6,opt,name=lookups" json:"lookups,omitempty"`
+}
+
+func (x *MountedPackage) Reset() {
+	*x = MountedPackage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fusectl_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MountedPackage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MountedPackage) ProtoMessage() {}
+
+func (x *MountedPackage) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MountedPackage.ProtoReflect.Descriptor instead.
+func (*MountedPackage) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *MountedPackage) GetName() string {
+	if x != nil && x.Name != nil {
+		return *x.Name
+	}
+	return ""
+}
+
+func (x *MountedPackage) GetImage() string {
+	if x != nil && x.Image != nil {
+		return *x.Image
+	}
+	return ""
+}
+
+func (x *MountedPackage) GetInodeMin() uint64 {
+	if x != nil && x.InodeMin != nil {
+		return *x.InodeMin
+	}
+	return 0
+}
+
+func (x *MountedPackage) GetInodeMax() uint64 {
+	if x != nil && x.InodeMax != nil {
+		return *x.InodeMax
+	}
+	return 0
+}
+
+func (x *MountedPackage) GetOpen() bool {
+	if x != nil && x.Open != nil {
+		return *x.Open
+	}
+	return false
+}
+
+func (x *MountedPackage) GetLookups() uint64 {
+	if x != nil && x.Lookups != nil {
+		return *x.Lookups
+	}
+	return 0
+}
+
+type ListPackagesReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Package []*MountedPackage `protobuf:"bytes,1,rep,name=package" json:"package,omitempty"`
+}
+
+func (x *ListPackagesReply) Reset() {
+	*x = ListPackagesReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fusectl_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListPackagesReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListPackagesReply) ProtoMessage() {}
+
+func (x *ListPackagesReply) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListPackagesReply.ProtoReflect.Descriptor instead.
+func (*ListPackagesReply) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListPackagesReply) GetPackage() []*MountedPackage {
+	if x != nil {
+		return x.Package
+	}
+	return nil
+}
+
+type ExchangeConflictsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// If non-empty, only exchange directories within this directory (e.g. bin)
+	// are considered.
+	Dir *string `protobuf:"bytes,1,opt,name=dir" json:"dir,omitempty"`
+}
+
+func (x *ExchangeConflictsRequest) Reset() {
+	*x = ExchangeConflictsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fusectl_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ExchangeConflictsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExchangeConflictsRequest) ProtoMessage() {}
+
+func (x *ExchangeConflictsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExchangeConflictsRequest.ProtoReflect.Descriptor instead.
+func (*ExchangeConflictsRequest) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ExchangeConflictsRequest) GetDir() string {
+	if x != nil && x.Dir != nil {
+		return *x.Dir
+	}
+	return ""
+}
+
+type ExchangeConflict struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Path      *string  `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`           // e.g. bin/sh
+	Winner    *string  `protobuf:"bytes,2,opt,name=winner" json:"winner,omitempty"`       // e.g. bash-amd64-5.0-4
+	Candidate []string `protobuf:"bytes,3,rep,name=candidate" json:"candidate,omitempty"` // all packages providing path, incl. winner
+}
+
+func (x *ExchangeConflict) Reset() {
+	*x = ExchangeConflict{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_fusectl_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ExchangeConflict) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExchangeConflict) ProtoMessage() {}
+
+func (x *ExchangeConflict) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExchangeConflict.ProtoReflect.Descriptor instead.
+func (*ExchangeConflict) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ExchangeConflict) GetPath() string {
+	if x != nil && x.Path != nil {
+		return *x.Path
+	}
+	return ""
+}
+
+func (x *ExchangeConflict) GetWinner() string {
+	if x != nil && x.Winner != nil {
+		return *x.Winner
+	}
+	return ""
+}
+
+func (x *ExchangeConflict) GetCandidate() []string {
+	if x != nil {
+		return x.Candidate
+	}
+	return nil
+}
+
+type ExchangeConflictsReply struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Dir *string `protobuf:"bytes,1,opt,name=dir" json:"dir,omitempty"`
+	Conflict []*ExchangeConflict `protobuf:"bytes,1,rep,name=conflict" json:"conflict,omitempty"`
 }
 
-func (x *MkdirAllRequest) Reset() {
-	*x = MkdirAllRequest{}
+func (x *ExchangeConflictsReply) Reset() {
+	*x = ExchangeConflictsReply{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_fusectl_proto_msgTypes[2]
+		mi := &file_fusectl_proto_msgTypes[13]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *MkdirAllRequest) String() string {
+func (x *ExchangeConflictsReply) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*MkdirAllRequest) ProtoMessage() {}
+func (*ExchangeConflictsReply) ProtoMessage() {}
 
-func (x *MkdirAllRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_fusectl_proto_msgTypes[2]
+func (x *ExchangeConflictsReply) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[13]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -140,41 +667,41 @@ func (x *MkdirAllRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use MkdirAllRequest.ProtoReflect.Descriptor instead.
-func (*MkdirAllRequest) Descriptor() ([]byte, []int) {
-	return file_fusectl_proto_rawDescGZIP(), []int{2}
+// Deprecated: Use ExchangeConflictsReply.ProtoReflect.Descriptor instead.
+func (*ExchangeConflictsReply) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{13}
 }
 
-func (x *MkdirAllRequest) GetDir() string {
-	if x != nil && x.Dir != nil {
-		return *x.Dir
+func (x *ExchangeConflictsReply) GetConflict() []*ExchangeConflict {
+	if x != nil {
+		return x.Conflict
 	}
-	return ""
+	return nil
 }
 
-type MkdirAllReply struct {
+type StatsRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 }
 
-func (x *MkdirAllReply) Reset() {
-	*x = MkdirAllReply{}
+func (x *StatsRequest) Reset() {
+	*x = StatsRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_fusectl_proto_msgTypes[3]
+		mi := &file_fusectl_proto_msgTypes[14]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *MkdirAllReply) String() string {
+func (x *StatsRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*MkdirAllReply) ProtoMessage() {}
+func (*StatsRequest) ProtoMessage() {}
 
-func (x *MkdirAllReply) ProtoReflect() protoreflect.Message {
-	mi := &file_fusectl_proto_msgTypes[3]
+func (x *StatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[14]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -185,34 +712,40 @@ func (x *MkdirAllReply) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use MkdirAllReply.ProtoReflect.Descriptor instead.
-func (*MkdirAllReply) Descriptor() ([]byte, []int) {
-	return file_fusectl_proto_rawDescGZIP(), []int{3}
+// Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
+func (*StatsRequest) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{14}
 }
 
-type ScanPackagesRequest struct {
+type StatsReply struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
+
+	Lookups       *uint64 `protobuf:"varint,1,opt,name=lookups" json:"lookups,omitempty"`                            // LookUpInode requests
+	Reads         *uint64 `protobuf:"varint,2,opt,name=reads" json:"reads,omitempty"`                                // ReadFile requests
+	BytesServed   *uint64 `protobuf:"varint,3,opt,name=bytes_served,json=bytesServed" json:"bytes_served,omitempty"` // bytes returned by ReadFile
+	Autodownloads *uint64 `protobuf:"varint,4,opt,name=autodownloads" json:"autodownloads,omitempty"`                // images downloaded on demand
+	Packages      *uint64 `protobuf:"varint,5,opt,name=packages" json:"packages,omitempty"`                          // currently available packages
 }
 
-func (x *ScanPackagesRequest) Reset() {
-	*x = ScanPackagesRequest{}
+func (x *StatsReply) Reset() {
+	*x = StatsReply{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_fusectl_proto_msgTypes[4]
+		mi := &file_fusectl_proto_msgTypes[15]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *ScanPackagesRequest) String() string {
+func (x *StatsReply) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ScanPackagesRequest) ProtoMessage() {}
+func (*StatsReply) ProtoMessage() {}
 
-func (x *ScanPackagesRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_fusectl_proto_msgTypes[4]
+func (x *StatsReply) ProtoReflect() protoreflect.Message {
+	mi := &file_fusectl_proto_msgTypes[15]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -223,47 +756,44 @@ func (x *ScanPackagesRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ScanPackagesRequest.ProtoReflect.Descriptor instead.
-func (*ScanPackagesRequest) Descriptor() ([]byte, []int) {
-	return file_fusectl_proto_rawDescGZIP(), []int{4}
+// Deprecated: Use StatsReply.ProtoReflect.Descriptor instead.
+func (*StatsReply) Descriptor() ([]byte, []int) {
+	return file_fusectl_proto_rawDescGZIP(), []int{15}
 }
 
-type ScanPackagesReply struct {
-	state         protoimpl.MessageState
-	sizeCache     protoimpl.SizeCache
-	unknownFields protoimpl.UnknownFields
+func (x *StatsReply) GetLookups() uint64 {
+	if x != nil && x.Lookups != nil {
+		return *x.Lookups
+	}
+	return 0
 }
 
-func (x *ScanPackagesReply) Reset() {
-	*x = ScanPackagesReply{}
-	if protoimpl.UnsafeEnabled {
-		mi := &file_fusectl_proto_msgTypes[5]
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		ms.StoreMessageInfo(mi)
+func (x *StatsReply) GetReads() uint64 {
+	if x != nil && x.Reads != nil {
+		return *x.Reads
 	}
+	return 0
 }
 
-func (x *ScanPackagesReply) String() string {
-	return protoimpl.X.MessageStringOf(x)
+func (x *StatsReply) GetBytesServed() uint64 {
+	if x != nil && x.BytesServed != nil {
+		return *x.BytesServed
+	}
+	return 0
 }
 
-func (*ScanPackagesReply) ProtoMessage() {}
-
-func (x *ScanPackagesReply) ProtoReflect() protoreflect.Message {
-	mi := &file_fusectl_proto_msgTypes[5]
-	if protoimpl.UnsafeEnabled && x != nil {
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		if ms.LoadMessageInfo() == nil {
-			ms.StoreMessageInfo(mi)
-		}
-		return ms
+func (x *StatsReply) GetAutodownloads() uint64 {
+	if x != nil && x.Autodownloads != nil {
+		return *x.Autodownloads
 	}
-	return mi.MessageOf(x)
+	return 0
 }
 
-// Deprecated: Use ScanPackagesReply.ProtoReflect.Descriptor instead.
-func (*ScanPackagesReply) Descriptor() ([]byte, []int) {
-	return file_fusectl_proto_rawDescGZIP(), []int{5}
+func (x *StatsReply) GetPackages() uint64 {
+	if x != nil && x.Packages != nil {
+		return *x.Packages
+	}
+	return 0
 }
 
 var File_fusectl_proto protoreflect.FileDescriptor
@@ -278,18 +808,79 @@ var file_fusectl_proto_rawDesc = []byte{
 	0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x15, 0x0a, 0x13, 0x53, 0x63, 0x61, 0x6e, 0x50, 0x61, 0x63,
 	0x6b, 0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x13, 0x0a, 0x11,
 	0x53, 0x63, 0x61, 0x6e, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x70, 0x6c,
-	0x79, 0x32, 0xa8, 0x01, 0x0a, 0x04, 0x46, 0x55, 0x53, 0x45, 0x12, 0x28, 0x0a, 0x04, 0x50, 0x69,
-	0x6e, 0x67, 0x12, 0x0f, 0x2e, 0x70, 0x62, 0x2e, 0x50, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x71, 0x75,
-	0x65, 0x73, 0x74, 0x1a, 0x0d, 0x2e, 0x70, 0x62, 0x2e, 0x50, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x70,
-	0x6c, 0x79, 0x22, 0x00, 0x12, 0x34, 0x0a, 0x08, 0x4d, 0x6b, 0x64, 0x69, 0x72, 0x41, 0x6c, 0x6c,
-	0x12, 0x13, 0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x6b, 0x64, 0x69, 0x72, 0x41, 0x6c, 0x6c, 0x52, 0x65,
-	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x11, 0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x6b, 0x64, 0x69, 0x72,
-	0x41, 0x6c, 0x6c, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x40, 0x0a, 0x0c, 0x53, 0x63,
-	0x61, 0x6e, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x12, 0x17, 0x2e, 0x70, 0x62, 0x2e,
-	0x53, 0x63, 0x61, 0x6e, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75,
-	0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x63, 0x61, 0x6e, 0x50, 0x61, 0x63,
-	0x6b, 0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x42, 0x06, 0x5a, 0x04,
-	0x2e, 0x3b, 0x70, 0x62,
+	0x79, 0x22, 0x29, 0x0a, 0x15, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x50, 0x61, 0x63, 0x6b, 0x61,
+	0x67, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x70, 0x6b,
+	0x67, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x70, 0x6b, 0x67, 0x22, 0x15, 0x0a, 0x13,
+	0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x52, 0x65,
+	0x70, 0x6c, 0x79, 0x22, 0x15, 0x0a, 0x13, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x61, 0x63, 0x6b, 0x61,
+	0x67, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0xa2, 0x01, 0x0a, 0x0e, 0x4d,
+	0x6f, 0x75, 0x6e, 0x74, 0x65, 0x64, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x12, 0x12, 0x0a,
+	0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d,
+	0x65, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x69, 0x6e, 0x6f, 0x64, 0x65,
+	0x5f, 0x6d, 0x69, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x69, 0x6e, 0x6f, 0x64,
+	0x65, 0x4d, 0x69, 0x6e, 0x12, 0x1b, 0x0a, 0x09, 0x69, 0x6e, 0x6f, 0x64, 0x65, 0x5f, 0x6d, 0x61,
+	0x78, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x69, 0x6e, 0x6f, 0x64, 0x65, 0x4d, 0x61,
+	0x78, 0x12, 0x12, 0x0a, 0x04, 0x6f, 0x70, 0x65, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x04, 0x6f, 0x70, 0x65, 0x6e, 0x12, 0x18, 0x0a, 0x07, 0x6c, 0x6f, 0x6f, 0x6b, 0x75, 0x70, 0x73,
+	0x18, 0x06, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x6c, 0x6f, 0x6f, 0x6b, 0x75, 0x70, 0x73, 0x22,
+	0x41, 0x0a, 0x11, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x52,
+	0x65, 0x70, 0x6c, 0x79, 0x12, 0x2c, 0x0a, 0x07, 0x70, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x18,
+	0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x6f, 0x75, 0x6e, 0x74,
+	0x65, 0x64, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x52, 0x07, 0x70, 0x61, 0x63, 0x6b, 0x61,
+	0x67, 0x65, 0x22, 0x2c, 0x0a, 0x18, 0x45, 0x78, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x43, 0x6f,
+	0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10,
+	0x0a, 0x03, 0x64, 0x69, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x64, 0x69, 0x72,
+	0x22, 0x5c, 0x0a, 0x10, 0x45, 0x78, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x43, 0x6f, 0x6e, 0x66,
+	0x6c, 0x69, 0x63, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x70, 0x61, 0x74, 0x68, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x69, 0x6e, 0x6e,
+	0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x77, 0x69, 0x6e, 0x6e, 0x65, 0x72,
+	0x12, 0x1c, 0x0a, 0x09, 0x63, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x18, 0x03, 0x20,
+	0x03, 0x28, 0x09, 0x52, 0x09, 0x63, 0x61, 0x6e, 0x64, 0x69, 0x64, 0x61, 0x74, 0x65, 0x22, 0x4a,
+	0x0a, 0x16, 0x45, 0x78, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69,
+	0x63, 0x74, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x30, 0x0a, 0x08, 0x63, 0x6f, 0x6e, 0x66,
+	0x6c, 0x69, 0x63, 0x74, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x70, 0x62, 0x2e,
+	0x45, 0x78, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74,
+	0x52, 0x08, 0x63, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x22, 0x0e, 0x0a, 0x0c, 0x53, 0x74,
+	0x61, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0xa1, 0x01, 0x0a, 0x0a, 0x53,
+	0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x6c, 0x6f, 0x6f,
+	0x6b, 0x75, 0x70, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x6c, 0x6f, 0x6f, 0x6b,
+	0x75, 0x70, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x72, 0x65, 0x61, 0x64, 0x73, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x05, 0x72, 0x65, 0x61, 0x64, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x62, 0x79, 0x74,
+	0x65, 0x73, 0x5f, 0x73, 0x65, 0x72, 0x76, 0x65, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x0b, 0x62, 0x79, 0x74, 0x65, 0x73, 0x53, 0x65, 0x72, 0x76, 0x65, 0x64, 0x12, 0x24, 0x0a, 0x0d,
+	0x61, 0x75, 0x74, 0x6f, 0x64, 0x6f, 0x77, 0x6e, 0x6c, 0x6f, 0x61, 0x64, 0x73, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x0d, 0x61, 0x75, 0x74, 0x6f, 0x64, 0x6f, 0x77, 0x6e, 0x6c, 0x6f, 0x61,
+	0x64, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x70, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x32, 0xb0,
+	0x03, 0x0a, 0x04, 0x46, 0x55, 0x53, 0x45, 0x12, 0x28, 0x0a, 0x04, 0x50, 0x69, 0x6e, 0x67, 0x12,
+	0x0f, 0x2e, 0x70, 0x62, 0x2e, 0x50, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x0d, 0x2e, 0x70, 0x62, 0x2e, 0x50, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22,
+	0x00, 0x12, 0x34, 0x0a, 0x08, 0x4d, 0x6b, 0x64, 0x69, 0x72, 0x41, 0x6c, 0x6c, 0x12, 0x13, 0x2e,
+	0x70, 0x62, 0x2e, 0x4d, 0x6b, 0x64, 0x69, 0x72, 0x41, 0x6c, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x11, 0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x6b, 0x64, 0x69, 0x72, 0x41, 0x6c, 0x6c,
+	0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x40, 0x0a, 0x0c, 0x53, 0x63, 0x61, 0x6e, 0x50,
+	0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x12, 0x17, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x63, 0x61,
+	0x6e, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x15, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x63, 0x61, 0x6e, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67,
+	0x65, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x12, 0x46, 0x0a, 0x0e, 0x52, 0x65, 0x6d,
+	0x6f, 0x76, 0x65, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x12, 0x19, 0x2e, 0x70, 0x62,
+	0x2e, 0x52, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x70, 0x62, 0x2e, 0x52, 0x65, 0x6d, 0x6f,
+	0x76, 0x65, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22,
+	0x00, 0x12, 0x40, 0x0a, 0x0c, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65,
+	0x73, 0x12, 0x17, 0x2e, 0x70, 0x62, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x50, 0x61, 0x63, 0x6b, 0x61,
+	0x67, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x70, 0x62, 0x2e,
+	0x4c, 0x69, 0x73, 0x74, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x70, 0x6c,
+	0x79, 0x22, 0x00, 0x12, 0x4f, 0x0a, 0x11, 0x45, 0x78, 0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x43,
+	0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x73, 0x12, 0x1c, 0x2e, 0x70, 0x62, 0x2e, 0x45, 0x78,
+	0x63, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1a, 0x2e, 0x70, 0x62, 0x2e, 0x45, 0x78, 0x63, 0x68,
+	0x61, 0x6e, 0x67, 0x65, 0x43, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x73, 0x52, 0x65, 0x70,
+	0x6c, 0x79, 0x22, 0x00, 0x12, 0x2b, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x10, 0x2e,
+	0x70, 0x62, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x0e, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22,
+	0x00, 0x42, 0x06, 0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62,
 }
 
 var (
@@ -304,27 +895,47 @@ func file_fusectl_proto_rawDescGZIP() []byte {
 	return file_fusectl_proto_rawDescData
 }
 
-var file_fusectl_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_fusectl_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
 var file_fusectl_proto_goTypes = []interface{}{
-	(*PingRequest)(nil),         // 0: pb.PingRequest
-	(*PingReply)(nil),           // 1: pb.PingReply
-	(*MkdirAllRequest)(nil),     // 2: pb.MkdirAllRequest
-	(*MkdirAllReply)(nil),       // 3: pb.MkdirAllReply
-	(*ScanPackagesRequest)(nil), // 4: pb.ScanPackagesRequest
-	(*ScanPackagesReply)(nil),   // 5: pb.ScanPackagesReply
+	(*PingRequest)(nil),              // 0: pb.PingRequest
+	(*PingReply)(nil),                // 1: pb.PingReply
+	(*MkdirAllRequest)(nil),          // 2: pb.MkdirAllRequest
+	(*MkdirAllReply)(nil),            // 3: pb.MkdirAllReply
+	(*ScanPackagesRequest)(nil),      // 4: pb.ScanPackagesRequest
+	(*ScanPackagesReply)(nil),        // 5: pb.ScanPackagesReply
+	(*RemovePackagesRequest)(nil),    // 6: pb.RemovePackagesRequest
+	(*RemovePackagesReply)(nil),      // 7: pb.RemovePackagesReply
+	(*ListPackagesRequest)(nil),      // 8: pb.ListPackagesRequest
+	(*MountedPackage)(nil),           // 9: pb.MountedPackage
+	(*ListPackagesReply)(nil),        // 10: pb.ListPackagesReply
+	(*ExchangeConflictsRequest)(nil), // 11: pb.ExchangeConflictsRequest
+	(*ExchangeConflict)(nil),         // 12: pb.ExchangeConflict
+	(*ExchangeConflictsReply)(nil),   // 13: pb.ExchangeConflictsReply
+	(*StatsRequest)(nil),             // 14: pb.StatsRequest
+	(*StatsReply)(nil),               // 15: pb.StatsReply
 }
 var file_fusectl_proto_depIdxs = []int32{
-	0, // 0: pb.FUSE.Ping:input_type -> pb.PingRequest
-	2, // 1: pb.FUSE.MkdirAll:input_type -> pb.MkdirAllRequest
-	4, // 2: pb.FUSE.ScanPackages:input_type -> pb.ScanPackagesRequest
-	1, // 3: pb.FUSE.Ping:output_type -> pb.PingReply
-	3, // 4: pb.FUSE.MkdirAll:output_type -> pb.MkdirAllReply
-	5, // 5: pb.FUSE.ScanPackages:output_type -> pb.ScanPackagesReply
-	3, // [3:6] is the sub-list for method output_type
-	0, // [0:3] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	9,  // 0: pb.ListPackagesReply.package:type_name -> pb.MountedPackage
+	12, // 1: pb.ExchangeConflictsReply.conflict:type_name -> pb.ExchangeConflict
+	0,  // 2: pb.FUSE.Ping:input_type -> pb.PingRequest
+	2,  // 3: pb.FUSE.MkdirAll:input_type -> pb.MkdirAllRequest
+	4,  // 4: pb.FUSE.ScanPackages:input_type -> pb.ScanPackagesRequest
+	6,  // 5: pb.FUSE.RemovePackages:input_type -> pb.RemovePackagesRequest
+	8,  // 6: pb.FUSE.ListPackages:input_type -> pb.ListPackagesRequest
+	11, // 7: pb.FUSE.ExchangeConflicts:input_type -> pb.ExchangeConflictsRequest
+	14, // 8: pb.FUSE.Stats:input_type -> pb.StatsRequest
+	1,  // 9: pb.FUSE.Ping:output_type -> pb.PingReply
+	3,  // 10: pb.FUSE.MkdirAll:output_type -> pb.MkdirAllReply
+	5,  // 11: pb.FUSE.ScanPackages:output_type -> pb.ScanPackagesReply
+	7,  // 12: pb.FUSE.RemovePackages:output_type -> pb.RemovePackagesReply
+	10, // 13: pb.FUSE.ListPackages:output_type -> pb.ListPackagesReply
+	13, // 14: pb.FUSE.ExchangeConflicts:output_type -> pb.ExchangeConflictsReply
+	15, // 15: pb.FUSE.Stats:output_type -> pb.StatsReply
+	9,  // [9:16] is the sub-list for method output_type
+	2,  // [2:9] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_fusectl_proto_init() }
@@ -405,6 +1016,126 @@ func file_fusectl_proto_init() {
 				return nil
 			}
 		}
+		file_fusectl_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RemovePackagesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fusectl_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RemovePackagesReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fusectl_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListPackagesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fusectl_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MountedPackage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fusectl_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListPackagesReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fusectl_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ExchangeConflictsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fusectl_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ExchangeConflict); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fusectl_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ExchangeConflictsReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fusectl_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_fusectl_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatsReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -412,7 +1143,7 @@ func file_fusectl_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_fusectl_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   16,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -443,9 +1174,22 @@ type FUSEClient interface {
 	// (e.g. /ro/systemd-amd64-239). This is useful for bind-mounting
 	// DESTDIR/PREFIX to PREFIX when building packages.
 	MkdirAll(ctx context.Context, in *MkdirAllRequest, opts ...grpc.CallOption) (*MkdirAllReply, error)
-	// ScanPackages discovers new packages in the mounted repository. This is
+	// ScanPackages discovers new packages in the mounted repository, and
+	// removes packages whose image is gone (e.g. after “distri gc”). This is
 	// called by “distri install”.
 	ScanPackages(ctx context.Context, in *ScanPackagesRequest, opts ...grpc.CallOption) (*ScanPackagesReply, error)
+	// RemovePackages removes the specified packages from the mountpoint,
+	// regardless of whether their image is still present in the repository. The
+	// next ScanPackages call adds packages whose image is still present.
+	RemovePackages(ctx context.Context, in *RemovePackagesRequest, opts ...grpc.CallOption) (*RemovePackagesReply, error)
+	// ListPackages returns all currently available packages.
+	ListPackages(ctx context.Context, in *ListPackagesRequest, opts ...grpc.CallOption) (*ListPackagesReply, error)
+	// ExchangeConflicts returns all exchange directory entries (e.g. /ro/bin/sh)
+	// which are provided by more than one package (not counting other revisions
+	// of the same package), and which package the entry resolves to.
+	ExchangeConflicts(ctx context.Context, in *ExchangeConflictsRequest, opts ...grpc.CallOption) (*ExchangeConflictsReply, error)
+	// Stats returns request counters since the file system was mounted.
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsReply, error)
 }
 
 type fUSEClient struct {
@@ -483,6 +1227,42 @@ func (c *fUSEClient) ScanPackages(ctx context.Context, in *ScanPackagesRequest,
 	return out, nil
 }
 
+func (c *fUSEClient) RemovePackages(ctx context.Context, in *RemovePackagesRequest, opts ...grpc.CallOption) (*RemovePackagesReply, error) {
+	out := new(RemovePackagesReply)
+	err := c.cc.Invoke(ctx, "/pb.FUSE/RemovePackages", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fUSEClient) ListPackages(ctx context.Context, in *ListPackagesRequest, opts ...grpc.CallOption) (*ListPackagesReply, error) {
+	out := new(ListPackagesReply)
+	err := c.cc.Invoke(ctx, "/pb.FUSE/ListPackages", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fUSEClient) ExchangeConflicts(ctx context.Context, in *ExchangeConflictsRequest, opts ...grpc.CallOption) (*ExchangeConflictsReply, error) {
+	out := new(ExchangeConflictsReply)
+	err := c.cc.Invoke(ctx, "/pb.FUSE/ExchangeConflicts", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fUSEClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsReply, error) {
+	out := new(StatsReply)
+	err := c.cc.Invoke(ctx, "/pb.FUSE/Stats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // FUSEServer is the server API for FUSE service.
 type FUSEServer interface {
 	Ping(context.Context, *PingRequest) (*PingReply, error)
@@ -490,9 +1270,22 @@ type FUSEServer interface {
 	// (e.g. /ro/systemd-amd64-239). This is useful for bind-mounting
 	// DESTDIR/PREFIX to PREFIX when building packages.
 	MkdirAll(context.Context, *MkdirAllRequest) (*MkdirAllReply, error)
-	// ScanPackages discovers new packages in the mounted repository. This is
+	// ScanPackages discovers new packages in the mounted repository, and
+	// removes packages whose image is gone (e.g. after “distri gc”). This is
 	// called by “distri install”.
 	ScanPackages(context.Context, *ScanPackagesRequest) (*ScanPackagesReply, error)
+	// RemovePackages removes the specified packages from the mountpoint,
+	// regardless of whether their image is still present in the repository. The
+	// next ScanPackages call adds packages whose image is still present.
+	RemovePackages(context.Context, *RemovePackagesRequest) (*RemovePackagesReply, error)
+	// ListPackages returns all currently available packages.
+	ListPackages(context.Context, *ListPackagesRequest) (*ListPackagesReply, error)
+	// ExchangeConflicts returns all exchange directory entries (e.g. /ro/bin/sh)
+	// which are provided by more than one package (not counting other revisions
+	// of the same package), and which package the entry resolves to.
+	ExchangeConflicts(context.Context, *ExchangeConflictsRequest) (*ExchangeConflictsReply, error)
+	// Stats returns request counters since the file system was mounted.
+	Stats(context.Context, *StatsRequest) (*StatsReply, error)
 }
 
 // UnimplementedFUSEServer can be embedded to have forward compatible implementations.
@@ -508,6 +1301,18 @@ func (*UnimplementedFUSEServer) MkdirAll(context.Context, *MkdirAllRequest) (*Mk
 func (*UnimplementedFUSEServer) ScanPackages(context.Context, *ScanPackagesRequest) (*ScanPackagesReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ScanPackages not implemented")
 }
+func (*UnimplementedFUSEServer) RemovePackages(context.Context, *RemovePackagesRequest) (*RemovePackagesReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemovePackages not implemented")
+}
+func (*UnimplementedFUSEServer) ListPackages(context.Context, *ListPackagesRequest) (*ListPackagesReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPackages not implemented")
+}
+func (*UnimplementedFUSEServer) ExchangeConflicts(context.Context, *ExchangeConflictsRequest) (*ExchangeConflictsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExchangeConflicts not implemented")
+}
+func (*UnimplementedFUSEServer) Stats(context.Context, *StatsRequest) (*StatsReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
+}
 
 func RegisterFUSEServer(s *grpc.Server, srv FUSEServer) {
 	s.RegisterService(&_FUSE_serviceDesc, srv)
@@ -567,6 +1372,78 @@ func _FUSE_ScanPackages_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _FUSE_RemovePackages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemovePackagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FUSEServer).RemovePackages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.FUSE/RemovePackages",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FUSEServer).RemovePackages(ctx, req.(*RemovePackagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FUSE_ListPackages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPackagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FUSEServer).ListPackages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.FUSE/ListPackages",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FUSEServer).ListPackages(ctx, req.(*ListPackagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FUSE_ExchangeConflicts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExchangeConflictsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FUSEServer).ExchangeConflicts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.FUSE/ExchangeConflicts",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FUSEServer).ExchangeConflicts(ctx, req.(*ExchangeConflictsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FUSE_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FUSEServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.FUSE/Stats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FUSEServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _FUSE_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "pb.FUSE",
 	HandlerType: (*FUSEServer)(nil),
@@ -583,6 +1460,22 @@ var _FUSE_serviceDesc = grpc.ServiceDesc{
 			MethodName: "ScanPackages",
 			Handler:    _FUSE_ScanPackages_Handler,
 		},
+		{
+			MethodName: "RemovePackages",
+			Handler:    _FUSE_RemovePackages_Handler,
+		},
+		{
+			MethodName: "ListPackages",
+			Handler:    _FUSE_ListPackages_Handler,
+		},
+		{
+			MethodName: "ExchangeConflicts",
+			Handler:    _FUSE_ExchangeConflicts_Handler,
+		},
+		{
+			MethodName: "Stats",
+			Handler:    _FUSE_Stats_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "fusectl.proto",
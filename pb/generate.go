@@ -1,3 +1,3 @@
 package pb
 
-//go:generate protoc --go_out=plugins=grpc:. build.proto meta.proto mirrormeta.proto fusectl.proto
+//go:generate protoc --go_out=plugins=grpc:. build.proto meta.proto mirrormeta.proto fusectl.proto generation.proto
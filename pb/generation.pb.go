@@ -0,0 +1,299 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.33.0
+// 	protoc        (unknown)
+// source: generation.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Generation is a snapshot of the installed system, recorded by distri update
+// in /var/lib/distri/generations/<number>.textproto so that distri generations
+// rollback can restore it.
+type Generation struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Monotonically increasing generation number, starting at 1.
+	Number *int64 `protobuf:"varint,1,opt,name=number" json:"number,omitempty"`
+	// UNIX timestamp of when the generation was recorded.
+	Timestamp *int64 `protobuf:"varint,2,opt,name=timestamp" json:"timestamp,omitempty"`
+	// Free-form description of why the generation was recorded, e.g. “distri
+	// update”.
+	Reason *string `protobuf:"bytes,3,opt,name=reason" json:"reason,omitempty"`
+	// Full names of all packages in the package store, e.g.
+	// ["bash-amd64-5.0-4", "glibc-amd64-2.31-4"]
+	Package []string             `protobuf:"bytes,4,rep,name=package" json:"package,omitempty"`
+	Pkgset  []*Generation_Pkgset `protobuf:"bytes,5,rep,name=pkgset" json:"pkgset,omitempty"`
+	// Full name of the kernel package, e.g. “linux-amd64-5.6.5-15”.
+	Kernel *string `protobuf:"bytes,6,opt,name=kernel" json:"kernel,omitempty"`
+	// Path of the initramfs belonging to kernel, e.g.
+	// “/boot/initramfs-5.6.5-15.img”.
+	Initramfs *string `protobuf:"bytes,7,opt,name=initramfs" json:"initramfs,omitempty"`
+	// Full names of the CPU microcode packages, e.g.
+	// ["intel-ucode-amd64-20200508-3"].
+	Ucode []string `protobuf:"bytes,8,rep,name=ucode" json:"ucode,omitempty"`
+}
+
+func (x *Generation) Reset() {
+	*x = Generation{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_generation_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Generation) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Generation) ProtoMessage() {}
+
+func (x *Generation) ProtoReflect() protoreflect.Message {
+	mi := &file_generation_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Generation.ProtoReflect.Descriptor instead.
+func (*Generation) Descriptor() ([]byte, []int) {
+	return file_generation_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Generation) GetNumber() int64 {
+	if x != nil && x.Number != nil {
+		return *x.Number
+	}
+	return 0
+}
+
+func (x *Generation) GetTimestamp() int64 {
+	if x != nil && x.Timestamp != nil {
+		return *x.Timestamp
+	}
+	return 0
+}
+
+func (x *Generation) GetReason() string {
+	if x != nil && x.Reason != nil {
+		return *x.Reason
+	}
+	return ""
+}
+
+func (x *Generation) GetPackage() []string {
+	if x != nil {
+		return x.Package
+	}
+	return nil
+}
+
+func (x *Generation) GetPkgset() []*Generation_Pkgset {
+	if x != nil {
+		return x.Pkgset
+	}
+	return nil
+}
+
+func (x *Generation) GetKernel() string {
+	if x != nil && x.Kernel != nil {
+		return *x.Kernel
+	}
+	return ""
+}
+
+func (x *Generation) GetInitramfs() string {
+	if x != nil && x.Initramfs != nil {
+		return *x.Initramfs
+	}
+	return ""
+}
+
+func (x *Generation) GetUcode() []string {
+	if x != nil {
+		return x.Ucode
+	}
+	return nil
+}
+
+type Generation_Pkgset struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Name of the package set, e.g. “extrabase”
+	// (for /etc/distri/pkgset.d/extrabase.pkgset).
+	Name *string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// Packages listed in the package set, e.g. ["base-full"].
+	Package []string `protobuf:"bytes,2,rep,name=package" json:"package,omitempty"`
+}
+
+func (x *Generation_Pkgset) Reset() {
+	*x = Generation_Pkgset{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_generation_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Generation_Pkgset) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Generation_Pkgset) ProtoMessage() {}
+
+func (x *Generation_Pkgset) ProtoReflect() protoreflect.Message {
+	mi := &file_generation_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Generation_Pkgset.ProtoReflect.Descriptor instead.
+func (*Generation_Pkgset) Descriptor() ([]byte, []int) {
+	return file_generation_proto_rawDescGZIP(), []int{0, 0}
+}
+
+func (x *Generation_Pkgset) GetName() string {
+	if x != nil && x.Name != nil {
+		return *x.Name
+	}
+	return ""
+}
+
+func (x *Generation_Pkgset) GetPackage() []string {
+	if x != nil {
+		return x.Package
+	}
+	return nil
+}
+
+var File_generation_proto protoreflect.FileDescriptor
+
+var file_generation_proto_rawDesc = []byte{
+	0x0a, 0x10, 0x67, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x02, 0x70, 0x62, 0x22, 0xa7, 0x02, 0x0a, 0x0a, 0x47, 0x65, 0x6e, 0x65, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x12, 0x1c, 0x0a,
+	0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x12, 0x16, 0x0a, 0x06, 0x72,
+	0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x72, 0x65, 0x61,
+	0x73, 0x6f, 0x6e, 0x12, 0x18, 0x0a, 0x07, 0x70, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x18, 0x04,
+	0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x70, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x12, 0x2d, 0x0a,
+	0x06, 0x70, 0x6b, 0x67, 0x73, 0x65, 0x74, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x15, 0x2e,
+	0x70, 0x62, 0x2e, 0x47, 0x65, 0x6e, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x50, 0x6b,
+	0x67, 0x73, 0x65, 0x74, 0x52, 0x06, 0x70, 0x6b, 0x67, 0x73, 0x65, 0x74, 0x12, 0x16, 0x0a, 0x06,
+	0x6b, 0x65, 0x72, 0x6e, 0x65, 0x6c, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x6b, 0x65,
+	0x72, 0x6e, 0x65, 0x6c, 0x12, 0x1c, 0x0a, 0x09, 0x69, 0x6e, 0x69, 0x74, 0x72, 0x61, 0x6d, 0x66,
+	0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x69, 0x6e, 0x69, 0x74, 0x72, 0x61, 0x6d,
+	0x66, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x75, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x08, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x05, 0x75, 0x63, 0x6f, 0x64, 0x65, 0x1a, 0x36, 0x0a, 0x06, 0x50, 0x6b, 0x67, 0x73,
+	0x65, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x70, 0x61, 0x63, 0x6b, 0x61, 0x67,
+	0x65, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x70, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65,
+	0x42, 0x06, 0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62,
+}
+
+var (
+	file_generation_proto_rawDescOnce sync.Once
+	file_generation_proto_rawDescData = file_generation_proto_rawDesc
+)
+
+func file_generation_proto_rawDescGZIP() []byte {
+	file_generation_proto_rawDescOnce.Do(func() {
+		file_generation_proto_rawDescData = protoimpl.X.CompressGZIP(file_generation_proto_rawDescData)
+	})
+	return file_generation_proto_rawDescData
+}
+
+var file_generation_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_generation_proto_goTypes = []interface{}{
+	(*Generation)(nil),        // 0: pb.Generation
+	(*Generation_Pkgset)(nil), // 1: pb.Generation.Pkgset
+}
+var file_generation_proto_depIdxs = []int32{
+	1, // 0: pb.Generation.pkgset:type_name -> pb.Generation.Pkgset
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_generation_proto_init() }
+func file_generation_proto_init() {
+	if File_generation_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_generation_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Generation); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_generation_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Generation_Pkgset); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_generation_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_generation_proto_goTypes,
+		DependencyIndexes: file_generation_proto_depIdxs,
+		MessageInfos:      file_generation_proto_msgTypes,
+	}.Build()
+	File_generation_proto = out.File
+	file_generation_proto_rawDesc = nil
+	file_generation_proto_goTypes = nil
+	file_generation_proto_depIdxs = nil
+}
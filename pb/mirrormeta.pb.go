@@ -1,13 +1,12 @@
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.20.1
-// 	protoc        v3.11.4
+// 	protoc-gen-go v1.33.0
+// 	protoc        (unknown)
 // source: mirrormeta.proto
 
 package pb
 
 import (
-	proto "github.com/golang/protobuf/proto"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
@@ -21,10 +20,6 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
-// This is a compile-time assertion that a sufficiently up-to-date version
-// of the legacy proto package is being used.
-const _ = proto.ProtoPackageIsVersion4
-
 type MirrorMeta struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -79,6 +74,20 @@ type MirrorMeta_Package struct {
 
 	Name          *string  `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
 	WellKnownPath []string `protobuf:"bytes,2,rep,name=well_known_path,json=wellKnownPath" json:"well_known_path,omitempty"`
+	// Hex-encoded SHA-256 hash and size in bytes of <name>.squashfs, for
+	// detecting truncated or corrupted downloads.
+	SquashfsSha256 *string `protobuf:"bytes,3,opt,name=squashfs_sha256,json=squashfsSha256" json:"squashfs_sha256,omitempty"`
+	SquashfsSize   *int64  `protobuf:"varint,4,opt,name=squashfs_size,json=squashfsSize" json:"squashfs_size,omitempty"`
+	// Hex-encoded SHA-256 hash and size in bytes of <name>.meta.textproto.
+	MetaSha256 *string `protobuf:"bytes,5,opt,name=meta_sha256,json=metaSha256" json:"meta_sha256,omitempty"`
+	MetaSize   *int64  `protobuf:"varint,6,opt,name=meta_size,json=metaSize" json:"meta_size,omitempty"`
+	// Runtime dependencies, copied from <name>.meta.textproto so that clients
+	// can answer dependency queries without fetching every meta file.
+	RuntimeDep []string `protobuf:"bytes,7,rep,name=runtime_dep,json=runtimeDep" json:"runtime_dep,omitempty"`
+	// Package metadata, copied from <name>.meta.textproto for distri search.
+	Description *string `protobuf:"bytes,8,opt,name=description" json:"description,omitempty"`
+	Homepage    *string `protobuf:"bytes,9,opt,name=homepage" json:"homepage,omitempty"`
+	License     *string `protobuf:"bytes,10,opt,name=license" json:"license,omitempty"`
 }
 
 func (x *MirrorMeta_Package) Reset() {
@@ -127,20 +136,92 @@ func (x *MirrorMeta_Package) GetWellKnownPath() []string {
 	return nil
 }
 
+func (x *MirrorMeta_Package) GetSquashfsSha256() string {
+	if x != nil && x.SquashfsSha256 != nil {
+		return *x.SquashfsSha256
+	}
+	return ""
+}
+
+func (x *MirrorMeta_Package) GetSquashfsSize() int64 {
+	if x != nil && x.SquashfsSize != nil {
+		return *x.SquashfsSize
+	}
+	return 0
+}
+
+func (x *MirrorMeta_Package) GetMetaSha256() string {
+	if x != nil && x.MetaSha256 != nil {
+		return *x.MetaSha256
+	}
+	return ""
+}
+
+func (x *MirrorMeta_Package) GetMetaSize() int64 {
+	if x != nil && x.MetaSize != nil {
+		return *x.MetaSize
+	}
+	return 0
+}
+
+func (x *MirrorMeta_Package) GetRuntimeDep() []string {
+	if x != nil {
+		return x.RuntimeDep
+	}
+	return nil
+}
+
+func (x *MirrorMeta_Package) GetDescription() string {
+	if x != nil && x.Description != nil {
+		return *x.Description
+	}
+	return ""
+}
+
+func (x *MirrorMeta_Package) GetHomepage() string {
+	if x != nil && x.Homepage != nil {
+		return *x.Homepage
+	}
+	return ""
+}
+
+func (x *MirrorMeta_Package) GetLicense() string {
+	if x != nil && x.License != nil {
+		return *x.License
+	}
+	return ""
+}
+
 var File_mirrormeta_proto protoreflect.FileDescriptor
 
 var file_mirrormeta_proto_rawDesc = []byte{
 	0x0a, 0x10, 0x6d, 0x69, 0x72, 0x72, 0x6f, 0x72, 0x6d, 0x65, 0x74, 0x61, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x12, 0x02, 0x70, 0x62, 0x22, 0x85, 0x01, 0x0a, 0x0a, 0x4d, 0x69, 0x72, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x02, 0x70, 0x62, 0x22, 0x8b, 0x03, 0x0a, 0x0a, 0x4d, 0x69, 0x72, 0x72, 0x6f,
 	0x72, 0x4d, 0x65, 0x74, 0x61, 0x12, 0x30, 0x0a, 0x07, 0x70, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65,
 	0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x69, 0x72, 0x72,
 	0x6f, 0x72, 0x4d, 0x65, 0x74, 0x61, 0x2e, 0x50, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x52, 0x07,
-	0x70, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x1a, 0x45, 0x0a, 0x07, 0x50, 0x61, 0x63, 0x6b, 0x61,
-	0x67, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x26, 0x0a, 0x0f, 0x77, 0x65, 0x6c, 0x6c, 0x5f, 0x6b,
-	0x6e, 0x6f, 0x77, 0x6e, 0x5f, 0x70, 0x61, 0x74, 0x68, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52,
-	0x0d, 0x77, 0x65, 0x6c, 0x6c, 0x4b, 0x6e, 0x6f, 0x77, 0x6e, 0x50, 0x61, 0x74, 0x68, 0x42, 0x06,
-	0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62,
+	0x70, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x1a, 0xca, 0x02, 0x0a, 0x07, 0x50, 0x61, 0x63, 0x6b,
+	0x61, 0x67, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x26, 0x0a, 0x0f, 0x77, 0x65, 0x6c, 0x6c, 0x5f,
+	0x6b, 0x6e, 0x6f, 0x77, 0x6e, 0x5f, 0x70, 0x61, 0x74, 0x68, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09,
+	0x52, 0x0d, 0x77, 0x65, 0x6c, 0x6c, 0x4b, 0x6e, 0x6f, 0x77, 0x6e, 0x50, 0x61, 0x74, 0x68, 0x12,
+	0x27, 0x0a, 0x0f, 0x73, 0x71, 0x75, 0x61, 0x73, 0x68, 0x66, 0x73, 0x5f, 0x73, 0x68, 0x61, 0x32,
+	0x35, 0x36, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x73, 0x71, 0x75, 0x61, 0x73, 0x68,
+	0x66, 0x73, 0x53, 0x68, 0x61, 0x32, 0x35, 0x36, 0x12, 0x23, 0x0a, 0x0d, 0x73, 0x71, 0x75, 0x61,
+	0x73, 0x68, 0x66, 0x73, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0c, 0x73, 0x71, 0x75, 0x61, 0x73, 0x68, 0x66, 0x73, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x1f, 0x0a,
+	0x0b, 0x6d, 0x65, 0x74, 0x61, 0x5f, 0x73, 0x68, 0x61, 0x32, 0x35, 0x36, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0a, 0x6d, 0x65, 0x74, 0x61, 0x53, 0x68, 0x61, 0x32, 0x35, 0x36, 0x12, 0x1b,
+	0x0a, 0x09, 0x6d, 0x65, 0x74, 0x61, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x72,
+	0x75, 0x6e, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x64, 0x65, 0x70, 0x18, 0x07, 0x20, 0x03, 0x28, 0x09,
+	0x52, 0x0a, 0x72, 0x75, 0x6e, 0x74, 0x69, 0x6d, 0x65, 0x44, 0x65, 0x70, 0x12, 0x20, 0x0a, 0x0b,
+	0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x08, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0b, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x1a,
+	0x0a, 0x08, 0x68, 0x6f, 0x6d, 0x65, 0x70, 0x61, 0x67, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x68, 0x6f, 0x6d, 0x65, 0x70, 0x61, 0x67, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x6c, 0x69,
+	0x63, 0x65, 0x6e, 0x73, 0x65, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6c, 0x69, 0x63,
+	0x65, 0x6e, 0x73, 0x65, 0x42, 0x06, 0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62,
 }
 
 var (